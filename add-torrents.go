@@ -0,0 +1,103 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Returned by Client.AddTorrents when any spec is invalid or fails to merge.
+type AddTorrentsError struct {
+	// The error for each spec, by index. nil for specs without errors.
+	Errs []error
+}
+
+func (me AddTorrentsError) Error() string {
+	var first error
+	failed := 0
+	for _, err := range me.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%v of %v torrent specs failed, first error: %v", failed, len(me.Errs), first)
+}
+
+// Adds many torrents at once, such as when importing torrents at startup. All specs are validated
+// before any are added, and new Torrents are registered under a single acquisition of the Client
+// lock. If any spec is invalid or fails to merge, the Torrents that were new are dropped, and an
+// AddTorrentsError is returned. Specs for Torrents already in the Client are merged as with
+// AddTorrentSpec, which isn't undone.
+func (cl *Client) AddTorrents(specs []TorrentSpec) ([]*Torrent, error) {
+	errs := make([]error, len(specs))
+	failed := false
+	seen := make(map[metainfo.Hash]int, len(specs))
+	cl.rLock()
+	for i := range specs {
+		spec := &specs[i]
+		_, exists := cl.torrents[spec.InfoHash]
+		if j, ok := seen[spec.InfoHash]; ok {
+			errs[i] = fmt.Errorf("duplicate of spec %v", j)
+		} else {
+			errs[i] = cl.validateTorrentSpec(spec, exists)
+		}
+		seen[spec.InfoHash] = i
+		failed = failed || errs[i] != nil
+	}
+	cl.rUnlock()
+	if failed {
+		return nil, AddTorrentsError{errs}
+	}
+	ts := make([]*Torrent, len(specs))
+	news := make([]bool, len(specs))
+	cl.lock()
+	for i := range specs {
+		ts[i], news[i] = cl.addTorrentOptLocked(specs[i].addTorrentOpts())
+	}
+	cl.unlock()
+	for i := range specs {
+		errs[i] = cl.mergeAddedSpec(ts[i], news[i], &specs[i])
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		for i, t := range ts {
+			if news[i] {
+				t.Drop()
+			}
+		}
+		return nil, AddTorrentsError{errs}
+	}
+	return ts, nil
+}
+
+// Checks what can be checked in a spec without adding it.
+func (cl *Client) validateTorrentSpec(spec *TorrentSpec, exists bool) error {
+	if spec.InfoHash == (metainfo.Hash{}) {
+		return errors.New("missing infohash")
+	}
+	if spec.InfoBytes != nil {
+		if metainfo.HashBytes(spec.InfoBytes) != spec.InfoHash {
+			return errors.New("info bytes don't match infohash")
+		}
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err != nil {
+			return fmt.Errorf("unmarshalling info: %w", err)
+		}
+	}
+	if exists {
+		if spec.ChunkSize != 0 {
+			return errors.New("chunk size cannot be changed for existing Torrent")
+		}
+		return nil
+	}
+	// Info for Torrents that already exist is checked when it's merged.
+	if len(cl.config.TrustedPublishers) != 0 && spec.InfoBytes != nil {
+		return cl.checkPublisherSignature(spec.InfoBytes, spec.Signatures)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package torrent
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/time/rate"
+)
+
+// Discovers the uplink capacity by watching for latency inflation while uploading, and caps
+// uploads slightly below it so the link stays usable for interactive traffic. This is like LEDBAT,
+// but for TCP peers. The cap has its own limiter, applied along with ClientConfig.UploadRateLimiter,
+// so the configured limit remains a ceiling and can still be changed with Client.ApplyConfig.
+type Autoband struct {
+	// Where latency is measured to, by timing TCP connects. This should be past the uplink, such as
+	// a nearby well-connected host. Autoband is disabled if this is empty.
+	ProbeAddr string
+	// How often latency is measured and the cap adjusted. Defaults to a second.
+	ProbeInterval time.Duration
+	// Latency above the base latency at which the uplink is considered saturated. Defaults to
+	// 100ms, the LEDBAT target.
+	TargetDelay time.Duration
+	// The cap is never set below this, in bytes per second. Defaults to 16 KiB/s.
+	MinRate rate.Limit
+}
+
+const (
+	defaultAutobandProbeInterval = time.Second
+	defaultAutobandTargetDelay   = 100 * time.Millisecond
+	defaultAutobandMinRate       = 16 << 10
+	// The cap is set this far below the upload rate at which latency inflated.
+	autobandHeadroom = 0.9
+	// While uploading at the cap without latency inflating, the cap is raised by this factor to
+	// find capacity that's become available.
+	autobandIncrease = 1.1
+	// The base latency is the least seen over roughly this long, so that route changes are
+	// eventually accepted.
+	autobandBaseWindow = 5 * time.Minute
+	// Needs to fit the largest chunk that'll be uploaded in one reservation.
+	autobandBurst = 1 << 20
+)
+
+func (me Autoband) probeInterval() time.Duration {
+	if me.ProbeInterval == 0 {
+		return defaultAutobandProbeInterval
+	}
+	return me.ProbeInterval
+}
+
+func (me Autoband) targetDelay() time.Duration {
+	if me.TargetDelay == 0 {
+		return defaultAutobandTargetDelay
+	}
+	return me.TargetDelay
+}
+
+func (me Autoband) minRate() rate.Limit {
+	if me.MinRate == 0 {
+		return defaultAutobandMinRate
+	}
+	return me.MinRate
+}
+
+type autobandState struct {
+	config Autoband
+	// The least latency seen in the current and previous base windows.
+	base        time.Duration
+	nextBase    time.Duration
+	windowStart time.Time
+	// Upload total at the last sample.
+	lastSample   time.Time
+	lastUploaded int64
+	limit        rate.Limit
+}
+
+// Takes a latency sample along with the Client's upload total, and returns the new upload cap.
+func (me *autobandState) update(now time.Time, latency time.Duration, uploaded int64) rate.Limit {
+	if me.windowStart.IsZero() || now.Sub(me.windowStart) >= autobandBaseWindow {
+		me.base = me.nextBase
+		me.nextBase = latency
+		me.windowStart = now
+	}
+	if latency < me.nextBase {
+		me.nextBase = latency
+	}
+	if me.base == 0 || latency < me.base {
+		me.base = latency
+	}
+	var upRate rate.Limit
+	if !me.lastSample.IsZero() {
+		if dt := now.Sub(me.lastSample).Seconds(); dt > 0 {
+			upRate = rate.Limit(float64(uploaded-me.lastUploaded) / dt)
+		}
+	}
+	me.lastSample = now
+	me.lastUploaded = uploaded
+	switch {
+	case latency-me.base > me.config.targetDelay():
+		// Uploads are queuing at the uplink. If we're barely uploading, something else is
+		// responsible and there's nothing to learn.
+		if upRate >= me.config.minRate() {
+			me.limit = upRate * autobandHeadroom
+		}
+	case me.limit != rate.Inf && upRate >= me.limit*autobandHeadroom:
+		me.limit *= autobandIncrease
+	}
+	if me.limit < me.config.minRate() {
+		me.limit = me.config.minRate()
+	}
+	return me.limit
+}
+
+// The Client's upload limit, which is the lesser of ClientConfig.UploadRateLimiter and the Autoband
+// cap.
+func (cl *Client) uploadRateLimit() rate.Limit {
+	ret := cl.settings.uploadRateLimiter.Limit()
+	if l := cl.autobandLimiter; l != nil && l.Limit() < ret {
+		ret = l.Limit()
+	}
+	return ret
+}
+
+// Measures the latency to Autoband.ProbeAddr.
+func (cl *Client) autobandProbe(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, cl.config.Autoband.probeInterval())
+	defer cancel()
+	started := time.Now()
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", cl.config.Autoband.ProbeAddr)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(started)
+	c.Close()
+	return latency, nil
+}
+
+func (cl *Client) autobandLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cl.closed.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	limiter := cl.autobandLimiter
+	s := autobandState{
+		config: cl.config.Autoband,
+		limit:  limiter.Limit(),
+	}
+	ticker := time.NewTicker(s.config.probeInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case <-ticker.C:
+		}
+		latency, err := cl.autobandProbe(ctx)
+		if err != nil {
+			torrent.Add("autoband probe errors", 1)
+			cl.logger.Levelf(log.Debug, "autoband probe: %v", err)
+			continue
+		}
+		old := s.limit
+		limit := s.update(time.Now(), latency, cl.stats.BytesWrittenData.Int64())
+		if limit != old {
+			limiter.SetLimit(limit)
+			torrent.Add("autoband adjustments", 1)
+			cl.logger.Levelf(log.Debug, "autoband: upload cap %v -> %v B/s (latency %v, base %v)", old, limit, latency, s.base)
+		}
+	}
+}
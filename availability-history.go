@@ -0,0 +1,81 @@
+package torrent
+
+import (
+	"time"
+)
+
+// The most samples of availability history kept per Torrent. At one minute intervals this is a
+// day.
+const maxAvailabilitySamples = 1440
+
+// The availability of a Torrent's data in the swarm at a point in time, from the connected peers.
+type AvailabilitySample struct {
+	Time time.Time
+	// Connected peers that have all the pieces.
+	Seeders int
+	// All connected peers.
+	Peers int
+	// The number of complete copies of the data among connected peers. The fractional part is
+	// the proportion of pieces that are more available than the least available piece. Zero
+	// without the info.
+	DistributedCopies float64
+}
+
+// Excludes the data we have.
+func (t *Torrent) distributedCopies() float64 {
+	if !t.haveInfo() || t.numPieces() == 0 {
+		return 0
+	}
+	minAvail := t.piece(0).availability()
+	numAbove := 0
+	for i := range t.pieces {
+		avail := t.piece(i).availability()
+		switch {
+		case avail < minAvail:
+			// All the preceding pieces are more available.
+			numAbove = i
+			minAvail = avail
+		case avail > minAvail:
+			numAbove++
+		}
+	}
+	return float64(minAvail) + float64(numAbove)/float64(t.numPieces())
+}
+
+func (t *Torrent) recordAvailability(now time.Time) {
+	if len(t.availabilityHistory) == maxAvailabilitySamples {
+		copy(t.availabilityHistory, t.availabilityHistory[1:])
+		t.availabilityHistory = t.availabilityHistory[:maxAvailabilitySamples-1]
+	}
+	t.availabilityHistory = append(t.availabilityHistory, AvailabilitySample{
+		Time:              now,
+		Seeders:           len(t.connsWithAllPieces),
+		Peers:             t.numActivePeers(),
+		DistributedCopies: t.distributedCopies(),
+	})
+}
+
+// Returns the swarm availability recorded every ClientConfig.AvailabilitySampleInterval, oldest
+// first. Use this to analyze how a swarm decays over time.
+func (t *Torrent) AvailabilityHistory() []AvailabilitySample {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return append([]AvailabilitySample(nil), t.availabilityHistory...)
+}
+
+func (cl *Client) availabilityLoop() {
+	ticker := time.NewTicker(cl.config.AvailabilitySampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.lock()
+			for _, t := range cl.torrents {
+				t.recordAvailability(now)
+			}
+			cl.unlock()
+		}
+	}
+}
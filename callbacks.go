@@ -25,6 +25,32 @@ type Callbacks struct {
 	SentRequest        []func(PeerRequestEvent)
 	PeerClosed         []func(*Peer)
 	NewPeer            []func(*Peer)
+	// Called after the DeadTorrentPolicy is applied to a Torrent. The Client lock is not held.
+	DeadTorrent []func(DeadTorrentEvent)
+	// Called after the FreeRiderPolicy is applied to a peer. The Client lock is not held.
+	FreeRider []func(FreeRiderEvent)
+	// Called after a MutableTorrent migrates to a new version. The Client lock is not held.
+	MutableTorrentUpdated []func(MutableTorrentUpdateEvent)
+	// Called after a torrent from a Feed is added. The Client lock is not held.
+	FeedEntryAdded []func(FeedEntryAddedEvent)
+	// Called after a scheduled verification of a Torrent's data. The Client lock is not held.
+	ScheduledVerification []func(ScheduledVerificationEvent)
+	// Called when a Namespace goes over or back under its disk or transfer quota. The Client lock
+	// is not held.
+	NamespaceQuota []func(NamespaceQuotaEvent)
+	// Called when the addresses discovered through ClientConfig.StunServers change. The Client lock
+	// is not held.
+	ExternalAddrsChanged []func(ExternalAddrs)
+	// Called for each setting changed by Client.ApplyConfig. The Client lock is not held.
+	ConfigChanged []func(ConfigChange)
+	// Called when the Client runs out of file descriptors, after shedding connections. The Client
+	// lock is held.
+	FdPressure []func(FdPressureEvent)
+	// Called with each piece's data after it passes verification, and before it's marked complete.
+	// The data is captured as it's read for hashing, so it isn't read from storage again. Hashing
+	// waits for these to return, so slow consumers should copy the data and return. The Client lock
+	// is not held.
+	PieceVerified []func(PieceVerifiedEvent)
 }
 
 type ReceivedUsefulDataEvent = PeerMessageEvent
@@ -38,3 +64,10 @@ type PeerRequestEvent struct {
 	Peer *Peer
 	Request
 }
+
+type PieceVerifiedEvent struct {
+	Torrent *Torrent
+	Index   int
+	// Only valid until the callback returns.
+	Data []byte
+}
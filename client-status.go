@@ -0,0 +1,192 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"golang.org/x/time/rate"
+)
+
+// A machine-readable snapshot of Client state, comparable to the output of Client.WriteStatus.
+type ClientStatus struct {
+	PeerId        string
+	ClientVersion string
+	ExperimentId  string
+	ListenAddrs   []string
+	ExtensionBits string
+	AnnounceKey   int32
+	BannedIps     int
+	HalfOpen      int
+	Limits        ClientLimitsStatus
+	Stats         ConnStats
+	Connectivity  ConnectivityStats
+	// Established peer connections across all Torrents.
+	ConnEncryption ConnEncryptionCounts
+	DhtServers     []DhtServerStatus
+	Torrents       []TorrentStatus
+}
+
+type ClientLimitsStatus struct {
+	// Bytes per second. Negative if unlimited.
+	UploadRate   float64
+	DownloadRate float64
+	// Bytes that can be consumed in a single burst.
+	UploadBurst                int
+	DownloadBurst              int
+	MaxUnverifiedBytes         int64
+	EstablishedConnsPerTorrent int
+	HalfOpenConnsPerTorrent    int
+	TotalHalfOpenConns         int
+}
+
+type DhtServerStatus struct {
+	Network string
+	Addr    string
+	Id      string
+	Stats   interface{}
+}
+
+type TorrentStatus struct {
+	InfoHash       string
+	Name           string
+	HaveInfo       bool
+	Length         int64
+	BytesCompleted int64
+	Stats          TorrentStats
+	Trackers       []string
+	Scheduler      SchedulerParams
+	Peers          []PeerConnStatus
+}
+
+type PeerConnStatus struct {
+	RemoteAddr string
+	Network    string
+	PeerId     string
+	ClientName string
+	Discovery  PeerSource
+	// Whether the MSE handshake was used. See Encryption for what it negotiated.
+	Encrypted  bool
+	Encryption ConnEncryption
+	Outgoing   bool
+	// Download rate in bytes per second, as observed by the request logic.
+	DownloadRate float64
+	Stats        ConnStats
+	// Labels from the peer's extended handshake. See ClientConfig.HandshakeMetadata.
+	HandshakeMetadata map[string]string `json:",omitempty"`
+}
+
+func rateLimitStatus(l *rate.Limiter) (limit float64, burst int) {
+	if l == nil || l.Limit() == rate.Inf {
+		return -1, 0
+	}
+	return float64(l.Limit()), l.Burst()
+}
+
+// Returns a snapshot of the Client's state suitable for diagnostics.
+func (cl *Client) Status() (ret ClientStatus) {
+	cl.rLock()
+	defer cl.rUnlock()
+	ret.PeerId = fmt.Sprintf("%+q", cl.peerID[:])
+	ret.ClientVersion = cl.config.ExtendedHandshakeClientVersion
+	ret.ExperimentId = cl.config.ExperimentId
+	for _, l := range cl.listeners {
+		ret.ListenAddrs = append(ret.ListenAddrs, l.Addr().String())
+	}
+	ret.ExtensionBits = cl.config.Extensions.String()
+	ret.AnnounceKey = cl.announceKey()
+	ret.BannedIps = len(cl.badPeerIPs)
+	ret.HalfOpen = cl.numHalfOpen
+	ret.Limits = ClientLimitsStatus{
+		MaxUnverifiedBytes:         cl.config.MaxUnverifiedBytes,
+		EstablishedConnsPerTorrent: cl.settings.establishedConnsPerTorrent,
+		HalfOpenConnsPerTorrent:    cl.settings.halfOpenConnsPerTorrent,
+		TotalHalfOpenConns:         cl.settings.totalHalfOpenConns,
+	}
+	ret.Limits.UploadRate, ret.Limits.UploadBurst = rateLimitStatus(cl.settings.uploadRateLimiter)
+	ret.Limits.DownloadRate, ret.Limits.DownloadBurst = rateLimitStatus(cl.settings.downloadRateLimiter)
+	ret.Stats = cl.stats.Copy()
+	ret.Connectivity = cl.connectivity.copy()
+	ret.ConnEncryption = cl.connEncryptionCountsLocked()
+	cl.eachDhtServer(func(s DhtServer) {
+		id := s.ID()
+		ret.DhtServers = append(ret.DhtServers, DhtServerStatus{
+			Network: s.Addr().Network(),
+			Addr:    s.Addr().String(),
+			Id:      hex.EncodeToString(id[:]),
+			Stats:   s.Stats(),
+		})
+	})
+	torrents := cl.torrentsAsSlice()
+	sort.Slice(torrents, func(l, r int) bool {
+		return torrents[l].infoHash.AsString() < torrents[r].infoHash.AsString()
+	})
+	for _, t := range torrents {
+		ret.Torrents = append(ret.Torrents, t.statusLocked())
+	}
+	return
+}
+
+func (t *Torrent) statusLocked() (ret TorrentStatus) {
+	ret.InfoHash = t.infoHash.HexString()
+	ret.Name = t.name()
+	ret.HaveInfo = t.haveInfo()
+	if ret.HaveInfo {
+		ret.Length = t.length()
+		ret.BytesCompleted = t.bytesCompleted()
+	}
+	ret.Stats = t.statsLocked()
+	for url := range t.trackerAnnouncers {
+		ret.Trackers = append(ret.Trackers, url)
+	}
+	sort.Strings(ret.Trackers)
+	ret.Scheduler = t.schedulerParams()
+	for _, c := range t.appendUnclosedConns(nil) {
+		ps := PeerConnStatus{
+			Network:      c.Network,
+			PeerId:       fmt.Sprintf("%+q", c.PeerID[:]),
+			Discovery:    c.Discovery,
+			Encrypted:    c.headerEncrypted,
+			Encryption:   c.Encryption(),
+			Outgoing:     c.outgoing,
+			DownloadRate: c.downloadRate(),
+			Stats:        c._stats.Copy(),
+			// Handshake metadata is replaced rather than modified, so it can be shared.
+			HandshakeMetadata: c.peerHandshakeMetadata,
+		}
+		if c.RemoteAddr != nil {
+			ps.RemoteAddr = c.RemoteAddr.String()
+		}
+		ps.ClientName, _ = c.PeerClientName.Load().(string)
+		ret.Peers = append(ret.Peers, ps)
+	}
+	return
+}
+
+// Returns the Client status as indented JSON. See Client.Status.
+func (cl *Client) StatusJSON() ([]byte, error) {
+	status := cl.Status()
+	return json.MarshalIndent(&status, "", "  ")
+}
+
+// Returns a handler that serves the Client status, such as for mounting on a debug HTTP server.
+// JSON is served if the "format" query parameter is "json", otherwise the output of
+// Client.WriteStatus.
+func (cl *Client) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "json" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			cl.WriteStatus(w)
+			return
+		}
+		b, err := cl.StatusJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
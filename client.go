@@ -3,6 +3,7 @@ package torrent
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/binary"
@@ -47,6 +48,7 @@ import (
 	request_strategy "github.com/anacrolix/torrent/request-strategy"
 	"github.com/anacrolix/torrent/storage"
 	"github.com/anacrolix/torrent/tracker"
+	"github.com/anacrolix/torrent/tracker/udp"
 	"github.com/anacrolix/torrent/webtorrent"
 )
 
@@ -70,7 +72,9 @@ type Client struct {
 	dialers        []Dialer
 	listeners      []Listener
 	dhtServers     []DhtServer
-	ipBlockList    iplist.Ranger
+	// The DHT servers created on our own sockets, which are restarted when our public IP changes.
+	clientDhtServers []*clientDhtServer
+	ipBlockList      iplist.Ranger
 
 	// Set of addresses that have our client ID. This intentionally will
 	// include ourselves if we end up trying to connect to our own address
@@ -84,10 +88,42 @@ type Client struct {
 	dialRateLimiter *rate.Limiter
 	numHalfOpen     int
 
+	storageReadWorkers workerSlots
+	handshakeWorkers   workerSlots
+	fdPressure         fdPressureState
+	uploadReceiptKey   ed25519.PrivateKey
+	// Set if ClientConfig.StatsReportInterval is.
+	statsReporter *statsReporter
+	// A goroutine is applying ClientConfig.DeadTorrentPolicy.
+	deadTorrentPolicyLoopRunning bool
+	// See ApplyConfig.
+	settings clientSettings
+	// The upload cap set by Autoband, if it's enabled. It's separate from the UploadRateLimiter
+	// that ApplyConfig changes, which still applies. Never replaced.
+	autobandLimiter *rate.Limiter
+	// By name. See Client.Namespace.
+	namespaces map[string]*Namespace
+	// Set once any Torrent could have scheduled verifications.
+	scheduledVerificationStarted bool
+	// Connections to each remote client, across Torrents.
+	remotePeerConns map[remotePeerKey]map[*PeerConn]struct{}
+
 	websocketTrackers websocketTrackers
 
 	activeAnnounceLimiter limiter.Instance
+	externalAddrs         externalAddrsState
 	httpClient            *http.Client
+
+	connectivity connectivityStats
+	// Announces by all Torrents, including those since dropped.
+	announces AnnounceStats
+	// Shared by announces to UDP trackers so connection IDs are reused.
+	udpTrackers udp.ConnClientPool
+	// Peers disconnected by ClientConfig.StrictProtocol, by reason.
+	protocolViolations map[ProtocolViolationReason]int64
+	// The highest priority of Torrents downloading, if any are. See preemptedByPriority.
+	highestDownloadingPriority TorrentPriority
+	anyDownloadingPriority     bool
 }
 
 type ipStr string
@@ -140,9 +176,16 @@ func (cl *Client) WriteStatus(_w io.Writer) {
 	defer w.Flush()
 	fmt.Fprintf(w, "Listen port: %d\n", cl.LocalPort())
 	fmt.Fprintf(w, "Peer ID: %+q\n", cl.PeerID())
+	fmt.Fprintf(w, "Client version: %q\n", cl.config.ExtendedHandshakeClientVersion)
+	if cl.config.ExperimentId != "" {
+		fmt.Fprintf(w, "Experiment ID: %q\n", cl.config.ExperimentId)
+	}
 	fmt.Fprintf(w, "Extension bits: %v\n", cl.config.Extensions)
 	fmt.Fprintf(w, "Announce key: %x\n", cl.announceKey())
 	fmt.Fprintf(w, "Banned IPs: %d\n", len(cl.badPeerIPsLocked()))
+	encryption := cl.connEncryptionCountsLocked()
+	fmt.Fprintf(w, "Peer encryption: %d encrypted, %d obfuscated, %d plaintext\n",
+		encryption.Encrypted, encryption.HeaderObfuscated, encryption.Plaintext)
 	cl.eachDhtServer(func(s DhtServer) {
 		fmt.Fprintf(w, "%s DHT server at %s:\n", s.Addr().Network(), s.Addr().String())
 		writeDhtServerStatus(w, s)
@@ -179,12 +222,16 @@ func (cl *Client) WriteStatus(_w io.Writer) {
 
 func (cl *Client) initLogger() {
 	logger := cl.config.Logger
-	if logger.IsZero() {
+	if cl.config.Slogger != nil {
+		logger = log.NewLogger()
+		logger.SetHandlers(slogHandler{cl.config.Slogger})
+	} else if logger.IsZero() {
 		logger = log.Default
 	}
-	if cl.config.Debug {
-		logger = logger.FilterLevel(log.Debug)
-	}
+	// The level is applied by clientLogHandler, so it can be changed later.
+	base := logger
+	logger = logger.FilterLevel(log.Debug)
+	logger.SetHandlers(clientLogHandler{cl, base})
 	cl.logger = logger.WithValues(cl)
 }
 
@@ -195,9 +242,12 @@ func (cl *Client) announceKey() int32 {
 // Initializes a bare minimum Client. *Client and *ClientConfig must not be nil.
 func (cl *Client) init(cfg *ClientConfig) {
 	cl.config = cfg
+	cl.initSettings()
 	generics.MakeMap(&cl.dopplegangerAddrs)
 	cl.torrents = make(map[metainfo.Hash]*Torrent)
 	cl.dialRateLimiter = rate.NewLimiter(10, 10)
+	cl.storageReadWorkers = newWorkerSlots(cfg.StorageReadWorkers)
+	cl.handshakeWorkers = newWorkerSlots(cfg.HandshakeWorkers)
 	cl.activeAnnounceLimiter.SlotsPerKey = 2
 	cl.event.L = cl.locker()
 	cl.ipBlockList = cfg.IPBlocklist
@@ -217,6 +267,10 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		cfg = NewDefaultClientConfig()
 		cfg.ListenPort = 0
 	}
+	if l := cfg.PieceHashRateLimiter; l != nil && l.Limit() != rate.Inf && l.Burst() < defaultChunkSize {
+		err = fmt.Errorf("piece hash rate limiter burst %v is smaller than a chunk", l.Burst())
+		return
+	}
 	var client Client
 	client.init(cfg)
 	cl = &client
@@ -245,6 +299,10 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 	if cfg.PeerID != "" {
 		missinggo.CopyExact(&cl.peerID, cfg.PeerID)
 	} else {
+		if len(cfg.Bep20) > len(cl.peerID) {
+			err = fmt.Errorf("peer id prefix %q is longer than a peer id", cfg.Bep20)
+			return
+		}
 		o := copy(cl.peerID[:], cfg.Bep20)
 		_, err = rand.Read(cl.peerID[o:])
 		if err != nil {
@@ -252,6 +310,16 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		}
 	}
 
+	if cfg.UploadReceipts {
+		cl.uploadReceiptKey = cfg.UploadReceiptKey
+		if cl.uploadReceiptKey == nil {
+			_, cl.uploadReceiptKey, err = ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return
+			}
+		}
+	}
+
 	sockets, err := listenAll(cl.listenNetworks(), cl.config.ListenHost, cl.config.ListenPort, cl.firewallCallback, cl.logger)
 	if err != nil {
 		return
@@ -273,17 +341,60 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 	}
 
 	go cl.forwardPort()
+	cl.lock()
+	cl.startDeadTorrentPolicyLoop()
+	cl.unlock()
+	if cfg.FreeRiderPolicy.CheckInterval != 0 {
+		go cl.freeRiderLoop()
+	}
+	if cfg.Autoband.ProbeAddr != "" {
+		cl.autobandLimiter = rate.NewLimiter(rate.Inf, autobandBurst)
+		go cl.autobandLoop()
+	}
+	if cfg.SchedulerParams != nil {
+		go cl.requestTimeoutLoop()
+	}
+	if cfg.FairRateSharing {
+		go cl.rateSharingLoop()
+	}
+	if cfg.FileChangeCheckInterval != 0 {
+		go cl.fileChangeLoop()
+	}
+	if cfg.ScheduledVerificationInterval > 0 {
+		cl.lock()
+		cl.startScheduledVerification()
+		cl.unlock()
+	}
+	if cfg.TransferRateWindow > 0 {
+		go cl.transferRateLoop()
+	}
+	if cfg.AvailabilitySampleInterval != 0 {
+		go cl.availabilityLoop()
+	}
+	if cfg.StatsReportInterval != 0 && !cfg.DisableTrackers {
+		cl.statsReporter = cl.newStatsReporter()
+		cl.statsReporter.start()
+	}
 	if !cfg.NoDHT {
 		for _, s := range sockets {
 			if pc, ok := s.(net.PacketConn); ok {
-				ds, err := cl.NewAnacrolixDhtServer(pc)
+				conn := dhtServerConn{pc}
+				ds, err := cl.NewAnacrolixDhtServer(conn)
 				if err != nil {
 					panic(err)
 				}
 				cl.dhtServers = append(cl.dhtServers, AnacrolixDhtServerWrapper{ds})
-				cl.onClose = append(cl.onClose, func() { ds.Close() })
+				cl.clientDhtServers = append(cl.clientDhtServers, &clientDhtServer{conn, ds})
 			}
 		}
+		cl.onClose = append(cl.onClose, func() {
+			for _, s := range cl.clientDhtServers {
+				s.server.Close()
+			}
+		})
+	}
+	if len(cfg.StunServers) != 0 {
+		go cl.externalAddrLoop()
 	}
 
 	cl.websocketTrackers = websocketTrackers{
@@ -301,6 +412,7 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		Proxy:                      cl.config.HTTPProxy,
 		WebsocketTrackerHttpHeader: cl.config.WebsocketTrackerHttpHeader,
 		DialContext:                cl.config.TrackerDialContext,
+		NumOffers:                  cl.config.WebtorrentNumOffers,
 		OnConn: func(dc datachannel.ReadWriteCloser, dcc webtorrent.DataChannelContext) {
 			cl.lock()
 			defer cl.unlock()
@@ -317,6 +429,11 @@ func NewClient(cfg *ClientConfig) (cl *Client, err error) {
 		},
 	}
 
+	// Started last, as feeds add Torrents.
+	for i := range cfg.Feeds {
+		go cl.feedLoop(&cfg.Feeds[i])
+	}
+
 	return
 }
 
@@ -392,15 +509,10 @@ func (cl *Client) NewAnacrolixDhtServer(conn net.PacketConn) (s *dht.Server, err
 		IPBlocklist:    cl.ipBlockList,
 		Conn:           conn,
 		OnAnnouncePeer: cl.onDHTAnnouncePeer,
-		PublicIP: func() net.IP {
-			if connIsIpv6(conn) && cl.config.PublicIp6 != nil {
-				return cl.config.PublicIp6
-			}
-			return cl.config.PublicIp4
-		}(),
-		StartingNodes: cl.config.DhtStartingNodes(conn.LocalAddr().Network()),
-		OnQuery:       cl.config.DHTOnQuery,
-		Logger:        logger,
+		PublicIP:       cl.dhtPublicIp(conn),
+		StartingNodes:  cl.config.DhtStartingNodes(conn.LocalAddr().Network()),
+		OnQuery:        cl.config.DHTOnQuery,
+		Logger:         logger,
 	}
 	if f := cl.config.ConfigureAnacrolixDhtServer; f != nil {
 		f(&cfg)
@@ -432,11 +544,16 @@ func (cl *Client) Close() (errs []error) {
 			errs = append(errs, err)
 		}
 	}
+	if cl.statsReporter != nil {
+		// This lets the reporter save queued reports before Close returns.
+		cl.statsReporter.stop(&closeGroup)
+	}
 	for i := range cl.onClose {
 		cl.onClose[len(cl.onClose)-1-i]()
 	}
 	cl.closed.Set()
 	cl.unlock()
+	cl.udpTrackers.Close()
 	cl.event.Broadcast()
 	closeGroup.Wait() // defer is LIFO. We want to Wait() after cl.unlock()
 	return
@@ -493,6 +610,7 @@ func (cl *Client) rejectAccepted(conn net.Conn) error {
 }
 
 func (cl *Client) acceptConnections(l Listener) {
+	var errBackoff time.Duration
 	for {
 		conn, err := l.Accept()
 		torrent.Add("client listener accepts", 1)
@@ -512,8 +630,17 @@ func (cl *Client) acceptConnections(l Listener) {
 		}
 		if err != nil {
 			log.Fmsg("error accepting connection: %s", err).LogLevel(log.Debug, cl.logger)
+			errBackoff = acceptErrBackoff(err, errBackoff)
+			if errBackoff != 0 {
+				// Don't spin while out of file descriptors.
+				cl.lock()
+				cl.onFdPressure("accept", err)
+				cl.unlock()
+				time.Sleep(errBackoff)
+			}
 			continue
 		}
+		errBackoff = 0
 		go func() {
 			if reject != nil {
 				torrent.Add("rejected accepted connections", 1)
@@ -564,6 +691,10 @@ func (cl *Client) incomingConnection(nc net.Conn) {
 		c.close()
 	}()
 	c.Discovery = PeerSourceIncoming
+	cl.connectivity.update(false, c.Network, func(s *ConnAttemptStats) {
+		s.Attempts++
+		s.Connected++
+	})
 	cl.runReceivedConn(c)
 }
 
@@ -582,6 +713,8 @@ func (cl *Client) torrent(ih metainfo.Hash) *Torrent {
 type DialResult struct {
 	Conn   net.Conn
 	Dialer Dialer
+	// The error from the last dialer to fail, if Conn is nil.
+	Err error
 }
 
 func countDialResult(err error) {
@@ -608,7 +741,14 @@ func (cl *Client) dopplegangerAddr(addr string) bool {
 
 // Returns a connection over UTP or TCP, whichever is first to connect.
 func (cl *Client) dialFirst(ctx context.Context, addr string) (res DialResult) {
-	return DialFirst(ctx, addr, cl.dialers)
+	for _, d := range cl.dialers {
+		cl.connectivity.update(true, d.DialerNetwork(), func(s *ConnAttemptStats) { s.Attempts++ })
+	}
+	res = DialFirst(ctx, addr, cl.dialers)
+	if res.Conn != nil {
+		cl.connectivity.update(true, res.Dialer.DialerNetwork(), func(s *ConnAttemptStats) { s.Connected++ })
+	}
+	return
 }
 
 // Returns a connection over UTP or TCP, whichever is first to connect.
@@ -632,9 +772,11 @@ func DialFirst(ctx context.Context, addr string, dialers []Dialer) (res DialResu
 		left++
 		s := _s
 		go func() {
+			conn, err := dialFromSocket(ctx, s, addr)
 			resCh <- DialResult{
-				dialFromSocket(ctx, s, addr),
-				s,
+				Conn:   conn,
+				Dialer: s,
+				Err:    err,
 			}
 		}()
 	}
@@ -660,7 +802,7 @@ func DialFirst(ctx context.Context, addr string, dialers []Dialer) (res DialResu
 	return res
 }
 
-func dialFromSocket(ctx context.Context, s Dialer, addr string) net.Conn {
+func dialFromSocket(ctx context.Context, s Dialer, addr string) (net.Conn, error) {
 	c, err := s.Dial(ctx, addr)
 	// This is a bit optimistic, but it looks non-trivial to thread this through the proxy code. Set
 	// it now in case we close the connection forthwith.
@@ -668,7 +810,7 @@ func dialFromSocket(ctx context.Context, s Dialer, addr string) net.Conn {
 		tc.SetLinger(0)
 	}
 	countDialResult(err)
-	return c
+	return c, err
 }
 
 func forgettableDialError(err error) bool {
@@ -727,6 +869,9 @@ func (cl *Client) establishOutgoingConnEx(t *Torrent, addr PeerRemoteAddr, obfus
 		if dialCtx.Err() != nil {
 			return nil, fmt.Errorf("dialing: %w", dialCtx.Err())
 		}
+		if dr.Err != nil {
+			return nil, fmt.Errorf("dial failed: %w", dr.Err)
+		}
 		return nil, errors.New("dial failed")
 	}
 	addrIpPort, _ := tryIpPortFromNetAddr(addr)
@@ -740,6 +885,8 @@ func (cl *Client) establishOutgoingConnEx(t *Torrent, addr PeerRemoteAddr, obfus
 	})
 	if err != nil {
 		nc.Close()
+	} else {
+		cl.connectivity.update(true, dr.Dialer.DialerNetwork(), func(s *ConnAttemptStats) { s.Handshook++ })
 	}
 	return c, err
 }
@@ -783,7 +930,10 @@ func (cl *Client) outgoingConnection(t *Torrent, addr PeerRemoteAddr, ps PeerSou
 	// failure.
 	cl.noLongerHalfOpen(t, addr.String())
 	if err != nil {
-		if cl.config.Debug {
+		if fdExhausted(err) {
+			cl.onFdPressure("dial", err)
+		}
+		if cl.debugLogging() {
 			cl.logger.Levelf(log.Debug, "error establishing outgoing connection to %v: %v", addr, err)
 		}
 		return
@@ -794,8 +944,12 @@ func (cl *Client) outgoingConnection(t *Torrent, addr PeerRemoteAddr, ps PeerSou
 	t.runHandshookConnLoggingErr(c)
 }
 
-// The port number for incoming peer connections. 0 if the client isn't listening.
+// The port peers should use to connect to us. This may differ from the local port. See
+// ClientConfig.AdvertisedPort. 0 if the client isn't listening and no port is advertised.
 func (cl *Client) incomingPeerPort() int {
+	if cl.config.AdvertisedPort != 0 {
+		return cl.config.AdvertisedPort
+	}
 	return cl.LocalPort()
 }
 
@@ -919,11 +1073,14 @@ func (cl *Client) connBtHandshake(c *PeerConn, ih *metainfo.Hash) (ret metainfo.
 }
 
 func (cl *Client) runReceivedConn(c *PeerConn) {
+	// Waiting for a slot doesn't count against the handshake timeout.
+	cl.handshakeWorkers.acquire()
 	err := c.conn.SetDeadline(time.Now().Add(cl.config.HandshakesTimeout))
 	if err != nil {
 		panic(err)
 	}
 	t, err := cl.receiveHandshakes(c)
+	cl.handshakeWorkers.release()
 	if err != nil {
 		cl.logger.LazyLog(log.Debug, func() log.Msg {
 			return log.Fmsg(
@@ -949,6 +1106,7 @@ func (cl *Client) runReceivedConn(c *PeerConn) {
 		return
 	}
 	torrent.Add("received handshake for loaded torrent", 1)
+	cl.connectivity.update(false, c.Network, func(s *ConnAttemptStats) { s.Handshook++ })
 	c.conn.SetWriteDeadline(time.Time{})
 	cl.lock()
 	defer cl.unlock()
@@ -977,6 +1135,19 @@ func (cl *Client) runHandshookConn(c *PeerConn, t *Torrent) error {
 		return nil
 	}
 	c.r = deadlineReader{c.conn, c.r}
+	c.r = &rateLimitedReader{
+		l: t.downloadLimiter,
+		r: c.r,
+	}
+	c.r = &namespaceLimitedReader{t: t, r: c.r}
+	if cl.config.FairRateSharing {
+		c.uploadLimiter = rate.NewLimiter(rate.Inf, torrentRateBurst)
+		c.downloadLimiter = rate.NewLimiter(rate.Inf, torrentRateBurst)
+		c.r = &rateLimitedReader{
+			l: c.downloadLimiter,
+			r: c.r,
+		}
+	}
 	completedHandshakeConnectionFlags.Add(c.connectionFlags(), 1)
 	if connIsIpv6(c.conn) {
 		torrent.Add("completed handshake over ipv6", 1)
@@ -990,6 +1161,7 @@ func (cl *Client) runHandshookConn(c *PeerConn, t *Torrent) error {
 	c.initUpdateRequestsTimer()
 	err := c.mainReadLoop()
 	if err != nil {
+		c.setCloseReason(readLoopDisconnectReason(err))
 		return fmt.Errorf("main read loop: %w", err)
 	}
 	return nil
@@ -1052,17 +1224,35 @@ func (cl *Client) sendInitialMessages(conn *PeerConn, torrent *Torrent) {
 					Port:         cl.incomingPeerPort(),
 					MetadataSize: torrent.metadataSize(),
 					// TODO: We can figure these out specific to the socket used.
-					Ipv4: pp.CompactIp(cl.config.PublicIp4.To4()),
-					Ipv6: cl.config.PublicIp6.To16(),
+					Ipv4: pp.CompactIp(cl.publicIp4().To4()),
+					Ipv6: cl.publicIp6().To16(),
 				}
+				msg.Metadata = cl.handshakeMetadata()
 				if !cl.config.DisablePEX {
 					msg.M[pp.ExtensionNamePex] = pexExtendedId
 				}
+				if !cl.config.DisableHaveBatching {
+					msg.M[pp.ExtensionNameHaveBatch] = haveBatchExtendedId
+				}
+				if torrent.preSharedKey != nil {
+					msg.M[pp.ExtensionNamePayloadCrypt] = payloadCryptExtendedId
+				}
+				if cl.config.PieceCompression {
+					msg.M[pp.ExtensionNamePieceCompression] = pieceCompressionExtendedId
+				}
+				if cl.config.UploadReceipts {
+					msg.M[pp.ExtensionNameUploadReceipt] = uploadReceiptExtendedId
+				}
 				return bencode.MustMarshal(msg)
 			}(),
 		})
 	}
 	func() {
+		if conn.deferBitfieldForHaveBatch() {
+			conn.bitfieldDeferred = true
+			conn.deferredBitfieldTimer = time.AfterFunc(deferredBitfieldTimeout, conn.deferredBitfieldTimedOut)
+			return
+		}
 		if conn.fastEnabled() {
 			if torrent.haveAllPieces() {
 				conn.write(pp.Message{Type: pp.HaveAll})
@@ -1196,28 +1386,39 @@ func (cl *Client) newTorrentOpt(opts AddTorrentOpts) (t *Torrent) {
 				if p.BaselineProvider {
 					return math.MaxUint32
 				}
+				// Then the peers ranked fastest by trackers, in order.
+				if p.TrackerSpeedRank > 0 {
+					return math.MaxUint32 - uint32(p.TrackerSpeedRank)
+				}
+				if p.TrackerReliability.Ok {
+					return trackerReliabilityPeerPriority(p.TrackerReliability.Value)
+				}
 				ipPort := p.addr()
 				return bep40PriorityIgnoreError(cl.publicAddr(ipPort.IP), ipPort)
 			},
 		},
-		conns: make(map[*PeerConn]struct{}, 2*cl.config.EstablishedConnsPerTorrent),
+		conns: make(map[*PeerConn]struct{}, 2*cl.settings.establishedConnsPerTorrent),
 
 		halfOpen: make(map[string]PeerInfo),
 
 		storageOpener:       storageClient,
-		maxEstablishedConns: cl.config.EstablishedConnsPerTorrent,
+		maxEstablishedConns: cl.settings.establishedConnsPerTorrent,
 
 		metadataChanged: sync.Cond{
 			L: cl.locker(),
 		},
 		webSeeds:     make(map[string]*Peer),
 		gotMetainfoC: make(chan struct{}),
+		preSharedKey: opts.PreSharedKey,
+
+		uploadLimiter:   rate.NewLimiter(rate.Inf, torrentRateBurst),
+		downloadLimiter: rate.NewLimiter(rate.Inf, torrentRateBurst),
 	}
+	t.joinTimes.Added = time.Now()
 	t.smartBanCache.Hash = sha1.Sum
 	t.smartBanCache.Init()
 	t.networkingEnabled.Set()
-	t.logger = cl.logger.WithContextValue(t).WithNames("torrent", t.infoHash.HexString())
-	t.sourcesLogger = t.logger.WithNames("sources")
+	t.initLogger()
 	if opts.ChunkSize == 0 {
 		opts.ChunkSize = defaultChunkSize
 	}
@@ -1267,9 +1468,13 @@ func (cl *Client) AddTorrentInfoHashWithStorage(infoHash metainfo.Hash, specStor
 // If the torrent already exists then this Storage is ignored and the
 // existing torrent returned with `new` set to `false`
 func (cl *Client) AddTorrentOpt(opts AddTorrentOpts) (t *Torrent, new bool) {
-	infoHash := opts.InfoHash
 	cl.lock()
 	defer cl.unlock()
+	return cl.addTorrentOptLocked(opts)
+}
+
+func (cl *Client) addTorrentOptLocked(opts AddTorrentOpts) (t *Torrent, new bool) {
+	infoHash := opts.InfoHash
 	t, ok := cl.torrents[infoHash]
 	if ok {
 		return
@@ -1294,27 +1499,77 @@ type AddTorrentOpts struct {
 	InfoHash  infohash.T
 	Storage   storage.ClientImpl
 	ChunkSize pp.Integer
+	// See Torrent.SetPreSharedKey.
+	PreSharedKey []byte
 }
 
 // Add or merge a torrent spec. Returns new if the torrent wasn't already in the client. See also
 // Torrent.MergeSpec.
 func (cl *Client) AddTorrentSpec(spec *TorrentSpec) (t *Torrent, new bool, err error) {
-	t, new = cl.AddTorrentOpt(AddTorrentOpts{
-		InfoHash:  spec.InfoHash,
-		Storage:   spec.Storage,
-		ChunkSize: spec.ChunkSize,
-	})
+	t, new = cl.AddTorrentOpt(spec.addTorrentOpts())
+	err = cl.mergeAddedSpec(t, new, spec)
+	if err != nil && new {
+		t.Drop()
+	}
+	return
+}
+
+func (spec *TorrentSpec) addTorrentOpts() AddTorrentOpts {
+	return AddTorrentOpts{
+		InfoHash:     spec.InfoHash,
+		Storage:      spec.Storage,
+		ChunkSize:    spec.ChunkSize,
+		PreSharedKey: spec.PreSharedKey,
+	}
+}
+
+// Merges a spec into the Torrent it was just used to add.
+func (cl *Client) mergeAddedSpec(t *Torrent, new bool, spec *TorrentSpec) error {
 	modSpec := *spec
+	if modSpec.InfoBytes == nil && t.Info() == nil {
+		mi := cl.cachedMetainfo(spec.InfoHash)
+		// Cached info that isn't trusted is ignored rather than failing the add, so signed info
+		// can still be merged later.
+		if mi != nil && (len(cl.config.TrustedPublishers) == 0 ||
+			cl.checkPublisherSignature(mi.InfoBytes, mi.Signatures) == nil) {
+			modSpec.InfoBytes = mi.InfoBytes
+			modSpec.Signatures = mi.Signatures
+		}
+	}
 	if new {
 		// ChunkSize was already applied by adding a new Torrent, and MergeSpec disallows changing
 		// it.
 		modSpec.ChunkSize = 0
 	}
-	err = t.MergeSpec(&modSpec)
-	if err != nil && new {
-		t.Drop()
+	return t.MergeSpec(&modSpec)
+}
+
+// Checks that info bytes are signed by one of the trusted publishers.
+func (cl *Client) checkPublisherSignature(infoBytes []byte, sigs map[string]metainfo.Signature) error {
+	for _, cert := range cl.config.TrustedPublishers {
+		err := metainfo.VerifyInfoSignature(infoBytes, sigs, cert)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, metainfo.ErrNotSigned) {
+			return fmt.Errorf("verifying signature by %q: %w", cert.Subject.CommonName, err)
+		}
 	}
-	return
+	return errors.New("torrent not signed by a trusted publisher")
+}
+
+// Sets the info, keeping its signatures for checking against ClientConfig.TrustedPublishers, now
+// and if the info is set later from elsewhere.
+func (t *Torrent) setSignedInfoBytes(b []byte, sigs map[string]metainfo.Signature) error {
+	t.cl.lock()
+	defer t.cl.unlock()
+	for name, sig := range sigs {
+		if t.infoSignatures == nil {
+			t.infoSignatures = make(map[string]metainfo.Signature)
+		}
+		t.infoSignatures[name] = sig
+	}
+	return t.setInfoBytesLocked(b)
 }
 
 type stringAddr string
@@ -1332,7 +1587,7 @@ func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 		t.SetDisplayName(spec.DisplayName)
 	}
 	if spec.InfoBytes != nil {
-		err := t.SetInfoBytes(spec.InfoBytes)
+		err := t.setSignedInfoBytes(spec.InfoBytes, spec.Signatures)
 		if err != nil {
 			return err
 		}
@@ -1340,6 +1595,7 @@ func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 	cl := t.cl
 	cl.AddDhtNodes(spec.DhtNodes)
 	t.UseSources(spec.Sources)
+	t.UseMetadataSources(spec.MetadataSources)
 	cl.lock()
 	defer cl.unlock()
 	t.initialPieceCheckDisabled = spec.DisableInitialPieceCheck
@@ -1358,7 +1614,7 @@ func (t *Torrent) MergeSpec(spec *TorrentSpec) error {
 	}
 	t.addTrackers(spec.Trackers)
 	t.maybeNewConns()
-	t.dataDownloadDisallowed.SetBool(spec.DisallowDataDownload)
+	t.dataDownloadDisallowed.SetBool(spec.DisallowDataDownload || t.storageReadOnly())
 	t.dataUploadDisallowed = spec.DisallowDataUpload
 	return nil
 }
@@ -1370,7 +1626,9 @@ func (cl *Client) dropTorrent(infoHash metainfo.Hash, wg *sync.WaitGroup) (err e
 		return
 	}
 	err = t.close(wg)
+	t.leaveNamespace()
 	delete(cl.torrents, infoHash)
+	t.updateDownloadingForPriority("torrent dropped")
 	return
 }
 
@@ -1477,6 +1735,7 @@ func (cl *Client) banPeerIP(ip net.IP) {
 			if p.remoteIp().Equal(ip) {
 				t.logger.Levelf(log.Warning, "dropping peer %v with banned ip %v", p, ip)
 				// Should this be a close?
+				p.setCloseReason(DisconnectBanned)
 				p.drop()
 			}
 		})
@@ -1523,7 +1782,7 @@ func (cl *Client) newConnection(nc net.Conn, opts newConnectionOpts) (c *PeerCon
 	c.logger = cl.logger.WithDefaultLevel(log.Warning).WithContextValue(c)
 	c.setRW(connStatsReadWriter{nc, c})
 	c.r = &rateLimitedReader{
-		l: cl.config.DownloadRateLimiter,
+		l: cl.settings.downloadRateLimiter,
 		r: c.r,
 	}
 	c.logger.WithDefaultLevel(log.Debug).Printf("initialized with remote %v over network %v (outgoing=%t)", opts.remoteAddr, opts.network, opts.outgoing)
@@ -1576,13 +1835,13 @@ func (cl *Client) publicIp(peer net.IP) net.IP {
 	// TODO: Use BEP 10 to determine how peers are seeing us.
 	if peer.To4() != nil {
 		return firstNotNil(
-			cl.config.PublicIp4,
+			cl.publicIp4(),
 			cl.findListenerIp(func(ip net.IP) bool { return ip.To4() != nil }),
 		)
 	}
 
 	return firstNotNil(
-		cl.config.PublicIp6,
+		cl.publicIp6(),
 		cl.findListenerIp(func(ip net.IP) bool { return ip.To4() == nil }),
 	)
 }
@@ -1616,10 +1875,10 @@ func (cl *Client) ListenAddrs() (ret []net.Addr) {
 }
 
 func (cl *Client) PublicIPs() (ips []net.IP) {
-	if ip := cl.config.PublicIp4; ip != nil {
+	if ip := cl.publicIp4(); ip != nil {
 		ips = append(ips, ip)
 	}
-	if ip := cl.config.PublicIp6; ip != nil {
+	if ip := cl.publicIp6(); ip != nil {
 		ips = append(ips, ip)
 	}
 	return
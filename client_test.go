@@ -1,25 +1,52 @@
 package torrent
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"testing/iotest"
 	"time"
 
 	"github.com/frankban/quicktest"
+	"github.com/pion/stun"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/missinggo/v2"
 	"github.com/anacrolix/missinggo/v2/filecache"
 
@@ -27,7 +54,15 @@ import (
 	"github.com/anacrolix/torrent/internal/testutil"
 	"github.com/anacrolix/torrent/iplist"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/mse"
+	pp "github.com/anacrolix/torrent/peer_protocol"
 	"github.com/anacrolix/torrent/storage"
+	"github.com/anacrolix/torrent/testdata"
+	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+	httpTrackerServer "github.com/anacrolix/torrent/tracker/http/server"
+	trackerServer "github.com/anacrolix/torrent/tracker/server"
+	"github.com/anacrolix/torrent/version"
 )
 
 func TestClientDefault(t *testing.T) {
@@ -846,3 +881,4171 @@ func TestBadPeerIpPort(t *testing.T) {
 		})
 	}
 }
+
+func TestClientStatusJSON(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	_, err = cl.AddTorrent(mi)
+	require.NoError(t, err)
+	b, err := cl.StatusJSON()
+	require.NoError(t, err)
+	var status struct {
+		Torrents []struct {
+			InfoHash string
+			HaveInfo bool
+		}
+		Limits ClientLimitsStatus
+	}
+	require.NoError(t, json.Unmarshal(b, &status))
+	require.Len(t, status.Torrents, 1)
+	assert.Equal(t, mi.HashInfoBytes().HexString(), status.Torrents[0].InfoHash)
+	assert.True(t, status.Torrents[0].HaveInfo)
+	assert.EqualValues(t, -1, status.Limits.UploadRate)
+}
+
+func TestClientPeerIdPrefix(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.Bep20 = version.ReliableBTBep20Prefix
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	id := cl.PeerID()
+	assert.Equal(t, version.ReliableBTBep20Prefix, string(id[:len(version.ReliableBTBep20Prefix)]))
+	cfg = TestingConfig(t)
+	cfg.Bep20 = "-RB0001-0123456789abcdef"
+	_, err = NewClient(cfg)
+	assert.Error(t, err)
+}
+
+func TestAdvertisedPort(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.AdvertisedPort = 6881
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	assert.NotEqual(t, 6881, cl.LocalPort())
+	tt, _ := cl.AddTorrentInfoHash(metainfo.Hash{})
+	cl.lock()
+	defer cl.unlock()
+	assert.EqualValues(t, 6881, tt.announceRequest(tracker.Started).Port)
+}
+
+func TestConnectivityStats(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, _ := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	sum := func(m map[string]ConnAttemptStats) (ret ConnAttemptStats) {
+		for _, s := range m {
+			ret.Attempts += s.Attempts
+			ret.Connected += s.Connected
+			ret.Handshook += s.Handshook
+		}
+		return
+	}
+	out := sum(leecher.ConnectivityStats().Outgoing)
+	assert.NotZero(t, out.Handshook)
+	assert.GreaterOrEqual(t, out.Attempts, out.Connected)
+	assert.GreaterOrEqual(t, out.Connected, out.Handshook)
+	in := sum(seeder.ConnectivityStats().Incoming)
+	assert.NotZero(t, in.Handshook)
+	assert.Greater(t, in.SuccessRatio(), 0.0)
+	assert.LessOrEqual(t, in.SuccessRatio(), 1.0)
+}
+
+func TestHaveBatchingBetweenReliableBTPeers(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.Bep20 = version.ReliableBTBep20Prefix
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	cfg.Bep20 = version.ReliableBTBep20Prefix
+	var supported bool
+	cfg.Callbacks.ReadExtendedHandshake = func(_ *PeerConn, msg *pp.ExtendedHandshakeMessage) {
+		// Called with the Client lock held.
+		supported = msg.M[pp.ExtensionNameHaveBatch] != 0
+	}
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, _ := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	leecher.rLock()
+	defer leecher.rUnlock()
+	assert.True(t, supported)
+}
+
+func TestMetadataSourcesCacheDir(t *testing.T) {
+	greetingDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingDataDir)
+	cacheDir := t.TempDir()
+	f, err := os.Create(filepath.Join(cacheDir, mi.HashInfoBytes().HexString()+".torrent"))
+	require.NoError(t, err)
+	require.NoError(t, mi.Write(f))
+	require.NoError(t, f.Close())
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: mi.HashInfoBytes(),
+		MetadataSources: []MetadataSource{
+			{CacheDir: t.TempDir()},
+			{PeerAddr: "127.0.0.1:1", Timeout: time.Millisecond},
+			{CacheDir: cacheDir},
+		},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, tt.WaitForInfo(ctx))
+	assert.Equal(t, mi.HashInfoBytes(), tt.Metainfo().HashInfoBytes())
+}
+
+func TestTorrentCacheDir(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	cacheDir := t.TempDir()
+	cfg = TestingConfig(t)
+	cfg.TorrentCacheDir = cacheDir
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	tt, _, err := leecher.AddTorrentSpec(&TorrentSpec{InfoHash: mi.HashInfoBytes()})
+	require.NoError(t, err)
+	tt.AddClientPeer(seeder)
+	<-tt.GotInfo()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(cacheDir, mi.HashInfoBytes().HexString()+".torrent"))
+		return err == nil
+	}, 10*time.Second, 10*time.Millisecond)
+	cfg = TestingConfig(t)
+	cfg.TorrentCacheDir = cacheDir
+	other, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer other.Close()
+	tt, _, err = other.AddTorrentSpec(&TorrentSpec{InfoHash: mi.HashInfoBytes()})
+	require.NoError(t, err)
+	require.NotNil(t, tt.Info())
+}
+
+func TestTrackerTierFailover(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events[r.URL.Path] = append(events[r.URL.Path], r.URL.Query().Get("event"))
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/bad") {
+			bencode.NewEncoder(w).Encode(map[string]interface{}{"failure reason": "nope"})
+			return
+		}
+		bencode.NewEncoder(w).Encode(map[string]interface{}{"interval": 1800, "peers": ""})
+	}))
+	defer s.Close()
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.TrackerTierFailover = true
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Trackers: [][]string{
+			{s.URL + "/bad1", s.URL + "/bad2"},
+			{s.URL + "/bad3", s.URL + "/good"},
+			{s.URL + "/lowest"},
+		},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, ts := range tt.TrackerStatuses() {
+			if ts.Current {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, time.Millisecond)
+	statuses := tt.TrackerStatuses()
+	require.Len(t, statuses, 5)
+	// Every tracker in the first tier failed before the second tier was tried.
+	for _, ts := range statuses[:2] {
+		assert.Equal(t, 0, ts.Tier)
+		assert.False(t, ts.Current)
+		assert.Error(t, ts.Err)
+	}
+	// The working tracker is at the front of its tier.
+	assert.Equal(t, s.URL+"/good", statuses[2].Url)
+	assert.Equal(t, 1, statuses[2].Tier)
+	assert.True(t, statuses[2].Current)
+	assert.NoError(t, statuses[2].Err)
+	assert.Equal(t, 30*time.Minute, statuses[2].Interval)
+	assert.Equal(t, s.URL+"/bad3", statuses[3].Url)
+	// Lower tiers aren't tried once a tracker works.
+	assert.Equal(t, 2, statuses[4].Tier)
+	assert.True(t, statuses[4].LastAnnounce.IsZero())
+	mu.Lock()
+	assert.Equal(t, []string{"started"}, events["/good"])
+	assert.Empty(t, events["/lowest"])
+	mu.Unlock()
+	// Only the trackers that are tracking us are told we've stopped.
+	tt.Drop()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events["/good"]) == 2
+	}, 10*time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, "stopped", events["/good"][1])
+	assert.Len(t, events["/bad1"], 1)
+	mu.Unlock()
+}
+
+func TestTrackerMessages(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			bencode.NewEncoder(w).Encode(map[string]interface{}{"failure reason": "unregistered torrent"})
+			return
+		}
+		bencode.NewEncoder(w).Encode(map[string]interface{}{
+			"interval":        1800,
+			"peers":           "",
+			"warning message": "client version outdated",
+		})
+	}))
+	defer s.Close()
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	sub := tt.Events()
+	defer sub.Close()
+	tt.AddTrackers([][]string{{s.URL + "/warn", s.URL + "/fail"}})
+	events := make(map[string]TrackerAnnounceResultEvent)
+	timeout := time.After(10 * time.Second)
+	for len(events) < 2 {
+		select {
+		case v := <-sub.Values:
+			if e, ok := v.(TrackerAnnounceResultEvent); ok {
+				events[e.Url] = e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for announces")
+		}
+	}
+	assert.NoError(t, events[s.URL+"/warn"].Err)
+	assert.Equal(t, "client version outdated", events[s.URL+"/warn"].Warning)
+	assert.Error(t, events[s.URL+"/fail"].Err)
+	assert.Equal(t, "unregistered torrent", events[s.URL+"/fail"].FailureReason)
+	statuses := tt.TrackerStatuses()
+	require.Len(t, statuses, 2)
+	for _, ts := range statuses {
+		switch ts.Url {
+		case s.URL + "/warn":
+			assert.Equal(t, "client version outdated", ts.Warning)
+			assert.Empty(t, ts.FailureReason)
+		case s.URL + "/fail":
+			assert.Empty(t, ts.Warning)
+			assert.Equal(t, "unregistered torrent", ts.FailureReason)
+		}
+	}
+}
+
+func TestTrackerAnnounceIntervals(t *testing.T) {
+	var mu sync.Mutex
+	announces := make(map[string]int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		announces[r.URL.Path]++
+		mu.Unlock()
+		resp := map[string]interface{}{"interval": 1800, "peers": ""}
+		if r.URL.Path == "/min" {
+			resp["min interval"] = 1800
+		}
+		bencode.NewEncoder(w).Encode(resp)
+	}))
+	defer s.Close()
+	getAnnounces := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return announces[path]
+	}
+	addTorrent := func(cfg *ClientConfig) *Torrent {
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { cl.Close() })
+		tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+			InfoHash: metainfo.Hash{1},
+			Trackers: [][]string{{s.URL + "/clamped", s.URL + "/min"}},
+		})
+		require.NoError(t, err)
+		return tt
+	}
+	// The interval is clamped, but not below the tracker's min interval.
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.MinAnnounceInterval = time.Millisecond
+	cfg.MaxAnnounceInterval = 10 * time.Millisecond
+	tt := addTorrent(cfg)
+	require.Eventually(t, func() bool {
+		return getAnnounces("/clamped") >= 3
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, 1, getAnnounces("/min"))
+	tt.Drop()
+	mu.Lock()
+	announces = make(map[string]int)
+	mu.Unlock()
+	// Forced reannounces also respect the min interval.
+	cfg = TestingConfig(t)
+	cfg.DisableTrackers = false
+	tt = addTorrent(cfg)
+	require.Eventually(t, func() bool {
+		return getAnnounces("/clamped") == 1 && getAnnounces("/min") == 1
+	}, 10*time.Second, time.Millisecond)
+	tt.ForceReannounce()
+	require.Eventually(t, func() bool {
+		return getAnnounces("/clamped") == 2
+	}, 10*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, getAnnounces("/min"))
+}
+
+func TestTrackerAnnounceLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events[r.URL.Path] = append(events[r.URL.Path], r.URL.Query().Get("event"))
+		mu.Unlock()
+		bencode.NewEncoder(w).Encode(map[string]interface{}{"interval": 1800, "peers": ""})
+	}))
+	defer s.Close()
+	getEvents := func(path string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events[path]...)
+	}
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DisableTrackers = false
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	spec := TorrentSpecFromMetaInfo(mi)
+	spec.Trackers = [][]string{{s.URL + "/leech"}}
+	leecherTorrent, _, err := leecher.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	leecherTorrent.DownloadAll()
+	leecherTorrent.AddClientPeer(seeder)
+	<-leecherTorrent.Complete.On()
+	require.Eventually(t, func() bool {
+		return len(getEvents("/leech")) == 2
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, []string{"started", "completed"}, getEvents("/leech"))
+	// Dropping waits for the stopped event to be announced.
+	leecherTorrent.Drop()
+	assert.Equal(t, []string{"started", "completed", "stopped"}, getEvents("/leech"))
+	// Torrents that were complete when added don't announce completed, and closing the Client
+	// waits for stopped too.
+	spec.Trackers = [][]string{{s.URL + "/seed"}}
+	cfg = TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.DataDir = seederDataDir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	tt.VerifyData()
+	require.True(t, tt.Complete.Bool())
+	require.Eventually(t, func() bool {
+		return len(getEvents("/seed")) == 1
+	}, 10*time.Second, time.Millisecond)
+	cl.Close()
+	assert.Equal(t, []string{"started", "stopped"}, getEvents("/seed"))
+}
+
+func TestPreviewTorrent(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ih := r.URL.Query().Get("info_hash")
+		bencode.NewEncoder(w).Encode(map[string]interface{}{
+			"files": map[string]interface{}{
+				ih: map[string]int{"complete": 4, "incomplete": 6},
+			},
+		})
+	}))
+	defer s.Close()
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p := cl.PreviewTorrent(ctx, &TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Trackers: [][]string{{s.URL + "/announce", s.URL + "/a"}},
+	})
+	require.Len(t, p.Trackers, 2)
+	assert.Equal(t, 4, p.Seeders)
+	assert.Equal(t, 6, p.Leechers)
+	assert.Equal(t, 7.0, p.Availability)
+	cl.rLock()
+	assert.Empty(t, cl.torrents)
+	cl.rUnlock()
+}
+
+func TestHandshakeMetadata(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.ExperimentId = "exp1"
+	cfg.HandshakeMetadata = map[string]string{"region": "us-west"}
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, _ := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	leecherTorrent.DownloadAll()
+	leecherTorrent.AddClientPeer(seeder)
+	var got map[string]string
+	require.Eventually(t, func() bool {
+		for _, c := range leecherTorrent.PeerConns() {
+			got = c.PeerHandshakeMetadata()
+			if got != nil {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, map[string]string{"experiment_id": "exp1", "region": "us-west"}, got)
+	// The leecher has no labels to send.
+	for _, c := range seederTorrent.PeerConns() {
+		assert.Nil(t, c.PeerHandshakeMetadata())
+	}
+}
+
+func TestDecodeHandshakeMetadata(t *testing.T) {
+	m := map[string]bencode.Bytes{
+		"region":                 bencode.MustMarshal("us-west"),
+		"capacity":               bencode.MustMarshal(3),
+		"tags":                   bencode.MustMarshal(map[string]int{"a": 1}),
+		"long":                   bencode.MustMarshal(strings.Repeat("x", maxPeerHandshakeMetadataLen+1)),
+		strings.Repeat("k", 257): bencode.MustMarshal("v"),
+	}
+	assert.Equal(t, map[string]string{"region": "us-west"}, decodeHandshakeMetadata(m))
+	assert.Nil(t, decodeHandshakeMetadata(nil))
+	// Labels past the limit are dropped.
+	m = make(map[string]bencode.Bytes)
+	for i := 0; i < 2*maxPeerHandshakeMetadataLabels; i++ {
+		m[fmt.Sprintf("%03d", i)] = bencode.MustMarshal("v")
+	}
+	got := decodeHandshakeMetadata(m)
+	assert.Len(t, got, maxPeerHandshakeMetadataLabels)
+	assert.Contains(t, got, "000")
+	// A handshake with labels of unexpected types still decodes.
+	var d pp.ExtendedHandshakeMessage
+	require.NoError(t, bencode.Unmarshal([]byte("d1:v4:test8:rbt_metad1:ai1e1:b2:xyee"), &d))
+	assert.Equal(t, "test", d.V)
+	assert.Equal(t, map[string]string{"b": "xy"}, decodeHandshakeMetadata(d.Metadata))
+}
+
+func TestAuthorizeUpload(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	var (
+		mu      sync.Mutex
+		allowed bool
+		denied  int
+	)
+	leecherPeerId := PeerID{'l'}
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.AuthorizeUpload = func(r UploadRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Conn.PeerID != leecherPeerId {
+			return errors.New("unknown peer")
+		}
+		if r.Index == 1 && !allowed {
+			denied++
+			return errors.New("not yet")
+		}
+		return nil
+	}
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, _ := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.PeerID = string(leecherPeerId[:])
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, _ := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	leecherTorrent.DownloadAll()
+	leecherTorrent.AddClientPeer(seeder)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return denied != 0 && leecherTorrent.PieceState(0).Complete && leecherTorrent.PieceState(2).Complete
+	}, 10*time.Second, time.Millisecond)
+	assert.False(t, leecherTorrent.PieceState(1).Complete)
+	mu.Lock()
+	allowed = true
+	mu.Unlock()
+	<-leecherTorrent.Complete.On()
+}
+
+func TestTrackerAuth(t *testing.T) {
+	type auth struct {
+		user, token, passkey string
+	}
+	var mu sync.Mutex
+	got := make(map[string]auth)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		mu.Lock()
+		got[r.URL.Path] = auth{user, r.Header.Get("X-Token"), r.URL.Query().Get("passkey")}
+		mu.Unlock()
+		bencode.NewEncoder(w).Encode(map[string]interface{}{"interval": 1800, "peers": ""})
+	}))
+	defer s.Close()
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.TrackerHTTPHeaders = http.Header{"X-Token": {"default"}}
+	cfg.TrackerCredentials = func(u *url.URL) (ret TrackerCredentials) {
+		if u.Path == "/creds/announce" {
+			ret.Username = "alice"
+			ret.Header = http.Header{"X-Token": {"alice's"}}
+		}
+		return
+	}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	u, err := url.Parse(s.URL + "/url/announce?passkey=abc")
+	require.NoError(t, err)
+	u.User = url.UserPassword("bob", "secret")
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Trackers: [][]string{{u.String(), s.URL + "/creds/announce"}},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, auth{"bob", "default", "abc"}, got["/url/announce"])
+	assert.Equal(t, auth{"alice", "alice's", ""}, got["/creds/announce"])
+}
+
+func TestScrape(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		files := make(map[string]interface{})
+		for i, ih := range r.URL.Query()["info_hash"] {
+			files[ih] = map[string]int{"complete": i + 1, "incomplete": 2, "downloaded": 3}
+		}
+		bencode.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+	}))
+	defer s.Close()
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := cl.ScrapeTracker(ctx, s.URL+"/announce", []metainfo.Hash{{1}, {2}})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, metainfo.Hash{2}, res[1].InfoHash)
+	assert.EqualValues(t, 2, res[1].Seeders)
+	assert.EqualValues(t, 2, res[1].Leechers)
+	assert.EqualValues(t, 3, res[1].Completed)
+	_, err = cl.ScrapeTracker(ctx, s.URL+"/a", []metainfo.Hash{{1}})
+	assert.Error(t, err)
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Trackers: [][]string{{s.URL + "/announce"}, {s.URL + "/a"}},
+	})
+	require.NoError(t, err)
+	trs := tt.Scrape(ctx)
+	require.Len(t, trs, 2)
+	for _, tr := range trs {
+		if tr.Url == s.URL+"/a" {
+			assert.Error(t, tr.Err)
+			continue
+		}
+		require.NoError(t, tr.Err)
+		assert.Equal(t, metainfo.Hash{1}, tr.InfoHash)
+		assert.EqualValues(t, 1, tr.Seeders)
+	}
+}
+
+func TestDeadTorrentPolicy(t *testing.T) {
+	for _, action := range []DeadTorrentAction{DeadTorrentPause, DeadTorrentDrop} {
+		t.Run(action.String(), func(t *testing.T) {
+			greetingDataDir, mi := testutil.GreetingTestTorrent()
+			defer os.RemoveAll(greetingDataDir)
+			cfg := TestingConfig(t)
+			cfg.DeadTorrentPolicy = DeadTorrentPolicy{After: time.Hour, Action: action}
+			var events []DeadTorrentEvent
+			cfg.Callbacks.DeadTorrent = append(cfg.Callbacks.DeadTorrent, func(e DeadTorrentEvent) {
+				events = append(events, e)
+			})
+			cl, err := NewClient(cfg)
+			require.NoError(t, err)
+			defer cl.Close()
+			tt, err := cl.AddTorrent(mi)
+			require.NoError(t, err)
+			now := time.Now()
+			cl.applyDeadTorrentPolicy(now)
+			require.Empty(t, events)
+			cl.applyDeadTorrentPolicy(now.Add(2 * time.Hour))
+			require.Len(t, events, 1)
+			assert.Equal(t, tt, events[0].Torrent)
+			assert.Equal(t, action, events[0].Action)
+			switch action {
+			case DeadTorrentPause:
+				assert.True(t, tt.dataDownloadDisallowed.Bool())
+				assert.Len(t, cl.Torrents(), 1)
+			case DeadTorrentDrop:
+				assert.Empty(t, cl.Torrents())
+			}
+			// The policy is only applied once.
+			cl.applyDeadTorrentPolicy(now.Add(4 * time.Hour))
+			assert.Len(t, events, 1)
+			if action != DeadTorrentPause {
+				return
+			}
+			// A seeder brings it back, so the policy can be applied again.
+			tt.AddWebSeeds([]string{"http://127.0.0.1:1/"})
+			cl.applyDeadTorrentPolicy(now.Add(5 * time.Hour))
+			cl.lock()
+			assert.False(t, tt.deadState.dead)
+			cl.unlock()
+			assert.Len(t, events, 1)
+		})
+	}
+}
+
+func TestApplyConfigDeadTorrentPolicy(t *testing.T) {
+	cfg := TestingConfig(t)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	loopRunning := func() bool {
+		cl.lock()
+		defer cl.unlock()
+		return cl.deadTorrentPolicyLoopRunning
+	}
+	assert.False(t, loopRunning())
+	newCfg := *cfg
+	newCfg.DeadTorrentPolicy = DeadTorrentPolicy{After: 10 * time.Second}
+	assert.Equal(t, []ConfigChange{{"DeadTorrentPolicy", false}}, cl.ApplyConfig(&newCfg))
+	assert.True(t, loopRunning())
+	cl.ApplyConfig(cfg)
+	assert.Eventually(t, func() bool { return !loopRunning() }, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestFileChangedExternally(t *testing.T) {
+	greetingDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingDataDir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingDataDir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	require.True(t, tt.Complete.Bool())
+	// Records the initial file states.
+	tt.checkFilesChanged()
+	tt.checkFilesChanged()
+	require.True(t, tt.Complete.Bool())
+	name := filepath.Join(greetingDataDir, tt.Name())
+	require.NoError(t, os.WriteFile(name, []byte("hello, world!\n"), 0o644))
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(name, future, future))
+	tt.checkFilesChanged()
+	assert.False(t, tt.Complete.Bool())
+	// The first piece is unchanged, and the following pieces should fail.
+	assert.Eventually(t, func() bool {
+		ps := tt.Piece(1).State()
+		return !ps.Checking && !ps.QueuedForHash && !ps.Complete
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestSeedFromReadOnlyStorage(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	// Read-only storage is seeded regardless.
+	cfg.Seed = false
+	cfg.DefaultStorage = storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: seederDataDir,
+		ReadOnly:      true,
+	})
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	require.True(t, seederTorrent.Complete.Bool())
+	cfg = TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+}
+
+func TestReadOnlyStorageDoesntDownload(t *testing.T) {
+	greetingDataDir, mi := testutil.GreetingTestTorrent()
+	os.RemoveAll(greetingDataDir)
+	cfg := TestingConfig(t)
+	cfg.DefaultStorage = storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: t.TempDir(),
+		ReadOnly:      true,
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.DownloadAll()
+	cl.rLock()
+	assert.False(t, tt.needData())
+	cl.rUnlock()
+	// Readers wait for data that can't arrive, as they do whenever downloading is disallowed.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := tt.NewReader()
+	defer r.Close()
+	_, err = r.ReadContext(ctx, make([]byte, 1))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestVerifyManifest(t *testing.T) {
+	greetingDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingDataDir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingDataDir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	sum := sha256.Sum256([]byte(testutil.GreetingFileContents))
+	m, err := ParseManifest(strings.NewReader(fmt.Sprintf(
+		"%x *./%s\n%x  missing-c\n%x  missing-a\n%x  missing-b\n",
+		sum, testutil.GreetingFileName, sum, sum, sum)))
+	require.NoError(t, err)
+	res, err := tt.VerifyManifest(context.Background(), m)
+	require.NoError(t, err)
+	require.Len(t, res, 4)
+	assert.True(t, res[0].Ok())
+	assert.Equal(t, testutil.GreetingFileName, res[0].Path)
+	// Paths missing from the torrent are in a stable order.
+	for i, path := range []string{"missing-a", "missing-b", "missing-c"} {
+		assert.Equal(t, path, res[i+1].Path)
+		assert.ErrorIs(t, res[i+1].Err, ErrFileNotInTorrent)
+	}
+	m[testutil.GreetingFileName] = sha256.Sum256(nil)
+	res, err = tt.VerifyManifest(context.Background(), m)
+	require.NoError(t, err)
+	assert.NoError(t, res[0].Err)
+	assert.False(t, res[0].Ok())
+}
+
+func TestTrustedPublishers(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "publisher"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	greetingDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingDataDir)
+	cfg := TestingConfig(t)
+	cfg.TrustedPublishers = []*x509.Certificate{cert}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	_, err = cl.AddTorrent(mi)
+	assert.Error(t, err)
+	assert.Empty(t, cl.Torrents())
+	// Torrents can be added without info, but unsigned info isn't accepted for them from anywhere.
+	tt, _ := cl.AddTorrentInfoHash(mi.HashInfoBytes())
+	assert.Error(t, tt.SetInfoBytes(mi.InfoBytes))
+	_, err = cl.AddTorrent(mi)
+	assert.Error(t, err)
+	assert.Nil(t, tt.Info())
+	tt.Drop()
+	tt, err = cl.AddMagnet(mi.Magnet(nil, nil).String())
+	require.NoError(t, err)
+	assert.Error(t, tt.MergeSpec(TorrentSpecFromMetaInfo(mi)))
+	assert.Nil(t, tt.Info())
+	// Info fetched from peers is rejected once, rather than fetched over and over.
+	seederCfg := TestingConfig(t)
+	seederCfg.Seed = true
+	seederCfg.DataDir = greetingDataDir
+	seeder, err := NewClient(seederCfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	_, err = seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.AddClientPeer(seeder)
+	require.Eventually(t, func() bool {
+		p := tt.MetadataProgress()
+		return p.Pieces != 0 && p.PiecesHave == p.Pieces
+	}, 10*time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	p := tt.MetadataProgress()
+	assert.Equal(t, p.Pieces, p.PiecesHave)
+	assert.False(t, p.Complete)
+	require.NoError(t, mi.Sign(cert, key, false))
+	assert.NoError(t, tt.MergeSpec(TorrentSpecFromMetaInfo(mi)))
+	assert.NotNil(t, tt.Info())
+	tt.Drop()
+	_, err = cl.AddTorrent(mi)
+	assert.NoError(t, err)
+}
+
+func testPayloadCrypt(t *testing.T, seederKey, leecherKey []byte) (completed bool) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	spec := TorrentSpecFromMetaInfo(mi)
+	spec.PreSharedKey = seederKey
+	seederTorrent, _, err := seeder.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	spec = TorrentSpecFromMetaInfo(mi)
+	spec.PreSharedKey = leecherKey
+	leecherTorrent, _, err := leecher.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	select {
+	case <-leecherTorrent.Complete.On():
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestPayloadCrypt(t *testing.T) {
+	assert.True(t, testPayloadCrypt(t, []byte("secret"), []byte("secret")))
+	assert.False(t, testPayloadCrypt(t, []byte("secret"), []byte("other secret")))
+	assert.False(t, testPayloadCrypt(t, []byte("secret"), nil))
+}
+
+func TestPieceCompression(t *testing.T) {
+	data := strings.Repeat("2022-01-01T00:00:00Z INFO something happened\n", 10000)
+	tor := testutil.Torrent{
+		Files: []testutil.File{{Data: data}},
+		Name:  "log",
+	}
+	mi := tor.Metainfo(1 << 16)
+	seederDataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(seederDataDir, "log"), []byte(data), 0o644))
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.PieceCompression = true
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	spec := TorrentSpecFromMetaInfo(mi)
+	// Compression is applied before encryption.
+	spec.PreSharedKey = []byte("secret")
+	seederTorrent, _, err := seeder.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	cfg.PieceCompression = true
+	// There may be more than one connection to the seeder.
+	conns := make(map[*PeerConn]struct{})
+	cfg.Callbacks.ReceivedUsefulData = append(cfg.Callbacks.ReceivedUsefulData, func(e ReceivedUsefulDataEvent) {
+		conns[e.Peer.peerImpl.(*PeerConn)] = struct{}{}
+	})
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	spec = TorrentSpecFromMetaInfo(mi)
+	spec.PreSharedKey = []byte("secret")
+	leecherTorrent, _, err := leecher.AddTorrentSpec(spec)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	leecher.rLock()
+	defer leecher.rUnlock()
+	var uncompressed, compressed int64
+	for pc := range conns {
+		stats := pc.PieceCompressionStats()
+		uncompressed += stats.BytesReadUncompressed.Int64()
+		compressed += stats.BytesReadCompressed.Int64()
+	}
+	// Chunks can be received more than once.
+	assert.GreaterOrEqual(t, uncompressed, int64(len(data)))
+	assert.Less(t, compressed*10, uncompressed)
+}
+
+func TestDeltaSync(t *testing.T) {
+	oldTorrent := testutil.Torrent{
+		Name: "d",
+		Files: []testutil.File{
+			{Name: "a", Data: "aaaabbbbcccc"},
+			{Name: "o", Data: "dddd"},
+		},
+	}
+	newTorrent := testutil.Torrent{
+		Name: "d",
+		Files: []testutil.File{
+			{Name: "a", Data: "aaaaXXXXcccc"},
+			{Name: "n", Data: "dddd"},
+		},
+	}
+	oldDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(oldDir, "d"), 0o755))
+	for _, f := range oldTorrent.Files {
+		require.NoError(t, os.WriteFile(filepath.Join(oldDir, "d", f.Name), []byte(f.Data), 0o644))
+	}
+	oldInfo := oldTorrent.Info(4)
+	cfg := TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(newTorrent.Metainfo(4))
+	require.NoError(t, err)
+	stats, err := tt.DeltaSync(context.Background(), DeltaSyncOpts{
+		Dir:     oldDir,
+		OldInfo: &oldInfo,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, DeltaSyncStats{PiecesReused: 3, BytesReused: 12}, stats)
+	tt.VerifyData()
+	var completed []bool
+	for i := 0; i < tt.NumPieces(); i++ {
+		completed = append(completed, tt.Piece(i).State().Complete)
+	}
+	assert.Equal(t, []bool{true, false, true, true}, completed)
+}
+
+type testMutableItemDhtServer struct {
+	DhtServer
+	mu    sync.Mutex
+	value []byte
+	seq   int64
+}
+
+func (me *testMutableItemDhtServer) GetMutableItem(ctx context.Context, publicKey [32]byte, salt []byte) ([]byte, int64, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.value, me.seq, nil
+}
+
+// Announces are made for versions without info while they're fetched.
+func (me *testMutableItemDhtServer) Announce([20]byte, int, bool) (DhtAnnounce, error) {
+	return nil, errors.New("announces not supported")
+}
+
+func (me *testMutableItemDhtServer) set(ih metainfo.Hash, seq int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.value = bencode.MustMarshal(mutableTorrentItem{InfoHash: ih[:]})
+	me.seq = seq
+}
+
+func TestMutableTorrent(t *testing.T) {
+	t.Run("DefaultStorage", func(t *testing.T) { testMutableTorrent(t, false) })
+	// Other storage may not put the old data where it's looked for, so nothing is reused.
+	t.Run("CustomStorage", func(t *testing.T) { testMutableTorrent(t, true) })
+}
+
+func testMutableTorrent(t *testing.T, customStorage bool) {
+	v1 := testutil.GreetingMetaInfo()
+	v2 := (&testutil.Torrent{
+		Files: []testutil.File{{Data: "hello,\x00WORLD\n"}},
+		Name:  testutil.GreetingFileName,
+	}).Metainfo(5)
+	cfg := TestingConfig(t)
+	cfg.MutableTorrentPollInterval = time.Millisecond
+	// Provides the info for the magnet links.
+	cfg.TorrentCacheDir = t.TempDir()
+	for _, mi := range []*metainfo.MetaInfo{v1, v2} {
+		require.NoError(t, writeCachedMetainfo(cfg.TorrentCacheDir, mi.HashInfoBytes(), *mi))
+	}
+	if customStorage {
+		fileStorage := storage.NewFileByInfoHash(cfg.DataDir)
+		defer fileStorage.Close()
+		cfg.DefaultStorage = fileStorage
+		dir := filepath.Join(cfg.DataDir, v1.HashInfoBytes().HexString())
+		require.NoError(t, os.Mkdir(dir, 0o755))
+		testutil.CreateDummyTorrentData(dir)
+	} else {
+		testutil.CreateDummyTorrentData(cfg.DataDir)
+	}
+	updated := make(chan MutableTorrentUpdateEvent, 1)
+	cfg.Callbacks.MutableTorrentUpdated = append(cfg.Callbacks.MutableTorrentUpdated, func(e MutableTorrentUpdateEvent) {
+		updated <- e
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	dhtServer := &testMutableItemDhtServer{}
+	dhtServer.set(v1.HashInfoBytes(), 1)
+	cl.lock()
+	cl.AddDhtServer(dhtServer)
+	cl.unlock()
+	mt, err := cl.AddMutableMagnet(context.Background(), metainfo.MutableMagnet{Salt: []byte("salt")}.String())
+	require.NoError(t, err)
+	defer mt.Close()
+	tt := mt.Torrent()
+	assert.Equal(t, v1.HashInfoBytes(), tt.InfoHash())
+	tt.VerifyData()
+	require.True(t, tt.Complete.Bool())
+	dhtServer.set(v2.HashInfoBytes(), 2)
+	e := <-updated
+	assert.Equal(t, tt, e.Old)
+	assert.Equal(t, e.New, mt.Torrent())
+	assert.EqualValues(t, 2, mt.Seq())
+	assert.Equal(t, v2.HashInfoBytes(), e.New.InfoHash())
+	e.New.VerifyData()
+	assert.Equal(t, !customStorage, e.New.Piece(0).State().Complete)
+	assert.False(t, e.New.Piece(1).State().Complete)
+}
+
+// A new version that's still waiting for its info when the MutableTorrent is closed is dropped.
+func TestMutableTorrentClosedDuringMigration(t *testing.T) {
+	v1 := testutil.GreetingMetaInfo()
+	cfg := TestingConfig(t)
+	cfg.MutableTorrentPollInterval = time.Millisecond
+	cfg.TorrentCacheDir = t.TempDir()
+	require.NoError(t, writeCachedMetainfo(cfg.TorrentCacheDir, v1.HashInfoBytes(), *v1))
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	dhtServer := &testMutableItemDhtServer{}
+	dhtServer.set(v1.HashInfoBytes(), 1)
+	cl.lock()
+	cl.AddDhtServer(dhtServer)
+	cl.unlock()
+	mt, err := cl.AddMutableMagnet(context.Background(), metainfo.MutableMagnet{Salt: []byte("salt")}.String())
+	require.NoError(t, err)
+	// No info is available for this version.
+	v2 := metainfo.Hash{2}
+	dhtServer.set(v2, 2)
+	require.Eventually(t, func() bool {
+		_, ok := cl.Torrent(v2)
+		return ok
+	}, 10*time.Second, time.Millisecond)
+	mt.Close()
+	require.Eventually(t, func() bool {
+		_, ok := cl.Torrent(v2)
+		return !ok
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, v1.HashInfoBytes(), mt.Torrent().InfoHash())
+}
+
+func TestFeed(t *testing.T) {
+	mi := testutil.GreetingMetaInfo()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/greeting.torrent", func(w http.ResponseWriter, r *http.Request) {
+		mi.Write(w)
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+<item><title>Greeting v1</title><guid>1</guid><enclosure url="http://%s/greeting.torrent" type="application/x-bittorrent"/></item>
+<item><title>Something else</title><link>magnet:?xt=urn:btih:%s</link></item>
+</channel></rss>`, r.Host, metainfo.Hash{1}.HexString())
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	downloadDir := t.TempDir()
+	testutil.CreateDummyTorrentData(downloadDir)
+	cfg := TestingConfig(t)
+	cfg.Feeds = []Feed{{
+		Url:         s.URL + "/feed.xml",
+		Filters:     []*regexp.Regexp{regexp.MustCompile(`(?i)^greeting`)},
+		DownloadDir: downloadDir,
+	}}
+	added := make(chan FeedEntryAddedEvent, 2)
+	cfg.Callbacks.FeedEntryAdded = append(cfg.Callbacks.FeedEntryAdded, func(e FeedEntryAddedEvent) {
+		added <- e
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	e := <-added
+	assert.Equal(t, "Greeting v1", e.Entry.Title)
+	assert.Equal(t, mi.HashInfoBytes(), e.Torrent.InfoHash())
+	// The data was found in the feed's download directory.
+	e.Torrent.VerifyData()
+	assert.True(t, e.Torrent.Complete.Bool())
+	assert.Len(t, cl.Torrents(), 1)
+}
+
+func TestFeedSeenPersisted(t *testing.T) {
+	entries := int32(1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel>`)
+		for i := int32(1); i <= atomic.LoadInt32(&entries); i++ {
+			fmt.Fprintf(w, `<item><title>%v</title><link>magnet:?xt=urn:btih:%s</link></item>`, i, metainfo.Hash{byte(i)}.HexString())
+		}
+		fmt.Fprintf(w, `</channel></rss>`)
+	}))
+	defer s.Close()
+	seenFile := filepath.Join(t.TempDir(), "seen")
+	newClient := func() (*Client, chan FeedEntryAddedEvent) {
+		cfg := TestingConfig(t)
+		cfg.Feeds = []Feed{{Url: s.URL, SeenFile: seenFile}}
+		added := make(chan FeedEntryAddedEvent, 2)
+		cfg.Callbacks.FeedEntryAdded = append(cfg.Callbacks.FeedEntryAdded, func(e FeedEntryAddedEvent) {
+			added <- e
+		})
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		return cl, added
+	}
+	cl, added := newClient()
+	assert.Equal(t, "1", (<-added).Entry.Title)
+	require.Eventually(t, func() bool {
+		seen, err := loadFeedSeen(seenFile)
+		return err == nil && seen.has("magnet:?xt=urn:btih:"+metainfo.Hash{1}.HexString())
+	}, 10*time.Second, time.Millisecond)
+	cl.Close()
+	atomic.StoreInt32(&entries, 2)
+	cl, added = newClient()
+	defer cl.Close()
+	// The first entry isn't added again.
+	assert.Equal(t, "2", (<-added).Entry.Title)
+	assert.Len(t, cl.Torrents(), 1)
+}
+
+func TestFeedSeenBounded(t *testing.T) {
+	var seen feedSeen
+	for i := 0; i <= maxFeedSeenEntries; i++ {
+		seen.add(strconv.Itoa(i))
+	}
+	assert.False(t, seen.has("0"))
+	assert.True(t, seen.has("1"))
+	assert.True(t, seen.has(strconv.Itoa(maxFeedSeenEntries)))
+	assert.Len(t, seen.ids, maxFeedSeenEntries)
+}
+
+func TestFeedTooLarge(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxFeedSize+1))
+	}))
+	defer s.Close()
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	_, err = cl.getFeed(context.Background(), s.URL)
+	assert.ErrorContains(t, err, "larger than")
+}
+
+func TestParseAtomFeed(t *testing.T) {
+	entries, err := parseFeed([]byte(`<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<entry><title> A </title><id>urn:a</id><link href="http://example.com/a"/><link rel="enclosure" href="http://example.com/a.torrent"/></entry>
+<entry><title>B</title><link href="magnet:?xt=urn:btih:0000000000000000000000000000000000000000"/></entry>
+</feed>`))
+	require.NoError(t, err)
+	assert.Equal(t, []FeedEntry{
+		{Title: "A", Id: "urn:a", Url: "http://example.com/a.torrent"},
+		{Title: "B", Id: "magnet:?xt=urn:btih:0000000000000000000000000000000000000000", Url: "magnet:?xt=urn:btih:0000000000000000000000000000000000000000"},
+	}, entries)
+}
+
+func TestAddTorrents(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	greeting := testutil.GreetingMetaInfo()
+	other := (&testutil.Torrent{
+		Files: []testutil.File{{Data: "other"}},
+		Name:  "other",
+	}).Metainfo(5)
+	bad := *TorrentSpecFromMetaInfo(other)
+	bad.InfoBytes = []byte("garbage")
+	_, err = cl.AddTorrents([]TorrentSpec{*TorrentSpecFromMetaInfo(greeting), bad})
+	var addErr AddTorrentsError
+	require.ErrorAs(t, err, &addErr)
+	assert.NoError(t, addErr.Errs[0])
+	assert.Error(t, addErr.Errs[1])
+	assert.Empty(t, cl.Torrents())
+	ts, err := cl.AddTorrents([]TorrentSpec{*TorrentSpecFromMetaInfo(greeting), *TorrentSpecFromMetaInfo(other)})
+	require.NoError(t, err)
+	require.Len(t, ts, 2)
+	assert.Equal(t, other.HashInfoBytes(), ts[1].InfoHash())
+	assert.NotNil(t, ts[1].Info())
+	assert.Len(t, cl.Torrents(), 2)
+}
+
+func TestTorrentStatsSampler(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	sampler := leecherTorrent.NewStatsSampler()
+	first := sampler.Sample()
+	assert.Zero(t, first.Delta.BytesReadUsefulData.Int64())
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	second := sampler.Sample()
+	assert.EqualValues(t, len(testutil.GreetingFileContents), second.Delta.BytesReadUsefulData.Int64())
+	assert.EqualValues(t, len(testutil.GreetingFileContents), second.Total.BytesReadUsefulData.Int64())
+	assert.Positive(t, second.Interval)
+	third := sampler.Sample()
+	assert.Zero(t, third.Delta.BytesReadUsefulData.Int64())
+	assert.EqualValues(t, len(testutil.GreetingFileContents), third.Total.BytesReadUsefulData.Int64())
+}
+
+func TestBytesCompletedWanted(t *testing.T) {
+	tor := testutil.Torrent{
+		Name: "d",
+		Files: []testutil.File{
+			{Name: "a", Data: "aaaabbbbcccc"},
+			{Name: "b", Data: "dddd"},
+		},
+	}
+	cfg := TestingConfig(t)
+	cfg.DataDir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(cfg.DataDir, "d"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.DataDir, "d", "a"), []byte(tor.Files[0].Data), 0o644))
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(tor.Metainfo(4))
+	require.NoError(t, err)
+	tt.VerifyData()
+	assert.EqualValues(t, 0, tt.BytesWanted())
+	assert.EqualValues(t, 0, tt.BytesCompletedWanted())
+	tt.Files()[0].SetPriority(PiecePriorityNormal)
+	assert.EqualValues(t, 12, tt.BytesWanted())
+	assert.EqualValues(t, 12, tt.BytesCompletedWanted())
+	assert.EqualValues(t, 12, tt.BytesCompleted())
+	tt.DownloadAll()
+	assert.EqualValues(t, 16, tt.BytesWanted())
+	assert.EqualValues(t, 12, tt.BytesCompletedWanted())
+}
+
+func TestPieceHashRateLimiter(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = dir
+	// Drain the burst, so hashing the 13 byte greeting should take at least 120ms.
+	cfg.PieceHashRateLimiter = rate.NewLimiter(100, defaultChunkSize)
+	cfg.PieceHashRateLimiter.AllowN(time.Now(), defaultChunkSize)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	started := time.Now()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	assert.True(t, tt.Complete.Bool())
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+}
+
+func TestPieceHashRateLimiterBurstTooSmall(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.PieceHashRateLimiter = rate.NewLimiter(100, 0)
+	_, err := NewClient(cfg)
+	require.Error(t, err)
+}
+
+func TestPieceHashRateLimiterSkipsDownloadedPieces(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	// With the burst drained, a limited hash of the greeting would block for hours.
+	cfg.PieceHashRateLimiter = rate.NewLimiter(0.001, defaultChunkSize)
+	cfg.PieceHashRateLimiter.AllowN(time.Now(), defaultChunkSize)
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	select {
+	case <-leecherTorrent.Complete.On():
+	case <-time.After(10 * time.Second):
+		t.Fatal("downloaded piece wasn't checked")
+	}
+}
+
+// Returns the bytes of heap still in use after a collection.
+func liveHeapBytes() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func TestLowMemoryClientConfig(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	heapBefore := liveHeapBytes()
+	cfg := TestingConfig(t)
+	cfg.setLowMemory()
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.setLowMemory()
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	// The heap held by both clients with a connection between them. This excludes the Go runtime
+	// and binary, which dominate the process size.
+	heapUsed := int64(liveHeapBytes()) - int64(heapBefore)
+	t.Logf("heap used by two low memory clients: %v bytes", heapUsed)
+	assert.Less(t, heapUsed, int64(8<<20))
+}
+
+func TestWorkerPoolSizes(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.PieceHashersPerTorrent = 1
+	cfg.StorageReadWorkers = 1
+	cfg.HandshakeWorkers = 1
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	assert.Equal(t, 1, seederTorrent.maxPieceHashers())
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	assert.Equal(t, 2, leecherTorrent.maxPieceHashers())
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	assert.Len(t, seeder.storageReadWorkers, 0)
+	assert.Nil(t, leecher.storageReadWorkers)
+}
+
+type deadlineNotifyConn struct {
+	net.Conn
+	deadlineSet chan struct{}
+}
+
+func (me deadlineNotifyConn) SetDeadline(t time.Time) error {
+	select {
+	case me.deadlineSet <- struct{}{}:
+	default:
+	}
+	return me.Conn.SetDeadline(t)
+}
+
+func TestHandshakeDeadlineStartsWithSlot(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.HandshakeWorkers = 1
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	local, remote := net.Pipe()
+	defer remote.Close()
+	nc := deadlineNotifyConn{local, make(chan struct{}, 1)}
+	c := cl.newConnection(nc, newConnectionOpts{
+		remoteAddr: remote.LocalAddr(),
+		network:    "pipe",
+	})
+	cl.handshakeWorkers.acquire()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cl.runReceivedConn(c)
+	}()
+	select {
+	case <-nc.deadlineSet:
+		t.Fatal("handshake deadline set while waiting for a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cl.handshakeWorkers.release()
+	<-nc.deadlineSet
+	remote.Close()
+	<-done
+}
+
+func TestFdPressure(t *testing.T) {
+	assert.True(t, fdExhausted(fmt.Errorf("accept: %w", syscall.EMFILE)))
+	assert.True(t, fdExhausted(&os.PathError{Op: "open", Path: "x", Err: syscall.ENFILE}))
+	assert.False(t, fdExhausted(io.EOF))
+	assert.EqualValues(t, 0, acceptErrBackoff(io.EOF, time.Second))
+	assert.EqualValues(t, 5*time.Millisecond, acceptErrBackoff(syscall.EMFILE, 0))
+	assert.EqualValues(t, time.Second, acceptErrBackoff(syscall.EMFILE, time.Second))
+
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.DropMutuallyCompletePeers = false
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DropMutuallyCompletePeers = false
+	var events []FdPressureEvent
+	cfg.Callbacks.FdPressure = append(cfg.Callbacks.FdPressure, func(e FdPressureEvent) {
+		events = append(events, e)
+	})
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	leecher.lock()
+	assert.NotEmpty(t, leecherTorrent.conns)
+	assert.True(t, leecher.onFdPressure("dial", syscall.EMFILE))
+	// Already backing off.
+	assert.False(t, leecher.onFdPressure("dial", syscall.EMFILE))
+	assert.Zero(t, leecherTorrent.openNewConns())
+	leecher.unlock()
+	require.Len(t, events, 1)
+	assert.Equal(t, "dial", events[0].Op)
+	assert.Equal(t, 1, events[0].ConnsShed)
+	assert.Equal(t, minFdPressureBackoff, events[0].Backoff)
+}
+
+func TestRemotePeerRequestBudgetShared(t *testing.T) {
+	seederDataDir, greeting := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	other := testutil.Torrent{
+		Name:  "other",
+		Files: []testutil.File{{Data: "hello, other world\n"}},
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(seederDataDir, "other"), []byte(other.Files[0].Data), 0o644))
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	cfg = TestingConfig(t)
+	// Connect without wanting data, so the leecher isn't interested.
+	cfg.AlwaysWantConns = true
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	var leecherTorrents []*Torrent
+	for _, mi := range []*metainfo.MetaInfo{greeting, other.Metainfo(4)} {
+		st, err := seeder.AddTorrent(mi)
+		require.NoError(t, err)
+		st.VerifyData()
+		lt, err := leecher.AddTorrent(mi)
+		require.NoError(t, err)
+		st.AddClientPeer(leecher)
+		leecherTorrents = append(leecherTorrents, lt)
+	}
+	// Connections are made over each loopback address, so pair them up by remote client.
+	var a, b *PeerConn
+	require.Eventually(t, func() bool {
+		for _, c0 := range leecherTorrents[0].PeerConns() {
+			for _, c1 := range leecherTorrents[1].PeerConns() {
+				k0, _ := c0.remotePeerKey()
+				k1, ok := c1.remotePeerKey()
+				if ok && k0 == k1 {
+					a, b = c0, c1
+					return true
+				}
+			}
+		}
+		return false
+	}, 10*time.Second, time.Millisecond)
+	leecher.lock()
+	defer leecher.unlock()
+	key, _ := a.remotePeerKey()
+	assert.Len(t, leecher.remotePeerConns[key], 2)
+	before := a.nominalMaxRequests()
+	b.requestState.Interested = true
+	assert.Equal(t, maxInt(1, (before+1)/2), a.nominalMaxRequests())
+	b.requestState.Interested = false
+	assert.Equal(t, before, a.nominalMaxRequests())
+	// Changes in interest update the other connection's requests for its new budget.
+	a.needRequestUpdate = ""
+	b.setInterested(true)
+	assert.Equal(t, "remote peer interest changed", a.needRequestUpdate)
+	assert.NotEqual(t, "remote peer interest changed", b.needRequestUpdate)
+}
+
+func TestAvailabilityHistory(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.DropMutuallyCompletePeers = false
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.DropMutuallyCompletePeers = false
+	cfg.AvailabilitySampleInterval = time.Millisecond
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	assert.GreaterOrEqual(t, leecherTorrent.Stats().DistributedCopies, 1.0)
+	require.Eventually(t, func() bool {
+		history := leecherTorrent.AvailabilityHistory()
+		last := history[len(history)-1]
+		return last.Seeders >= 1 && last.DistributedCopies >= 1
+	}, 10*time.Second, time.Millisecond)
+	history := leecherTorrent.AvailabilityHistory()
+	for i := 1; i < len(history); i++ {
+		assert.False(t, history[i].Time.Before(history[i-1].Time))
+	}
+}
+
+func TestDistributedCopies(t *testing.T) {
+	tt := &Torrent{}
+	assert.Zero(t, tt.distributedCopies())
+	tt.info = &metainfo.Info{Pieces: make([]byte, 4*metainfo.HashSize)}
+	tt.pieces = make([]Piece, 4)
+	for i := range tt.pieces {
+		tt.pieces[i].t = tt
+	}
+	for i, avail := range []int{2, 1, 3, 1} {
+		tt.pieces[i].relativeAvailability = avail
+	}
+	assert.Equal(t, 1.5, tt.distributedCopies())
+}
+
+func TestPeerChurnStats(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	// Mutually complete peers are dropped by one side or the other.
+	require.Eventually(t, func() bool {
+		return len(leecherTorrent.PeerConns()) == 0
+	}, 10*time.Second, time.Millisecond)
+	stats := leecherTorrent.PeerChurnStats()
+	assert.NotZero(t, stats.Connects)
+	disconnects := 0
+	for reason, h := range stats.Disconnects {
+		assert.Contains(t, []DisconnectReason{DisconnectEvicted, DisconnectRemoteClose}, reason)
+		assert.Less(t, h.Sum, 10*time.Second)
+		assert.Equal(t, h.Count(), h.Counts[0])
+		disconnects += h.Count()
+	}
+	assert.Equal(t, stats.Connects, disconnects)
+}
+
+func TestConnLifetimeHistogram(t *testing.T) {
+	var h ConnLifetimeHistogram
+	for _, d := range []time.Duration{time.Second, 10 * time.Second, 5 * time.Minute, 2 * time.Hour} {
+		h.add(d)
+	}
+	assert.Equal(t, [5]int{1, 1, 1, 0, 1}, h.Counts)
+	assert.Equal(t, 4, h.Count())
+	assert.Equal(t, 2*time.Hour+5*time.Minute+11*time.Second, h.Sum)
+	assert.Equal(t, DisconnectTimeout, readLoopDisconnectReason(fmt.Errorf("reading: %w", os.ErrDeadlineExceeded)))
+	assert.Equal(t, DisconnectRemoteClose, readLoopDisconnectReason(io.EOF))
+	assert.Equal(t, DisconnectOther, readLoopDisconnectReason(errors.New("bad message")))
+}
+
+func TestEvictionReason(t *testing.T) {
+	var c PeerConn
+	assert.Equal(t, DisconnectEvicted, c.evictionReason())
+	c.peerChoking = true
+	assert.Equal(t, DisconnectEvicted, c.evictionReason())
+	c.requestState.Interested = true
+	assert.Equal(t, DisconnectChokedOut, c.evictionReason())
+	assert.Equal(t, "choked out", DisconnectChokedOut.String())
+}
+
+func TestStatsReportUploadedTo(t *testing.T) {
+	var mu sync.Mutex
+	reports := make(map[[20]byte]httpTracker.StatsReport)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/report" {
+			report, err := httpTracker.ParseStatsReportRequest(r)
+			assert.NoError(t, err)
+			mu.Lock()
+			reports[report.PeerId] = report
+			mu.Unlock()
+		}
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer s.Close()
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	mi.Announce = s.URL + "/announce"
+	newConfig := func() *ClientConfig {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.StatsReportInterval = time.Millisecond
+		cfg.ExperimentId = "uploaded-to"
+		return cfg
+	}
+	cfg := newConfig()
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(newConfig())
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	greetingLen := int64(len(testutil.GreetingFileContents))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		seederReport := reports[seeder.PeerID()]
+		leecherReport := reports[leecher.PeerID()]
+		return seederReport.UploadedTo[leecher.PeerID()] >= greetingLen &&
+			leecherReport.Downloaded == greetingLen && leecherReport.Left == 0 &&
+			leecherReport.ExperimentId == "uploaded-to"
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestStatsReportUploadedDelta(t *testing.T) {
+	var mu sync.Mutex
+	uploaded := make(map[[20]byte]int64)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/report" {
+			report, err := httpTracker.ParseStatsReportRequest(r)
+			assert.NoError(t, err)
+			mu.Lock()
+			uploaded[report.PeerId] += report.UploadedDelta
+			mu.Unlock()
+		}
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer s.Close()
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	mi.Announce = s.URL + "/announce"
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	// The deltas sum to the upload total, with nothing reported twice.
+	require.Eventually(t, func() bool {
+		stats := seederTorrent.Stats()
+		total := stats.BytesWrittenData.Int64()
+		mu.Lock()
+		defer mu.Unlock()
+		return total >= int64(len(testutil.GreetingFileContents)) &&
+			uploaded[seeder.PeerID()] == total && seederTorrent.ReportedUploadBytes() == total
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestFreeRiderPolicyJudge(t *testing.T) {
+	p := FreeRiderPolicy{MinLocalRatio: 0.5, MinTrackerRatio: 0.5}
+	assert.False(t, p.judge(-1, false, -1, false))
+	assert.True(t, p.judge(0.1, true, -1, false))
+	assert.False(t, p.judge(1, true, -1, false))
+	// Tracker ratios alone aren't acted on.
+	assert.False(t, p.judge(-1, false, 0.1, true))
+	assert.True(t, p.judge(0.1, true, 0.1, true))
+	// Local and tracker observations must agree.
+	assert.False(t, p.judge(0.1, true, 1, true))
+	assert.False(t, p.judge(1, true, 0.1, true))
+	p.RequireTrackerAgreement = true
+	assert.False(t, p.judge(0.1, true, -1, false))
+	assert.True(t, p.judge(0.1, true, 0.1, true))
+}
+
+func TestFreeRiderPolicyTrackerRatio(t *testing.T) {
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherId := leecher.PeerID()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bencode.NewEncoder(w).Encode(httpTracker.ReportResponse{
+			Peers: map[string]httpTracker.ReportedPeer{
+				string(leecherId[:]): {Uploaded: 1, Downloaded: 100},
+			},
+		})
+	}))
+	defer s.Close()
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	mi.Announce = s.URL + "/announce"
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.FreeRiderPolicy = FreeRiderPolicy{
+		CheckInterval:   time.Millisecond,
+		MinLocalRatio:   0.5,
+		MinTrackerRatio: 0.5,
+	}
+	events := make(chan FreeRiderEvent, 2)
+	cfg.Callbacks.FreeRider = append(cfg.Callbacks.FreeRider, func(e FreeRiderEvent) {
+		events <- e
+	})
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	require.Eventually(t, func() bool {
+		seeder.lock()
+		defer seeder.unlock()
+		_, ok := seederTorrent.trackerReportedPeers[leecherId]
+		return ok
+	}, 10*time.Second, time.Millisecond)
+	// The tracker ratio isn't enough to act on, and local ratios aren't judged while seeding.
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected free-rider event for %v", e.Peer)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUploadReceipts(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.UploadReceipts = true
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	_, leecherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	cfg = TestingConfig(t)
+	cfg.UploadReceipts = true
+	cfg.UploadReceiptKey = leecherKey
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	// A receipt is sent after each piece, and the last one covers the whole download.
+	require.Eventually(t, func() bool {
+		receipts := seederTorrent.UploadReceipts()
+		return len(receipts) != 0 && receipts[0].Bytes == int64(len(testutil.GreetingFileContents))
+	}, 10*time.Second, time.Millisecond)
+	receipts := seederTorrent.UploadReceipts()
+	require.Len(t, receipts, 1)
+	r := receipts[0]
+	assert.True(t, r.Verify())
+	assert.EqualValues(t, leecherKey.Public(), r.PublicKey)
+	assert.EqualValues(t, leecher.PeerID(), r.Downloader)
+	assert.EqualValues(t, seeder.PeerID(), r.Uploader)
+	assert.EqualValues(t, mi.HashInfoBytes(), r.InfoHash)
+	assert.EqualValues(t, len(testutil.GreetingFileContents), r.Bytes)
+	r.Bytes++
+	assert.False(t, r.Verify())
+}
+
+// Receipts only cover pieces that pass their hash check, so serving bad data earns no credit.
+func TestUploadReceiptsOnlyForVerifiedPieces(t *testing.T) {
+	const pieceLength = 1 << 14
+	spec := testdata.Torrent{
+		Name:        "receipts",
+		PieceLength: pieceLength,
+		Files:       []testdata.File{{Seed: 1, Length: 4 * pieceLength}},
+	}
+	mi, err := spec.MetaInfo()
+	require.NoError(t, err)
+	seederDataDir := t.TempDir()
+	require.NoError(t, spec.WriteFiles(seederDataDir))
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.UploadReceipts = true
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	require.True(t, seederTorrent.Complete.Bool())
+	// Corrupt the second piece after the seeder has verified it.
+	f, err := os.OpenFile(filepath.Join(seederDataDir, spec.Name), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("bad"), pieceLength)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cfg = TestingConfig(t)
+	cfg.UploadReceipts = true
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	// Client peers are trusted, so the seeder isn't banned for the bad piece.
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	require.Eventually(t, func() bool {
+		stats := leecherTorrent.Stats()
+		return leecherTorrent.BytesCompleted() == 3*pieceLength && stats.PiecesDirtiedBad.Int64() != 0
+	}, 10*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		receipts := seederTorrent.UploadReceipts()
+		return len(receipts) == 1 && receipts[0].Bytes == 3*pieceLength
+	}, 10*time.Second, time.Millisecond)
+	assert.False(t, leecherTorrent.Complete.Bool())
+}
+
+func TestTrackerLeaderboard(t *testing.T) {
+	lb := &trackerServer.Leaderboard{}
+	mux := http.NewServeMux()
+	mux.Handle("/", httpTrackerServer.Handler{Leaderboard: lb})
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
+		// Reports are only accepted from announced peers.
+		var ih, id [20]byte
+		copy(ih[:], r.URL.Query().Get("info_hash"))
+		copy(id[:], r.URL.Query().Get("peer_id"))
+		lb.TrackAnnounce(ih, id, netip.MustParseAddrPort(r.RemoteAddr), trackerServer.AnnounceTiming{
+			Time:     time.Now(),
+			Interval: time.Minute,
+		})
+		// Receipts are only credited if the downloader announced its key.
+		key, err := hex.DecodeString(r.URL.Query().Get("receipt_key"))
+		assert.NoError(t, err)
+		lb.TrackReceiptKey(ih, id, key)
+		w.Write([]byte("d8:intervali60ee"))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	mi.Announce = s.URL + "/announce"
+	newConfig := func() *ClientConfig {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.StatsReportInterval = time.Millisecond
+		cfg.UploadReceipts = true
+		return cfg
+	}
+	cfg := newConfig()
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(newConfig())
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	greetingLen := int64(len(testutil.GreetingFileContents))
+	var entries []httpTracker.LeaderboardEntry
+	require.Eventually(t, func() bool {
+		entries, err = leecherTorrent.Leaderboard(context.Background())
+		require.NoError(t, err)
+		return len(entries) == 2 && entries[0].Credit == greetingLen && entries[1].Downloaded == greetingLen
+	}, 10*time.Second, time.Millisecond)
+	assert.EqualValues(t, seeder.PeerID(), entries[0].PeerId)
+	assert.GreaterOrEqual(t, entries[0].Uploaded, greetingLen)
+	assert.EqualValues(t, leecher.PeerID(), entries[1].PeerId)
+	assert.Zero(t, entries[1].Credit)
+}
+
+// A fake stats report tracker that can be taken down.
+type testStatsReportTracker struct {
+	*httptest.Server
+	mu      sync.Mutex
+	down    bool
+	reports []httpTracker.StatsReport
+}
+
+func newTestStatsReportTracker(t *testing.T) *testStatsReportTracker {
+	me := &testStatsReportTracker{}
+	me.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		if me.down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		report, err := httpTracker.ParseStatsReportRequest(r)
+		assert.NoError(t, err)
+		me.reports = append(me.reports, report)
+		w.Write([]byte("de"))
+	}))
+	return me
+}
+
+func (me *testStatsReportTracker) setDown(down bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.down = down
+}
+
+func (me *testStatsReportTracker) numReports() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return len(me.reports)
+}
+
+func TestStatsReportTrackersFailover(t *testing.T) {
+	primary := newTestStatsReportTracker(t)
+	defer primary.Close()
+	secondary := newTestStatsReportTracker(t)
+	defer secondary.Close()
+	primary.setDown(true)
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportTrackers = []string{primary.URL + "/announce", secondary.URL + "/announce"}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return secondary.numReports() != 0 }, 10*time.Second, time.Millisecond)
+	assert.Zero(t, primary.numReports())
+	primary.setDown(false)
+	require.Eventually(t, func() bool { return primary.numReports() != 0 }, 10*time.Second, time.Millisecond)
+}
+
+func TestStatsReportQueueRetried(t *testing.T) {
+	tr := newTestStatsReportTracker(t)
+	defer tr.Close()
+	tr.setDown(true)
+	cfg := TestingConfig(t)
+	cfg.StatsReportTrackers = []string{tr.URL + "/announce"}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	queue := []httpTracker.StatsReport{{InfoHash: [20]byte{1}}, {InfoHash: [20]byte{2}}}
+	ctx := context.Background()
+	queue = cl.deliverStatsReports(ctx, queue)
+	require.Len(t, queue, 2)
+	tr.setDown(false)
+	queue = append(queue, httpTracker.StatsReport{InfoHash: [20]byte{3}})
+	assert.Empty(t, cl.deliverStatsReports(ctx, queue))
+	require.Len(t, tr.reports, 3)
+	for i, r := range tr.reports {
+		assert.EqualValues(t, i+1, r.InfoHash[0])
+	}
+}
+
+func TestStatsReportBackoff(t *testing.T) {
+	var b statsReportBackoff
+	now := time.Now()
+	assert.True(t, b.ready(now))
+	interval := time.Second
+	var delays []time.Duration
+	for i := 0; i < 8; i++ {
+		b.failed(now, interval)
+		delays = append(delays, b.retryAt.Sub(now))
+	}
+	// Doubling from the interval, with up to half of each delay random, and capped at 32
+	// intervals.
+	for i, d := range delays {
+		nominal := interval << i
+		if nominal > 32*interval {
+			nominal = 32 * interval
+		}
+		assert.GreaterOrEqual(t, d, nominal/2, i)
+		assert.LessOrEqual(t, d, nominal, i)
+	}
+	assert.False(t, b.ready(now))
+	assert.True(t, b.ready(b.retryAt))
+	assert.Equal(t, 8, b.succeeded())
+	assert.True(t, b.ready(now))
+	// Intervals past the cap aren't shortened.
+	b.failed(now, time.Hour)
+	b.failed(now, time.Hour)
+	assert.GreaterOrEqual(t, b.retryAt.Sub(now), 30*time.Minute)
+	assert.LessOrEqual(t, b.retryAt.Sub(now), time.Hour)
+}
+
+func TestStatsReportsResumeAfterFailures(t *testing.T) {
+	tr := newTestStatsReportTracker(t)
+	defer tr.Close()
+	tr.setDown(true)
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportURL = tr.URL
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return tt.Stats().StatsReportsFailed >= 3
+	}, 10*time.Second, time.Millisecond)
+	cl.rLock()
+	assert.NotZero(t, tt.statsReportBackoff.failures)
+	cl.rUnlock()
+	tr.setDown(false)
+	require.Eventually(t, func() bool { return tr.numReports() != 0 }, 10*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		cl.rLock()
+		defer cl.rUnlock()
+		return tt.statsReportBackoff.failures == 0
+	}, 10*time.Second, time.Millisecond)
+}
+
+// Collects slog records for inspection.
+type testSlogHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (me *testSlogHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (me *testSlogHandler) Handle(r slog.Record) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.records = append(me.records, r.Clone())
+	return nil
+}
+
+func (me *testSlogHandler) WithAttrs([]slog.Attr) slog.Handler { return me }
+
+func (me *testSlogHandler) WithGroup(string) slog.Handler { return me }
+
+// Returns the attrs of the first record at the level containing text.
+func (me *testSlogHandler) find(level slog.Level, text string) (attrs map[string]slog.Value, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, r := range me.records {
+		if r.Level != level || !strings.Contains(r.Message, text) {
+			continue
+		}
+		attrs = make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) {
+			attrs[a.Key] = a.Value
+		})
+		return attrs, true
+	}
+	return
+}
+
+func TestStatsReportSlogger(t *testing.T) {
+	tr := newTestStatsReportTracker(t)
+	defer tr.Close()
+	tr.setDown(true)
+	h := &testSlogHandler{}
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportURL = tr.URL
+	cfg.Slogger = slog.New(h)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := metainfo.Hash{1}
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{InfoHash: ih})
+	require.NoError(t, err)
+	var attrs map[string]slog.Value
+	require.Eventually(t, func() (ok bool) {
+		attrs, ok = h.find(slog.LevelWarn, "stats report failed, backing off")
+		return
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, ih.HexString(), attrs["infohash"].String())
+	assert.Equal(t, time.Millisecond, attrs["interval"].Duration())
+	assert.NotNil(t, attrs["error"].Any())
+	tr.setDown(false)
+	require.Eventually(t, func() (ok bool) {
+		attrs, ok = h.find(slog.LevelDebug, "reported stats")
+		return
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, ih.HexString(), attrs["infohash"].String())
+	assert.Equal(t, tr.URL, attrs["url"].String())
+	assert.Equal(t, int64(0), attrs["uploaded"].Int64())
+	assert.Contains(t, attrs, "interval")
+	assert.Contains(t, attrs["names"].String(), "torrent")
+}
+
+func TestAttrsMsg(t *testing.T) {
+	m := attrsMsg("reported stats", []slog.Attr{slog.String("url", "http://a"), slog.Int("uploaded", 1)})
+	// Handlers other than the slog one show the fields after the text.
+	assert.Equal(t, "reported stats url=http://a uploaded=1", m.Text())
+	h := &testSlogHandler{}
+	slogHandler{slog.New(h)}.Handle(log.Record{Msg: m, Level: log.Info})
+	require.Len(t, h.records, 1)
+	assert.Equal(t, "reported stats", h.records[0].Message)
+	attrs, _ := h.find(slog.LevelInfo, "reported stats")
+	assert.Equal(t, "http://a", attrs["url"].String())
+}
+
+// A fake HTTP tracker that can be killed and restarted on the same address. It forgets the peers
+// that announced when it's restarted, but keeps the stats reports it received, as a tracker with a
+// database might.
+type testRestartableTracker struct {
+	t      *testing.T
+	addr   string
+	mu     sync.Mutex
+	server *http.Server
+	// Announce events by peer ID since the last restart.
+	events  map[string][]tracker.AnnounceEvent
+	peers   map[string]httpTracker.Peer
+	reports []httpTracker.StatsReport
+}
+
+func newTestRestartableTracker(t *testing.T) *testRestartableTracker {
+	me := &testRestartableTracker{t: t}
+	me.start("127.0.0.1:0")
+	t.Cleanup(me.kill)
+	return me
+}
+
+func (me *testRestartableTracker) start(addr string) {
+	l, err := net.Listen("tcp", addr)
+	require.NoError(me.t, err)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.addr = l.Addr().String()
+	me.events = make(map[string][]tracker.AnnounceEvent)
+	me.peers = make(map[string]httpTracker.Peer)
+	me.server = &http.Server{Handler: me}
+	go me.server.Serve(l)
+}
+
+func (me *testRestartableTracker) kill() {
+	me.mu.Lock()
+	s := me.server
+	me.mu.Unlock()
+	s.Close()
+}
+
+func (me *testRestartableTracker) restart() {
+	me.start(me.addr)
+}
+
+func (me *testRestartableTracker) announceUrl() string {
+	return "http://" + me.addr + "/announce"
+}
+
+func (me *testRestartableTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if r.URL.Path == "/report" {
+		report, err := httpTracker.ParseStatsReportRequest(r)
+		assert.NoError(me.t, err)
+		me.reports = append(me.reports, report)
+		w.Write([]byte("de"))
+		return
+	}
+	q := r.URL.Query()
+	var event tracker.AnnounceEvent
+	assert.NoError(me.t, event.UnmarshalText([]byte(q.Get("event"))))
+	peerId := q.Get("peer_id")
+	me.events[peerId] = append(me.events[peerId], event)
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	port, _ := strconv.Atoi(q.Get("port"))
+	resp := httpTracker.HttpResponse{Interval: 60}
+	resp.Peers.Compact = true
+	for id, p := range me.peers {
+		if id != peerId {
+			resp.Peers.List = append(resp.Peers.List, p)
+		}
+	}
+	me.peers[peerId] = httpTracker.Peer{IP: net.ParseIP(host).To4(), Port: port}
+	bencode.NewEncoder(w).Encode(resp)
+}
+
+// Returns the announce events from the peer since the tracker last restarted.
+func (me *testRestartableTracker) peerEvents(peerId PeerID) []tracker.AnnounceEvent {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]tracker.AnnounceEvent(nil), me.events[string(peerId[:])]...)
+}
+
+func (me *testRestartableTracker) reportedDownloaded() (ret int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, r := range me.reports {
+		ret += r.DownloadedDelta
+	}
+	return
+}
+
+// The tracker is killed and restarted mid-download, losing track of the swarm. Peers register with
+// it again, stats reports made while it was down are queued and delivered, and the leecher finds
+// the seeder again through it to complete the download.
+func TestTrackerRestart(t *testing.T) {
+	tr := newTestRestartableTracker(t)
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	mi.Announce = tr.announceUrl()
+	newConfig := func() *ClientConfig {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.DisablePEX = true
+		cfg.MinAnnounceInterval = time.Millisecond
+		cfg.MaxAnnounceInterval = 10 * time.Millisecond
+		return cfg
+	}
+	cfg := newConfig()
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	require.True(t, seederTorrent.Complete.Bool())
+	cfg = newConfig()
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportTrackers = []string{tr.announceUrl()}
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	pieceComplete := func(i int) func() bool {
+		return func() bool { return leecherTorrent.Piece(i).State().Complete }
+	}
+	leecherTorrent.DownloadPieces(0, 1)
+	require.Eventually(t, pieceComplete(0), 10*time.Second, time.Millisecond)
+
+	tr.kill()
+	// The existing connection carries on without the tracker, and reports of what it downloads are
+	// queued.
+	leecherTorrent.DownloadPieces(1, 2)
+	require.Eventually(t, pieceComplete(1), 10*time.Second, time.Millisecond)
+	failed := leecherTorrent.Stats().StatsReportsFailed
+	require.Eventually(t, func() bool {
+		return leecherTorrent.Stats().StatsReportsFailed > failed
+	}, 10*time.Second, time.Millisecond)
+	// Lose the connection, so the leecher needs the tracker to find the seeder again.
+	leecher.lock()
+	for c := range leecherTorrent.conns {
+		leecherTorrent.dropConnection(c)
+	}
+	leecher.unlock()
+	require.Eventually(t, func() bool {
+		return leecherTorrent.Stats().ActivePeers == 0
+	}, 10*time.Second, time.Millisecond)
+
+	// Both peers notice the tracker is down.
+	for _, tt := range []*Torrent{seederTorrent, leecherTorrent} {
+		require.Eventually(t, func() bool {
+			return tt.TrackerStatuses()[0].Err != nil
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	tr.restart()
+	leecherTorrent.DownloadAll()
+	select {
+	case <-leecherTorrent.Complete.On():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for download to complete")
+	}
+	r := leecherTorrent.NewReader()
+	defer r.Close()
+	quicktest.Check(t, iotest.TestReader(r, []byte(testutil.GreetingFileContents)), quicktest.IsNil)
+	// Both peers registered with the restarted tracker from scratch.
+	for _, cl := range []*Client{seeder, leecher} {
+		events := tr.peerEvents(cl.PeerID())
+		require.NotEmpty(t, events)
+		assert.Equal(t, tracker.Started, events[0])
+	}
+	require.Eventually(t, func() bool {
+		stats := leecherTorrent.Stats()
+		return tr.reportedDownloaded() == stats.BytesReadUsefulData.Int64()
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestStatsReportQueuePersisted(t *testing.T) {
+	tr := newTestStatsReportTracker(t)
+	defer tr.Close()
+	tr.setDown(true)
+	queueDir := t.TempDir()
+	newClient := func() *Client {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.StatsReportInterval = time.Millisecond
+		cfg.StatsReportTrackers = []string{tr.URL + "/announce"}
+		cfg.StatsReportQueueDir = queueDir
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		return cl
+	}
+	cl := newClient()
+	_, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		queue, err := loadStatsReportQueue(queueDir)
+		return err == nil && len(queue) != 0
+	}, 10*time.Second, time.Millisecond)
+	cl.Close()
+	queue, err := loadStatsReportQueue(queueDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, queue)
+	assert.EqualValues(t, metainfo.Hash{1}, queue[0].InfoHash)
+	assert.NotZero(t, queue[0].Time)
+	// The next Client delivers the reports queued by the first, with their original times.
+	tr.setDown(false)
+	cl = newClient()
+	defer cl.Close()
+	require.Eventually(t, func() bool { return tr.numReports() >= len(queue) }, 10*time.Second, time.Millisecond)
+	tr.mu.Lock()
+	assert.Equal(t, queue, tr.reports[:len(queue)])
+	tr.mu.Unlock()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(statsReportQueuePath(queueDir))
+		return os.IsNotExist(err)
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestStatsReportDurations(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	cl.lock()
+	first := tt.takeStatsReportLocked()
+	cl.unlock()
+	assert.Zero(t, first.Interval)
+	time.Sleep(time.Millisecond)
+	cl.lock()
+	second := tt.takeStatsReportLocked()
+	cl.unlock()
+	assert.GreaterOrEqual(t, second.Interval, time.Millisecond)
+	assert.Equal(t, second.Elapsed-first.Elapsed, second.Interval)
+}
+
+func TestStatsReportPieces(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	cl.lock()
+	assert.Zero(t, tt.takeStatsReportLocked().Pieces)
+	cl.unlock()
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	tt, err = cl.AddTorrent(mi)
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	cl.lock()
+	r := tt.takeStatsReportLocked()
+	cl.unlock()
+	// No data and no peers.
+	assert.Equal(t, httpTracker.StatsReportPieces{Total: 3, Unavailable: 3}, r.Pieces)
+	assert.Zero(t, r.ActivePeers)
+}
+
+func TestLeaderboardRatesIgnoreClock(t *testing.T) {
+	var lb trackerServer.Leaderboard
+	ih := [20]byte{1}
+	// The reporter's wall clock runs backwards, which shouldn't matter.
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, Time: 2000, Elapsed: 10 * time.Second, Uploaded: 100})
+	up, down := lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, Time: 1000, Elapsed: 20 * time.Second, Uploaded: 300, Downloaded: 50})
+	assert.EqualValues(t, 20, up)
+	assert.EqualValues(t, 5, down)
+	entries := lb.Entries(ih)
+	require.Len(t, entries, 1)
+	assert.EqualValues(t, 20, entries[0].UploadRate)
+	assert.EqualValues(t, 5, entries[0].DownloadRate)
+	// After a restart, rates are computed from the new session's start.
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, Elapsed: 2 * time.Second, Uploaded: 10})
+	entries = lb.Entries(ih)
+	assert.EqualValues(t, 5, entries[0].UploadRate)
+	assert.EqualValues(t, 0, entries[0].DownloadRate)
+}
+
+func TestLeaderboardFastestPeers(t *testing.T) {
+	var lb trackerServer.Leaderboard
+	ih := [20]byte{1}
+	addr := func(port uint16) netip.AddrPort {
+		return netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port)
+	}
+	for i, rate := range []int64{10, 30, 20, 0} {
+		id := [20]byte{byte(i)}
+		lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: id, Elapsed: time.Second})
+		lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: id, Elapsed: 2 * time.Second, Uploaded: rate})
+		lb.TrackAnnounce(ih, id, addr(uint16(i)), trackerServer.AnnounceTiming{})
+	}
+	// A peer that reports but hasn't announced has no known address.
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: [20]byte{9}, Elapsed: time.Second, Uploaded: 100})
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: [20]byte{9}, Elapsed: 2 * time.Second, Uploaded: 200})
+	assert.Equal(t, []netip.AddrPort{addr(1), addr(2), addr(0)}, lb.FastestPeers(ih, [20]byte{9}, 5))
+	assert.Equal(t, []netip.AddrPort{addr(2)}, lb.FastestPeers(ih, [20]byte{1}, 1))
+	assert.Empty(t, lb.FastestPeers([20]byte{2}, [20]byte{}, 5))
+	// Peers that announce stopped are forgotten.
+	lb.TrackAnnounce(ih, [20]byte{1}, addr(1), trackerServer.AnnounceTiming{Event: tracker.Stopped})
+	assert.Equal(t, []netip.AddrPort{addr(2), addr(0)}, lb.FastestPeers(ih, [20]byte{9}, 5))
+	// As are peers that stop announcing.
+	now := time.Now()
+	lb.TrackAnnounce(ih, [20]byte{2}, addr(2), trackerServer.AnnounceTiming{Time: now, Interval: time.Minute})
+	lb.TrackAnnounce(ih, [20]byte{2}, addr(2), trackerServer.AnnounceTiming{Time: now.Add(time.Hour), Interval: time.Minute})
+	assert.Equal(t, []netip.AddrPort{addr(2)}, lb.FastestPeers(ih, [20]byte{9}, 5))
+}
+
+func TestLeaderboardReliability(t *testing.T) {
+	var lb trackerServer.Leaderboard
+	ih := [20]byte{1}
+	addr := func(id byte) netip.AddrPort {
+		return netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(id))
+	}
+	start := time.Unix(1700000000, 0)
+	announce := func(id byte, event tracker.AnnounceEvent, at time.Duration) {
+		lb.TrackAnnounce(ih, [20]byte{id}, addr(id), trackerServer.AnnounceTiming{
+			Event:    event,
+			Time:     start.Add(at),
+			Interval: time.Minute,
+		})
+	}
+	announce(2, tracker.Started, 0)
+	// Forgotten when it stops.
+	announce(4, tracker.Started, 0)
+	announce(4, tracker.Stopped, 30*time.Second)
+	// Late once, and then on time.
+	announce(2, tracker.None, 2*time.Minute)
+	announce(1, tracker.Started, 2*time.Minute)
+	announce(2, tracker.None, 3*time.Minute)
+	// Always on time.
+	announce(1, tracker.None, 3*time.Minute)
+	announce(1, tracker.None, 4*time.Minute)
+	announce(2, tracker.None, 4*time.Minute)
+	announce(3, tracker.Started, 4*time.Minute)
+	// Peer 3 uploads 1 MiB to peer 1, which blames it for hash failures.
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	rcpt := pp.UploadReceipt{InfoHash: ih, Uploader: [20]byte{3}, Downloader: [20]byte{1}, Bytes: 1 << 20}
+	rcpt.Sign(key)
+	lb.TrackReceiptKey(ih, [20]byte{1}, key.Public().(ed25519.PublicKey))
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: [20]byte{3}, Receipts: [][]byte{bencode.MustMarshal(rcpt)}})
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: [20]byte{1}, Downloaded: 1 << 20, HashFailures: map[[20]byte]int64{{3}: 5, {1}: 1}})
+	// Peers that haven't announced can't complain.
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: [20]byte{9}, HashFailures: map[[20]byte]int64{{1}: 5}})
+	assert.Equal(t, []httpTracker.ReliabilityEntry{
+		{PeerId: [20]byte{1}, Score: 70, OnTimeAnnounces: 2},
+		{PeerId: [20]byte{2}, Score: 56, OnTimeAnnounces: 2, LateAnnounces: 1},
+		{PeerId: [20]byte{3}, Score: 51, Credit: 1 << 20, HashFailures: 5},
+		{PeerId: [20]byte{9}, Score: 50},
+	}, lb.Reliability(ih))
+	assert.Equal(t, []httpTracker.PeerReliability{
+		{PeerId: [20]byte{2}, Addr: "127.0.0.1:2", Score: 56},
+		{PeerId: [20]byte{3}, Addr: "127.0.0.1:3", Score: 51},
+	}, lb.PeerReliability(ih, []trackerServer.PeerInfo{
+		{AnnounceAddr: addr(2)}, {AnnounceAddr: addr(3)}, {AnnounceAddr: addr(4)},
+	}))
+	assert.Empty(t, lb.Reliability([20]byte{2}))
+	// Peers that stop announcing are forgotten, along with their complaints.
+	announce(2, tracker.None, 5*time.Minute)
+	announce(2, tracker.None, 6*time.Minute+30*time.Second)
+	announce(2, tracker.None, 8*time.Minute)
+	assert.Equal(t, []httpTracker.ReliabilityEntry{
+		{PeerId: [20]byte{3}, Score: 71, Credit: 1 << 20},
+		{PeerId: [20]byte{2}, Score: 63, OnTimeAnnounces: 5, LateAnnounces: 1},
+		{PeerId: [20]byte{1}, Score: 50},
+		{PeerId: [20]byte{9}, Score: 50},
+	}, lb.Reliability(ih))
+}
+
+func TestTrackerReliabilityEndpoint(t *testing.T) {
+	lb := &trackerServer.Leaderboard{}
+	ih := [20]byte{1}
+	lb.TrackAnnounce(ih, [20]byte{2}, netip.MustParseAddrPort("127.0.0.1:2"), trackerServer.AnnounceTiming{})
+	// The endpoint is refused to everyone without AdminAuthorized.
+	unauthorized := httptest.NewServer(httpTrackerServer.Handler{Leaderboard: lb})
+	defer unauthorized.Close()
+	u, err := url.Parse(unauthorized.URL + "/announce")
+	require.NoError(t, err)
+	_, err = httpTracker.NewClient(u, httpTracker.NewClientOpts{
+		Header: http.Header{"X-Admin": {"yes"}},
+	}).Reliability(context.Background(), ih, httpTracker.ReportOpt{})
+	assert.Error(t, err)
+	s := httptest.NewServer(httpTrackerServer.Handler{
+		Leaderboard: lb,
+		AdminAuthorized: func(r *http.Request) bool {
+			return r.Header.Get("X-Admin") == "yes"
+		},
+	})
+	defer s.Close()
+	u, err = url.Parse(s.URL + "/announce")
+	require.NoError(t, err)
+	_, err = httpTracker.NewClient(u, httpTracker.NewClientOpts{}).Reliability(context.Background(), ih, httpTracker.ReportOpt{})
+	assert.Error(t, err)
+	entries, err := httpTracker.NewClient(u, httpTracker.NewClientOpts{
+		Header: http.Header{"X-Admin": {"yes"}},
+	}).Reliability(context.Background(), ih, httpTracker.ReportOpt{})
+	require.NoError(t, err)
+	assert.Equal(t, []httpTracker.ReliabilityEntry{{PeerId: [20]byte{2}, Score: httpTracker.NeutralReliabilityScore}}, entries)
+}
+
+func TestPreferReliablePeersFromTracker(t *testing.T) {
+	peer := func(port int) krpc.NodeAddr {
+		return krpc.NodeAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: port}
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := httpTracker.HttpResponse{
+			Interval: 1800,
+			Reliability: []httpTracker.PeerReliability{
+				{PeerId: [20]byte{1}, Addr: "127.0.0.1:1001", Score: 90},
+				{PeerId: [20]byte{2}, Addr: "127.0.0.1:1002", Score: 10},
+				{PeerId: [20]byte{4}, Addr: "127.0.0.1:1004", Score: 60},
+			},
+		}
+		resp.Peers.Compact = true
+		for _, na := range []krpc.NodeAddr{peer(1001), peer(1002), peer(1003), peer(1004)} {
+			resp.Peers.List = append(resp.Peers.List, httpTracker.Peer{}.FromNodeAddr(na))
+		}
+		bencode.NewEncoder(w).Encode(resp)
+	}))
+	defer s.Close()
+	// Returns the tracker's peers in the order they'd be dialed, with their scores.
+	reservePeers := func(prefer bool) (ret []string, reliability map[PeerID]int) {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.PreferReliablePeersFromTracker = prefer
+		// Keep the peers in reserve.
+		cfg.TotalHalfOpenConns = 0
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		defer cl.Close()
+		tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+			InfoHash: metainfo.Hash{1},
+			Trackers: [][]string{{s.URL + "/announce"}},
+		})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			cl.rLock()
+			defer cl.rUnlock()
+			return tt.peers.Len() >= 4
+		}, 10*time.Second, time.Millisecond)
+		cl.lock()
+		defer cl.unlock()
+		for tt.peers.Len() != 0 {
+			p := tt.peers.PopMax()
+			ret = append(ret, fmt.Sprintf("%v %v", p.Addr, p.TrackerReliability))
+		}
+		reliability = make(map[PeerID]int)
+		for key, s := range tt.trackerReliability {
+			reliability[key.id] = s.score
+		}
+		return
+	}
+	peers, reliability := reservePeers(true)
+	assert.Equal(t, []string{
+		"127.0.0.1:1001 {true 90}",
+		"127.0.0.1:1004 {true 60}",
+		"127.0.0.1:1003 {false 0}",
+		"127.0.0.1:1002 {true 10}",
+	}, peers)
+	assert.Equal(t, map[PeerID]int{{1}: 90, {2}: 10, {4}: 60}, reliability)
+	peers, reliability = reservePeers(false)
+	assert.Len(t, peers, 4)
+	for _, p := range peers {
+		assert.True(t, strings.HasSuffix(p, " {false 0}"), p)
+	}
+	assert.Empty(t, reliability)
+}
+
+func TestTrackerReliabilityExpires(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	newConn := func(id PeerID, port int) *PeerConn {
+		pc := &PeerConn{PeerID: id}
+		pc.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		pc.outgoing = true
+		return pc
+	}
+	pc := newConn(PeerID{1}, 1)
+	tt.applyTrackerReliability(nil, []httpTracker.PeerReliability{{PeerId: PeerID{1}, Addr: "127.0.0.1:1", Score: 90}}, time.Hour)
+	cl.lock()
+	assert.Equal(t, 90, tt.peerReliability(pc))
+	// The score isn't given to peers that copy the ID from other addresses.
+	assert.Equal(t, httpTracker.NeutralReliabilityScore, tt.peerReliability(newConn(PeerID{1}, 2)))
+	cl.unlock()
+	// Scores not repeated within their trackers' intervals are forgotten.
+	tt.applyTrackerReliability(nil, []httpTracker.PeerReliability{{PeerId: PeerID{1}, Addr: "127.0.0.1:1", Score: 90}}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	cl.lock()
+	assert.Equal(t, httpTracker.NeutralReliabilityScore, tt.peerReliability(pc))
+	cl.unlock()
+	tt.applyTrackerReliability(nil, []httpTracker.PeerReliability{{PeerId: PeerID{2}, Addr: "127.0.0.1:2", Score: 10}}, time.Hour)
+	cl.lock()
+	assert.Len(t, tt.trackerReliability, 1)
+	cl.unlock()
+	// And all of them when the torrent is dropped.
+	tt.Drop()
+	cl.lock()
+	assert.Empty(t, tt.trackerReliability)
+	cl.unlock()
+}
+
+// A more reliable leecher takes the only unchoke slot from a less reliable one.
+func TestUnchokeReliablePeersFirst(t *testing.T) {
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	spec := testutil.Torrent{Files: []testutil.File{{Data: string(data)}}, Name: "data"}
+	mi := spec.Metainfo(1 << 16)
+	seederDataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(seederDataDir, spec.Name), data, 0o644))
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	cfg.PreferReliablePeersFromTracker = true
+	cfg.SchedulerParams = StaticSchedulerParams{UnchokeSlots: 1}
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	<-seederTorrent.Complete.On()
+	addLeecher := func(score int) *Client {
+		cfg := TestingConfig(t)
+		// Slow enough that the leechers stay interested.
+		cfg.DownloadRateLimiter = rate.NewLimiter(32<<10, 32<<10)
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { cl.Close() })
+		seeder.lock()
+		if seederTorrent.trackerReliability == nil {
+			seederTorrent.trackerReliability = make(map[trackerReliabilityKey]trackerReliabilityScore)
+		}
+		key, ok := makeTrackerReliabilityKey(fmt.Sprintf("127.0.0.1:%d", cl.LocalPort()), cl.PeerID())
+		require.True(t, ok)
+		seederTorrent.trackerReliability[key] = trackerReliabilityScore{score, time.Now().Add(time.Hour)}
+		seeder.unlock()
+		tt, err := cl.AddTorrent(mi)
+		require.NoError(t, err)
+		tt.AddClientPeer(seeder)
+		tt.DownloadAll()
+		return cl
+	}
+	// Whether the seeder has unchoked the leecher.
+	unchoked := func(cl *Client) bool {
+		seeder.rLock()
+		defer seeder.rUnlock()
+		for c := range seederTorrent.conns {
+			if c.PeerID == cl.PeerID() && !c.choking {
+				return true
+			}
+		}
+		return false
+	}
+	unreliable := addLeecher(20)
+	require.Eventually(t, func() bool { return unchoked(unreliable) }, 10*time.Second, time.Millisecond)
+	reliable := addLeecher(80)
+	require.Eventually(t, func() bool {
+		return unchoked(reliable) && !unchoked(unreliable)
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestUnchokeSlotsReduced(t *testing.T) {
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	spec := testutil.Torrent{Files: []testutil.File{{Data: string(data)}}, Name: "data"}
+	mi := spec.Metainfo(1 << 16)
+	seederDataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(seederDataDir, spec.Name), data, 0o644))
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	<-seederTorrent.Complete.On()
+	var leechers []*Client
+	for i := 0; i < 2; i++ {
+		cfg := TestingConfig(t)
+		// Slow enough that the leechers stay interested.
+		cfg.DownloadRateLimiter = rate.NewLimiter(32<<10, 32<<10)
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		defer cl.Close()
+		tt, err := cl.AddTorrent(mi)
+		require.NoError(t, err)
+		tt.AddClientPeer(seeder)
+		tt.DownloadAll()
+		leechers = append(leechers, cl)
+	}
+	// The number of leechers, and of conns, that the seeder has unchoked.
+	numUnchoked := func() (leechersUnchoked, connsUnchoked int) {
+		seeder.rLock()
+		defer seeder.rUnlock()
+		for _, cl := range leechers {
+			for c := range seederTorrent.conns {
+				if c.PeerID == cl.PeerID() && !c.choking {
+					leechersUnchoked++
+					break
+				}
+			}
+		}
+		for c := range seederTorrent.conns {
+			if !c.choking {
+				connsUnchoked++
+			}
+		}
+		return
+	}
+	require.Eventually(t, func() bool {
+		leechersUnchoked, _ := numUnchoked()
+		return leechersUnchoked == 2
+	}, 10*time.Second, time.Millisecond)
+	seeder.lock()
+	seederTorrent.setTrackerSchedulerParams(SchedulerParams{UnchokeSlots: 1})
+	seeder.unlock()
+	require.Eventually(t, func() bool {
+		leechersUnchoked, connsUnchoked := numUnchoked()
+		return leechersUnchoked == 1 && connsUnchoked == 1
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestPreferFastPeersFromTracker(t *testing.T) {
+	peer := func(port int) krpc.NodeAddr {
+		return krpc.NodeAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: port}
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := httpTracker.HttpResponse{
+			Interval: 1800,
+			// The fastest peer isn't in the usual list.
+			FastPeers: krpc.CompactIPv4NodeAddrs{peer(1004), peer(1003), peer(1002)},
+		}
+		resp.Peers.Compact = true
+		for _, na := range []krpc.NodeAddr{peer(1001), peer(1002), peer(1003)} {
+			resp.Peers.List = append(resp.Peers.List, httpTracker.Peer{}.FromNodeAddr(na))
+		}
+		bencode.NewEncoder(w).Encode(resp)
+	}))
+	defer s.Close()
+	// Returns the tracker's peers in the order they'd be dialed, with their ranks.
+	reservePeers := func(prefer bool) (ret []string) {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.PreferFastPeersFromTracker = prefer
+		// Keep the peers in reserve.
+		cfg.TotalHalfOpenConns = 0
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		defer cl.Close()
+		tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+			InfoHash: metainfo.Hash{1},
+			Trackers: [][]string{{s.URL + "/announce"}},
+		})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			cl.rLock()
+			defer cl.rUnlock()
+			return tt.peers.Len() >= 3
+		}, 10*time.Second, time.Millisecond)
+		cl.lock()
+		defer cl.unlock()
+		for tt.peers.Len() != 0 {
+			p := tt.peers.PopMax()
+			ret = append(ret, fmt.Sprintf("%v %v", p.Addr, p.TrackerSpeedRank))
+		}
+		return
+	}
+	assert.Equal(t, []string{
+		"127.0.0.1:1004 1",
+		"127.0.0.1:1003 2",
+		"127.0.0.1:1002 3",
+		"127.0.0.1:1001 0",
+	}, reservePeers(true))
+	peers := reservePeers(false)
+	assert.Len(t, peers, 3)
+	for _, p := range peers {
+		assert.True(t, strings.HasSuffix(p, " 0"), p)
+	}
+}
+
+func TestRankTrackerPeersForgetsUnlisted(t *testing.T) {
+	cfg := TestingConfig(t)
+	// Keep the peers in reserve.
+	cfg.TotalHalfOpenConns = 0
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	rank := func(tracker string, ports ...int) {
+		var fast []httpTracker.Peer
+		for _, port := range ports {
+			fast = append(fast, httpTracker.Peer{IP: net.IPv4(127, 0, 0, 1).To4(), Port: port})
+		}
+		tt.AddPeers(tt.rankTrackerPeers(tracker, nil, fast))
+	}
+	rank("a", 1001, 1002)
+	rank("b", 1003)
+	// The first tracker no longer ranks 1001, but the second tracker's ranking stands.
+	rank("a", 1002)
+	cl.lock()
+	defer cl.unlock()
+	ranks := make(map[string]int)
+	tt.peers.Each(func(p PeerInfo) {
+		ranks[p.Addr.String()] = p.TrackerSpeedRank
+	})
+	assert.Equal(t, map[string]int{
+		"127.0.0.1:1001": 0,
+		"127.0.0.1:1002": 1,
+		"127.0.0.1:1003": 1,
+	}, ranks)
+}
+
+func TestPieceAssigner(t *testing.T) {
+	var pa trackerServer.PieceAssigner
+	ih := [20]byte{1}
+	now := time.Now()
+	assign := func(id byte, left int64) []int {
+		begin, end, ok := pa.Assign(ih, [20]byte{id}, 10, left, false, now)
+		if !ok {
+			return nil
+		}
+		return []int{begin, end}
+	}
+	assert.Equal(t, []int{0, 10}, assign(1, 1))
+	assert.Equal(t, []int{5, 10}, assign(2, 1))
+	assert.Equal(t, []int{6, 10}, assign(3, 1))
+	assert.Equal(t, []int{0, 3}, assign(1, 1))
+	assert.Equal(t, []int{3, 6}, assign(2, 1))
+	// Seeders aren't assigned pieces, and leave the swarm.
+	assert.Nil(t, assign(4, 0))
+	assert.Nil(t, assign(1, 0))
+	assert.Equal(t, []int{0, 5}, assign(2, 1))
+	_, _, ok := pa.Assign(ih, [20]byte{3}, 10, 1, true, now)
+	assert.False(t, ok)
+	assert.Equal(t, []int{0, 10}, assign(2, 1))
+	// There aren't enough pieces for everyone.
+	for id := byte(5); id < 15; id++ {
+		assign(id, 1)
+	}
+	assert.Nil(t, assign(2, 1))
+	assert.Equal(t, []int{9, 10}, assign(14, 1))
+	// Leechers that stop announcing leave the swarm.
+	now = now.Add(trackerServer.DefaultPieceAssignerTimeout)
+	assign(14, 1)
+	now = now.Add(time.Second)
+	assert.Equal(t, []int{0, 10}, assign(14, 1))
+	assert.Equal(t, []int{5, 10}, assign(2, 1))
+}
+
+func TestTrackerPieceAssignmentCleared(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	tt.DownloadAll()
+	priorities := func() (ret []piecePriority) {
+		for i := 0; i < tt.NumPieces(); i++ {
+			ret = append(ret, tt.Piece(i).State().Priority)
+		}
+		return
+	}
+	cl.lock()
+	tt.setTrackerAssignedPieces(tracker.PieceRange{Begin: 1, End: 2})
+	cl.unlock()
+	assert.Equal(t, []piecePriority{PiecePriorityNormal, PiecePriorityHigh, PiecePriorityNormal}, priorities())
+	// A response without an assignment.
+	cl.lock()
+	tt.setTrackerAssignedPieces(tracker.PieceRange{})
+	cl.unlock()
+	assert.Equal(t, []piecePriority{PiecePriorityNormal, PiecePriorityNormal, PiecePriorityNormal}, priorities())
+}
+
+func TestTrackerPieceAssignment(t *testing.T) {
+	var numPieces atomic.Value
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numPieces.Store(r.URL.Query()["num_pieces"])
+		bencode.NewEncoder(w).Encode(httpTracker.HttpResponse{
+			Interval:       1800,
+			AssignedPieces: []int{1, 2},
+		})
+	}))
+	defer s.Close()
+	_, mi := testutil.GreetingTestTorrent()
+	mi.Announce = s.URL + "/announce"
+	priorities := func(assignment bool) (ret []piecePriority) {
+		cfg := TestingConfig(t)
+		cfg.DisableTrackers = false
+		cfg.TrackerPieceAssignment = assignment
+		cl, err := NewClient(cfg)
+		require.NoError(t, err)
+		defer cl.Close()
+		tt, err := cl.AddTorrent(mi)
+		require.NoError(t, err)
+		tt.DownloadAll()
+		require.Eventually(t, func() bool {
+			return !tt.TrackerStatuses()[0].LastAnnounce.IsZero()
+		}, 10*time.Second, time.Millisecond)
+		for i := 0; i < tt.NumPieces(); i++ {
+			ret = append(ret, tt.Piece(i).State().Priority)
+		}
+		return
+	}
+	assert.Equal(t, []piecePriority{PiecePriorityNormal, PiecePriorityHigh, PiecePriorityNormal}, priorities(true))
+	assert.Equal(t, []string{"3"}, numPieces.Load())
+	assert.Equal(t, []piecePriority{PiecePriorityNormal, PiecePriorityNormal, PiecePriorityNormal}, priorities(false))
+	assert.Nil(t, numPieces.Load())
+}
+
+func TestStatsReportJitter(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.StatsReportInterval = 10 * time.Second
+	cfg.StatsReportJitter = 2 * time.Second
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	minDelay, maxDelay := time.Duration(math.MaxInt64), time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		d := cl.statsReportDelay()
+		if d < minDelay {
+			minDelay = d
+		}
+		if d > maxDelay {
+			maxDelay = d
+		}
+	}
+	assert.GreaterOrEqual(t, minDelay, 8*time.Second)
+	assert.Less(t, minDelay, 9*time.Second)
+	assert.Less(t, maxDelay, 12*time.Second)
+	assert.Greater(t, maxDelay, 11*time.Second)
+	cl.lock()
+	cl.settings.statsReportJitter = time.Minute
+	cl.unlock()
+	for i := 0; i < 1000; i++ {
+		assert.Positive(t, cl.statsReportDelay())
+	}
+}
+
+func TestStatsReportsContinueWhileSeeding(t *testing.T) {
+	tr := newTestStatsReportTracker(t)
+	defer tr.Close()
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	cfg = TestingConfig(t)
+	cfg.Seed = true
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportTrackers = []string{tr.URL + "/announce"}
+	leecher, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	seedingReports := func() (n int) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		for _, r := range tr.reports {
+			if r.Left == 0 {
+				n++
+			}
+		}
+		return
+	}
+	require.Eventually(t, func() bool { return seedingReports() >= 3 }, 10*time.Second, time.Millisecond)
+}
+
+func TestCompletionSLOEscalation(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	_, mi := testutil.GreetingTestTorrent()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.DownloadAll()
+	maxConns := cl.config.EstablishedConnsPerTorrent
+	tt.SetCompletionDeadline(time.Now().Add(time.Hour))
+	slo, ok := tt.CompletionSLO()
+	require.True(t, ok)
+	assert.True(t, slo.OnPace)
+	assert.False(t, slo.Escalated)
+	// No progress after the grace period is behind pace.
+	cl.lock()
+	tt.checkCompletionSLO(slo.Start.Add(completionSLOGracePeriod))
+	assert.Equal(t, 2*maxConns, tt.maxEstablishedConns)
+	cl.unlock()
+	slo, _ = tt.CompletionSLO()
+	assert.False(t, slo.OnPace)
+	assert.True(t, slo.Escalated)
+	assert.True(t, slo.Projected.IsZero())
+	assert.False(t, slo.Missed)
+	cl.lock()
+	tt.checkCompletionSLO(slo.Deadline.Add(time.Second))
+	cl.unlock()
+	slo, _ = tt.CompletionSLO()
+	assert.True(t, slo.Missed)
+	tt.SetCompletionDeadline(time.Time{})
+	_, ok = tt.CompletionSLO()
+	assert.False(t, ok)
+	cl.lock()
+	assert.Equal(t, maxConns, tt.maxEstablishedConns)
+	cl.unlock()
+}
+
+func TestCompletionSLOMet(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.DownloadAll()
+	leecherTorrent.SetCompletionDeadline(time.Now().Add(time.Minute))
+	leecherTorrent.AddClientPeer(seeder)
+	<-leecherTorrent.Complete.On()
+	slo, ok := leecherTorrent.CompletionSLO()
+	require.True(t, ok)
+	assert.False(t, slo.Completed.IsZero())
+	assert.True(t, slo.Met)
+	assert.True(t, slo.OnPace)
+	assert.False(t, slo.Missed)
+}
+
+func TestStatsReportURL(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[metainfo.Hash]string)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := httpTracker.ParseStatsReportRequest(r)
+		assert.NoError(t, err)
+		mu.Lock()
+		paths[report.InfoHash] = r.URL.Path
+		mu.Unlock()
+		w.Write([]byte("de"))
+	}))
+	defer s.Close()
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportURL = s.URL + "/download"
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{2},
+		Trackers: [][]string{{s.URL + "/announce"}},
+	})
+	require.NoError(t, err)
+	tt.SetStatsReportURL(s.URL + "/torrent")
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return paths[metainfo.Hash{1}] == "/download" && paths[metainfo.Hash{2}] == "/torrent"
+	}, 10*time.Second, time.Millisecond)
+	// Without an override the Torrent's trackers are used, and then the fallback.
+	cl.config.StatsReportURL = ""
+	tt.SetStatsReportURL("")
+	cl.lock()
+	defer cl.unlock()
+	targets := tt.statsReportTargets()
+	require.Len(t, targets, 1)
+	assert.Equal(t, s.URL+"/announce", targets[0].u.String())
+	assert.False(t, targets[0].endpoint)
+	targets = cl.torrents[metainfo.Hash{1}].statsReportTargets()
+	require.Len(t, targets, 1)
+	assert.Equal(t, fallbackStatsReportURL, targets[0].u.String())
+	assert.True(t, targets[0].endpoint)
+}
+
+func TestTorrentPriorityPreemption(t *testing.T) {
+	cfg := TestingConfig(t)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	_, mi := testutil.GreetingTestTorrent()
+	low, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	low.DownloadAll()
+	high, err := cl.AddTorrent((&testutil.Torrent{
+		Name:  "high",
+		Files: []testutil.File{{Data: "high priority"}},
+	}).Metainfo(5))
+	require.NoError(t, err)
+	high.DownloadAll()
+	assert.Equal(t, TorrentPriorityNormal, low.Priority())
+	low.SetPriority(TorrentPriorityLow)
+	assert.Equal(t, TorrentPriorityLow, low.Priority())
+	lowPeer := &Peer{t: low, PeerMaxRequests: 250, peakRequests: 100}
+	// Wait for the initial piece checks, so the high priority Torrent wants data.
+	require.Eventually(t, func() bool {
+		cl.rLock()
+		defer cl.rUnlock()
+		return high.needData()
+	}, 10*time.Second, time.Millisecond)
+	cl.lock()
+	defer cl.unlock()
+	// The high priority Torrent doesn't preempt until it has peers to download from.
+	assert.False(t, low.preemptedByPriority())
+	assert.EqualValues(t, 200, lowPeer.nominalMaxRequests())
+	cn := &PeerConn{Peer: Peer{t: high, callbacks: &cfg.Callbacks}}
+	cn.initRequestState()
+	cn.peerImpl = cn
+	high.conns[cn] = struct{}{}
+	high.updateDownloadingForPriority("test")
+	assert.True(t, low.preemptedByPriority())
+	assert.False(t, high.preemptedByPriority())
+	assert.EqualValues(t, preemptedMaxRequests, lowPeer.nominalMaxRequests())
+	// Preemption ends when the high priority Torrent stops downloading.
+	high.dataDownloadDisallowed.Set()
+	high.updateDownloadingForPriority("test")
+	assert.False(t, low.preemptedByPriority())
+	high.dataDownloadDisallowed.Clear()
+	high.updateDownloadingForPriority("test")
+	assert.True(t, low.preemptedByPriority())
+	// Equal priorities don't preempt each other.
+	low.priority = TorrentPriorityNormal
+	assert.False(t, low.preemptedByPriority())
+	delete(high.conns, cn)
+	high.updateDownloadingForPriority("test")
+}
+
+func TestTorrentRequestState(t *testing.T) {
+	cfg := TestingConfig(t)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	_, mi := testutil.GreetingTestTorrent()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	assert.Empty(t, tt.RequestState())
+	cn := &PeerConn{Peer: Peer{
+		t:           tt,
+		Network:     "tcp",
+		RemoteAddr:  &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5},
+		peerChoking: true,
+	}, PeerID: PeerID{1}}
+	cn.peerImpl = cn
+	sent := time.Now().Add(-time.Minute)
+	cl.lock()
+	tt.requestState[0] = requestState{peer: &cn.Peer, when: sent}
+	cl.unlock()
+	defer func() {
+		cl.lock()
+		delete(tt.requestState, 0)
+		cl.unlock()
+	}()
+	assert.Equal(t, []OutstandingRequest{{
+		Request:     newRequest(0, 0, 5),
+		Sent:        sent,
+		PeerID:      PeerID{1},
+		RemoteAddr:  "1.2.3.4:5",
+		Network:     "tcp",
+		PeerChoking: true,
+	}}, tt.RequestState())
+}
+
+func TestAutobandState(t *testing.T) {
+	s := autobandState{limit: rate.Inf}
+	now := time.Now()
+	var uploaded int64
+	step := func(latency time.Duration, upRate int64) rate.Limit {
+		now = now.Add(time.Second)
+		uploaded += upRate
+		return s.update(now, latency, uploaded)
+	}
+	// No inflation, no cap.
+	assert.Equal(t, rate.Inf, step(20*time.Millisecond, 0))
+	assert.Equal(t, rate.Inf, step(20*time.Millisecond, 1<<20))
+	// Latency inflates under load, so the cap goes just under the rate at the time.
+	assert.EqualValues(t, 1<<20*autobandHeadroom, step(200*time.Millisecond, 1<<20))
+	// Inflation while barely uploading isn't ours.
+	limit := step(200*time.Millisecond, 1<<10)
+	assert.EqualValues(t, 1<<20*autobandHeadroom, limit)
+	// Uploading at the cap without inflation probes for more capacity.
+	assert.EqualValues(t, limit*autobandIncrease, step(20*time.Millisecond, int64(limit)))
+	// But not when there's no demand.
+	assert.EqualValues(t, limit*autobandIncrease, step(20*time.Millisecond, 0))
+	// The cap never goes below the minimum.
+	s.limit = defaultAutobandMinRate
+	assert.EqualValues(t, defaultAutobandMinRate, step(time.Second, defaultAutobandMinRate))
+}
+
+func TestAutobandBaseLatencyWindow(t *testing.T) {
+	s := autobandState{limit: rate.Inf}
+	now := time.Now()
+	s.update(now, 20*time.Millisecond, 0)
+	assert.Equal(t, 20*time.Millisecond, s.base)
+	// The route changes, and the base latency is accepted after it's been seen for a window.
+	for i := 0; i < 3; i++ {
+		now = now.Add(autobandBaseWindow)
+		s.update(now, 50*time.Millisecond, 0)
+	}
+	assert.Equal(t, 50*time.Millisecond, s.base)
+}
+
+func TestAutobandOwnLimiter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	cfg := TestingConfig(t)
+	cfg.Autoband.ProbeAddr = l.Addr().String()
+	cfg.Autoband.ProbeInterval = time.Millisecond
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	// The shared default limiter must not be adjusted.
+	assert.NotSame(t, unlimited, cl.settings.uploadRateLimiter)
+	assert.Equal(t, rate.Inf, unlimited.Limit())
+	assert.Equal(t, 0, unlimited.Burst())
+	autobandLimiter := cl.autobandLimiter
+	require.NotNil(t, autobandLimiter)
+	assert.Equal(t, autobandBurst, autobandLimiter.Burst())
+	// Config reloads change the configured limit, and leave the autoband cap to autoband.
+	autobandLimiter.SetLimit(2000)
+	newCfg := *cfg
+	newCfg.UploadRateLimiter = rate.NewLimiter(1000, 1<<20)
+	cl.ApplyConfig(&newCfg)
+	assert.EqualValues(t, 1000, cl.settings.uploadRateLimiter.Limit())
+	assert.Same(t, autobandLimiter, cl.autobandLimiter)
+	assert.Equal(t, autobandBurst, autobandLimiter.Burst())
+	cl.lock()
+	assert.EqualValues(t, 1000, cl.uploadRateLimit())
+	cl.unlock()
+}
+
+func TestStatsReporterLifecycle(t *testing.T) {
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body has been read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+		select {
+		case cancelled <- struct{}{}:
+		default:
+		}
+	}))
+	defer s.Close()
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportInterval = time.Millisecond
+	cfg.StatsReportURL = s.URL
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	<-started
+	// Dropping the Torrent abandons its report rather than waiting out the timeout.
+	tt.Drop()
+	select {
+	case <-cancelled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("report not abandoned after drop")
+	}
+	reporter := cl.statsReporter
+	cl.Close()
+	select {
+	case <-reporter.done:
+	default:
+		t.Fatal("reporter still running after Close")
+	}
+}
+
+func TestReportStatsErrors(t *testing.T) {
+	var fail int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("d5:peersd20:aaaaaaaaaaaaaaaaaaaad8:uploadedi1eeee"))
+	}))
+	defer s.Close()
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	tt.SetStatsReportURL(s.URL)
+	resp, err := tt.reportStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]httpTracker.ReportedPeer{
+		"aaaaaaaaaaaaaaaaaaaa": {Uploaded: 1},
+	}, resp.Peers)
+	atomic.StoreInt32(&fail, 1)
+	_, err = tt.reportStats(context.Background())
+	assert.ErrorContains(t, err, s.URL)
+	// A tracker that doesn't take reports isn't an error worth reporting.
+	tt.SetStatsReportURL("")
+	tt.AddTrackers([][]string{{s.URL + "/a"}})
+	_, err = tt.reportStats(context.Background())
+	assert.Equal(t, httpTracker.ErrReportNotSupported, err)
+	tt.Drop()
+	_, err = tt.reportStats(context.Background())
+	assert.EqualError(t, err, "torrent closed")
+}
+
+// Deltas of reports that weren't delivered are carried into the next report.
+func TestStatsReportDeltasKeptUntilDelivered(t *testing.T) {
+	var fail int32
+	var deltas []int64
+	var mu sync.Mutex
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		report, err := httpTracker.ParseStatsReportRequest(r)
+		assert.NoError(t, err)
+		mu.Lock()
+		deltas = append(deltas, report.UploadedDelta)
+		mu.Unlock()
+		w.Write([]byte("de"))
+	}))
+	defer s.Close()
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	tt.SetStatsReportURL(s.URL)
+	uploaded := func(n int64) {
+		cl.lock()
+		tt.stats.BytesWrittenData.Add(n)
+		cl.unlock()
+	}
+	uploaded(1)
+	_, err = tt.reportStats(context.Background())
+	require.NoError(t, err)
+	uploaded(2)
+	atomic.StoreInt32(&fail, 1)
+	_, err = tt.reportStats(context.Background())
+	require.Error(t, err)
+	uploaded(4)
+	atomic.StoreInt32(&fail, 0)
+	_, err = tt.reportStats(context.Background())
+	require.NoError(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int64{1, 6}, deltas)
+	assert.EqualValues(t, 7, tt.ReportedUploadBytes())
+}
+
+func TestScheduledVerification(t *testing.T) {
+	dataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dataDir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = dataDir
+	var events []ScheduledVerificationEvent
+	cfg.Callbacks.ScheduledVerification = append(cfg.Callbacks.ScheduledVerification, func(e ScheduledVerificationEvent) {
+		events = append(events, e)
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	require.True(t, tt.Complete.Bool())
+	// Nothing is scheduled by default.
+	now := time.Now()
+	cl.runScheduledVerifications(now.Add(24 * time.Hour))
+	assert.Empty(t, events)
+	tt.SetScheduledVerificationInterval(time.Hour)
+	cl.runScheduledVerifications(now)
+	assert.Empty(t, events)
+	cl.runScheduledVerifications(now.Add(2 * time.Hour))
+	require.Len(t, events, 1)
+	assert.Equal(t, tt, events[0].Torrent)
+	assert.Empty(t, events[0].FailedPieces)
+	// The next is scheduled from when the last finished.
+	cl.runScheduledVerifications(events[0].Finished.Add(time.Hour - time.Second))
+	require.Len(t, events, 1)
+	f, err := os.OpenFile(filepath.Join(dataDir, testutil.GreetingFileName), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("j"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	cl.runScheduledVerifications(now.Add(5 * time.Hour))
+	require.Len(t, events, 2)
+	assert.Equal(t, []int{0}, events[1].FailedPieces)
+	assert.False(t, tt.Complete.Bool())
+}
+
+func TestTransferRatesState(t *testing.T) {
+	var s transferRatesState
+	now := time.Now()
+	var read int64
+	s.sample(now, read, 0, 10*time.Second)
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Second)
+		read += 1000
+		s.sample(now, read, 0, 10*time.Second)
+	}
+	assert.InDelta(t, 1000, s.download, 1)
+	assert.Zero(t, s.upload)
+	// A single long sample has the same effect as many short ones covering the same time.
+	a, b := s, s
+	a.sample(now.Add(5*time.Second), read, 0, 10*time.Second)
+	for i := 1; i <= 5; i++ {
+		b.sample(now.Add(time.Duration(i)*time.Second), read, 0, 10*time.Second)
+	}
+	assert.InDelta(t, 1000*math.Exp(-0.5), a.download, 1)
+	assert.InDelta(t, a.download, b.download, 1e-6)
+}
+
+func TestTorrentTransferRates(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	leecherTorrent.AddClientPeer(seeder)
+	leecherTorrent.DownloadAll()
+	<-leecherTorrent.Complete.On()
+	require.Eventually(t, func() bool {
+		return leecherTorrent.DownloadRate() > 0 && seederTorrent.UploadRate() > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Zero(t, leecherTorrent.UploadRate())
+}
+
+func TestPeerConnTransferRates(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	now := time.Now()
+	cn := &PeerConn{Peer: Peer{
+		t:                  tt,
+		completedHandshake: now,
+	}}
+	cn.peerImpl = cn
+	cl.lock()
+	cn._stats.BytesWrittenData.Add(10000)
+	cn.sampleTransferRates(now.Add(time.Second))
+	cl.unlock()
+	// Transfers before the first sample count from the handshake.
+	assert.InDelta(t, 10000*(1-math.Exp(-0.1)), cn.SmoothedUploadRate(), 1)
+	assert.Zero(t, cn.SmoothedDownloadRate())
+}
+
+func TestApplyConfig(t *testing.T) {
+	cfg := TestingConfig(t)
+	var changed []ConfigChange
+	cfg.Callbacks.ConfigChanged = append(cfg.Callbacks.ConfigChanged, func(c ConfigChange) {
+		changed = append(changed, c)
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	a, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	b, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{2}})
+	require.NoError(t, err)
+	b.SetMaxEstablishedConns(3)
+	uploadLimiter := cl.settings.uploadRateLimiter
+	downloadLimiter := cl.settings.downloadRateLimiter
+
+	newCfg := *cfg
+	newCfg.UploadRateLimiter = rate.NewLimiter(1000, 1<<20)
+	newCfg.DownloadRateLimiter = rate.NewLimiter(2000, 1<<20)
+	newCfg.EstablishedConnsPerTorrent = cfg.EstablishedConnsPerTorrent + 10
+	newCfg.PeerReadBufferSize = 1 << 10
+	newCfg.Debug = !cfg.Debug
+	changes := cl.ApplyConfig(&newCfg)
+	assert.EqualValues(t, []ConfigChange{
+		{"UploadRateLimiter", false},
+		{"DownloadRateLimiter", false},
+		{"EstablishedConnsPerTorrent", false},
+		{"PeerReadBufferSize", true},
+		{"Debug", false},
+	}, changes)
+	assert.EqualValues(t, changes, changed)
+	// The Client's limiters are changed in place, so existing conns see it.
+	assert.Same(t, uploadLimiter, cl.settings.uploadRateLimiter)
+	assert.Same(t, downloadLimiter, cl.settings.downloadRateLimiter)
+	assert.EqualValues(t, 1000, uploadLimiter.Limit())
+	assert.EqualValues(t, 2000, downloadLimiter.Limit())
+	assert.Equal(t, newCfg.Debug, cl.debugLogging())
+	// The config the Client was created with, and the shared default limiter, are unchanged.
+	assert.Same(t, unlimited, cfg.UploadRateLimiter)
+	assert.EqualValues(t, rate.Inf, unlimited.Limit())
+	assert.NotEqual(t, newCfg.EstablishedConnsPerTorrent, cfg.EstablishedConnsPerTorrent)
+	assert.NotEqual(t, newCfg.Debug, cfg.Debug)
+	cl.rLock()
+	assert.EqualValues(t, newCfg.EstablishedConnsPerTorrent, a.maxEstablishedConns)
+	// Explicitly set limits are kept.
+	assert.EqualValues(t, 3, b.maxEstablishedConns)
+	cl.rUnlock()
+
+	assert.Empty(t, cl.ApplyConfig(&newCfg))
+}
+
+// Collects the text of log records.
+type testLogHandler struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (me *testLogHandler) Handle(r log.Record) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.texts = append(me.texts, r.Text())
+}
+
+func (me *testLogHandler) logged(text string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, s := range me.texts {
+		if strings.Contains(s, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApplyConfigDebug(t *testing.T) {
+	h := &testLogHandler{}
+	cfg := TestingConfig(t)
+	cfg.Logger = log.NewLogger().FilterLevel(log.Info)
+	cfg.Logger.SetHandlers(h)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	// Loggers made before the change see it.
+	tt.logger.Levelf(log.Debug, "before")
+	cl.logger.Levelf(log.Info, "info")
+	newCfg := *cfg
+	newCfg.Debug = true
+	cl.ApplyConfig(&newCfg)
+	tt.logger.Levelf(log.Debug, "after")
+	assert.False(t, h.logged("before"))
+	assert.True(t, h.logged("info"))
+	assert.True(t, h.logged("after"))
+}
+
+func TestNamespaces(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	alice := cl.Namespace("alice")
+	bob := cl.Namespace("bob")
+	assert.Same(t, alice, cl.Namespace("alice"))
+	assert.Equal(t, []*Namespace{alice, bob}, cl.Namespaces())
+	alice.SetQuota(NamespaceQuota{MaxTorrents: 1, UploadRate: 1000})
+	assert.EqualValues(t, 1000, alice.uploadLimiter.Limit())
+	assert.EqualValues(t, rate.Inf, alice.downloadLimiter.Limit())
+
+	a, _, err := alice.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	assert.Same(t, alice, a.Namespace())
+	// Adding it again merges as usual.
+	_, isNew, err := alice.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	assert.False(t, isNew)
+	_, _, err = alice.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{2}})
+	assert.ErrorIs(t, err, ErrNamespaceQuota)
+	_, ok := cl.Torrent(metainfo.Hash{2})
+	assert.False(t, ok)
+	_, _, err = bob.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	assert.ErrorIs(t, err, ErrTorrentInOtherNamespace)
+	assert.Equal(t, []*Torrent{a}, alice.Torrents())
+	assert.Empty(t, bob.Torrents())
+
+	// Torrents with info are counted against the byte quota.
+	_, mi := testutil.GreetingTestTorrent()
+	bob.SetQuota(NamespaceQuota{MaxBytes: int64(len(testutil.GreetingFileContents)) - 1})
+	_, err = bob.AddTorrent(mi)
+	assert.ErrorIs(t, err, ErrNamespaceQuota)
+	bob.SetQuota(NamespaceQuota{MaxBytes: int64(len(testutil.GreetingFileContents))})
+	b, err := bob.AddTorrent(mi)
+	require.NoError(t, err)
+	assert.Equal(t, []*Torrent{b}, bob.Torrents())
+
+	// Dropped Torrents leave their Namespace, freeing quota.
+	a.Drop()
+	assert.Empty(t, alice.Torrents())
+	_, _, err = alice.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{2}})
+	assert.NoError(t, err)
+}
+
+func TestNamespaceDownloadLimit(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	ns := cl.Namespace("alice")
+	ns.SetQuota(NamespaceQuota{DownloadRate: 1})
+	limiterFull := func() bool {
+		now := time.Now()
+		res := ns.downloadLimiter.ReserveN(now, namespaceRateBurst)
+		defer res.CancelAt(now)
+		return res.DelayFrom(now) == 0
+	}
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	// Connections can be made before the Torrent joins its Namespace.
+	r := &namespaceLimitedReader{t: tt, r: bytes.NewReader(make([]byte, 2))}
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+	assert.True(t, limiterFull())
+	cl.lock()
+	ns.addTorrentLocked(tt)
+	cl.unlock()
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+	assert.False(t, limiterFull())
+}
+
+func TestNamespaceQuotaPauses(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = dir
+	cfg.Seed = true
+	var events []NamespaceQuotaEvent
+	cfg.Callbacks.NamespaceQuota = append(cfg.Callbacks.NamespaceQuota, func(e NamespaceQuotaEvent) {
+		events = append(events, e)
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ns := cl.Namespace("alice")
+	tt, err := ns.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	greetingLen := int64(len(testutil.GreetingFileContents))
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	ns.SetQuota(NamespaceQuota{MaxDiskBytes: greetingLen})
+	cl.checkNamespaceQuotas(now)
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Exceeded)
+	assert.Equal(t, NamespaceUsage{
+		Torrents:  1,
+		Bytes:     greetingLen,
+		DiskBytes: greetingLen,
+		Paused:    true,
+	}, ns.Usage())
+	cl.rLock()
+	assert.False(t, tt.seeding())
+	cl.rUnlock()
+
+	// Raising the disk quota resumes the Torrent, until the month's transfers reach the transfer
+	// quota.
+	ns.SetQuota(NamespaceQuota{MaxDiskBytes: 2 * greetingLen, MaxMonthlyTransfer: 100})
+	cl.checkNamespaceQuotas(now)
+	require.Len(t, events, 2)
+	assert.False(t, events[1].Exceeded)
+	cl.lock()
+	assert.True(t, tt.seeding())
+	tt.stats.BytesWrittenData.Add(60)
+	tt.stats.BytesReadData.Add(40)
+	cl.unlock()
+	cl.checkNamespaceQuotas(now)
+	require.Len(t, events, 3)
+	assert.True(t, events[2].Exceeded)
+	assert.EqualValues(t, 100, events[2].Usage.MonthlyTransfer)
+	// The count starts again next month.
+	cl.checkNamespaceQuotas(now.AddDate(0, 1, 0))
+	require.Len(t, events, 4)
+	assert.False(t, events[3].Exceeded)
+	assert.Zero(t, ns.Usage().MonthlyTransfer)
+}
+
+func TestExternalAddrs(t *testing.T) {
+	stunServer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stunServer.Close()
+	var mapped atomic.Value
+	mapped.Store(&stun.XORMappedAddress{IP: net.ParseIP("203.0.113.7"), Port: 6881})
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, addr, err := stunServer.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			req := stun.Message{Raw: b[:n]}
+			if req.Decode() != nil {
+				continue
+			}
+			res := stun.MustBuild(
+				stun.NewTransactionIDSetter(req.TransactionID),
+				stun.BindingSuccess,
+				mapped.Load().(*stun.XORMappedAddress),
+			)
+			stunServer.WriteTo(res.Raw, addr)
+		}
+	}()
+	var (
+		mu  sync.Mutex
+		ips url.Values
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/announce" {
+			mu.Lock()
+			ips = r.URL.Query()
+			mu.Unlock()
+		}
+		bencode.NewEncoder(w).Encode(map[string]interface{}{"interval": 60})
+	}))
+	defer s.Close()
+	changed := make(chan ExternalAddrs, 1)
+	cfg := TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.AnnounceIp6 = net.ParseIP("2001:db8::1")
+	cfg.StunServers = []string{stunServer.LocalAddr().String()}
+	cfg.NoDHT = false
+	cfg.DisableIPv6 = true
+	cfg.DhtStartingNodes = func(string) dht.StartingNodesGetter { return func() ([]dht.Addr, error) { return nil, nil } }
+	cfg.Callbacks.ExternalAddrsChanged = append(cfg.Callbacks.ExternalAddrsChanged, func(addrs ExternalAddrs) {
+		changed <- addrs
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	dhtServerSecure := func(ip string) bool {
+		cl.rLock()
+		defer cl.rUnlock()
+		require.Len(t, cl.dhtServers, 1)
+		return dht.NodeIdSecure(cl.dhtServers[0].ID(), net.ParseIP(ip))
+	}
+	// The first discovery happens in the background after NewClient returns, and the DHT server is
+	// restarted with a node ID for the discovered address.
+	assert.Equal(t, "203.0.113.7", (<-changed).Ip4.IP.String())
+	assert.True(t, dhtServerSecure("203.0.113.7"))
+	addrs := cl.ExternalAddrs()
+	assert.Equal(t, "203.0.113.7:6881", addrs.Ip4.String())
+	assert.Nil(t, addrs.Ip6.IP)
+	assert.Equal(t, "203.0.113.7", cl.publicIp(net.ParseIP("198.51.100.1")).String())
+	_, _, err = cl.AddTorrentSpec(&TorrentSpec{
+		InfoHash: metainfo.Hash{1},
+		Trackers: [][]string{{s.URL + "/announce"}},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ips != nil
+	}, 10*time.Second, time.Millisecond)
+	// The explicit announce address takes precedence over the discovered one.
+	assert.Equal(t, "203.0.113.7", ips.Get("ipv4"))
+	assert.Equal(t, "2001:db8::1", ips.Get("ipv6"))
+	assert.ElementsMatch(t, []string{"203.0.113.7", "2001:db8::1"}, ips["ip"])
+	mapped.Store(&stun.XORMappedAddress{IP: net.ParseIP("203.0.113.8"), Port: 6881})
+	require.True(t, cl.discoverExternalAddrs(context.Background()))
+	assert.Equal(t, "203.0.113.8", (<-changed).Ip4.IP.String())
+	assert.True(t, dhtServerSecure("203.0.113.8"))
+	// Unchanged addresses aren't notified.
+	require.True(t, cl.discoverExternalAddrs(context.Background()))
+	select {
+	case <-changed:
+		t.Fatal("notified without a change")
+	default:
+	}
+}
+
+func TestTorrentEvents(t *testing.T) {
+	seederDataDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(seederDataDir)
+	cfg := TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	seeder, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	leecher, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer leecher.Close()
+	tt, _ := leecher.AddTorrentInfoHash(mi.HashInfoBytes())
+	events := tt.Events()
+	defer events.Close()
+	tt.AddClientPeer(seeder)
+	go func() {
+		<-tt.GotInfo()
+		tt.DownloadAll()
+	}()
+	var (
+		got       []TorrentEvent
+		completed []int
+	)
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case e := <-events.Values:
+			got = append(got, e)
+			switch e := e.(type) {
+			case PieceCompletedEvent:
+				completed = append(completed, e.Index)
+			case DownloadCompletedEvent:
+				done = true
+			}
+		case <-timeout:
+			t.Fatalf("download didn't complete, got events %v", got)
+		}
+	}
+	assert.Contains(t, got, MetadataReceivedEvent{})
+	assert.ElementsMatch(t, []int{0, 1, 2}, completed)
+	var connected *PeerConn
+	for _, e := range got {
+		if e, ok := e.(PeerConnectedEvent); ok {
+			connected = e.Peer
+		}
+	}
+	require.NotNil(t, connected)
+	// Dropping the Torrent disconnects its peers and ends the subscription.
+	tt.Drop()
+	var disconnected bool
+	for e := range events.Values {
+		if e == (PeerDisconnectedEvent{connected}) {
+			disconnected = true
+		}
+	}
+	assert.True(t, disconnected)
+}
+
+func TestConnEncryptionCounts(t *testing.T) {
+	for _, tc := range []struct {
+		expected       ConnEncryption
+		preferred      bool
+		cryptoProvides mse.CryptoMethod
+	}{
+		{ConnPlaintext, false, mse.AllSupportedCrypto},
+		{ConnHeaderObfuscated, true, mse.AllSupportedCrypto},
+		{ConnEncrypted, true, mse.CryptoMethodRC4},
+	} {
+		t.Run(tc.expected.String(), func(t *testing.T) {
+			_, mi := testutil.GreetingTestTorrent()
+			newClient := func() (*Client, *Torrent) {
+				cfg := TestingConfig(t)
+				cfg.HeaderObfuscationPolicy = HeaderObfuscationPolicy{
+					Preferred:        tc.preferred,
+					RequirePreferred: true,
+				}
+				cfg.CryptoProvides = tc.cryptoProvides
+				cl, err := NewClient(cfg)
+				require.NoError(t, err)
+				t.Cleanup(func() { cl.Close() })
+				tt, err := cl.AddTorrent(mi)
+				require.NoError(t, err)
+				tt.DownloadAll()
+				return cl, tt
+			}
+			// Neither has the data, so the connections aren't dropped for being useless.
+			cl1, tt1 := newClient()
+			cl2, _ := newClient()
+			tt1.AddClientPeer(cl2)
+			require.Eventually(t, func() bool {
+				return len(tt1.PeerConns()) != 0
+			}, 10*time.Second, time.Millisecond)
+			// There may be a connection over each loopback address.
+			cl1.lock()
+			var expected ConnEncryptionCounts
+			for c := range tt1.conns {
+				assert.Equal(t, tc.expected, c.Encryption())
+				expected.add(tc.expected)
+			}
+			assert.Equal(t, expected, tt1.statsLocked().ConnEncryption)
+			assert.Equal(t, expected, cl1.connEncryptionCountsLocked())
+			cl1.unlock()
+			status := cl1.Status()
+			assert.Equal(t, tc.expected, status.Torrents[0].Peers[0].Encryption)
+		})
+	}
+}
+
+func TestTorrentRateLimits(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	other, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{2}})
+	require.NoError(t, err)
+	assert.Zero(t, tt.UploadLimit())
+	tt.SetUploadLimit(1 << 10)
+	tt.SetDownloadLimit(2 << 10)
+	assert.EqualValues(t, 1<<10, tt.UploadLimit())
+	assert.EqualValues(t, 2<<10, tt.DownloadLimit())
+	cn := &PeerConn{Peer: Peer{t: tt}}
+	otherCn := &PeerConn{Peer: Peer{t: other}}
+	// The burst is available immediately, and then uploads wait on the limit.
+	assert.Zero(t, cn.reserveUpload(torrentRateBurst))
+	assert.Greater(t, int64(cn.reserveUpload(defaultChunkSize)), int64(0))
+	// Other Torrents aren't affected.
+	assert.Zero(t, otherCn.reserveUpload(torrentRateBurst))
+	assert.Zero(t, otherCn.reserveUpload(defaultChunkSize))
+	tt.SetUploadLimit(0)
+	assert.Zero(t, tt.UploadLimit())
+	assert.Zero(t, cn.reserveUpload(defaultChunkSize))
+}
+
+func TestDivideRateShares(t *testing.T) {
+	const budget = 1 << 20
+	// Unlimited budgets aren't divided.
+	assert.Equal(t, []rate.Limit{0, 0}, divideRateShares(rate.Inf, []rate.Limit{1, 2}, []rate.Limit{0, 0}))
+	// Without previous shares, everyone starts even.
+	assert.Equal(t, []rate.Limit{budget / 2, budget / 2}, divideRateShares(budget, []rate.Limit{0, 0}, []rate.Limit{0, 0}))
+	// The idle node keeps what it used with headroom, and the busy node gets the rest.
+	assert.Equal(t,
+		[]rate.Limit{100 << 10 * rateShareHeadroom, budget - 100<<10*rateShareHeadroom},
+		divideRateShares(budget, []rate.Limit{100 << 10, budget / 2}, []rate.Limit{budget / 2, budget / 2}))
+	// Idle nodes wanting more than an even split are treated as busy.
+	assert.Equal(t,
+		[]rate.Limit{budget / 2, budget / 2},
+		divideRateShares(budget, []rate.Limit{budget / 2, budget}, []rate.Limit{budget, budget}))
+	// Remaining allowance is spread evenly when nobody's busy.
+	assert.Equal(t,
+		[]rate.Limit{budget / 2, budget / 2},
+		divideRateShares(budget, []rate.Limit{0, 0}, []rate.Limit{budget / 2, budget / 2}))
+}
+
+func TestFairRateSharing(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.FairRateSharing = true
+	cfg.UploadRateLimiter = rate.NewLimiter(1<<20, torrentRateBurst)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	busy, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	idle, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{2}})
+	require.NoError(t, err)
+	idle.SetUploadLimit(1 << 10)
+	cl.lock()
+	defer cl.unlock()
+	cl.rebalanceRateShares(time.Second)
+	// The explicit limit still applies to the Torrent's share.
+	assert.EqualValues(t, 1<<19, busy.uploadLimiter.Limit())
+	assert.EqualValues(t, 1<<10, idle.uploadLimiter.Limit())
+	busy.stats.BytesWrittenData.Add(1 << 19)
+	cl.rebalanceRateShares(time.Second)
+	assert.EqualValues(t, 1<<20-rateShareMin, busy.uploadLimiter.Limit())
+	assert.EqualValues(t, 1<<10, idle.uploadLimiter.Limit())
+	assert.EqualValues(t, rateShareMin, idle.uploadShare.share)
+	// Download isn't limited.
+	assert.Equal(t, rate.Inf, busy.downloadLimiter.Limit())
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"os"
 
 	"github.com/anacrolix/bargle"
@@ -26,6 +30,8 @@ func create() (cmd bargle.Command) {
 		PieceLength       tagflag.Bytes
 		Url               []string `name:"u" help:"add webseed url"`
 		Private           *bool
+		SignCert          string `help:"PEM certificate file to sign the info with (BEP 35)"`
+		SignKey           string `help:"PEM RSA private key file for the signing certificate"`
 		Root              string `arg:"positional"`
 	}
 	cmd = bargle.FromStruct(&args)
@@ -63,8 +69,30 @@ func create() (cmd bargle.Command) {
 		if err != nil {
 			return
 		}
+		if args.SignCert != "" {
+			err = signMetaInfo(&mi, args.SignCert, args.SignKey)
+			if err != nil {
+				return
+			}
+		}
 		err = mi.Write(os.Stdout)
 		return
 	}
 	return
 }
+
+func signMetaInfo(mi *metainfo.MetaInfo, certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", pair.PrivateKey)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	return mi.Sign(cert, key, true)
+}
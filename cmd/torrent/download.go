@@ -21,6 +21,7 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/config"
 	"github.com/anacrolix/torrent/iplist"
 	"github.com/anacrolix/torrent/metainfo"
 	pp "github.com/anacrolix/torrent/peer_protocol"
@@ -239,6 +240,7 @@ type downloadFlags struct {
 }
 
 type DownloadCmd struct {
+	Config             string `help:"YAML or TOML client config file, overriding the other client flags"`
 	SaveMetainfos      bool
 	Mmap               bool           `help:"memory-map torrent data"`
 	Seed               bool           `help:"seed after download is complete"`
@@ -246,14 +248,19 @@ type DownloadCmd struct {
 	MaxUnverifiedBytes *tagflag.Bytes `help:"maximum number bytes to have pending verification"`
 	UploadRate         *tagflag.Bytes `help:"max piece bytes to send per second"`
 	DownloadRate       *tagflag.Bytes `help:"max bytes per second down from peers"`
+	HashRate           *tagflag.Bytes `help:"max bytes per second read from disk for piece hashing"`
 	PackedBlocklist    string
 	PublicIP           net.IP
-	Progress           bool `default:"true"`
-	PieceStates        bool `help:"Output piece state runs at progress intervals."`
-	Quiet              bool `help:"discard client logging"`
-	Stats              bool `help:"print stats at termination"`
-	Dht                bool `default:"true"`
-	PortForward        bool `default:"true"`
+	AnnounceIP         net.IP   `help:"address sent to trackers, when it differs from the source of announces"`
+	StunServer         []string `help:"STUN server to discover our external address"`
+	Progress           bool     `default:"true"`
+	PieceStates        bool     `help:"Output piece state runs at progress intervals."`
+	Quiet              bool     `help:"discard client logging"`
+	Stats              bool     `help:"print stats at termination"`
+	Dht                bool     `default:"true"`
+	PortForward        bool     `default:"true"`
+	PeerIdPrefix       string   `help:"peer id prefix, such as -RB0001- for ReliableBT experiments"`
+	ClientVersion      string   `help:"client name sent in the extended handshake"`
 
 	TcpPeers        bool `default:"true"`
 	UtpPeers        bool `default:"true"`
@@ -291,9 +298,21 @@ func downloadErr(flags downloadFlags) error {
 	clientConfig.Seed = flags.Seed
 	clientConfig.PublicIp4 = flags.PublicIP.To4()
 	clientConfig.PublicIp6 = flags.PublicIP
+	if ip4 := flags.AnnounceIP.To4(); ip4 != nil {
+		clientConfig.AnnounceIp4 = ip4
+	} else {
+		clientConfig.AnnounceIp6 = flags.AnnounceIP
+	}
+	clientConfig.StunServers = flags.StunServer
 	clientConfig.DisablePEX = !flags.Pex
 	clientConfig.DisableWebtorrent = !flags.Webtorrent
 	clientConfig.NoDefaultPortForwarding = !flags.PortForward
+	if flags.PeerIdPrefix != "" {
+		clientConfig.Bep20 = flags.PeerIdPrefix
+	}
+	if flags.ClientVersion != "" {
+		clientConfig.ExtendedHandshakeClientVersion = flags.ClientVersion
+	}
 	if flags.PackedBlocklist != "" {
 		blocklist, err := iplist.MMapPackedFile(flags.PackedBlocklist)
 		if err != nil {
@@ -315,6 +334,9 @@ func downloadErr(flags downloadFlags) error {
 	if flags.DownloadRate != nil {
 		clientConfig.DownloadRateLimiter = rate.NewLimiter(rate.Limit(*flags.DownloadRate), 1<<16)
 	}
+	if flags.HashRate != nil {
+		clientConfig.PieceHashRateLimiter = rate.NewLimiter(rate.Limit(*flags.HashRate), 1<<20)
+	}
 	{
 		logger := log.Default.WithNames("main", "client")
 		if flags.Quiet {
@@ -328,6 +350,13 @@ func downloadErr(flags downloadFlags) error {
 	if flags.MaxUnverifiedBytes != nil {
 		clientConfig.MaxUnverifiedBytes = flags.MaxUnverifiedBytes.Int64()
 	}
+	if flags.Config != "" {
+		settings, err := config.Load(flags.Config)
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		settings.Apply(clientConfig)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
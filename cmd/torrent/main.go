@@ -141,6 +141,7 @@ func main() {
 				fmt.Printf("HTTP User-Agent: %q\n", version.DefaultHttpUserAgent)
 				fmt.Printf("Torrent client version: %q\n", version.DefaultExtendedHandshakeClientVersion)
 				fmt.Printf("Torrent version prefix: %q\n", version.DefaultBep20Prefix)
+				fmt.Printf("ReliableBT version prefix: %q\n", version.ReliableBTBep20Prefix)
 				return nil
 			},
 			Desc: "prints various protocol default version strings",
@@ -0,0 +1,172 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const (
+	completionSLOCheckInterval = time.Second
+	// Pace isn't judged until progress has been measured for this long.
+	completionSLOGracePeriod = 10 * time.Second
+)
+
+// The state of a Torrent's completion deadline, see Torrent.SetCompletionDeadline.
+type CompletionSLO struct {
+	Deadline time.Time
+	// When the deadline was set. Pace is measured from here.
+	Start time.Time
+	// When the wanted data is expected to complete at the rate since Start. Zero if there's been
+	// no progress.
+	Projected time.Time
+	OnPace    bool
+	// The Torrent fell behind and is using more connections and reassigning requests from slower
+	// peers. This lasts until it completes or the deadline is cleared.
+	Escalated bool
+	// When the wanted data completed, or zero if it hasn't.
+	Completed time.Time
+	// Whether the wanted data completed by the deadline. Only meaningful once Completed is set.
+	Met bool
+	// The deadline passed before the wanted data completed.
+	Missed bool
+}
+
+type completionSLOState struct {
+	CompletionSLO
+	startBytes int64
+	// The conns limit set by escalation, and the one it replaced.
+	escalatedMaxConns int
+	savedMaxConns     int
+}
+
+// Sets a deadline for the wanted data to complete by. The Client tracks whether the download is on
+// pace, and escalates if it falls behind by allowing more connections and letting faster peers
+// take over requests from slower ones. Requests aren't duplicated between peers, as each chunk is
+// only requested from one peer at a time. A zero deadline clears it. See Torrent.CompletionSLO.
+func (t *Torrent) SetCompletionDeadline(deadline time.Time) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if t.completionSLO != nil {
+		t.deescalateCompletionSLO()
+	}
+	if deadline.IsZero() {
+		t.completionSLO = nil
+		return
+	}
+	s := &completionSLOState{
+		CompletionSLO: CompletionSLO{
+			Deadline: deadline,
+			Start:    time.Now(),
+			OnPace:   true,
+		},
+		startBytes: t.bytesCompletedWanted(),
+	}
+	t.completionSLO = s
+	t.checkCompletionSLO(s.Start)
+	if s.Completed.IsZero() {
+		go t.completionSLOLoop(s)
+	}
+}
+
+// Returns the state of the completion deadline, and false if none is set.
+func (t *Torrent) CompletionSLO() (CompletionSLO, bool) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	if t.completionSLO == nil {
+		return CompletionSLO{}, false
+	}
+	return t.completionSLO.CompletionSLO, true
+}
+
+func (t *Torrent) completionSLOLoop(s *completionSLOState) {
+	ticker := time.NewTicker(completionSLOCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed.Done():
+			return
+		case now := <-ticker.C:
+			t.cl.lock()
+			if t.completionSLO != s {
+				t.cl.unlock()
+				return
+			}
+			t.checkCompletionSLO(now)
+			done := !s.Completed.IsZero()
+			t.cl.unlock()
+			if done {
+				return
+			}
+		}
+	}
+}
+
+func (t *Torrent) checkCompletionSLO(now time.Time) {
+	s := t.completionSLO
+	if !s.Completed.IsZero() || !t.haveInfo() {
+		return
+	}
+	completed := t.bytesCompletedWanted()
+	remaining := t.bytesWanted() - completed
+	if remaining == 0 && t.bytesWanted() != 0 {
+		s.Completed = now
+		s.Met = !now.After(s.Deadline)
+		s.OnPace = s.Met
+		if s.Met {
+			torrent.Add("completion deadlines met", 1)
+		}
+		t.deescalateCompletionSLO()
+		return
+	}
+	if !s.Missed && now.After(s.Deadline) {
+		s.Missed = true
+		torrent.Add("completion deadlines missed", 1)
+		t.logger.Levelf(log.Info, "missed completion deadline %v", s.Deadline)
+	}
+	elapsed := now.Sub(s.Start)
+	if progress := completed - s.startBytes; progress > 0 && elapsed > 0 {
+		s.Projected = now.Add(time.Duration(float64(remaining) / float64(progress) * float64(elapsed)))
+	} else {
+		s.Projected = time.Time{}
+	}
+	if elapsed < completionSLOGracePeriod && !s.Missed {
+		return
+	}
+	s.OnPace = !s.Projected.IsZero() && !s.Projected.After(s.Deadline)
+	if !s.OnPace && !s.Escalated {
+		t.escalateCompletionSLO()
+	}
+}
+
+func (t *Torrent) escalateCompletionSLO() {
+	s := t.completionSLO
+	s.Escalated = true
+	s.savedMaxConns = t.maxEstablishedConns
+	s.escalatedMaxConns = 2 * t.maxEstablishedConns
+	t.maxEstablishedConns = s.escalatedMaxConns
+	torrent.Add("completion deadline escalations", 1)
+	t.logger.Levelf(log.Info, "behind pace for completion deadline %v, escalating", s.Deadline)
+	t.openNewConns()
+	t.iterPeers(func(p *Peer) {
+		p.updateRequests("completion deadline escalated")
+	})
+}
+
+// Restores the conns limit, unless it's been changed since escalation.
+func (t *Torrent) deescalateCompletionSLO() {
+	s := t.completionSLO
+	if !s.Escalated {
+		return
+	}
+	s.Escalated = false
+	if t.maxEstablishedConns == s.escalatedMaxConns {
+		t.maxEstablishedConns = s.savedMaxConns
+	}
+}
+
+// Whether requests can be taken over by peers that have sent data more recently, regardless of
+// how many requests they already have.
+func (t *Torrent) completionSLOEscalated() bool {
+	return t.completionSLO != nil && t.completionSLO.Escalated
+}
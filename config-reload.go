@@ -0,0 +1,209 @@
+package torrent
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/time/rate"
+)
+
+// A setting changed by Client.ApplyConfig.
+type ConfigChange struct {
+	// The ClientConfig field.
+	Setting string
+	// The change only applies to connections made after it. Existing connections can be dropped
+	// to apply it to them.
+	RequiresReconnect bool
+}
+
+// The settings that Client.ApplyConfig can change. They're copied from the ClientConfig when the
+// Client is created, so that ClientConfig isn't modified, and are guarded by the Client lock except
+// where noted.
+type clientSettings struct {
+	// Never replaced, so they can be used without the lock. ApplyConfig changes them in place.
+	uploadRateLimiter   *rate.Limiter
+	downloadRateLimiter *rate.Limiter
+
+	establishedConnsPerTorrent int
+	halfOpenConnsPerTorrent    int
+	totalHalfOpenConns         int
+	statsReportInterval        time.Duration
+	statsReportJitter          time.Duration
+	peerReadBufferSize         int
+	deadTorrentPolicy          DeadTorrentPolicy
+	// Accessed atomically, as loggers check it without the lock. See clientLogHandler.
+	debug int32
+}
+
+func (cl *Client) initSettings() {
+	cfg := cl.config
+	s := &cl.settings
+	s.uploadRateLimiter = ownRateLimiter(cfg.UploadRateLimiter)
+	s.downloadRateLimiter = ownRateLimiter(cfg.DownloadRateLimiter)
+	s.establishedConnsPerTorrent = cfg.EstablishedConnsPerTorrent
+	s.halfOpenConnsPerTorrent = cfg.HalfOpenConnsPerTorrent
+	s.totalHalfOpenConns = cfg.TotalHalfOpenConns
+	s.statsReportInterval = cfg.StatsReportInterval
+	s.statsReportJitter = cfg.StatsReportJitter
+	s.peerReadBufferSize = cfg.PeerReadBufferSize
+	s.deadTorrentPolicy = cfg.DeadTorrentPolicy
+	if cfg.Debug {
+		s.debug = 1
+	}
+}
+
+// The shared default limiter is replaced with one for the Client, so it can be changed.
+func ownRateLimiter(l *rate.Limiter) *rate.Limiter {
+	if l == nil || l == unlimited {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return l
+}
+
+func (cl *Client) debugLogging() bool {
+	return atomic.LoadInt32(&cl.settings.debug) != 0
+}
+
+// Returns ClientConfig.StatsReportInterval and StatsReportJitter as last applied.
+func (cl *Client) statsReportIntervalSettings() (interval, jitter time.Duration) {
+	cl.rLock()
+	defer cl.rUnlock()
+	return cl.settings.statsReportInterval, cl.settings.statsReportJitter
+}
+
+// Applies the settings from cfg that can be changed while the Client is running, and returns
+// those that changed. These are UploadRateLimiter, DownloadRateLimiter,
+// EstablishedConnsPerTorrent, HalfOpenConnsPerTorrent, TotalHalfOpenConns, StatsReportInterval,
+// StatsReportJitter, PeerReadBufferSize, DeadTorrentPolicy and Debug. Other settings are ignored. The ClientConfig the
+// Client was created with isn't modified, except that limiters other than the default are changed
+// in place. Torrents that have had Torrent.SetMaxEstablishedConns called keep their limit.
+// Callbacks.ConfigChanged is called for each change.
+func (cl *Client) ApplyConfig(cfg *ClientConfig) (changes []ConfigChange) {
+	cl.lock()
+	cur := &cl.settings
+	change := func(setting string, requiresReconnect bool) {
+		changes = append(changes, ConfigChange{setting, requiresReconnect})
+	}
+	// Existing connections use the Client's limiters, so changes apply to them immediately.
+	if applyRateLimiter(cur.uploadRateLimiter, cfg.UploadRateLimiter) {
+		change("UploadRateLimiter", false)
+	}
+	if applyRateLimiter(cur.downloadRateLimiter, cfg.DownloadRateLimiter) {
+		change("DownloadRateLimiter", false)
+	}
+	if old := cur.establishedConnsPerTorrent; cfg.EstablishedConnsPerTorrent != old {
+		cur.establishedConnsPerTorrent = cfg.EstablishedConnsPerTorrent
+		for _, t := range cl.torrents {
+			if t.maxEstablishedConns == old {
+				t.setMaxEstablishedConns(cfg.EstablishedConnsPerTorrent)
+			}
+		}
+		change("EstablishedConnsPerTorrent", false)
+	}
+	halfOpenChanged := false
+	if cfg.HalfOpenConnsPerTorrent != cur.halfOpenConnsPerTorrent {
+		cur.halfOpenConnsPerTorrent = cfg.HalfOpenConnsPerTorrent
+		halfOpenChanged = true
+		change("HalfOpenConnsPerTorrent", false)
+	}
+	if cfg.TotalHalfOpenConns != cur.totalHalfOpenConns {
+		cur.totalHalfOpenConns = cfg.TotalHalfOpenConns
+		halfOpenChanged = true
+		change("TotalHalfOpenConns", false)
+	}
+	if halfOpenChanged {
+		for _, t := range cl.torrents {
+			t.openNewConns()
+		}
+	}
+	reportIntervalChanged := false
+	if cfg.StatsReportInterval != cur.statsReportInterval {
+		cur.statsReportInterval = cfg.StatsReportInterval
+		reportIntervalChanged = true
+		change("StatsReportInterval", false)
+	}
+	if cfg.StatsReportJitter != cur.statsReportJitter {
+		cur.statsReportJitter = cfg.StatsReportJitter
+		reportIntervalChanged = true
+		change("StatsReportJitter", false)
+	}
+	if reportIntervalChanged {
+		cl.statsReportIntervalChanged()
+	}
+	if cfg.PeerReadBufferSize != cur.peerReadBufferSize {
+		cur.peerReadBufferSize = cfg.PeerReadBufferSize
+		// Connections keep the read buffer they started with.
+		change("PeerReadBufferSize", true)
+	}
+	if cfg.DeadTorrentPolicy != cur.deadTorrentPolicy {
+		cur.deadTorrentPolicy = cfg.DeadTorrentPolicy
+		cl.startDeadTorrentPolicyLoop()
+		change("DeadTorrentPolicy", false)
+	}
+	if cfg.Debug != cl.debugLogging() {
+		var debug int32
+		if cfg.Debug {
+			debug = 1
+		}
+		atomic.StoreInt32(&cur.debug, debug)
+		change("Debug", false)
+	}
+	cl.unlock()
+	for _, c := range changes {
+		cl.logger.Levelf(log.Info, "applied config change to %v", c.Setting)
+		for _, f := range cl.config.Callbacks.ConfigChanged {
+			f(c)
+		}
+	}
+	return
+}
+
+// Applies the new limiter's settings to the current one.
+func applyRateLimiter(cur, new *rate.Limiter) (changed bool) {
+	if new == nil {
+		new = unlimited
+	}
+	if cur == new || (cur.Limit() == new.Limit() && cur.Burst() == new.Burst()) {
+		return false
+	}
+	cur.SetLimit(new.Limit())
+	cur.SetBurst(new.Burst())
+	return true
+}
+
+// Drops log records below the Client's log level. Loggers for the Client, its Torrents and
+// connections always pass debug records through to this, so that ClientConfig.Debug can be changed
+// by ApplyConfig without replacing loggers that are in use.
+type clientLogHandler struct {
+	cl *Client
+	// The Client's logger before debug logging was considered.
+	base log.Logger
+}
+
+func (me clientLogHandler) Handle(r log.Record) {
+	if !me.cl.debugLogging() && !me.base.WithNames(r.Names...).IsEnabledFor(r.Level) {
+		return
+	}
+	for _, h := range me.base.Handlers {
+		h.Handle(r)
+	}
+}
+
+// Starts, stops or reschedules stats reports for a change to ClientConfig.StatsReportInterval.
+func (cl *Client) statsReportIntervalChanged() {
+	enabled := cl.settings.statsReportInterval != 0 && !cl.config.DisableTrackers
+	switch {
+	case enabled && cl.statsReporter == nil:
+		cl.statsReporter = cl.newStatsReporter()
+		cl.statsReporter.start()
+	case enabled:
+		cl.statsReporter.intervalChanged()
+	case cl.statsReporter != nil:
+		// Nothing waits for it, as Close would.
+		var wg sync.WaitGroup
+		cl.statsReporter.stop(&wg)
+		cl.statsReporter = nil
+	}
+}
@@ -2,6 +2,8 @@ package torrent
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
 	"net"
 	"net/http"
 	"net/url"
@@ -11,6 +13,7 @@ import (
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/log"
 	"github.com/anacrolix/missinggo/v2"
+	"golang.org/x/exp/slog"
 	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/torrent/iplist"
@@ -24,13 +27,53 @@ import (
 type ClientTrackerConfig struct {
 	// Don't announce to trackers. This only leaves DHT to discover peers.
 	DisableTrackers bool `long:"disable-trackers"`
+	// Announce to one tracker at a time per BEP 12, instead of to every tracker at once. Trackers
+	// in a tier are tried in random order, a tracker that works is moved to the front of its tier,
+	// and lower tiers are only tried once every tracker in the tiers above has failed. WebTorrent
+	// trackers are always announced to.
+	TrackerTierFailover bool
 	// Defines DialContext func to use for HTTP tracker announcements
 	TrackerDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
 	// Defines ListenPacket func to use for UDP tracker announcements
 	TrackerListenPacket func(network, addr string) (net.PacketConn, error)
+	// How long to wait for a UDP tracker to respond before retransmitting, doubling for each
+	// further retransmission. The BEP 15 default of 15s is the same as the announce timeout, so
+	// lost packets aren't retransmitted unless this is shorter.
+	UdpTrackerRetransmitTimeout time.Duration
+	// Clamps the announce interval given by trackers. Zero MinAnnounceInterval is a minute, unless
+	// Torrent.SmallIntervalAllowed is set. Zero MaxAnnounceInterval doesn't limit the interval.
+	// The tracker's min interval is still respected unless IgnoreTrackerMinInterval is set.
+	MinAnnounceInterval time.Duration
+	MaxAnnounceInterval time.Duration
+	// Announce more often than trackers' min interval, such as with a MaxAnnounceInterval below it,
+	// or with Torrent.ForceReannounce.
+	IgnoreTrackerMinInterval bool
+	// How long Torrent.Drop and Client.Close wait for the stopped event to be announced to trackers.
+	// Zero for 5s.
+	TrackerStoppedAnnounceTimeout time.Duration
 	// Takes a tracker's hostname and requests DNS A and AAAA records.
 	// Used in case DNS lookups require a special setup (i.e., dns-over-https)
 	LookupTrackerIp func(*url.URL) ([]net.IP, error)
+	// Include swarm join milestones (see Torrent.SwarmJoinTimes) in HTTP tracker announces, for
+	// aggregating experiment results.
+	AnnounceSwarmJoinTimes bool
+	// Dial the peers that HTTP trackers rank fastest by their reported upload rates before other
+	// peers, fastest first. Trackers that don't rank peers are unaffected.
+	PreferFastPeersFromTracker bool
+	// Ask HTTP trackers to assign a range of pieces to fetch before the others, so that peers
+	// joining a swarm together fetch different pieces from the seeds and then trade them. Trackers
+	// that don't assign pieces are unaffected.
+	TrackerPieceAssignment bool
+	// Dial peers in order of the reliability scores HTTP trackers give them, and when unchoke slots
+	// are limited by SchedulerParams.UnchokeSlots, have less reliable peers give theirs up to more
+	// reliable ones. Trackers that don't score peers are unaffected.
+	PreferReliablePeersFromTracker bool
+	// Headers added to every HTTP tracker request, such as a token for private trackers that
+	// authenticate with one. Basic auth credentials and passkeys in announce URLs are used as is.
+	TrackerHTTPHeaders http.Header
+	// Returns the credentials for an HTTP tracker, given its announce URL. They take precedence over
+	// TrackerHTTPHeaders and credentials in the URL.
+	TrackerCredentials func(trackerUrl *url.URL) TrackerCredentials
 }
 
 type ClientDhtConfig struct {
@@ -59,6 +102,10 @@ type ClientConfig struct {
 	NoDefaultPortForwarding bool
 	UpnpID                  string
 	DisablePEX              bool `long:"disable-pex"`
+	// The port advertised to peers, trackers and the DHT, if different to the port listened on.
+	// This is useful behind manual port forwards or container networking, where ListenPort might
+	// be zero to use an ephemeral port. Not used if zero.
+	AdvertisedPort int
 
 	// Never send chunks to peers.
 	NoUpload bool `long:"no-upload"`
@@ -79,8 +126,27 @@ type ClientConfig struct {
 	// (~4096), and the requested chunk size (~16KiB, see
 	// TorrentSpec.ChunkSize).
 	DownloadRateLimiter *rate.Limiter
+	// Rate limits piece data read from storage for verification passes (the initial check,
+	// Torrent.VerifyData, and rechecks after file changes), so checking large torrents doesn't
+	// saturate disks shared with other services. Checks of pieces as they finish downloading aren't
+	// limited. Each limiter token represents one byte, and unless the limit is rate.Inf, the burst
+	// must be at least a chunk (16 KiB). Storage that does its own hashing (storage.SelfHashing)
+	// isn't limited.
+	PieceHashRateLimiter *rate.Limiter
+	// Checks piece data after it's hashed, to augment or replace checking against the info's SHA-1
+	// hashes, such as to also check a v2 merkle proof or an application MAC. See PieceVerification.
+	// Called from piece hashing without the Client lock held.
+	VerifyPiece func(PieceVerification) error
+	// Decides whether to serve a peer's request for a chunk, for access control such as only
+	// uploading to peers that authenticated in the extended handshake. Returning an error denies the
+	// request, which is rejected if the peer supports the fast extension and otherwise ignored.
+	// Called with the Client lock held, so it mustn't block or call methods that take the lock.
+	AuthorizeUpload func(UploadRequest) error
 	// Maximum unverified bytes across all torrents. Not used if zero.
 	MaxUnverifiedBytes int64
+	// Stop requesting and serving a torrent's pieces while a full recheck (Torrent.VerifyData) is
+	// in progress. This avoids disk thrash between hashing and transfers on rotational media.
+	PauseTransfersDuringRecheck bool
 
 	// User-provided Client peer ID. If not present, one is generated automatically.
 	PeerID string
@@ -106,6 +172,10 @@ type ClientConfig struct {
 	// Perform logging and any other behaviour that will help debug.
 	Debug  bool `help:"enable debugging"`
 	Logger log.Logger
+	// If set, the Client logs to this instead of Logger. Messages carry structured fields where
+	// they're available, such as the infohash, byte counts and intervals in stats reporting. The
+	// Slogger's handler decides which levels are output.
+	Slogger *slog.Logger
 
 	// Defines proxy for HTTP requests, such as for trackers. It's commonly set from the result of
 	// "net/http".ProxyURL(HTTPProxy).
@@ -150,11 +220,47 @@ type ClientConfig struct {
 	KeepAliveTimeout time.Duration
 	// Maximum bytes to buffer per peer connection for peer request data before it is sent.
 	MaxAllocPeerRequestDataPerConn int64
+	// Size of the read buffer for each peer connection. Defaults to 128 KiB.
+	PeerReadBufferSize int
+	// The rate of messages other than piece data and haves accepted from each peer, in messages per
+	// second, and the burst allowed above it. Peers that exceed it are disconnected, to protect
+	// against floods of requests and extension messages. Haves are exempt as a seed may send one for
+	// every piece of a large torrent in a burst, as are trusted peers. The limit is off by default,
+	// and when the rate is zero. A zero burst allows a second's worth of messages.
+	PeerMessageRate  rate.Limit
+	PeerMessageBurst int
+	// Validates inbound peer messages against the torrent's bounds and the protocol's ordering
+	// rules, beyond what's needed to handle them safely, and disconnects peers that break them with
+	// a ProtocolViolation. See Client.ProtocolViolations.
+	StrictProtocol bool
+	// Divides the upload and download rate limits fairly among Torrents, and each Torrent's among
+	// its peers, rebalancing every second. Allowance left unused by a Torrent or peer is given to
+	// those that are using all of theirs. Explicit Torrent limits still apply.
+	FairRateSharing bool
+	// Worker pool sizes, for balancing CPU and disk use with co-located applications. The number
+	// of pieces each Torrent hashes concurrently defaults to 2. Concurrent storage reads for
+	// serving peer requests, and concurrent incoming connection handshakes (which may include
+	// header obfuscation key exchange) are unlimited if zero.
+	PieceHashersPerTorrent int
+	StorageReadWorkers     int
+	HandshakeWorkers       int
 
 	// The IP addresses as our peers should see them. May differ from the
-	// local interfaces due to NAT or other network configurations.
+	// local interfaces due to NAT or other network configurations. These
+	// default to the addresses discovered through StunServers.
 	PublicIp4 net.IP
 	PublicIp6 net.IP
+	// The addresses sent to trackers in the ip and ipv6 announce parameters, for when the address
+	// trackers see connections from, such as a proxy's, isn't where peers can reach us. These
+	// default to PublicIp4 and PublicIp6.
+	AnnounceIp4 net.IP
+	AnnounceIp6 net.IP
+	// STUN servers, as host:port, queried over UDP to discover our external addresses. These are
+	// used in announces, the DHT and the extended handshake where no public address is configured,
+	// and are refreshed every 10 minutes. Discovery happens in the background, and DHT servers
+	// created by the Client are restarted with node IDs derived from newly discovered addresses.
+	// See Client.ExternalAddrs.
+	StunServers []string
 
 	// Accept rate limiting affects excessive connection attempts from IPs that fail during
 	// handshakes or request torrents that we don't have.
@@ -178,11 +284,98 @@ type ClientConfig struct {
 
 	DisableWebtorrent bool
 	DisableWebseeds   bool
+	// The number of WebRTC offers to keep outstanding for each torrent with each websocket tracker.
+	// Zero for webtorrent.DefaultNumOffers.
+	WebtorrentNumOffers int
 
 	Callbacks Callbacks
 
+	// Don't batch Haves or compress bitfields for peers supporting the ReliableBT have batch
+	// extension.
+	DisableHaveBatching bool
+	// Compress piece payloads with LZ4 for peers supporting the ReliableBT compression extension.
+	// This is worthwhile for highly compressible data, such as logs and VM images.
+	PieceCompression bool
+	// Experimental: exchange signed receipts for verified piece data with peers supporting the
+	// ReliableBT receipt extension. Receipts collected from downloaders are presented to trackers
+	// in stats reports for reputation credit. See Torrent.UploadReceipts.
+	UploadReceipts bool
+	// Signs the receipts we send. Generated when the Client is created if nil.
+	UploadReceiptKey ed25519.PrivateKey
+	// A receipt is sent to an uploader after a verified piece once at least this much data from it
+	// is unreceipted, and when the download completes. Zero sends one after every piece it
+	// contributed to.
+	UploadReceiptInterval int64
+
 	// ReliableBT: whether it can be a baseline provider
 	Reliable bool
+
+	// Tags the Client as part of an experiment. It's included in HTTP tracker announces, tracker
+	// stats reports and status reports so results can be grouped by experiment.
+	ExperimentId string
+	// Labels sent to peers in the extended handshake, such as a region or capacity class, so swarm
+	// experiments can segment results by peer. ExperimentId is included as "experiment_id" unless
+	// it's set here. See PeerConn.PeerHandshakeMetadata.
+	HandshakeMetadata map[string]string
+	// If set, metainfo obtained for Torrents added without info (such as from magnet links) is
+	// written here as "<hex infohash>.torrent", and reused when the same infohash is added again.
+	TorrentCacheDir string
+	// If non-zero, how often to check storage for files that were changed outside the client, such
+	// as by users editing downloaded files in place. Complete files that change have their pieces
+	// marked not complete and rechecked, so corrupted data isn't served. Requires storage support,
+	// such as from the file storage implementation.
+	FileChangeCheckInterval time.Duration
+	// If non-zero, how often complete Torrents have all their data re-verified, to catch storage
+	// corruption in long-term seeds. Torrents are verified one at a time to bound disk load. See
+	// Torrent.SetScheduledVerificationInterval and Callbacks.ScheduledVerification.
+	ScheduledVerificationInterval time.Duration
+	// The smoothing window for Torrent.DownloadRate and Torrent.UploadRate. Recent transfers carry
+	// the most weight, and those older than the window have little effect. Rates aren't tracked if
+	// this is zero.
+	TransferRateWindow time.Duration
+	// If non-zero, how often to record the swarm availability of each Torrent. See
+	// Torrent.AvailabilityHistory.
+	AvailabilitySampleInterval time.Duration
+	// If non-zero, how often each Torrent reports its transfer stats to its HTTP trackers,
+	// including the bytes uploaded to each peer. This is a ReliableBT extension that trackers
+	// receive at the "report" counterpart of the announce URL. Reports continue after downloads
+	// complete, so trackers can account for uploads while seeding.
+	StatsReportInterval time.Duration
+	// Each wait between stats reports is varied randomly by up to this much either way, so that
+	// many clients started together don't report in bursts.
+	StatsReportJitter time.Duration
+	// The endpoint to send stats reports to, instead of the report counterparts of each Torrent's
+	// HTTP trackers. See also Torrent.SetStatsReportURL. If neither is set and a Torrent has no HTTP
+	// trackers, reports go to a ReliableBT tracker on localhost.
+	StatsReportURL string
+	// Announce URLs of HTTP trackers to send stats reports to instead of each Torrent's own
+	// trackers. Each report goes to the first tracker that accepts it, and reports are queued and
+	// retried until one does, so none are lost while trackers restart. This takes precedence over
+	// StatsReportURL and Torrent.SetStatsReportURL.
+	StatsReportTrackers []string
+	// If set, reports for StatsReportTrackers that haven't been delivered are kept in this
+	// directory, and delivered by the next Client using it if this one is closed first.
+	StatsReportQueueDir string
+	// Pauses or drops torrents that have had no seeders or progress for a while. Can be changed with
+	// Client.ApplyConfig.
+	DeadTorrentPolicy DeadTorrentPolicy
+	// Chokes or bans peers that download without uploading.
+	FreeRiderPolicy FreeRiderPolicy
+	// Discovers the uplink capacity and adjusts UploadRateLimiter to stay just below it.
+	Autoband Autoband
+	// Provides scheduler parameter variations for experiments. Parameters pushed by trackers in
+	// announce responses take precedence.
+	SchedulerParams SchedulerParamsProvider
+	// If set, only info that's BEP 35 signed by one of these publishers is accepted, however the
+	// Torrent was added. Info obtained from peers, metadata sources or the metainfo cache without
+	// signatures is rejected, so Torrents added without info, such as from magnet links, need
+	// signed info merged with Torrent.MergeSpec. Info from peers is fetched once, and isn't fetched
+	// again after it's rejected.
+	TrustedPublishers []*x509.Certificate
+	// How often MutableTorrents poll the DHT for updates. Defaults to 10 minutes.
+	MutableTorrentPollInterval time.Duration
+	// RSS and Atom feeds to poll for torrents to add and download.
+	Feeds []Feed
 }
 
 func (cfg *ClientConfig) SetListenAddr(addr string) *ClientConfig {
@@ -214,6 +407,7 @@ func NewDefaultClientConfig() *ClientConfig {
 		ListenHost:                     func(string) string { return "" },
 		UploadRateLimiter:              unlimited,
 		DownloadRateLimiter:            unlimited,
+		PieceHashRateLimiter:           unlimited,
 		DisableAcceptRateLimiting:      true,
 		DropMutuallyCompletePeers:      true,
 		HeaderObfuscationPolicy: HeaderObfuscationPolicy{
@@ -226,6 +420,7 @@ func NewDefaultClientConfig() *ClientConfig {
 		Extensions:            defaultPeerExtensionBytes(),
 		AcceptPeerConnections: true,
 		MaxUnverifiedBytes:    64 << 20,
+		TransferRateWindow:    10 * time.Second,
 		// ReliableBT
 		Reliable: false,
 	}
@@ -236,6 +431,32 @@ func NewDefaultClientConfig() *ClientConfig {
 	return cc
 }
 
+// A configuration for memory-constrained devices, such as single-board computers seeding a few
+// torrents. Buffers and connection limits are minimized, and piece completion state is kept on
+// disk by the default storage. Throughput is traded for a small, predictable footprint.
+func NewLowMemoryClientConfig() *ClientConfig {
+	cc := NewDefaultClientConfig()
+	cc.setLowMemory()
+	return cc
+}
+
+func (cfg *ClientConfig) setLowMemory() {
+	cfg.EstablishedConnsPerTorrent = 8
+	cfg.HalfOpenConnsPerTorrent = 4
+	cfg.TotalHalfOpenConns = 8
+	cfg.TorrentPeersHighWater = 32
+	cfg.TorrentPeersLowWater = 8
+	cfg.MaxAllocPeerRequestDataPerConn = 64 << 10
+	cfg.MaxUnverifiedBytes = 4 << 20
+	cfg.PeerReadBufferSize = 16 << 10
+	// The default storage uses on-disk piece completion. Caching metainfo and compressing pieces
+	// cost memory.
+	cfg.DefaultStorage = nil
+	cfg.TorrentCacheDir = ""
+	cfg.PieceCompression = false
+	cfg.DisableWebtorrent = true
+}
+
 type HeaderObfuscationPolicy struct {
 	RequirePreferred bool // Whether the value of Preferred is a strict requirement.
 	Preferred        bool // Whether header obfuscation is preferred.
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	"gopkg.in/yaml.v3"
+)
+
+// A byte count, given as an integer or a string with units such as "1 MiB" or "500kB".
+type Bytes int64
+
+func (me *Bytes) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %v: expected a byte count", value.Line)
+	}
+	var i int64
+	if value.Tag == "!!int" {
+		if err := value.Decode(&i); err != nil {
+			return err
+		}
+		*me = Bytes(i)
+		return nil
+	}
+	u, err := humanize.ParseBytes(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %v: %w", value.Line, err)
+	}
+	*me = Bytes(u)
+	return nil
+}
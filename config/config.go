@@ -0,0 +1,243 @@
+// Package config loads Client configuration from YAML and TOML files, so that deployments can
+// tune a Client without writing Go.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"github.com/anacrolix/torrent"
+)
+
+type Format int
+
+const (
+	YAML Format = iota
+	TOML
+)
+
+// Returns the Format for a file name's extension.
+func FormatForPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".toml":
+		return TOML, nil
+	}
+	return 0, fmt.Errorf("unknown config file extension %q", filepath.Ext(path))
+}
+
+// The Client settings that can be given in a config file. Settings that are omitted keep their
+// defaults from torrent.NewDefaultClientConfig.
+type Settings struct {
+	DataDir    string `yaml:"data_dir"`
+	ListenAddr string `yaml:"listen_addr"`
+	Seed       *bool  `yaml:"seed"`
+	Debug      *bool  `yaml:"debug"`
+
+	NoDHT           *bool `yaml:"no_dht"`
+	DisablePEX      *bool `yaml:"disable_pex"`
+	DisableTrackers *bool `yaml:"disable_trackers"`
+	DisableTCP      *bool `yaml:"disable_tcp"`
+	DisableUTP      *bool `yaml:"disable_utp"`
+	DisableIPv4     *bool `yaml:"disable_ipv4"`
+	DisableIPv6     *bool `yaml:"disable_ipv6"`
+	DisableWebseeds *bool `yaml:"disable_webseeds"`
+
+	// Peer ID prefix, see BEP 20.
+	PeerIDPrefix  string `yaml:"peer_id_prefix"`
+	ClientVersion string `yaml:"client_version"`
+
+	// Per second.
+	UploadRate   *Bytes `yaml:"upload_rate"`
+	DownloadRate *Bytes `yaml:"download_rate"`
+
+	MaxUnverifiedBytes         *Bytes `yaml:"max_unverified_bytes"`
+	EstablishedConnsPerTorrent *int   `yaml:"established_conns_per_torrent"`
+	HalfOpenConnsPerTorrent    *int   `yaml:"half_open_conns_per_torrent"`
+	TotalHalfOpenConns         *int   `yaml:"total_half_open_conns"`
+
+	StatsReportURL                string         `yaml:"stats_report_url"`
+	StatsReportInterval           *time.Duration `yaml:"stats_report_interval"`
+	TransferRateWindow            *time.Duration `yaml:"transfer_rate_window"`
+	ScheduledVerificationInterval *time.Duration `yaml:"scheduled_verification_interval"`
+}
+
+// Loads and validates Settings from a file, with the Format determined by its extension.
+func Load(path string) (*Settings, error) {
+	format, err := FormatForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parses and validates Settings. Unknown settings are an error, so that typos don't go unnoticed.
+func Parse(data []byte, format Format) (*Settings, error) {
+	if format == TOML {
+		// TOML is decoded through the same path as YAML, so both get the same checks.
+		var m map[string]interface{}
+		err := toml.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		data, err = yaml.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var s Settings
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+	// An empty document is an empty config.
+	if err := d.Decode(&s); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Checks that the Settings are usable, naming the first that isn't.
+func (s *Settings) Validate() error {
+	if s.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(s.ListenAddr); err != nil {
+			return fmt.Errorf("listen_addr: %w", err)
+		}
+	}
+	if len(s.PeerIDPrefix) > 20 {
+		return fmt.Errorf("peer_id_prefix: longer than a peer ID")
+	}
+	for _, r := range []struct {
+		name string
+		b    *Bytes
+	}{
+		{"upload_rate", s.UploadRate},
+		{"download_rate", s.DownloadRate},
+		{"max_unverified_bytes", s.MaxUnverifiedBytes},
+	} {
+		if r.b != nil && *r.b <= 0 {
+			return fmt.Errorf("%s: must be positive", r.name)
+		}
+	}
+	for _, c := range []struct {
+		name string
+		i    *int
+	}{
+		{"established_conns_per_torrent", s.EstablishedConnsPerTorrent},
+		{"half_open_conns_per_torrent", s.HalfOpenConnsPerTorrent},
+		{"total_half_open_conns", s.TotalHalfOpenConns},
+	} {
+		if c.i != nil && *c.i < 0 {
+			return fmt.Errorf("%s: must not be negative", c.name)
+		}
+	}
+	if s.StatsReportURL != "" {
+		u, err := url.Parse(s.StatsReportURL)
+		if err != nil {
+			return fmt.Errorf("stats_report_url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("stats_report_url: unsupported scheme %q", u.Scheme)
+		}
+	}
+	for _, d := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"stats_report_interval", s.StatsReportInterval},
+		{"transfer_rate_window", s.TransferRateWindow},
+	} {
+		if d.d != nil && *d.d < 0 {
+			return fmt.Errorf("%s: must not be negative", d.name)
+		}
+	}
+	return nil
+}
+
+// Applies the Settings that were given over cfg.
+func (s *Settings) Apply(cfg *torrent.ClientConfig) {
+	if s.DataDir != "" {
+		cfg.DataDir = s.DataDir
+	}
+	if s.ListenAddr != "" {
+		cfg.SetListenAddr(s.ListenAddr)
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setBool(&cfg.Seed, s.Seed)
+	setBool(&cfg.Debug, s.Debug)
+	setBool(&cfg.NoDHT, s.NoDHT)
+	setBool(&cfg.DisablePEX, s.DisablePEX)
+	setBool(&cfg.DisableTrackers, s.DisableTrackers)
+	setBool(&cfg.DisableTCP, s.DisableTCP)
+	setBool(&cfg.DisableUTP, s.DisableUTP)
+	setBool(&cfg.DisableIPv4, s.DisableIPv4)
+	setBool(&cfg.DisableIPv6, s.DisableIPv6)
+	setBool(&cfg.DisableWebseeds, s.DisableWebseeds)
+	if s.PeerIDPrefix != "" {
+		cfg.Bep20 = s.PeerIDPrefix
+	}
+	if s.ClientVersion != "" {
+		cfg.ExtendedHandshakeClientVersion = s.ClientVersion
+	}
+	// Bursts are as for the torrent command's rate flags.
+	if s.UploadRate != nil {
+		cfg.UploadRateLimiter = rate.NewLimiter(rate.Limit(*s.UploadRate), 256<<10)
+	}
+	if s.DownloadRate != nil {
+		cfg.DownloadRateLimiter = rate.NewLimiter(rate.Limit(*s.DownloadRate), 1<<16)
+	}
+	if s.MaxUnverifiedBytes != nil {
+		cfg.MaxUnverifiedBytes = int64(*s.MaxUnverifiedBytes)
+	}
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setInt(&cfg.EstablishedConnsPerTorrent, s.EstablishedConnsPerTorrent)
+	setInt(&cfg.HalfOpenConnsPerTorrent, s.HalfOpenConnsPerTorrent)
+	setInt(&cfg.TotalHalfOpenConns, s.TotalHalfOpenConns)
+	if s.StatsReportURL != "" {
+		cfg.StatsReportURL = s.StatsReportURL
+	}
+	setDuration := func(dst *time.Duration, src *time.Duration) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setDuration(&cfg.StatsReportInterval, s.StatsReportInterval)
+	setDuration(&cfg.TransferRateWindow, s.TransferRateWindow)
+	setDuration(&cfg.ScheduledVerificationInterval, s.ScheduledVerificationInterval)
+}
+
+// Returns the default ClientConfig with the Settings applied.
+func (s *Settings) ClientConfig() *torrent.ClientConfig {
+	cfg := torrent.NewDefaultClientConfig()
+	s.Apply(cfg)
+	return cfg
+}
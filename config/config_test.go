@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/anacrolix/torrent"
+)
+
+const testYAML = `
+data_dir: /var/lib/torrent
+listen_addr: ":42069"
+seed: true
+no_dht: true
+peer_id_prefix: -RB0001-
+upload_rate: 1 MiB
+download_rate: 65536
+established_conns_per_torrent: 30
+stats_report_interval: 30s
+`
+
+const testTOML = `
+# The same settings as testYAML.
+data_dir = "/var/lib/torrent"
+listen_addr = ':42069'
+seed = true
+no_dht = true # Trackers only.
+peer_id_prefix = "-RB0001-"
+upload_rate = "1 MiB"
+download_rate = 65_536
+established_conns_per_torrent = 30
+stats_report_interval = "30s"
+`
+
+func TestParse(t *testing.T) {
+	yamlSettings, err := Parse([]byte(testYAML), YAML)
+	require.NoError(t, err)
+	tomlSettings, err := Parse([]byte(testTOML), TOML)
+	require.NoError(t, err)
+	assert.Equal(t, yamlSettings, tomlSettings)
+	cfg := tomlSettings.ClientConfig()
+	def := torrent.NewDefaultClientConfig()
+	assert.Equal(t, "/var/lib/torrent", cfg.DataDir)
+	assert.Equal(t, 42069, cfg.ListenPort)
+	assert.True(t, cfg.Seed)
+	assert.True(t, cfg.NoDHT)
+	assert.Equal(t, "-RB0001-", cfg.Bep20)
+	assert.EqualValues(t, 1<<20, cfg.UploadRateLimiter.Limit())
+	assert.EqualValues(t, 1<<16, cfg.DownloadRateLimiter.Limit())
+	assert.Equal(t, 30, cfg.EstablishedConnsPerTorrent)
+	assert.Equal(t, 30*time.Second, cfg.StatsReportInterval)
+	// Omitted settings keep their defaults.
+	assert.Equal(t, def.DisablePEX, cfg.DisablePEX)
+	assert.Equal(t, def.HalfOpenConnsPerTorrent, cfg.HalfOpenConnsPerTorrent)
+	assert.Equal(t, def.TransferRateWindow, cfg.TransferRateWindow)
+	assert.EqualValues(t, rate.Inf, def.UploadRateLimiter.Limit())
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, format := range []Format{YAML, TOML} {
+		s, err := Parse(nil, format)
+		require.NoError(t, err)
+		assert.Equal(t, &Settings{}, s)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		doc    string
+		err    string
+	}{
+		{YAML, "no_dth: true", "field no_dth not found"},
+		{TOML, "no_dth = true", "field no_dth not found"},
+		{YAML, "seed: 3s", "cannot unmarshal"},
+		{YAML, "upload_rate: lots", "line 1"},
+		{YAML, "upload_rate: 0", "upload_rate: must be positive"},
+		{YAML, "listen_addr: localhost", "listen_addr"},
+		{YAML, "peer_id_prefix: -RB0001-RB0001-RB0001-", "peer_id_prefix"},
+		{YAML, "stats_report_url: ftp://example.com/", "unsupported scheme"},
+		{YAML, "established_conns_per_torrent: -1", "must not be negative"},
+		{YAML, "transfer_rate_window: -1s", "must not be negative"},
+		{TOML, "seed = true\nseed = false", "line 2 (last key \"seed\"): Key 'seed' has already been defined"},
+		{TOML, "seed = yes", "line 1 (last key \"seed\"): expected value"},
+		{TOML, "seed true", "line 1: expected '.' or '='"},
+		{TOML, `data_dir = "unterminated`, "line 1 (last key \"data_dir\"): unexpected EOF"},
+	} {
+		_, err := Parse([]byte(tc.doc), tc.format)
+		if assert.Error(t, err, tc.doc) {
+			assert.Contains(t, err.Error(), tc.err, tc.doc)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "client.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testYAML), 0o644))
+	s, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/torrent", s.DataDir)
+	_, err = Load(filepath.Join(dir, "client.json"))
+	assert.ErrorContains(t, err, "unknown config file extension")
+}
@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent/mse"
+)
+
+// How a peer connection's stream is protected, as negotiated in the MSE handshake.
+type ConnEncryption int
+
+const (
+	// There was no MSE handshake, and everything is sent in the clear.
+	ConnPlaintext ConnEncryption = iota
+	// The BitTorrent handshake was obfuscated, and the stream is plaintext after it.
+	ConnHeaderObfuscated
+	// The stream is RC4 encrypted throughout.
+	ConnEncrypted
+)
+
+func (me ConnEncryption) String() string {
+	switch me {
+	case ConnPlaintext:
+		return "plaintext"
+	case ConnHeaderObfuscated:
+		return "obfuscated"
+	case ConnEncrypted:
+		return "encrypted"
+	default:
+		return fmt.Sprintf("ConnEncryption(%d)", int(me))
+	}
+}
+
+func (me ConnEncryption) MarshalText() ([]byte, error) {
+	return []byte(me.String()), nil
+}
+
+// Returns what was negotiated for the connection. This is settled before the connection is added
+// to a Torrent.
+func (cn *PeerConn) Encryption() ConnEncryption {
+	switch {
+	case cn.cryptoMethod == mse.CryptoMethodRC4:
+		return ConnEncrypted
+	case cn.headerEncrypted:
+		return ConnHeaderObfuscated
+	default:
+		return ConnPlaintext
+	}
+}
+
+// Counts of peer connections by how they're protected, for auditing the effect of
+// ClientConfig.HeaderObfuscationPolicy.
+type ConnEncryptionCounts struct {
+	Plaintext        int
+	HeaderObfuscated int
+	Encrypted        int
+}
+
+func (me *ConnEncryptionCounts) add(e ConnEncryption) {
+	switch e {
+	case ConnPlaintext:
+		me.Plaintext++
+	case ConnHeaderObfuscated:
+		me.HeaderObfuscated++
+	case ConnEncrypted:
+		me.Encrypted++
+	}
+}
+
+func (me *ConnEncryptionCounts) addCounts(other ConnEncryptionCounts) {
+	me.Plaintext += other.Plaintext
+	me.HeaderObfuscated += other.HeaderObfuscated
+	me.Encrypted += other.Encrypted
+}
+
+func (t *Torrent) connEncryptionCounts() (ret ConnEncryptionCounts) {
+	for c := range t.conns {
+		ret.add(c.Encryption())
+	}
+	return
+}
+
+// Returns counts of the established peer connections across all Torrents by encryption.
+func (cl *Client) ConnEncryptionCounts() ConnEncryptionCounts {
+	cl.rLock()
+	defer cl.rUnlock()
+	return cl.connEncryptionCountsLocked()
+}
+
+func (cl *Client) connEncryptionCountsLocked() (ret ConnEncryptionCounts) {
+	for _, t := range cl.torrents {
+		ret.addCounts(t.connEncryptionCounts())
+	}
+	return
+}
@@ -48,6 +48,16 @@ func (me *ConnStats) Copy() (ret ConnStats) {
 	return
 }
 
+// Returns the counts accumulated since prev, which should be an earlier Copy.
+func (me *ConnStats) Sub(prev *ConnStats) (ret ConnStats) {
+	for i := 0; i < reflect.TypeOf(ConnStats{}).NumField(); i++ {
+		n := reflect.ValueOf(me).Elem().Field(i).Addr().Interface().(*Count).Int64()
+		p := reflect.ValueOf(prev).Elem().Field(i).Addr().Interface().(*Count).Int64()
+		reflect.ValueOf(&ret).Elem().Field(i).Addr().Interface().(*Count).Add(n - p)
+	}
+	return
+}
+
 type Count struct {
 	n int64
 }
@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"sync"
+)
+
+// Counts of connection attempts and their outcomes for a network in one direction. Comparing these
+// across networks (such as TCP and uTP) and directions shows how well NAT traversal is working.
+type ConnAttemptStats struct {
+	// Dials initiated, or connections accepted for incoming connections.
+	Attempts int64
+	// Outgoing dials that were the first to connect. Always equal to Attempts for incoming
+	// connections.
+	Connected int64
+	// Connections that completed BitTorrent protocol handshakes.
+	Handshook int64
+}
+
+// Returns the fraction of attempts that completed handshakes.
+func (me ConnAttemptStats) SuccessRatio() float64 {
+	if me.Attempts == 0 {
+		return 0
+	}
+	return float64(me.Handshook) / float64(me.Attempts)
+}
+
+// Connection attempt statistics keyed by network, such as "tcp4" or "udp6" (for uTP).
+type ConnectivityStats struct {
+	Outgoing map[string]ConnAttemptStats
+	Incoming map[string]ConnAttemptStats
+}
+
+// These are updated from dialing and handshake goroutines that don't hold the Client lock.
+type connectivityStats struct {
+	mu       sync.Mutex
+	outgoing map[string]*ConnAttemptStats
+	incoming map[string]*ConnAttemptStats
+}
+
+func (me *connectivityStats) update(outgoing bool, network string, f func(*ConnAttemptStats)) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	m := &me.incoming
+	if outgoing {
+		m = &me.outgoing
+	}
+	if *m == nil {
+		*m = make(map[string]*ConnAttemptStats)
+	}
+	s, ok := (*m)[network]
+	if !ok {
+		s = new(ConnAttemptStats)
+		(*m)[network] = s
+	}
+	f(s)
+}
+
+func (me *connectivityStats) copy() (ret ConnectivityStats) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	copyMap := func(m map[string]*ConnAttemptStats) map[string]ConnAttemptStats {
+		ret := make(map[string]ConnAttemptStats, len(m))
+		for k, v := range m {
+			ret[k] = *v
+		}
+		return ret
+	}
+	ret.Outgoing = copyMap(me.outgoing)
+	ret.Incoming = copyMap(me.incoming)
+	return
+}
+
+// Returns statistics on outgoing and incoming connection attempts by network.
+func (cl *Client) ConnectivityStats() ConnectivityStats {
+	return cl.connectivity.copy()
+}
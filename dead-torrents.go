@@ -0,0 +1,164 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+type DeadTorrentAction int
+
+const (
+	// Disallow data download and upload. The Torrent stays in the Client, and transfers can be
+	// allowed again.
+	DeadTorrentPause DeadTorrentAction = iota
+	// Drop the Torrent from the Client.
+	DeadTorrentDrop
+)
+
+func (me DeadTorrentAction) String() string {
+	switch me {
+	case DeadTorrentPause:
+		return "pause"
+	case DeadTorrentDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
+
+// Pauses or drops incomplete torrents that have had no seeders and made no progress for a while.
+// This keeps long-running daemons from accumulating torrents that will never complete.
+type DeadTorrentPolicy struct {
+	// How long a Torrent must have been without seeders and progress. The policy is disabled if
+	// this is zero.
+	After  time.Duration
+	Action DeadTorrentAction
+}
+
+type DeadTorrentEvent struct {
+	Torrent *Torrent
+	Action  DeadTorrentAction
+	// When the Torrent last had a seeder or made progress, or when it was added.
+	LastAlive time.Time
+}
+
+// Per-Torrent state for the DeadTorrentPolicy.
+type deadTorrentState struct {
+	lastAlive          time.Time
+	lastBytesCompleted int64
+	// The policy has been applied.
+	dead bool
+}
+
+// Webseeds count as seeders, as they're expected to have all the data.
+func (t *Torrent) haveSeeders() bool {
+	return len(t.connsWithAllPieces) != 0 || len(t.webSeeds) != 0
+}
+
+// Updates the Torrent's dead state, returning true if the policy should be applied now. A dead
+// Torrent that gets seeders or makes progress again is alive, and the policy is applied again if it
+// dies again.
+func (t *Torrent) checkDead(now time.Time, after time.Duration) bool {
+	s := &t.deadState
+	if t.closed.IsSet() {
+		return false
+	}
+	if s.lastAlive.IsZero() {
+		s.lastAlive = t.joinTimes.Added
+	}
+	if t.haveInfo() && t.haveAllPieces() {
+		s.lastAlive = now
+		s.dead = false
+		return false
+	}
+	progress := t.bytesCompleted()
+	if !t.haveInfo() {
+		progress = int64(t.metadataProgressLocked().PiecesHave)
+	}
+	if progress > s.lastBytesCompleted || t.haveSeeders() {
+		s.lastAlive = now
+		s.dead = false
+	}
+	s.lastBytesCompleted = progress
+	if s.dead || now.Sub(s.lastAlive) < after {
+		return false
+	}
+	s.dead = true
+	return true
+}
+
+func deadTorrentCheckInterval(after time.Duration) time.Duration {
+	ret := after / 10
+	if ret < time.Second {
+		ret = time.Second
+	}
+	if ret > time.Minute {
+		ret = time.Minute
+	}
+	return ret
+}
+
+// Starts the loop applying the DeadTorrentPolicy if the policy is enabled and the loop isn't
+// running, such as after ApplyConfig enables it. Called with the Client lock held.
+func (cl *Client) startDeadTorrentPolicyLoop() {
+	if cl.settings.deadTorrentPolicy.After == 0 || cl.deadTorrentPolicyLoopRunning {
+		return
+	}
+	cl.deadTorrentPolicyLoopRunning = true
+	go cl.deadTorrentPolicyLoop()
+}
+
+// Applies the policy until the Client is closed, or the policy is disabled.
+func (cl *Client) deadTorrentPolicyLoop() {
+	for {
+		cl.lock()
+		after := cl.settings.deadTorrentPolicy.After
+		if after == 0 {
+			cl.deadTorrentPolicyLoopRunning = false
+			cl.unlock()
+			return
+		}
+		cl.unlock()
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-time.After(deadTorrentCheckInterval(after)):
+			cl.applyDeadTorrentPolicy(now)
+		}
+	}
+}
+
+func (cl *Client) applyDeadTorrentPolicy(now time.Time) {
+	var events []DeadTorrentEvent
+	cl.lock()
+	policy := cl.settings.deadTorrentPolicy
+	if policy.After == 0 {
+		// Disabled since the loop last waited.
+		cl.unlock()
+		return
+	}
+	for _, t := range cl.torrents {
+		if t.checkDead(now, policy.After) {
+			events = append(events, DeadTorrentEvent{
+				Torrent:   t,
+				Action:    policy.Action,
+				LastAlive: t.deadState.lastAlive,
+			})
+		}
+	}
+	cl.unlock()
+	for _, e := range events {
+		e.Torrent.logger.Levelf(log.Info, "applying dead torrent policy (%v), last alive %v", e.Action, e.LastAlive)
+		switch e.Action {
+		case DeadTorrentPause:
+			e.Torrent.DisallowDataDownload()
+			e.Torrent.DisallowDataUpload()
+		case DeadTorrentDrop:
+			e.Torrent.Drop()
+		}
+		for _, f := range cl.config.Callbacks.DeadTorrent {
+			f(e)
+		}
+	}
+}
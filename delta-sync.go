@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Describes an older version of a Torrent's content for Torrent.DeltaSync.
+type DeltaSyncOpts struct {
+	// The directory containing the old content, laid out as the file storage would have it.
+	Dir string
+	// The info of the old version, if known. Pieces of the new version that match old pieces by
+	// hash are then found even if the files containing them were renamed. Otherwise only data at
+	// the same file paths and offsets is reused.
+	OldInfo *metainfo.Info
+}
+
+type DeltaSyncStats struct {
+	PiecesReused int
+	BytesReused  int64
+}
+
+// Reuses the pieces of an older version of the content on disk that are identical in the new
+// version, so that only changed pieces need to be downloaded. Candidate data for each incomplete
+// piece is read from the same file paths and offsets in the old content, and from old pieces with
+// the same hash, and is only written to storage if it matches the piece hash. The Torrent must
+// have its info, and this should be called before downloading.
+func (t *Torrent) DeltaSync(ctx context.Context, opts DeltaSyncOpts) (stats DeltaSyncStats, err error) {
+	info := t.Info()
+	if info == nil {
+		return stats, errors.New("torrent has no info")
+	}
+	sameLayout, err := openDeltaSyncSource(opts.Dir, info)
+	if err != nil {
+		return
+	}
+	defer sameLayout.close()
+	var oldLayout *deltaSyncSource
+	oldPieces := make(map[metainfo.Hash]int64)
+	if opts.OldInfo != nil {
+		oldLayout, err = openDeltaSyncSource(opts.Dir, opts.OldInfo)
+		if err != nil {
+			return
+		}
+		defer oldLayout.close()
+		for i := 0; i < opts.OldInfo.NumPieces(); i++ {
+			p := opts.OldInfo.Piece(i)
+			oldPieces[p.Hash()] = p.Offset()
+		}
+	}
+	b := make([]byte, info.PieceLength)
+	for i := 0; i < info.NumPieces(); i++ {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		t.cl.rLock()
+		complete := t.pieceComplete(i)
+		t.cl.rUnlock()
+		if complete {
+			continue
+		}
+		p := info.Piece(i)
+		buf := b[:p.Length()]
+		found := sameLayout.readMatching(buf, p.Offset(), p.Hash())
+		if !found && oldLayout != nil {
+			if off, ok := oldPieces[p.Hash()]; ok {
+				found = oldLayout.readMatching(buf, off, p.Hash())
+			}
+		}
+		if !found {
+			continue
+		}
+		if err = t.writeReusedPiece(i, buf); err != nil {
+			return
+		}
+		stats.PiecesReused++
+		stats.BytesReused += int64(len(buf))
+	}
+	torrent.Add("delta sync pieces reused", int64(stats.PiecesReused))
+	t.logger.Levelf(log.Info, "delta sync reused %v pieces (%v bytes)", stats.PiecesReused, stats.BytesReused)
+	return
+}
+
+// Writes verified piece data to storage, and has the Client check it. The write is accounted for
+// like chunks received from peers, so the piece counts toward unverified bytes and isn't requested
+// while it's written, and readers and hashing wait for it.
+func (t *Torrent) writeReusedPiece(piece pieceIndex, b []byte) error {
+	t.cl.lock()
+	p := t.piece(piece)
+	p.incrementPendingWrites()
+	for i := chunkIndexType(0); i < p.numChunks(); i++ {
+		p.unpendChunkIndex(i)
+	}
+	t.cancelRequestsForPiece(piece)
+	t.cl.unlock()
+	concurrentChunkWrites.Add(1)
+	err := t.writeChunk(piece, 0, b)
+	concurrentChunkWrites.Add(-1)
+	t.cl.lock()
+	defer t.cl.unlock()
+	p.decrementPendingWrites()
+	if err != nil {
+		// Leave the piece to be downloaded.
+		t.pendAllChunkSpecs(piece)
+		t.updatePieceRequestOrder(piece)
+		return fmt.Errorf("writing piece %v: %w", piece, err)
+	}
+	t.queuePieceCheck(piece)
+	return nil
+}
+
+// Old content read through read-only file storage, using the given info for the layout.
+type deltaSyncSource struct {
+	client storage.ClientImplCloser
+	impl   storage.TorrentImpl
+}
+
+func openDeltaSyncSource(dir string, info *metainfo.Info) (*deltaSyncSource, error) {
+	client := storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: dir,
+		ReadOnly:      true,
+	})
+	impl, err := client.OpenTorrent(info, metainfo.Hash{})
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("opening old content: %w", err)
+	}
+	return &deltaSyncSource{client, impl}, nil
+}
+
+// Reads into b from off, returning true if the data has the given SHA-1 hash.
+func (me *deltaSyncSource) readMatching(b []byte, off int64, hash metainfo.Hash) bool {
+	n, _ := me.impl.ReadAt(b, off)
+	if n != len(b) {
+		return false
+	}
+	return sha1.Sum(b) == hash
+}
+
+func (me *deltaSyncSource) close() {
+	me.impl.Close()
+	me.client.Close()
+}
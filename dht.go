@@ -1,10 +1,14 @@
 package torrent
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
 
 	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/dht/v2/exts/getput"
 	"github.com/anacrolix/dht/v2/krpc"
 	peer_store "github.com/anacrolix/dht/v2/peer-store"
 )
@@ -27,6 +31,17 @@ type PeerStorer interface {
 	PeerStore() peer_store.Interface
 }
 
+// Optional interface for DhtServers that can estimate swarm size using BEP 33 scrapes.
+type DhtScraper interface {
+	Scrape(ctx context.Context, infoHash [20]byte) (seeders, leechers float64, err error)
+}
+
+// Optional interface for DhtServers that can look up BEP 44 mutable items. The value with the
+// highest sequence number found is returned.
+type DhtMutableItemGetter interface {
+	GetMutableItem(ctx context.Context, publicKey [32]byte, salt []byte) (value []byte, seq int64, err error)
+}
+
 type DhtAnnounce interface {
 	Close()
 	Peers() <-chan dht.PeersValues
@@ -53,6 +68,53 @@ func (me AnacrolixDhtServerWrapper) Announce(hash [20]byte, port int, impliedPor
 	return anacrolixDhtAnnounceWrapper{ann}, err
 }
 
+func (me AnacrolixDhtServerWrapper) Scrape(ctx context.Context, infoHash [20]byte) (seeders, leechers float64, err error) {
+	ann, err := me.Server.AnnounceTraversal(infoHash, dht.Scrape())
+	if err != nil {
+		return
+	}
+	defer ann.Close()
+	var bfsd, bfpe *krpc.ScrapeBloomFilter
+	merge := func(dst **krpc.ScrapeBloomFilter, src *krpc.ScrapeBloomFilter) {
+		if src == nil {
+			return
+		}
+		if *dst == nil {
+			*dst = new(krpc.ScrapeBloomFilter)
+		}
+		for i := range src {
+			(*dst)[i] |= src[i]
+		}
+	}
+	for done := false; !done; {
+		select {
+		case pv, ok := <-ann.Peers:
+			if !ok {
+				done = true
+				break
+			}
+			merge(&bfsd, pv.BFsd)
+			merge(&bfpe, pv.BFpe)
+		case <-ctx.Done():
+			// Use what we have so far.
+			done = true
+		}
+	}
+	return bfsd.EstimateCount(), bfpe.EstimateCount(), nil
+}
+
+func (me AnacrolixDhtServerWrapper) GetMutableItem(ctx context.Context, publicKey [32]byte, salt []byte) (value []byte, seq int64, err error) {
+	res, _, err := getput.Get(ctx, bep44.MakeMutableTarget(publicKey, salt), me.Server, nil, salt)
+	if err != nil {
+		return
+	}
+	if !res.Mutable {
+		err = errors.New("got immutable item")
+		return
+	}
+	return res.V, res.Seq, nil
+}
+
 func (me AnacrolixDhtServerWrapper) Ping(addr *net.UDPAddr) {
 	me.Server.PingQueryInput(addr, dht.QueryInput{
 		RateLimiting: dht.QueryRateLimiting{NoWaitFirst: true},
@@ -0,0 +1,174 @@
+package torrent
+
+import (
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Bounds on the labels kept from a peer's extended handshake.
+const (
+	maxPeerHandshakeMetadataLabels = 32
+	maxPeerHandshakeMetadataLen    = 256
+)
+
+// Scheduler parameters that can be varied between peers for experiments. Zero values use the
+// Client defaults.
+type SchedulerParams struct {
+	// Maximum number of peers that are unchoked at once for a Torrent.
+	UnchokeSlots int
+	// Maximum outstanding requests to each peer.
+	PipelineDepth int
+	// How long a chunk request can go unanswered before RequestTimeoutPolicy is applied to it. Zero
+	// disables request timeouts, leaving requests with a peer until it's dropped or the request is
+	// stolen by a better performing peer. Suitable values are much shorter on a LAN than in a WAN
+	// swarm.
+	RequestTimeout time.Duration
+	// What's done with chunk requests that exceed RequestTimeout.
+	RequestTimeoutPolicy RequestTimeoutPolicy
+}
+
+// Provides scheduler parameters for a Torrent. This lets experiments assign parameter variations
+// to subsets of peers.
+type SchedulerParamsProvider interface {
+	SchedulerParams(infoHash metainfo.Hash) SchedulerParams
+}
+
+// A SchedulerParamsProvider that returns the same parameters for every Torrent.
+type StaticSchedulerParams SchedulerParams
+
+func (me StaticSchedulerParams) SchedulerParams(metainfo.Hash) SchedulerParams {
+	return SchedulerParams(me)
+}
+
+// Returns the scheduler parameters for the Torrent. Parameters pushed by a tracker take precedence
+// over those from ClientConfig.SchedulerParams.
+func (t *Torrent) schedulerParams() (ret SchedulerParams) {
+	if p := t.cl.config.SchedulerParams; p != nil {
+		ret = p.SchedulerParams(t.infoHash)
+	}
+	if t.trackerSchedulerParams.UnchokeSlots != 0 {
+		ret.UnchokeSlots = t.trackerSchedulerParams.UnchokeSlots
+	}
+	if t.trackerSchedulerParams.PipelineDepth != 0 {
+		ret.PipelineDepth = t.trackerSchedulerParams.PipelineDepth
+	}
+	return
+}
+
+func (t *Torrent) setTrackerSchedulerParams(ps SchedulerParams) {
+	if ps == t.trackerSchedulerParams {
+		return
+	}
+	t.trackerSchedulerParams = ps
+	t.iterPeers(func(p *Peer) {
+		p.updateRequests("scheduler params changed")
+	})
+	for c := range t.conns {
+		c.tickleWriter()
+	}
+}
+
+// Whether another peer can be unchoked without exceeding SchedulerParams.UnchokeSlots.
+func (t *Torrent) unchokeSlotAvailable() bool {
+	slots := t.schedulerParams().UnchokeSlots
+	if slots <= 0 {
+		return true
+	}
+	unchoked := 0
+	for c := range t.conns {
+		if !c.choking {
+			unchoked++
+		}
+	}
+	return unchoked < slots
+}
+
+// Whether the unchoked peer c is to be choked because more peers are unchoked than
+// SchedulerParams.UnchokeSlots allows, as happens when the slots are reduced. The least reliable
+// peers (see Torrent.lessReliable) give up their slots first.
+func (t *Torrent) unchokeSlotsExceeded(c *PeerConn) bool {
+	slots := t.schedulerParams().UnchokeSlots
+	if slots <= 0 {
+		return false
+	}
+	unchoked, lessReliable := 0, 0
+	for o := range t.conns {
+		if o.choking {
+			continue
+		}
+		unchoked++
+		if o != c && t.lessReliable(o, c) {
+			lessReliable++
+		}
+	}
+	return lessReliable < unchoked-slots
+}
+
+// Returns the labels for our extended handshakes. See ClientConfig.HandshakeMetadata.
+func (cl *Client) handshakeMetadata() map[string]bencode.Bytes {
+	if len(cl.config.HandshakeMetadata) == 0 && cl.config.ExperimentId == "" {
+		return nil
+	}
+	ret := make(map[string]bencode.Bytes, len(cl.config.HandshakeMetadata)+1)
+	if cl.config.ExperimentId != "" {
+		ret["experiment_id"] = bencode.MustMarshal(cl.config.ExperimentId)
+	}
+	for k, v := range cl.config.HandshakeMetadata {
+		ret[k] = bencode.MustMarshal(v)
+	}
+	return ret
+}
+
+// Decodes the labels from a peer's extended handshake. Labels that aren't strings, or whose keys
+// or values are longer than maxPeerHandshakeMetadataLen, are ignored, and only the first
+// maxPeerHandshakeMetadataLabels by key are kept.
+func decodeHandshakeMetadata(m map[string]bencode.Bytes) (ret map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(ret) == maxPeerHandshakeMetadataLabels {
+			break
+		}
+		var v string
+		if len(k) > maxPeerHandshakeMetadataLen || bencode.Unmarshal(m[k], &v) != nil || len(v) > maxPeerHandshakeMetadataLen {
+			continue
+		}
+		if ret == nil {
+			ret = make(map[string]string)
+		}
+		ret[k] = v
+	}
+	return
+}
+
+// Returns the labels the peer sent in its extended handshake, or nil if it sent none. See
+// ClientConfig.HandshakeMetadata.
+func (cn *PeerConn) PeerHandshakeMetadata() map[string]string {
+	cn.locker().RLock()
+	defer cn.locker().RUnlock()
+	return copyStringMap(cn.peerHandshakeMetadata)
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (cl *Client) addExperimentAnnounceParams(vs url.Values) {
+	if cl.config.ExperimentId != "" {
+		vs.Set("experiment_id", cl.config.ExperimentId)
+	}
+}
@@ -0,0 +1,276 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/log"
+	"github.com/pion/stun"
+)
+
+const (
+	externalAddrRefreshInterval = 10 * time.Minute
+	// Failed discoveries are retried sooner than successful ones are refreshed.
+	externalAddrRetryInterval = time.Minute
+	stunTimeout               = 5 * time.Second
+)
+
+// Our addresses as seen from outside, discovered through ClientConfig.StunServers. The IP is nil
+// for an address family that hasn't been discovered. Ports are those the NAT mapped for the
+// discovery socket, and not necessarily the ones peers can reach us on.
+type ExternalAddrs struct {
+	Ip4 IpPort
+	Ip6 IpPort
+}
+
+// This has its own lock so that external addresses can be read wherever they're needed, whether
+// or not the Client lock is held.
+type externalAddrsState struct {
+	mu    sync.RWMutex
+	addrs ExternalAddrs
+}
+
+// Returns the addresses discovered through ClientConfig.StunServers.
+func (cl *Client) ExternalAddrs() ExternalAddrs {
+	cl.externalAddrs.mu.RLock()
+	defer cl.externalAddrs.mu.RUnlock()
+	return cl.externalAddrs.addrs
+}
+
+// Our public IPv4 address, configured or discovered.
+func (cl *Client) publicIp4() net.IP {
+	return firstNotNil(cl.config.PublicIp4, cl.ExternalAddrs().Ip4.IP)
+}
+
+// Our public IPv6 address, configured or discovered.
+func (cl *Client) publicIp6() net.IP {
+	return firstNotNil(cl.config.PublicIp6, cl.ExternalAddrs().Ip6.IP)
+}
+
+// Returns the addresses to send in announces. See ClientConfig.AnnounceIp4.
+func (cl *Client) announceIps() (ip4, ip6 net.IP) {
+	return firstNotNil(cl.config.AnnounceIp4, cl.publicIp4()),
+		firstNotNil(cl.config.AnnounceIp6, cl.publicIp6())
+}
+
+// The public IP a DHT server on the conn derives its node ID from.
+func (cl *Client) dhtPublicIp(conn net.PacketConn) net.IP {
+	if ip6 := cl.publicIp6(); connIsIpv6(conn) && ip6 != nil {
+		return ip6
+	}
+	return cl.publicIp4()
+}
+
+// A DHT server created by NewClient on one of its sockets.
+type clientDhtServer struct {
+	conn   net.PacketConn
+	server *dht.Server
+}
+
+// The Client's listeners own the socket, so it's left open when a DHT server using it is closed,
+// and a replacement can be started on it.
+type dhtServerConn struct {
+	net.PacketConn
+}
+
+func (dhtServerConn) Close() error {
+	return nil
+}
+
+// Replaces the DHT servers created by NewClient whose node IDs aren't secure (BEP 42) for our
+// public IP, as happens after external addresses are discovered, or change. Replacements keep the
+// nodes of the servers they replace, and take over their torrent announces. A closed server
+// reading from the shared socket may swallow one more packet before it stops.
+func (cl *Client) restartDhtServers() {
+	cl.lock()
+	defer cl.unlock()
+	if cl.closed.IsSet() {
+		return
+	}
+	servers := append([]DhtServer(nil), cl.dhtServers...)
+	for _, cs := range cl.clientDhtServers {
+		ip := cl.dhtPublicIp(cs.conn)
+		if ip == nil || dht.NodeIdSecure(cs.server.ID(), ip) {
+			continue
+		}
+		ds, err := cl.NewAnacrolixDhtServer(cs.conn)
+		if err != nil {
+			cl.logger.Levelf(log.Warning, "restarting dht server on %v: %v", cs.conn.LocalAddr(), err)
+			continue
+		}
+		for _, ni := range cs.server.Nodes() {
+			ds.AddNode(ni)
+		}
+		old := DhtServer(AnacrolixDhtServerWrapper{cs.server})
+		cs.server.Close()
+		cs.server = ds
+		for i := range servers {
+			if servers[i] == old {
+				servers[i] = AnacrolixDhtServerWrapper{ds}
+			}
+		}
+		torrent.Add("dht server restarts", 1)
+		if cl.config.PeriodicallyAnnounceTorrentsToDht {
+			for _, t := range cl.torrents {
+				go t.dhtAnnouncer(AnacrolixDhtServerWrapper{ds})
+			}
+		}
+	}
+	cl.dhtServers = servers
+	// Announcers for replaced servers stop.
+	cl.event.Broadcast()
+}
+
+// Whether s is a DHT server that was replaced by restartDhtServers.
+func (cl *Client) dhtServerReplaced(s DhtServer) bool {
+	if _, ok := s.(AnacrolixDhtServerWrapper); !ok {
+		return false
+	}
+	for _, ds := range cl.dhtServers {
+		if ds == s {
+			return false
+		}
+	}
+	return true
+}
+
+func (cl *Client) externalAddrLoop() {
+	for {
+		var wait time.Duration
+		if cl.discoverExternalAddrs(context.Background()) {
+			wait = externalAddrRefreshInterval
+		} else {
+			wait = externalAddrRetryInterval
+		}
+		select {
+		case <-cl.closed.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Queries the STUN servers for each enabled address family, and returns whether any address was
+// discovered. An address that fails to be discovered keeps its previous value, as the failure may
+// be transient.
+func (cl *Client) discoverExternalAddrs(ctx context.Context) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-cl.closed.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	var (
+		wg         sync.WaitGroup
+		ip4, ip6   IpPort
+		err4, err6 = errors.New("disabled"), errors.New("disabled")
+	)
+	discover := func(network string, ret *IpPort, err *error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			*ret, *err = stunDiscover(ctx, network, cl.config.StunServers)
+		}()
+	}
+	if !cl.config.DisableIPv4 {
+		discover("udp4", &ip4, &err4)
+	}
+	if !cl.config.DisableIPv6 {
+		discover("udp6", &ip6, &err6)
+	}
+	wg.Wait()
+	s := &cl.externalAddrs
+	s.mu.Lock()
+	old := s.addrs
+	if err4 == nil {
+		s.addrs.Ip4 = ip4
+	}
+	if err6 == nil {
+		s.addrs.Ip6 = ip6
+	}
+	addrs := s.addrs
+	s.mu.Unlock()
+	if err4 != nil && err6 != nil {
+		torrent.Add("external address discovery failures", 1)
+		cl.logger.Levelf(log.Warning, "discovering external addresses: ipv4: %v, ipv6: %v", err4, err6)
+		return false
+	}
+	if !ipPortEqual(old.Ip4, addrs.Ip4) || !ipPortEqual(old.Ip6, addrs.Ip6) {
+		torrent.Add("external address changes", 1)
+		cl.logger.Levelf(log.Info, "external addresses changed to %v and %v", addrs.Ip4, addrs.Ip6)
+		cl.restartDhtServers()
+		for _, f := range cl.config.Callbacks.ExternalAddrsChanged {
+			f(addrs)
+		}
+	}
+	return true
+}
+
+func ipPortEqual(a, b IpPort) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// Returns our address as seen by the first of the STUN servers to respond over the given network.
+func stunDiscover(ctx context.Context, network string, servers []string) (addr IpPort, err error) {
+	ctx, cancel := context.WithTimeout(ctx, stunTimeout)
+	defer cancel()
+	err = errors.New("no stun servers")
+	for _, server := range servers {
+		addr, err = stunQuery(ctx, network, server)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return IpPort{}, ctx.Err()
+		}
+	}
+	return IpPort{}, err
+}
+
+func stunQuery(ctx context.Context, network, server string) (IpPort, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return IpPort{}, err
+	}
+	defer c.Close()
+	go func() {
+		<-ctx.Done()
+		c.SetDeadline(time.Now())
+	}()
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := c.Write(req.Raw); err != nil {
+		return IpPort{}, err
+	}
+	b := make([]byte, 1500)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			return IpPort{}, fmt.Errorf("querying %v: %w", server, err)
+		}
+		res := stun.Message{Raw: b[:n]}
+		if res.Decode() != nil || res.TransactionID != req.TransactionID {
+			continue
+		}
+		if res.Type != stun.BindingSuccess {
+			return IpPort{}, fmt.Errorf("querying %v: unexpected response %v", server, res.Type)
+		}
+		var xor stun.XORMappedAddress
+		if xor.GetFrom(&res) == nil {
+			return IpPort{IP: xor.IP, Port: uint16(xor.Port)}, nil
+		}
+		var mapped stun.MappedAddress
+		if err := mapped.GetFrom(&res); err != nil {
+			return IpPort{}, fmt.Errorf("querying %v: %w", server, err)
+		}
+		return IpPort{IP: mapped.IP, Port: uint16(mapped.Port)}, nil
+	}
+}
@@ -0,0 +1,118 @@
+package torrent
+
+import (
+	"container/heap"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const (
+	minFdPressureBackoff = time.Second
+	maxFdPressureBackoff = 30 * time.Second
+)
+
+type FdPressureEvent struct {
+	// The operation that failed: "accept", "dial", "storage open", "storage read" or "storage
+	// write".
+	Op  string
+	Err error
+	// The number of peer connections closed to free file descriptors.
+	ConnsShed int
+	// How long new outgoing connections are held off.
+	Backoff time.Duration
+}
+
+// Whether the error is from the process or system running out of file descriptors.
+func fdExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// Client-wide state for backing off when file descriptors run out.
+type fdPressureState struct {
+	until   time.Time
+	backoff time.Duration
+}
+
+func (me *fdPressureState) active(now time.Time) bool {
+	return now.Before(me.until)
+}
+
+// Sheds the least useful connections and holds off dialing. Repeated pressure soon after a backoff
+// ends doubles the backoff. Returns false if already backing off. The Client lock must be held.
+func (cl *Client) onFdPressure(op string, err error) bool {
+	now := time.Now()
+	s := &cl.fdPressure
+	if s.active(now) {
+		return false
+	}
+	if s.backoff == 0 || now.Sub(s.until) > 2*s.backoff {
+		s.backoff = minFdPressureBackoff
+	} else if s.backoff *= 2; s.backoff > maxFdPressureBackoff {
+		s.backoff = maxFdPressureBackoff
+	}
+	s.until = now.Add(s.backoff)
+	e := FdPressureEvent{
+		Op:        op,
+		Err:       err,
+		ConnsShed: cl.shedWorstConns(),
+		Backoff:   s.backoff,
+	}
+	torrent.Add("fd pressure events", 1)
+	cl.logger.Levelf(log.Warning, "out of file descriptors on %v: %v (shed %v conns, backing off %v)", op, err, e.ConnsShed, e.Backoff)
+	time.AfterFunc(s.backoff, cl.openNewConnsAfterFdPressure)
+	for _, f := range cl.config.Callbacks.FdPressure {
+		f(e)
+	}
+	return true
+}
+
+func (cl *Client) openNewConnsAfterFdPressure() {
+	cl.lock()
+	defer cl.unlock()
+	if cl.closed.IsSet() {
+		return
+	}
+	for _, t := range cl.torrents {
+		t.openNewConns()
+	}
+}
+
+// Closes the least useful tenth of peer connections across all Torrents, and at least one.
+func (cl *Client) shedWorstConns() int {
+	var wcs worseConnSlice
+	for _, t := range cl.torrents {
+		wcs.conns = t.appendUnclosedConns(wcs.conns)
+	}
+	if len(wcs.conns) == 0 {
+		return 0
+	}
+	wcs.initKeys()
+	heap.Init(&wcs)
+	n := maxInt(1, len(wcs.conns)/10)
+	for i := 0; i < n; i++ {
+		c := heap.Pop(&wcs).(*PeerConn)
+		c.logger.Levelf(log.Debug, "shedding conn for fd pressure")
+		c.setCloseReason(c.evictionReason())
+		c.drop()
+	}
+	torrent.Add("conns shed for fd pressure", int64(n))
+	return n
+}
+
+// Returns how long to wait before accepting again after an error. Backoff doubles on consecutive
+// fd exhaustion, and resets otherwise.
+func acceptErrBackoff(err error, last time.Duration) time.Duration {
+	if !fdExhausted(err) {
+		return 0
+	}
+	if last == 0 {
+		return 5 * time.Millisecond
+	}
+	if last *= 2; last > time.Second {
+		last = time.Second
+	}
+	return last
+}
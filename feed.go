@@ -0,0 +1,333 @@
+package torrent
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+const (
+	defaultFeedPollInterval = 15 * time.Minute
+	// Feed documents larger than this are rejected.
+	maxFeedSize = 16 << 20
+	// The number of handled entry IDs remembered for each Feed. The oldest are forgotten first, so
+	// this must be more than the entries a feed lists at once.
+	maxFeedSeenEntries = 1 << 14
+)
+
+// An RSS or Atom feed polled for torrents to add automatically. See ClientConfig.Feeds.
+type Feed struct {
+	Url string
+	// Entries are added if their title matches any of these. All entries are added if there are
+	// none.
+	Filters []*regexp.Regexp
+	// Data for torrents from the feed is stored here, instead of in the default storage.
+	DownloadDir string
+	// Defaults to 15 minutes.
+	PollInterval time.Duration
+	// If set, the IDs of entries already handled are kept in this file, so they aren't added again
+	// after a restart, such as when they've since been dropped from the Client.
+	SeenFile string
+}
+
+// An entry in a Feed that refers to a torrent.
+type FeedEntry struct {
+	Title string
+	// The entry GUID or ID, or the Url if there's none.
+	Id string
+	// A magnet link or a metainfo URL.
+	Url string
+}
+
+type FeedEntryAddedEvent struct {
+	Feed    *Feed
+	Entry   FeedEntry
+	Torrent *Torrent
+}
+
+// Covers RSS 2.0 and Atom documents.
+type feedDocument struct {
+	Items []struct {
+		Title     string `xml:"title"`
+		Link      string `xml:"link"`
+		Guid      string `xml:"guid"`
+		Enclosure struct {
+			Url string `xml:"url,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Id    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func parseFeed(b []byte) (entries []FeedEntry, err error) {
+	var doc feedDocument
+	if err = xml.Unmarshal(b, &doc); err != nil {
+		return
+	}
+	for _, item := range doc.Items {
+		e := FeedEntry{Title: item.Title, Id: item.Guid, Url: item.Enclosure.Url}
+		if e.Url == "" {
+			e.Url = item.Link
+		}
+		entries = append(entries, e)
+	}
+	for _, entry := range doc.Entries {
+		e := FeedEntry{Title: entry.Title, Id: entry.Id}
+		for _, l := range entry.Links {
+			// Prefer torrent enclosures and magnet links to other links, such as to web pages.
+			if e.Url == "" || l.Rel == "enclosure" || l.Type == "application/x-bittorrent" || strings.HasPrefix(l.Href, "magnet:") {
+				e.Url = l.Href
+			}
+		}
+		entries = append(entries, e)
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.Title = strings.TrimSpace(e.Title)
+		e.Url = strings.TrimSpace(e.Url)
+		if e.Id == "" {
+			e.Id = e.Url
+		}
+	}
+	return
+}
+
+func (f *Feed) matches(e FeedEntry) bool {
+	if len(f.Filters) == 0 {
+		return true
+	}
+	for _, re := range f.Filters {
+		if re.MatchString(e.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Polls a Feed and adds new matching entries until the Client is closed. Entries present on the
+// first poll are added too.
+func (cl *Client) feedLoop(f *Feed) {
+	interval := f.PollInterval
+	if interval == 0 {
+		interval = defaultFeedPollInterval
+	}
+	var feedStorage storage.ClientImplCloser
+	if f.DownloadDir != "" {
+		feedStorage = storage.NewFile(f.DownloadDir)
+		defer feedStorage.Close()
+	}
+	var seen feedSeen
+	if f.SeenFile != "" {
+		var err error
+		seen, err = loadFeedSeen(f.SeenFile)
+		if err != nil {
+			cl.logger.Levelf(log.Warning, "loading seen entries for feed %q: %v", f.Url, err)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cl.pollFeed(f, feedStorage, &seen)
+		if seen.dirty && f.SeenFile != "" {
+			if err := seen.save(f.SeenFile); err != nil {
+				cl.logger.Levelf(log.Warning, "saving seen entries for feed %q: %v", f.Url, err)
+			} else {
+				seen.dirty = false
+			}
+		}
+		select {
+		case <-cl.closed.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cl *Client) pollFeed(f *Feed, feedStorage storage.ClientImpl, seen *feedSeen) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cl.closed.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	entries, err := cl.getFeed(ctx, f.Url)
+	if err != nil {
+		cl.logger.Levelf(log.Warning, "getting feed %q: %v", f.Url, err)
+		return
+	}
+	for _, e := range entries {
+		if seen.has(e.Id) {
+			continue
+		}
+		if e.Url == "" || !f.matches(e) {
+			seen.add(e.Id)
+			continue
+		}
+		t, err := cl.addFeedEntry(ctx, e, feedStorage)
+		if err != nil {
+			// Retried on the next poll.
+			cl.logger.Levelf(log.Warning, "adding feed entry %q: %v", e.Title, err)
+			continue
+		}
+		seen.add(e.Id)
+		torrent.Add("feed entries added", 1)
+		for _, cb := range cl.config.Callbacks.FeedEntryAdded {
+			cb(FeedEntryAddedEvent{Feed: f, Entry: e, Torrent: t})
+		}
+	}
+}
+
+func (cl *Client) getFeed(ctx context.Context, url string) ([]FeedEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code: %v", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %v bytes", maxFeedSize)
+	}
+	return parseFeed(b)
+}
+
+// The IDs of entries already handled for a Feed, oldest first, so that they're only added once.
+type feedSeen struct {
+	ids   map[string]struct{}
+	order []string
+	// Entries were added since the IDs were last saved.
+	dirty bool
+}
+
+func (me *feedSeen) has(id string) bool {
+	_, ok := me.ids[id]
+	return ok
+}
+
+// Records id, forgetting the oldest IDs beyond maxFeedSeenEntries.
+func (me *feedSeen) add(id string) {
+	if me.has(id) {
+		return
+	}
+	if me.ids == nil {
+		me.ids = make(map[string]struct{})
+	}
+	me.ids[id] = struct{}{}
+	me.order = append(me.order, id)
+	for len(me.order) > maxFeedSeenEntries {
+		delete(me.ids, me.order[0])
+		me.order = me.order[1:]
+	}
+	me.dirty = true
+}
+
+// Loads IDs saved by feedSeen.save. A missing file has none.
+func loadFeedSeen(path string) (ret feedSeen, err error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return
+	}
+	var ids []string
+	err = bencode.Unmarshal(b, &ids)
+	if err != nil {
+		return ret, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	for _, id := range ids {
+		ret.add(id)
+	}
+	ret.dirty = false
+	return
+}
+
+// Writes the IDs to path as a bencoded list, replacing it atomically.
+func (me *feedSeen) save(path string) error {
+	b, err := bencode.Marshal(me.order)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (cl *Client) addFeedEntry(ctx context.Context, e FeedEntry, feedStorage storage.ClientImpl) (t *Torrent, err error) {
+	var spec *TorrentSpec
+	if strings.HasPrefix(e.Url, "magnet:") {
+		spec, err = TorrentSpecFromMagnetUri(e.Url)
+	} else {
+		var mi metainfo.MetaInfo
+		mi, err = getTorrentSource(ctx, e.Url, cl.httpClient)
+		if err == nil {
+			spec, err = TorrentSpecFromMetaInfoErr(&mi)
+		}
+	}
+	if err != nil {
+		return
+	}
+	spec.Storage = feedStorage
+	t, _, err = cl.AddTorrentSpec(spec)
+	if err != nil {
+		return
+	}
+	// Entries with magnet links don't have the info yet.
+	go func() {
+		select {
+		case <-t.GotInfo():
+			t.DownloadAll()
+		case <-t.Closed():
+		}
+	}()
+	return
+}
@@ -0,0 +1,104 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Per-Torrent state for detecting changes made to files outside the client.
+type fileChangeState struct {
+	stats []storage.FileStat
+	// Whether each file was complete when its stat was recorded. The client doesn't write to
+	// complete files, so changes to those are external.
+	complete []bool
+}
+
+func fileStatChanged(a, b storage.FileStat) bool {
+	return a.Size != b.Size || !a.ModTime.Equal(b.ModTime)
+}
+
+func (cl *Client) fileChangeLoop() {
+	ticker := time.NewTicker(cl.config.FileChangeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, t := range cl.Torrents() {
+			t.checkFilesChanged()
+		}
+	}
+}
+
+// Compares the storage file states with those from the last check, and marks the pieces of
+// complete files that have changed as not complete, and queues them for hashing.
+func (t *Torrent) checkFilesChanged() {
+	t.cl.rLock()
+	if t.closed.IsSet() || !t.haveInfo() || t.storage == nil || t.storage.FileStats == nil {
+		t.cl.rUnlock()
+		return
+	}
+	fileStats := t.storage.FileStats
+	t.cl.rUnlock()
+	stats, err := fileStats()
+	if err != nil {
+		t.logger.Levelf(log.Warning, "getting file stats: %v", err)
+		return
+	}
+	t.cl.lock()
+	defer t.cl.unlock()
+	if t.closed.IsSet() {
+		return
+	}
+	files := *t.files
+	if len(stats) != len(files) {
+		t.logger.Levelf(log.Warning, "storage returned %v file stats for %v files", len(stats), len(files))
+		return
+	}
+	s := &t.fileChanges
+	complete := make([]bool, len(files))
+	var changed []*File
+	for i, f := range files {
+		complete[i] = f.length != 0 && t.fileComplete(f)
+		if s.stats != nil && s.complete[i] && complete[i] && fileStatChanged(stats[i], s.stats[i]) {
+			changed = append(changed, f)
+		}
+	}
+	for _, f := range changed {
+		t.logger.Levelf(log.Warning, "file %q changed outside the client, rechecking its pieces", f.DisplayPath())
+		torrent.Add("files changed externally", 1)
+		for i := f.BeginPieceIndex(); i < f.EndPieceIndex(); i++ {
+			if !t.pieceComplete(i) {
+				// Already handled for a file sharing this piece.
+				continue
+			}
+			if err := t.piece(i).Storage().MarkNotComplete(); err != nil {
+				t.logger.Levelf(log.Warning, "marking piece %v not complete: %v", i, err)
+			}
+			t.updatePieceCompletion(i)
+			t.queuePieceCheck(i)
+		}
+	}
+	if len(changed) != 0 {
+		// Files sharing pieces with changed files are no longer complete either.
+		for i, f := range files {
+			complete[i] = complete[i] && t.fileComplete(f)
+		}
+	}
+	s.stats = stats
+	s.complete = complete
+}
+
+func (t *Torrent) fileComplete(f *File) bool {
+	for i := f.BeginPieceIndex(); i < f.EndPieceIndex(); i++ {
+		if !t.pieceComplete(i) {
+			return false
+		}
+	}
+	return true
+}
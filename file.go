@@ -2,6 +2,8 @@ package torrent
 
 import (
 	"github.com/RoaringBitmap/roaring"
+	"github.com/anacrolix/chansync"
+	"github.com/anacrolix/chansync/events"
 	"github.com/anacrolix/missinggo/v2/bitmap"
 
 	"github.com/anacrolix/torrent/metainfo"
@@ -16,6 +18,8 @@ type File struct {
 	fi          metainfo.FileInfo
 	displayPath string
 	prio        piecePriority
+	// See File.Done. A pointer as File has value receivers.
+	done *chansync.SetOnce
 }
 
 func (f *File) Torrent() *Torrent {
@@ -55,6 +59,27 @@ func (f *File) bytesCompletedLocked() int64 {
 	return f.length - f.bytesLeft()
 }
 
+// The fraction of the file that's complete, from 0 to 1. See File.BytesCompleted.
+func (f *File) Progress() float64 {
+	if f.length == 0 {
+		return 1
+	}
+	return float64(f.BytesCompleted()) / float64(f.length)
+}
+
+// Closed when all the pieces containing the file's data have been verified. It isn't reopened if
+// any later fail verification.
+func (f *File) Done() events.Done {
+	return f.done.Done()
+}
+
+// Marks the file done if its data is complete.
+func (f *File) updateDone() {
+	if f.bytesLeft() == 0 {
+		f.done.Set()
+	}
+}
+
 func fileBytesLeft(
 	torrentUsualPieceSize int64,
 	fileFirstPieceIndex int,
@@ -85,6 +110,18 @@ func fileBytesLeft(
 	return
 }
 
+func (t *Torrent) fileWanted(f *File) bool {
+	if f.prio != PiecePriorityNone {
+		return true
+	}
+	for i := f.BeginPieceIndex(); i < f.EndPieceIndex(); i++ {
+		if t.pieces[i].priority == PiecePriorityNone {
+			return false
+		}
+	}
+	return true
+}
+
 func (f *File) bytesLeft() (left int64) {
 	return fileBytesLeft(int64(f.t.usualPieceSize()), f.BeginPieceIndex(), f.EndPieceIndex(), f.offset, f.length, &f.t._completedPieces)
 }
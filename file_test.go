@@ -1,10 +1,16 @@
 package torrent
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
 )
 
 func TestFileExclusivePieces(t *testing.T) {
@@ -114,3 +120,65 @@ func TestFileBytesLeft(t *testing.T) {
 		name: "ThreePiecesCompletedAll",
 	}.Run(t)
 }
+
+func TestPieceFilesSmallFiles(t *testing.T) {
+	cl := &Client{config: TestingConfig(t)}
+	cl.initLogger()
+	tor := cl.newTorrent(metainfo.Hash{}, nil)
+	require.NoError(t, tor.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*2),
+		PieceLength: 8,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 2},
+			{Path: []string{"empty"}, Length: 0},
+			{Path: []string{"b"}, Length: 3},
+			{Path: []string{"c"}, Length: 5},
+		},
+	}))
+	files := tor.Files()
+	assert.Equal(t, []*File{files[0], files[2], files[3]}, tor.piece(0).Files())
+	assert.Equal(t, []*File{files[3]}, tor.piece(1).Files())
+	files[0].prio = PiecePriorityNormal
+	files[1].prio = PiecePriorityNow
+	files[2].prio = PiecePriorityHigh
+	assert.Equal(t, PiecePriorityHigh, tor.piece(0).purePriority())
+	assert.Equal(t, PiecePriorityNone, tor.piece(1).purePriority())
+}
+
+func TestFileProgressAndDone(t *testing.T) {
+	mi := (&testutil.Torrent{
+		Name: "d",
+		Files: []testutil.File{
+			{Name: "a", Data: "aaaaabbbbb"},
+			{Name: "empty"},
+			{Name: "b", Data: "cccccddddd"},
+		},
+	}).Metainfo(5)
+	cfg := TestingConfig(t)
+	dir := filepath.Join(cfg.DataDir, "d")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	// All of a, and the first piece of b.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("aaaaabbbbb"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b"), []byte("ccccc"), 0o644))
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	files := tt.Files()
+	isDone := func(f *File) bool {
+		select {
+		case <-f.Done():
+			return true
+		default:
+			return false
+		}
+	}
+	assert.Equal(t, 1.0, files[0].Progress())
+	assert.True(t, isDone(files[0]))
+	assert.Equal(t, 1.0, files[1].Progress())
+	assert.True(t, isDone(files[1]))
+	assert.Equal(t, 0.5, files[2].Progress())
+	assert.False(t, isDone(files[2]))
+}
@@ -0,0 +1,164 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/log"
+
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+type FreeRiderAction int
+
+const (
+	// Stop uploading to the peer for the rest of the connection.
+	FreeRiderChoke FreeRiderAction = iota
+	// Ban the peer's IP and drop its connections.
+	FreeRiderBan
+)
+
+func (me FreeRiderAction) String() string {
+	switch me {
+	case FreeRiderChoke:
+		return "choke"
+	case FreeRiderBan:
+		return "ban"
+	default:
+		return "unknown"
+	}
+}
+
+// Detects peers that take data without giving back, by comparing what we observe locally with
+// the swarm-wide ratios that trackers return for stats reports (see
+// ClientConfig.StatsReportInterval), and chokes or bans them. Peers are only acted on if they're
+// judged locally, as tracker ratios can be skewed by reports made about them by others. The
+// thresholds are exposed for experiments.
+type FreeRiderPolicy struct {
+	// How often peers are evaluated. The policy is disabled if this is zero.
+	CheckInterval time.Duration
+	// Peers aren't judged until we've uploaded at least this much piece data to them. Tracker
+	// ratios are ignored for peers that have downloaded less than this in the swarm.
+	MinUploaded int64
+	// A peer is a local free-rider if the useful data it has sent us divided by the data we've
+	// sent it is below this. This isn't judged while we're seeding, as peers can't give back then.
+	MinLocalRatio float64
+	// A peer is a tracker free-rider if its swarm-wide upload divided by its download is below
+	// this.
+	MinTrackerRatio float64
+	// Only act on local free-riders if a tracker has a ratio for the peer. Otherwise local
+	// observations suffice when trackers have nothing to compare against.
+	RequireTrackerAgreement bool
+	Action                  FreeRiderAction
+}
+
+type FreeRiderEvent struct {
+	Torrent *Torrent
+	Peer    *PeerConn
+	Action  FreeRiderAction
+	// Negative if not judged.
+	LocalRatio float64
+	// Negative if no tracker reported a ratio for the peer.
+	TrackerRatio float64
+}
+
+// Returns the local ratio for a peer, and whether it's judged.
+func (p *FreeRiderPolicy) localRatio(c *PeerConn) (float64, bool) {
+	uploaded := c._stats.BytesWrittenData.Int64()
+	if uploaded == 0 || uploaded < p.MinUploaded || c.t.seeding() {
+		return -1, false
+	}
+	return float64(c._stats.BytesReadUsefulData.Int64()) / float64(uploaded), true
+}
+
+// Returns the swarm-wide ratio for a peer as reported by trackers, and whether it's known.
+func (p *FreeRiderPolicy) trackerRatio(c *PeerConn) (float64, bool) {
+	rp, ok := c.t.trackerReportedPeers[c.PeerID]
+	if !ok || rp.Downloaded == 0 || rp.Downloaded < p.MinUploaded {
+		return -1, false
+	}
+	return float64(rp.Uploaded) / float64(rp.Downloaded), true
+}
+
+// Combines the local and tracker judgements of a peer. When both are available they must agree.
+// The tracker judgement alone is never enough.
+func (p *FreeRiderPolicy) judge(local float64, localOk bool, tracker float64, trackerOk bool) bool {
+	if !localOk || local >= p.MinLocalRatio {
+		return false
+	}
+	if trackerOk {
+		return tracker < p.MinTrackerRatio
+	}
+	return !p.RequireTrackerAgreement
+}
+
+// Records the peer totals returned by a tracker for a stats report.
+func (t *Torrent) setTrackerReportedPeers(peers map[string]httpTracker.ReportedPeer) {
+	for k, v := range peers {
+		var id PeerID
+		if len(k) != len(id) {
+			continue
+		}
+		copy(id[:], k)
+		if t.trackerReportedPeers == nil {
+			t.trackerReportedPeers = make(map[PeerID]httpTracker.ReportedPeer)
+		}
+		t.trackerReportedPeers[id] = v
+	}
+}
+
+func (cl *Client) freeRiderLoop() {
+	ticker := time.NewTicker(cl.config.FreeRiderPolicy.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case <-ticker.C:
+		}
+		cl.applyFreeRiderPolicy()
+	}
+}
+
+func (cl *Client) applyFreeRiderPolicy() {
+	policy := &cl.config.FreeRiderPolicy
+	var events []FreeRiderEvent
+	cl.lock()
+	for _, t := range cl.torrents {
+		for c := range t.conns {
+			if c.freeRiderChoked {
+				continue
+			}
+			local, localOk := policy.localRatio(c)
+			tracker, trackerOk := policy.trackerRatio(c)
+			if policy.judge(local, localOk, tracker, trackerOk) {
+				events = append(events, FreeRiderEvent{
+					Torrent:      t,
+					Peer:         c,
+					Action:       policy.Action,
+					LocalRatio:   local,
+					TrackerRatio: tracker,
+				})
+			}
+		}
+	}
+	for _, e := range events {
+		e.Torrent.logger.Levelf(log.Info,
+			"applying free-rider policy (%v) to %v, local ratio %.3f, tracker ratio %.3f",
+			e.Action, e.Peer, e.LocalRatio, e.TrackerRatio)
+		switch e.Action {
+		case FreeRiderChoke:
+			torrent.Add("free-riders choked", 1)
+			e.Peer.freeRiderChoked = true
+			e.Peer.tickleWriter()
+		case FreeRiderBan:
+			torrent.Add("free-riders banned", 1)
+			e.Peer.ban()
+		}
+	}
+	cl.unlock()
+	for _, e := range events {
+		for _, f := range cl.config.Callbacks.FreeRider {
+			f(e)
+		}
+	}
+}
@@ -24,6 +24,10 @@ const (
 const (
 	metadataExtendedId = iota + 1 // 0 is reserved for deleting keys
 	pexExtendedId
+	haveBatchExtendedId
+	payloadCryptExtendedId
+	pieceCompressionExtendedId
+	uploadReceiptExtendedId
 )
 
 func defaultPeerExtensionBytes() PeerExtensionBits {
@@ -54,6 +58,8 @@ var (
 	requestedChunkLengths            = expvar.NewMap("requestedChunkLengths")
 
 	messageTypesReceived = expvar.NewMap("messageTypesReceived")
+	// Peers disconnected by ClientConfig.StrictProtocol, by reason.
+	protocolViolations = expvar.NewMap("protocolViolations")
 
 	// Track the effectiveness of Torrent.connPieceInclinationPool.
 	pieceInclinationsReused = expvar.NewInt("pieceInclinationsReused")
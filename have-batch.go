@@ -0,0 +1,171 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/anacrolix/missinggo/v2/bitmap"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/version"
+)
+
+// Bounds allocation for compressed bitfields received before we have the info. This is far more
+// pieces than any torrent is likely to have.
+const maxCompressedBitfieldPieces = 1 << 24
+
+// How long a deferred bitfield waits for the peer's extended handshake before it's sent as Haves
+// anyway.
+const deferredBitfieldTimeout = 10 * time.Second
+
+// Whether Have messages to the peer are batched using the ReliableBT have batch extension.
+func (cn *PeerConn) supportsHaveBatch() bool {
+	return !cn.t.cl.config.DisableHaveBatching && cn.PeerExtensionIDs[pp.ExtensionNameHaveBatch] != 0
+}
+
+// The initial bitfield is held back from peers that identify as ReliableBT, so it can be sent
+// compressed once their extended handshake confirms they support it, or as Haves if it doesn't
+// arrive within deferredBitfieldTimeout. Bitfields that are trivially small (have all or have
+// none) are sent as usual.
+func (cn *PeerConn) deferBitfieldForHaveBatch() bool {
+	cl := cn.t.cl
+	if cl.config.DisableHaveBatching {
+		return false
+	}
+	if !cn.PeerExtensionBytes.SupportsExtended() || !cl.config.Extensions.SupportsExtended() {
+		return false
+	}
+	if !bytes.HasPrefix(cn.PeerID[:], []byte(version.ReliableBTBep20Prefix[:3])) {
+		return false
+	}
+	return cn.t.haveAnyPieces() && !cn.t.haveAllPieces()
+}
+
+// Sends the bitfield held back by deferBitfieldForHaveBatch, now that we know whether the peer
+// supports the extension.
+func (cn *PeerConn) sendDeferredBitfield() {
+	if !cn.bitfieldDeferred {
+		return
+	}
+	cn.bitfieldDeferred = false
+	cn.stopDeferredBitfieldTimer()
+	if !cn.supportsHaveBatch() {
+		// It's too late for a Bitfield message.
+		cn.t._completedPieces.Iterate(func(x uint32) bool {
+			cn.have(pieceIndex(x))
+			return true
+		})
+		return
+	}
+	cn.write(pp.Message{
+		Type:       pp.Extended,
+		ExtendedID: cn.PeerExtensionIDs[pp.ExtensionNameHaveBatch],
+		ExtendedPayload: pp.HaveBatchMsg{
+			Type: pp.HaveBatchBitfieldMsgType,
+			Runs: bitmapRuns(&cn.t._completedPieces),
+		}.MustMarshalBinary(),
+	})
+	cn.sentHaves = bitmap.Bitmap{RB: cn.t._completedPieces.Clone()}
+	torrent.Add("compressed bitfields sent", 1)
+}
+
+// Sends the deferred bitfield if the peer hasn't sent its extended handshake in time, so peers
+// that never do still learn what we have.
+func (cn *PeerConn) deferredBitfieldTimedOut() {
+	cl := cn.t.cl
+	cl.lock()
+	defer cl.unlock()
+	if cn.closed.IsSet() || !cn.bitfieldDeferred {
+		return
+	}
+	torrent.Add("deferred bitfield timeouts", 1)
+	cn.sendDeferredBitfield()
+}
+
+func (cn *PeerConn) stopDeferredBitfieldTimer() {
+	if cn.deferredBitfieldTimer != nil {
+		cn.deferredBitfieldTimer.Stop()
+		cn.deferredBitfieldTimer = nil
+	}
+}
+
+// Sends Haves queued while batching. Returns false if the write buffer is full.
+func (cn *PeerConn) flushPendingHaves() bool {
+	if cn.pendingHaves.IsEmpty() {
+		return true
+	}
+	var haves []uint32
+	cn.pendingHaves.IterTyped(func(piece int) bool {
+		haves = append(haves, uint32(piece))
+		return true
+	})
+	cn.sentHaves.Union(cn.pendingHaves)
+	cn.pendingHaves.Clear()
+	torrent.Add("batched haves sent", int64(len(haves)))
+	return cn.write(pp.Message{
+		Type:       pp.Extended,
+		ExtendedID: cn.PeerExtensionIDs[pp.ExtensionNameHaveBatch],
+		ExtendedPayload: pp.HaveBatchMsg{
+			Type:  pp.HaveBatchHavesMsgType,
+			Haves: haves,
+		}.MustMarshalBinary(),
+	})
+}
+
+func (cn *PeerConn) onHaveBatchMsg(payload []byte) error {
+	var msg pp.HaveBatchMsg
+	if err := msg.UnmarshalBinary(payload); err != nil {
+		return fmt.Errorf("unmarshalling have batch message: %w", err)
+	}
+	switch msg.Type {
+	case pp.HaveBatchHavesMsgType:
+		for _, piece := range msg.Haves {
+			if err := cn.peerSentHave(pieceIndex(piece)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case pp.HaveBatchBitfieldMsgType:
+		var total uint64
+		for _, r := range msg.Runs {
+			total += uint64(r)
+		}
+		if cn.t.haveInfo() && total > uint64(cn.t.numPieces()) {
+			return fmt.Errorf("compressed bitfield has %v pieces, expected at most %v", total, cn.t.numPieces())
+		}
+		if total > maxCompressedBitfieldPieces {
+			return fmt.Errorf("compressed bitfield has too many pieces: %v", total)
+		}
+		bf := make([]bool, (total+7)/8*8)
+		var pos uint64
+		for i, r := range msg.Runs {
+			if i%2 == 1 {
+				for j := pos; j < pos+uint64(r); j++ {
+					bf[j] = true
+				}
+			}
+			pos += uint64(r)
+		}
+		return cn.peerSentBitfield(bf)
+	default:
+		return fmt.Errorf("unknown have batch message type %v", msg.Type)
+	}
+}
+
+// Returns alternating lengths of runs of unset and set bits, starting with unset.
+func bitmapRuns(bm *roaring.Bitmap) (runs []uint32) {
+	var pos uint32
+	it := bm.Iterator()
+	for it.HasNext() {
+		x := it.Next()
+		if len(runs) != 0 && x == pos {
+			runs[len(runs)-1]++
+		} else {
+			runs = append(runs, x-pos, 1)
+		}
+		pos = x + 1
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package torrent
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SHA-256 checksums of a torrent's files from an external source, keyed by File.DisplayPath. This
+// provides provenance beyond the piece hashes, which only establish that the data matches the
+// metainfo.
+type Manifest map[string][sha256.Size]byte
+
+// Parses a manifest in the format output by sha256sum. Paths are relative to the torrent's root
+// directory, with a leading "./" ignored.
+func ParseManifest(r io.Reader) (Manifest, error) {
+	ret := make(Manifest)
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, path, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %v: missing path", lineNum)
+		}
+		// Binary mode paths are prefixed by '*', and text mode paths by another space.
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "*"), " ")
+		path = strings.TrimPrefix(path, "./")
+		var h [sha256.Size]byte
+		if n, err := hex.Decode(h[:], []byte(sum)); err != nil || n != len(h) {
+			return nil, fmt.Errorf("line %v: bad sha256 %q", lineNum, sum)
+		}
+		ret[path] = h
+	}
+	return ret, s.Err()
+}
+
+var (
+	ErrFileNotInManifest = errors.New("file not in manifest")
+	ErrFileNotInTorrent  = errors.New("file not in torrent")
+	ErrFileIncomplete    = errors.New("file incomplete")
+)
+
+// The result of verifying a file against a Manifest.
+type ManifestResult struct {
+	Path string
+	// Nil if the path isn't in the torrent.
+	File     *File
+	Expected [sha256.Size]byte
+	Actual   [sha256.Size]byte
+	// Set if the file couldn't be hashed or isn't in both the torrent and the manifest.
+	Err error
+}
+
+func (me ManifestResult) Ok() bool {
+	return me.Err == nil && me.Expected == me.Actual
+}
+
+// Hashes each complete file in the torrent and compares it with the manifest. There's a result for
+// every file in the torrent, followed by any paths in the manifest that aren't in the torrent in
+// sorted order.
+// Incomplete files aren't read, and have ErrFileIncomplete.
+func (t *Torrent) VerifyManifest(ctx context.Context, m Manifest) (ret []ManifestResult, err error) {
+	if t.Info() == nil {
+		return nil, errors.New("torrent has no info")
+	}
+	seen := make(map[string]bool, len(m))
+	for _, f := range t.Files() {
+		res := ManifestResult{
+			Path: f.DisplayPath(),
+			File: f,
+		}
+		var ok bool
+		res.Expected, ok = m[res.Path]
+		seen[res.Path] = true
+		if !ok {
+			res.Err = ErrFileNotInManifest
+		} else {
+			res.Actual, res.Err = t.hashFile(ctx, f)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+		ret = append(ret, res)
+	}
+	var missing []string
+	for path := range m {
+		if !seen[path] {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	for _, path := range missing {
+		ret = append(ret, ManifestResult{
+			Path:     path,
+			Expected: m[path],
+			Err:      ErrFileNotInTorrent,
+		})
+	}
+	return
+}
+
+func (t *Torrent) hashFile(ctx context.Context, f *File) (sum [sha256.Size]byte, err error) {
+	t.cl.rLock()
+	complete := t.fileComplete(f)
+	t.cl.rUnlock()
+	if !complete {
+		err = ErrFileIncomplete
+		return
+	}
+	h := sha256.New()
+	b := make([]byte, 1<<16)
+	for off := f.Offset(); off < f.Offset()+f.Length(); {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		chunk := b[:minInt(len(b), int(f.Offset()+f.Length()-off))]
+		var n int
+		n, err = t.readAt(chunk, off)
+		h.Write(chunk[:n])
+		off += int64(n)
+		if n != len(chunk) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			err = fmt.Errorf("reading %q: %w", f.DisplayPath(), err)
+			return
+		}
+		err = nil
+	}
+	h.Sum(sum[:0])
+	return
+}
@@ -112,3 +112,14 @@ func contains(haystack []string, needle string) bool {
 	}
 	return false
 }
+
+func TestParseMutableMagnetUri(t *testing.T) {
+	const uri = "magnet:?xs=urn:btpk:8543d3e6115f0f98c944077a4493dcd543e49c739fd998550a1f614ab36ed63e&s=6e"
+	m, err := ParseMutableMagnetUri(uri)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x85, 0x43}, m.PublicKey[:2])
+	assert.Equal(t, []byte("n"), m.Salt)
+	assert.Equal(t, uri, m.String())
+	_, err = ParseMutableMagnetUri("magnet:?xs=urn:btpk:8543")
+	assert.Error(t, err)
+}
@@ -22,6 +22,8 @@ type MetaInfo struct {
 	CreatedBy    string  `bencode:"created by,omitempty"`
 	Encoding     string  `bencode:"encoding,omitempty"`
 	UrlList      UrlList `bencode:"url-list,omitempty"` // BEP 19 WebSeeds
+	// Keyed by the common name of the signer's certificate.
+	Signatures map[string]Signature `bencode:"signatures,omitempty"` // BEP 35
 }
 
 // Load a MetaInfo from an io.Reader. Returns a non-nil error in case of
@@ -0,0 +1,72 @@
+package metainfo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A BEP 46 magnet link, which refers to a BEP 44 mutable DHT item that contains the current
+// infohash of a torrent, rather than the infohash itself.
+type MutableMagnet struct {
+	PublicKey   [32]byte
+	Salt        []byte
+	Trackers    []string
+	DisplayName string
+}
+
+const xsPublicKeyPrefix = "urn:btpk:"
+
+func (m MutableMagnet) String() string {
+	vs := make(url.Values)
+	if len(m.Salt) != 0 {
+		vs.Set("s", hex.EncodeToString(m.Salt))
+	}
+	for _, tr := range m.Trackers {
+		vs.Add("tr", tr)
+	}
+	if m.DisplayName != "" {
+		vs.Set("dn", m.DisplayName)
+	}
+	u := url.URL{
+		Scheme:   "magnet",
+		RawQuery: "xs=" + xsPublicKeyPrefix + hex.EncodeToString(m.PublicKey[:]),
+	}
+	if len(vs) != 0 {
+		u.RawQuery += "&" + vs.Encode()
+	}
+	return u.String()
+}
+
+func ParseMutableMagnetUri(uri string) (m MutableMagnet, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		err = fmt.Errorf("error parsing uri: %w", err)
+		return
+	}
+	if u.Scheme != "magnet" {
+		err = fmt.Errorf("unexpected scheme %q", u.Scheme)
+		return
+	}
+	q := u.Query()
+	xs := q.Get("xs")
+	if !strings.HasPrefix(xs, xsPublicKeyPrefix) {
+		err = fmt.Errorf("unsupported xs %q", xs)
+		return
+	}
+	pk, err := hex.DecodeString(xs[len(xsPublicKeyPrefix):])
+	if err != nil || len(pk) != len(m.PublicKey) {
+		err = fmt.Errorf("bad public key in xs %q", xs)
+		return
+	}
+	copy(m.PublicKey[:], pk)
+	m.Salt, err = hex.DecodeString(q.Get("s"))
+	if err != nil {
+		err = fmt.Errorf("bad salt: %w", err)
+		return
+	}
+	m.Trackers = q["tr"]
+	m.DisplayName = q.Get("dn")
+	return
+}
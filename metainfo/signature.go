@@ -0,0 +1,78 @@
+package metainfo
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// A BEP 35 signature of the info dictionary.
+type Signature struct {
+	// Optional DER encoded X.509 certificate of the signer.
+	Certificate []byte `bencode:"certificate,omitempty"`
+	// Optional dictionary of additional data covered by the signature.
+	Info      bencode.Bytes `bencode:"info,omitempty"`
+	Signature []byte        `bencode:"signature"`
+}
+
+var ErrNotSigned = errors.New("not signed")
+
+// The digest that's signed: the SHA-1 of the bencoded info dictionary followed by the signature's
+// own info dictionary, if any.
+func signatureDigest(infoBytes []byte, sigInfo []byte) []byte {
+	h := sha1.New()
+	h.Write(infoBytes)
+	h.Write(sigInfo)
+	return h.Sum(nil)
+}
+
+// Signs the info dictionary, adding a signature keyed by the certificate's common name. Only RSA
+// keys are supported, per BEP 35. The certificate is included in the signature if embedCert is
+// set, so that consumers can check it against a CA rather than needing it in advance.
+func (mi *MetaInfo) Sign(cert *x509.Certificate, key *rsa.PrivateKey, embedCert bool) error {
+	if len(mi.InfoBytes) == 0 {
+		return errors.New("no info to sign")
+	}
+	if cert.Subject.CommonName == "" {
+		return errors.New("certificate has no common name")
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, signatureDigest(mi.InfoBytes, nil))
+	if err != nil {
+		return err
+	}
+	if mi.Signatures == nil {
+		mi.Signatures = make(map[string]Signature)
+	}
+	s := Signature{Signature: sig}
+	if embedCert {
+		s.Certificate = cert.Raw
+	}
+	mi.Signatures[cert.Subject.CommonName] = s
+	return nil
+}
+
+// Checks that the info dictionary is signed by the certificate's key. Returns ErrNotSigned if
+// there's no signature for the certificate's common name.
+func (mi *MetaInfo) VerifySignature(cert *x509.Certificate) error {
+	return VerifyInfoSignature(mi.InfoBytes, mi.Signatures, cert)
+}
+
+// Like MetaInfo.VerifySignature, for when the info and signatures have been separated from the
+// MetaInfo.
+func VerifyInfoSignature(infoBytes []byte, sigs map[string]Signature, cert *x509.Certificate) error {
+	s, ok := sigs[cert.Subject.CommonName]
+	if !ok {
+		return ErrNotSigned
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA1, signatureDigest(infoBytes, s.Info), s.Signature)
+}
@@ -0,0 +1,47 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func newTestCertificate(c *qt.C, commonName string) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, qt.IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	c.Assert(err, qt.IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, qt.IsNil)
+	return cert, key
+}
+
+func TestSignAndVerify(t *testing.T) {
+	c := qt.New(t)
+	cert, key := newTestCertificate(c, "publisher")
+	other, _ := newTestCertificate(c, "other")
+	mi := MetaInfo{InfoBytes: []byte("d4:name1:ae")}
+	c.Assert(mi.Sign(cert, key, true), qt.IsNil)
+	var buf bytes.Buffer
+	c.Assert(mi.Write(&buf), qt.IsNil)
+	loaded, err := Load(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Check(loaded.Signatures["publisher"].Certificate, qt.DeepEquals, cert.Raw)
+	c.Check(loaded.VerifySignature(cert), qt.IsNil)
+	c.Check(loaded.VerifySignature(other), qt.ErrorIs, ErrNotSigned)
+	loaded.InfoBytes = []byte("d4:name1:be")
+	c.Check(loaded.VerifySignature(cert), qt.IsNotNil)
+}
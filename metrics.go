@@ -0,0 +1,40 @@
+package torrent
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	metricsHandlerMu  sync.Mutex
+	newMetricsHandler func(*Client) http.Handler
+)
+
+// Sets the constructor for the handlers returned by Client.MetricsHandler. This is called by the
+// metrics package when it's imported, which keeps its dependencies optional.
+func RegisterMetricsHandler(f func(*Client) http.Handler) {
+	metricsHandlerMu.Lock()
+	defer metricsHandlerMu.Unlock()
+	newMetricsHandler = f
+}
+
+// Returns a handler exposing the Client's metrics in the Prometheus format. This requires
+// importing github.com/anacrolix/torrent/metrics, otherwise the handler responds with an error.
+func (cl *Client) MetricsHandler() http.Handler {
+	metricsHandlerMu.Lock()
+	f := newMetricsHandler
+	metricsHandlerMu.Unlock()
+	if f == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "metrics package not imported", http.StatusNotImplemented)
+		})
+	}
+	return f(cl)
+}
+
+// Returns the counts of announces by all the Client's Torrents, including those since dropped.
+func (cl *Client) AnnounceStats() AnnounceStats {
+	cl.rLock()
+	defer cl.rUnlock()
+	return cl.announces
+}
@@ -0,0 +1,124 @@
+// Package metrics exports a torrent.Client's stats as Prometheus metrics. Importing it enables
+// torrent.Client.MetricsHandler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/anacrolix/torrent"
+)
+
+func init() {
+	torrent.RegisterMetricsHandler(Handler)
+}
+
+const namespace = "torrent"
+
+var (
+	readBytesDesc = prometheus.NewDesc(
+		namespace+"_read_bytes_total",
+		"Bytes read from peers. Type wire includes protocol overhead, and data is only torrent content.",
+		[]string{"type"}, nil)
+	writtenBytesDesc = prometheus.NewDesc(
+		namespace+"_written_bytes_total",
+		"Bytes written to peers. Type wire includes protocol overhead, and data is only torrent content.",
+		[]string{"type"}, nil)
+	pieceHashFailuresDesc = prometheus.NewDesc(
+		namespace+"_piece_hash_failures_total",
+		"Pieces downloaded from peers that failed verification.",
+		nil, nil)
+	announcesDesc = prometheus.NewDesc(
+		namespace+"_announces_total",
+		"Announces to HTTP and UDP trackers, by result.",
+		[]string{"result"}, nil)
+	peerConnsDesc = prometheus.NewDesc(
+		namespace+"_peer_connections",
+		"Established peer connections across all torrents, by what the MSE handshake negotiated.",
+		[]string{"encryption"}, nil)
+	protocolViolationsDesc = prometheus.NewDesc(
+		namespace+"_protocol_violations_total",
+		"Peers disconnected by strict protocol validation, by reason.",
+		[]string{"reason"}, nil)
+	activePeersDesc = prometheus.NewDesc(
+		namespace+"_active_peers",
+		"Established peer connections for each torrent.",
+		[]string{"infohash"}, nil)
+	completionDesc = prometheus.NewDesc(
+		namespace+"_completion_ratio",
+		"Fraction of each torrent's data that's complete, once its info is known.",
+		[]string{"infohash"}, nil)
+)
+
+// A prometheus.Collector for a Client's stats and those of its Torrents. Torrent metrics are
+// labelled by infohash, and go away when the Torrent is dropped.
+type Collector struct {
+	cl *torrent.Client
+}
+
+func NewCollector(cl *torrent.Client) *Collector {
+	return &Collector{cl}
+}
+
+func (me *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- readBytesDesc
+	ch <- writtenBytesDesc
+	ch <- pieceHashFailuresDesc
+	ch <- announcesDesc
+	ch <- peerConnsDesc
+	ch <- protocolViolationsDesc
+	ch <- activePeersDesc
+	ch <- completionDesc
+}
+
+func (me *Collector) Collect(ch chan<- prometheus.Metric) {
+	counter := func(desc *prometheus.Desc, value int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+	stats := me.cl.ConnStats()
+	counter(readBytesDesc, stats.BytesRead.Int64(), "wire")
+	counter(readBytesDesc, stats.BytesReadData.Int64(), "data")
+	counter(writtenBytesDesc, stats.BytesWritten.Int64(), "wire")
+	counter(writtenBytesDesc, stats.BytesWrittenData.Int64(), "data")
+	counter(pieceHashFailuresDesc, stats.PiecesDirtiedBad.Int64())
+	announces := me.cl.AnnounceStats()
+	counter(announcesDesc, announces.AnnouncesSucceeded, "success")
+	counter(announcesDesc, announces.AnnouncesFailed, "failure")
+	encryption := me.cl.ConnEncryptionCounts()
+	for _, e := range []struct {
+		value int
+		label torrent.ConnEncryption
+	}{
+		{encryption.Plaintext, torrent.ConnPlaintext},
+		{encryption.HeaderObfuscated, torrent.ConnHeaderObfuscated},
+		{encryption.Encrypted, torrent.ConnEncrypted},
+	} {
+		ch <- prometheus.MustNewConstMetric(peerConnsDesc, prometheus.GaugeValue, float64(e.value), e.label.String())
+	}
+	for reason, count := range me.cl.ProtocolViolations() {
+		counter(protocolViolationsDesc, count, reason.String())
+	}
+	for _, t := range me.cl.Torrents() {
+		ih := t.InfoHash().HexString()
+		ts := t.Stats()
+		ch <- prometheus.MustNewConstMetric(activePeersDesc, prometheus.GaugeValue, float64(ts.ActivePeers), ih)
+		if t.Info() == nil {
+			continue
+		}
+		completion := 1.0
+		if length := t.Length(); length != 0 {
+			completion = float64(t.BytesCompleted()) / float64(length)
+		}
+		ch <- prometheus.MustNewConstMetric(completionDesc, prometheus.GaugeValue, completion, ih)
+	}
+}
+
+// Returns a handler serving the Client's metrics from a registry of their own, so that several
+// Clients can be exported from one process.
+func Handler(cl *torrent.Client) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(cl))
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
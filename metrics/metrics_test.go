@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestClientMetricsHandler(t *testing.T) {
+	c := qt.New(t)
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cfg := torrent.TestingConfig(t)
+	cfg.DataDir = dir
+	cl, err := torrent.NewClient(cfg)
+	c.Assert(err, qt.IsNil)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	c.Assert(err, qt.IsNil)
+	tt.VerifyData()
+	_, _, err = cl.AddTorrentSpec(&torrent.TorrentSpec{InfoHash: metainfo.Hash{1}})
+	c.Assert(err, qt.IsNil)
+	w := httptest.NewRecorder()
+	cl.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	c.Assert(w.Code, qt.Equals, 200)
+	body, _ := io.ReadAll(w.Body)
+	for _, line := range []string{
+		`torrent_read_bytes_total{type="wire"} 0`,
+		`torrent_written_bytes_total{type="data"} 0`,
+		`torrent_piece_hash_failures_total 0`,
+		`torrent_announces_total{result="failure"} 0`,
+		`torrent_peer_connections{encryption="obfuscated"} 0`,
+		`torrent_active_peers{infohash="` + tt.InfoHash().HexString() + `"} 0`,
+		`torrent_active_peers{infohash="` + metainfo.Hash{1}.HexString() + `"} 0`,
+		`torrent_completion_ratio{infohash="` + tt.InfoHash().HexString() + `"} 1`,
+	} {
+		c.Check(string(body), qt.Contains, line+"\n")
+	}
+	// Torrents without info have no completion.
+	c.Check(string(body), qt.Not(qt.Contains), `torrent_completion_ratio{infohash="`+metainfo.Hash{1}.HexString())
+}
@@ -18,6 +18,8 @@ type (
 	Request       = types.Request
 	ChunkSpec     = types.ChunkSpec
 	piecePriority = types.PiecePriority
+	// See Torrent.SetPriority.
+	TorrentPriority = types.TorrentPriority
 )
 
 const (
@@ -27,6 +29,10 @@ const (
 	PiecePriorityReadahead = types.PiecePriorityReadahead
 	PiecePriorityNext      = types.PiecePriorityNext
 	PiecePriorityHigh      = types.PiecePriorityHigh
+
+	TorrentPriorityLow    = types.TorrentPriorityLow
+	TorrentPriorityNormal = types.TorrentPriorityNormal
+	TorrentPriorityHigh   = types.TorrentPriorityHigh
 )
 
 func newRequest(index, begin, length pp.Integer) Request {
@@ -0,0 +1,241 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const defaultMutableTorrentPollInterval = 10 * time.Minute
+
+// A torrent followed through a BEP 46 mutable magnet link. When the publisher updates the DHT item
+// to a new infohash, the new torrent is added, pieces unchanged from the old version are reused,
+// and the old torrent is dropped.
+type MutableTorrent struct {
+	cl     *Client
+	magnet metainfo.MutableMagnet
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	t           *Torrent
+	seq         int64
+	downloadAll bool
+}
+
+type MutableTorrentUpdateEvent struct {
+	Mutable *MutableTorrent
+	// The dropped Torrent for the previous version.
+	Old *Torrent
+	New *Torrent
+	Seq int64
+}
+
+// The BEP 46 mutable item value.
+type mutableTorrentItem struct {
+	InfoHash []byte `bencode:"ih"`
+}
+
+// Resolves a BEP 46 magnet link using the Client's DHT servers, adds the current version of the
+// torrent, and then polls the DHT for updates. The DHT servers must implement
+// DhtMutableItemGetter.
+func (cl *Client) AddMutableMagnet(ctx context.Context, uri string) (*MutableTorrent, error) {
+	m, err := metainfo.ParseMutableMagnetUri(uri)
+	if err != nil {
+		return nil, err
+	}
+	ih, seq, err := cl.getMutableTorrentItem(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("resolving mutable magnet: %w", err)
+	}
+	t, _, err := cl.AddTorrentSpec(mutableTorrentSpec(m, ih))
+	if err != nil {
+		return nil, err
+	}
+	pollCtx, cancel := context.WithCancel(context.Background())
+	mt := &MutableTorrent{
+		cl:     cl,
+		magnet: m,
+		cancel: cancel,
+		t:      t,
+		seq:    seq,
+	}
+	go mt.pollLoop(pollCtx)
+	return mt, nil
+}
+
+func (m *MutableTorrent) Torrent() *Torrent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.t
+}
+
+// The sequence number of the DHT item for the current version.
+func (m *MutableTorrent) Seq() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.seq
+}
+
+// Downloads all data for the current version and all future versions.
+func (m *MutableTorrent) DownloadAll() {
+	m.mu.Lock()
+	m.downloadAll = true
+	t := m.t
+	m.mu.Unlock()
+	t.DownloadAll()
+}
+
+// Stops following updates. The current Torrent is left in the Client.
+func (m *MutableTorrent) Close() {
+	m.cancel()
+}
+
+func (m *MutableTorrent) pollLoop(ctx context.Context) {
+	interval := m.cl.config.MutableTorrentPollInterval
+	if interval == 0 {
+		interval = defaultMutableTorrentPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.cl.closed.Done():
+			return
+		case <-ticker.C:
+		}
+		m.poll(ctx, interval)
+	}
+}
+
+func (m *MutableTorrent) poll(ctx context.Context, timeout time.Duration) {
+	getCtx, cancel := context.WithTimeout(ctx, timeout)
+	ih, seq, err := m.cl.getMutableTorrentItem(getCtx, m.magnet)
+	cancel()
+	if err != nil {
+		m.cl.logger.Levelf(log.Debug, "polling mutable torrent %x: %v", m.magnet.PublicKey, err)
+		return
+	}
+	m.mu.Lock()
+	old := m.t
+	stale := seq <= m.seq || ih == old.InfoHash()
+	m.mu.Unlock()
+	if stale {
+		return
+	}
+	if err := m.migrate(ctx, old, ih, seq); err != nil {
+		m.cl.logger.Levelf(log.Warning, "migrating mutable torrent to %v: %v", ih, err)
+	}
+}
+
+// Adds the new version, waits for its info, and reuses pieces from the old version's data before
+// dropping it. Pieces are only reused with the default storage, as DeltaSync reads the old data
+// from where file storage puts it under ClientConfig.DataDir.
+func (m *MutableTorrent) migrate(ctx context.Context, old *Torrent, ih metainfo.Hash, seq int64) error {
+	cl := m.cl
+	t, new, err := cl.AddTorrentSpec(mutableTorrentSpec(m.magnet, ih))
+	if err != nil {
+		return err
+	}
+	// The new version is dropped if it doesn't get as far as replacing the old one, unless it was
+	// already added by someone else.
+	abandon := func() {
+		if new {
+			t.Drop()
+		}
+	}
+	select {
+	case <-t.GotInfo():
+	case <-ctx.Done():
+		abandon()
+		return ctx.Err()
+	case <-t.Closed():
+		abandon()
+		return errors.New("torrent closed")
+	}
+	if oldInfo := old.Info(); oldInfo == nil {
+	} else if cl.config.DefaultStorage != nil {
+		// Only the default file storage is known to lay out data by path under DataDir.
+		cl.logger.Levelf(log.Warning, "not reusing pieces from previous version with custom storage")
+	} else {
+		stats, err := t.DeltaSync(ctx, DeltaSyncOpts{
+			Dir:     cl.config.DataDir,
+			OldInfo: oldInfo,
+		})
+		if err != nil {
+			cl.logger.Levelf(log.Warning, "reusing pieces from previous version: %v", err)
+		}
+		torrent.Add("mutable torrent pieces reused", int64(stats.PiecesReused))
+	}
+	m.mu.Lock()
+	m.t = t
+	m.seq = seq
+	downloadAll := m.downloadAll
+	m.mu.Unlock()
+	old.Drop()
+	if downloadAll {
+		t.DownloadAll()
+	}
+	torrent.Add("mutable torrent updates", 1)
+	for _, f := range cl.config.Callbacks.MutableTorrentUpdated {
+		f(MutableTorrentUpdateEvent{
+			Mutable: m,
+			Old:     old,
+			New:     t,
+			Seq:     seq,
+		})
+	}
+	return nil
+}
+
+// Gets the latest value of the mutable item from all capable DHT servers.
+func (cl *Client) getMutableTorrentItem(ctx context.Context, m metainfo.MutableMagnet) (ih metainfo.Hash, seq int64, err error) {
+	cl.rLock()
+	dhtServers := append([]DhtServer(nil), cl.dhtServers...)
+	cl.rUnlock()
+	found := false
+	err = errors.New("no dht servers support mutable items")
+	for _, s := range dhtServers {
+		getter, ok := s.(DhtMutableItemGetter)
+		if !ok {
+			continue
+		}
+		value, itemSeq, getErr := getter.GetMutableItem(ctx, m.PublicKey, m.Salt)
+		if getErr != nil {
+			if !found {
+				err = getErr
+			}
+			continue
+		}
+		var item mutableTorrentItem
+		if getErr = bencode.Unmarshal(value, &item); getErr != nil || len(item.InfoHash) != len(ih) {
+			if !found {
+				err = fmt.Errorf("bad mutable torrent item %q", value)
+			}
+			continue
+		}
+		if !found || itemSeq > seq {
+			copy(ih[:], item.InfoHash)
+			seq = itemSeq
+		}
+		found = true
+		err = nil
+	}
+	return
+}
+
+func mutableTorrentSpec(m metainfo.MutableMagnet, ih metainfo.Hash) *TorrentSpec {
+	return &TorrentSpec{
+		InfoHash:    ih,
+		Trackers:    [][]string{m.Trackers},
+		DisplayName: m.DisplayName,
+	}
+}
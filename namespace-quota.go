@@ -0,0 +1,152 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// How often Namespace usage is updated and checked against NamespaceQuota.MaxDiskBytes and
+// NamespaceQuota.MaxMonthlyTransfer.
+const namespaceQuotaCheckInterval = 10 * time.Second
+
+// A Namespace's resource use, see Namespace.Usage.
+type NamespaceUsage struct {
+	Torrents int
+	// The total length of the Torrents that have info.
+	Bytes int64
+	// Data the Torrents have stored.
+	DiskBytes int64
+	// Piece data transferred in either direction this calendar month, in UTC. Includes Torrents
+	// that have since been dropped. Updated every namespaceQuotaCheckInterval.
+	MonthlyTransfer int64
+	// The Namespace's Torrents are paused for exceeding its quota.
+	Paused bool
+}
+
+// Raised when a Namespace goes over its disk or transfer quota and its Torrents are paused, and
+// when it's back under and they resume.
+type NamespaceQuotaEvent struct {
+	Namespace *Namespace
+	Usage     NamespaceUsage
+	Exceeded  bool
+}
+
+// Per-Namespace state for the disk and transfer quotas.
+type namespaceUsageState struct {
+	// The UTC month of the transfer count, see usageMonth.
+	month         int
+	monthTransfer int64
+	paused        bool
+}
+
+func usageMonth(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*12 + int(t.Month())
+}
+
+// Returns the Namespace's current usage.
+func (ns *Namespace) Usage() NamespaceUsage {
+	ns.cl.rLock()
+	defer ns.cl.rUnlock()
+	return ns.usageLocked()
+}
+
+func (ns *Namespace) usageLocked() NamespaceUsage {
+	ret := NamespaceUsage{
+		Torrents:        len(ns.torrents),
+		Bytes:           ns.bytesLocked(nil),
+		MonthlyTransfer: ns.usage.monthTransfer,
+		Paused:          ns.usage.paused,
+	}
+	for t := range ns.torrents {
+		ret.DiskBytes += t.bytesCompleted()
+	}
+	return ret
+}
+
+func (ns *Namespace) overQuota(u NamespaceUsage) bool {
+	q := ns.quota
+	return q.MaxDiskBytes != 0 && u.DiskBytes >= q.MaxDiskBytes ||
+		q.MaxMonthlyTransfer != 0 && u.MonthlyTransfer >= q.MaxMonthlyTransfer
+}
+
+// Piece data the Torrent has transferred.
+func (t *Torrent) namespaceTransferTotal() int64 {
+	return t.stats.BytesReadData.Int64() + t.stats.BytesWrittenData.Int64()
+}
+
+// Counts the Torrent's transfers since the last call against its Namespace.
+func (t *Torrent) sampleNamespaceTransfer() {
+	total := t.namespaceTransferTotal()
+	t.namespace.usage.monthTransfer += total - t.namespaceTransferSampled
+	t.namespaceTransferSampled = total
+}
+
+// Updates the monthly transfer count, and pauses or resumes the Namespace's Torrents. Returns an
+// event if that changed.
+func (ns *Namespace) checkQuotaUsageLocked(now time.Time) (e NamespaceQuotaEvent, changed bool) {
+	if month := usageMonth(now); month != ns.usage.month {
+		ns.usage.month = month
+		ns.usage.monthTransfer = 0
+	}
+	for t := range ns.torrents {
+		t.sampleNamespaceTransfer()
+	}
+	over := ns.overQuota(ns.usageLocked())
+	if over == ns.usage.paused {
+		return
+	}
+	ns.usage.paused = over
+	for t := range ns.torrents {
+		t.setPausedForQuota(over)
+	}
+	if over {
+		torrent.Add("namespace quota pauses", 1)
+		ns.cl.logger.Levelf(log.Info, "namespace %q over quota, pausing its torrents", ns.name)
+	} else {
+		ns.cl.logger.Levelf(log.Info, "namespace %q under quota, resuming its torrents", ns.name)
+	}
+	return NamespaceQuotaEvent{
+		Namespace: ns,
+		Usage:     ns.usageLocked(),
+		Exceeded:  over,
+	}, true
+}
+
+func (cl *Client) checkNamespaceQuotas(now time.Time) {
+	var events []NamespaceQuotaEvent
+	cl.lock()
+	for _, ns := range cl.namespaces {
+		if e, ok := ns.checkQuotaUsageLocked(now); ok {
+			events = append(events, e)
+		}
+	}
+	cl.unlock()
+	for _, e := range events {
+		for _, f := range cl.config.Callbacks.NamespaceQuota {
+			f(e)
+		}
+	}
+}
+
+func (cl *Client) namespaceQuotaLoop() {
+	ticker := time.NewTicker(namespaceQuotaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.checkNamespaceQuotas(now)
+		}
+	}
+}
+
+func (t *Torrent) setPausedForQuota(paused bool) {
+	if paused == t.pausedForQuota {
+		return
+	}
+	t.pausedForQuota = paused
+	t.transfersPausedChanged("namespace quota")
+}
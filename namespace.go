@@ -0,0 +1,259 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/time/rate"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var (
+	ErrNamespaceQuota          = errors.New("namespace quota exceeded")
+	ErrTorrentInOtherNamespace = errors.New("torrent belongs to another namespace")
+)
+
+// Needs to fit the largest chunk that'll be uploaded in one reservation.
+const namespaceRateBurst = torrentRateBurst
+
+// Limits for a Namespace. Zero values are unlimited.
+type NamespaceQuota struct {
+	MaxTorrents int
+	// The total length of the Namespace's Torrents. Torrents added without info are counted when
+	// their info arrives, and aren't downloaded if that exceeds the quota.
+	MaxBytes int64
+	// The Namespace's share of bandwidth in bytes per second, enforced in addition to the Client's
+	// rate limiters.
+	UploadRate   rate.Limit
+	DownloadRate rate.Limit
+	// When the data stored by the Namespace's Torrents, or the piece data they've transferred this
+	// month, reaches these, the Torrents are paused until it's back under. See
+	// Callbacks.NamespaceQuota.
+	MaxDiskBytes       int64
+	MaxMonthlyTransfer int64
+}
+
+// One user's Torrents in a Client shared between users, such as a seedbox. Each Namespace has its
+// own Torrent list, quota, and share of bandwidth. A Torrent belongs to at most one Namespace, and
+// Torrents added to the Client directly belong to none.
+type Namespace struct {
+	cl   *Client
+	name string
+	// Protected by the Client lock.
+	quota    NamespaceQuota
+	torrents map[*Torrent]struct{}
+	usage    namespaceUsageState
+	// Changed in place by SetQuota, so connections made before see the change.
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+}
+
+// Returns the Namespace with the given name, creating it if it doesn't exist.
+func (cl *Client) Namespace(name string) *Namespace {
+	cl.lock()
+	defer cl.unlock()
+	if ns, ok := cl.namespaces[name]; ok {
+		return ns
+	}
+	ns := &Namespace{
+		cl:              cl,
+		name:            name,
+		torrents:        make(map[*Torrent]struct{}),
+		usage:           namespaceUsageState{month: usageMonth(time.Now())},
+		uploadLimiter:   rate.NewLimiter(rate.Inf, namespaceRateBurst),
+		downloadLimiter: rate.NewLimiter(rate.Inf, namespaceRateBurst),
+	}
+	if cl.namespaces == nil {
+		cl.namespaces = make(map[string]*Namespace)
+		go cl.namespaceQuotaLoop()
+	}
+	cl.namespaces[name] = ns
+	return ns
+}
+
+// Returns the Client's Namespaces, ordered by name.
+func (cl *Client) Namespaces() (ret []*Namespace) {
+	cl.rLock()
+	defer cl.rUnlock()
+	for _, ns := range cl.namespaces {
+		ret = append(ret, ns)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return
+}
+
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Sets the Namespace's quota. Torrents already in the Namespace are kept if it's exceeded. Disk
+// and transfer quotas are applied at the next check.
+func (ns *Namespace) SetQuota(q NamespaceQuota) {
+	ns.cl.lock()
+	defer ns.cl.unlock()
+	ns.quota = q
+	for _, l := range []struct {
+		limiter *rate.Limiter
+		limit   rate.Limit
+	}{
+		{ns.uploadLimiter, q.UploadRate},
+		{ns.downloadLimiter, q.DownloadRate},
+	} {
+		if l.limit == 0 {
+			l.limit = rate.Inf
+		}
+		l.limiter.SetLimit(l.limit)
+	}
+}
+
+func (ns *Namespace) Quota() NamespaceQuota {
+	ns.cl.rLock()
+	defer ns.cl.rUnlock()
+	return ns.quota
+}
+
+// Returns the Namespace's Torrents.
+func (ns *Namespace) Torrents() (ret []*Torrent) {
+	ns.cl.rLock()
+	defer ns.cl.rUnlock()
+	for t := range ns.torrents {
+		ret = append(ret, t)
+	}
+	return
+}
+
+// The total length of the Namespace's Torrents that have info, other than exclude.
+func (ns *Namespace) bytesLocked(exclude *Torrent) (ret int64) {
+	for t := range ns.torrents {
+		if t != exclude && t.haveInfo() {
+			ret += t.info.TotalLength()
+		}
+	}
+	return
+}
+
+// Returns an error if adding the Torrent would exceed the quota.
+func (ns *Namespace) checkQuotaLocked(t *Torrent) error {
+	q := ns.quota
+	if q.MaxTorrents != 0 && len(ns.torrents) >= q.MaxTorrents {
+		return fmt.Errorf("%w: %v torrents", ErrNamespaceQuota, q.MaxTorrents)
+	}
+	if q.MaxBytes != 0 && t != nil && t.haveInfo() && ns.bytesLocked(t)+t.info.TotalLength() > q.MaxBytes {
+		return fmt.Errorf("%w: %v bytes", ErrNamespaceQuota, q.MaxBytes)
+	}
+	return nil
+}
+
+// Adds or merges a Torrent as for Client.AddTorrentSpec, within the Namespace. Torrents new to the
+// Client are dropped again if they'd exceed the quota. Returns ErrTorrentInOtherNamespace if the
+// Torrent is already in the Client outside the Namespace.
+func (ns *Namespace) AddTorrentSpec(spec *TorrentSpec) (t *Torrent, new bool, err error) {
+	ns.cl.lock()
+	if existing, ok := ns.cl.torrents[spec.InfoHash]; ok && existing.namespace != ns {
+		ns.cl.unlock()
+		err = ErrTorrentInOtherNamespace
+		return
+	}
+	if _, ok := ns.cl.torrents[spec.InfoHash]; !ok {
+		err = ns.checkQuotaLocked(nil)
+	}
+	ns.cl.unlock()
+	if err != nil {
+		return
+	}
+	t, new, err = ns.cl.AddTorrentSpec(spec)
+	if err != nil || !new {
+		return
+	}
+	ns.cl.lock()
+	// Another Namespace may have claimed it concurrently.
+	if t.namespace != nil {
+		ns.cl.unlock()
+		return nil, false, ErrTorrentInOtherNamespace
+	}
+	err = ns.checkQuotaLocked(t)
+	if err == nil {
+		ns.addTorrentLocked(t)
+	}
+	ns.cl.unlock()
+	if err != nil {
+		t.Drop()
+		t = nil
+	}
+	return
+}
+
+func (ns *Namespace) addTorrentLocked(t *Torrent) {
+	t.namespace = ns
+	t.namespaceDownloadLimiter.Store(ns.downloadLimiter)
+	ns.torrents[t] = struct{}{}
+	t.namespaceTransferSampled = t.namespaceTransferTotal()
+	t.setPausedForQuota(ns.usage.paused)
+}
+
+func (ns *Namespace) AddMagnet(uri string) (*Torrent, error) {
+	spec, err := TorrentSpecFromMagnetUri(uri)
+	if err != nil {
+		return nil, err
+	}
+	t, _, err := ns.AddTorrentSpec(spec)
+	return t, err
+}
+
+func (ns *Namespace) AddTorrent(mi *metainfo.MetaInfo) (*Torrent, error) {
+	spec, err := TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return nil, err
+	}
+	t, _, err := ns.AddTorrentSpec(spec)
+	return t, err
+}
+
+// Returns the Namespace the Torrent belongs to, or nil.
+func (t *Torrent) Namespace() *Namespace {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.namespace
+}
+
+// Limits reads by the download limiter of the Torrent's Namespace. The limiter is looked up for
+// each read, as connections can be made before the Torrent joins its Namespace.
+type namespaceLimitedReader struct {
+	t *Torrent
+	r io.Reader
+}
+
+func (me *namespaceLimitedReader) Read(b []byte) (int, error) {
+	l, _ := me.t.namespaceDownloadLimiter.Load().(*rate.Limiter)
+	if l == nil {
+		return me.r.Read(b)
+	}
+	lr := rateLimitedReader{l: l, r: me.r}
+	return lr.Read(b)
+}
+
+func (t *Torrent) leaveNamespace() {
+	if t.namespace != nil {
+		t.sampleNamespaceTransfer()
+		delete(t.namespace.torrents, t)
+	}
+}
+
+// Stops the Torrent downloading if its newly known length puts its Namespace over quota.
+func (t *Torrent) checkNamespaceBytesQuota() {
+	ns := t.namespace
+	if ns == nil || ns.quota.MaxBytes == 0 {
+		return
+	}
+	if ns.bytesLocked(nil) > ns.quota.MaxBytes {
+		t.logger.Levelf(log.Warning, "namespace %q over byte quota, disallowing download", ns.name)
+		t.disallowDataDownloadLocked()
+	}
+}
@@ -0,0 +1,152 @@
+package torrent
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/anacrolix/torrent/bencode"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+const (
+	payloadCryptNonceSize = 16
+	// The AES-GCM tag appended to encrypted piece payloads.
+	payloadCryptOverhead = 16
+)
+
+// Per-connection state for ReliableBT payload encryption. Piece payloads are sealed with AES-GCM,
+// using a key for each direction and a counter of Piece messages as the nonce. Messages are
+// delivered in order, so both sides agree on the counters.
+type payloadCryptState struct {
+	localNonce []byte
+	send       cipher.AEAD
+	recv       cipher.AEAD
+	sendSeq    uint64
+	recvSeq    uint64
+}
+
+// Sets a pre-shared key for the torrent. Piece data is then only exchanged with peers that have
+// the same key, and is encrypted even over connections without header obfuscation. This should be
+// set before any peers are added, see AddTorrentOpts.PreSharedKey. Webseeds aren't peers and are
+// exempt: data is still fetched from them as is, so torrents that must only be served to key
+// holders shouldn't have webseeds.
+func (t *Torrent) SetPreSharedKey(key []byte) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.preSharedKey = append([]byte(nil), key...)
+}
+
+// Whether the conn may exchange piece data. Torrents with a pre-shared key wait for the key exchange.
+func (cn *PeerConn) payloadCryptReady() bool {
+	return cn.t.preSharedKey == nil || cn.payloadCrypt.send != nil
+}
+
+// Starts the key exchange if both sides support it.
+func (cn *PeerConn) maybeSendPayloadCryptNonce() {
+	if cn.t.preSharedKey == nil || cn.payloadCrypt.localNonce != nil {
+		return
+	}
+	id := cn.PeerExtensionIDs[pp.ExtensionNamePayloadCrypt]
+	if id == 0 {
+		return
+	}
+	nonce := make([]byte, payloadCryptNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	cn.payloadCrypt.localNonce = nonce
+	cn.write(pp.Message{
+		Type:            pp.Extended,
+		ExtendedID:      id,
+		ExtendedPayload: bencode.MustMarshal(pp.PayloadCryptMsg{Nonce: nonce}),
+	})
+}
+
+func (cn *PeerConn) onPayloadCryptMsg(payload []byte) error {
+	var msg pp.PayloadCryptMsg
+	if err := bencode.Unmarshal(payload, &msg); err != nil {
+		return fmt.Errorf("unmarshalling payload crypt message: %w", err)
+	}
+	s := &cn.payloadCrypt
+	if s.localNonce == nil {
+		return errors.New("payload crypt nonce received before handshake")
+	}
+	if s.send != nil {
+		return errors.New("payload crypt nonce received twice")
+	}
+	if len(msg.Nonce) != payloadCryptNonceSize {
+		return fmt.Errorf("payload crypt nonce has length %v", len(msg.Nonce))
+	}
+	// The side that opened the connection is the initiator.
+	initiatorNonce, receiverNonce := s.localNonce, msg.Nonce
+	if !cn.outgoing {
+		initiatorNonce, receiverNonce = receiverNonce, initiatorNonce
+	}
+	newAead := func(label string) cipher.AEAD {
+		mac := hmac.New(sha256.New, cn.t.preSharedKey)
+		mac.Write([]byte(label))
+		mac.Write(cn.t.infoHash[:])
+		mac.Write(initiatorNonce)
+		mac.Write(receiverNonce)
+		block, err := aes.NewCipher(mac.Sum(nil))
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		return aead
+	}
+	initiatorKey, receiverKey := newAead("rbt_crypt initiator"), newAead("rbt_crypt receiver")
+	if cn.outgoing {
+		s.send, s.recv = initiatorKey, receiverKey
+	} else {
+		s.send, s.recv = receiverKey, initiatorKey
+	}
+	torrent.Add("payload crypt sessions established", 1)
+	cn.updateRequests("payload crypt established")
+	cn.tickleWriter()
+	return nil
+}
+
+func payloadCryptNonce(seq uint64) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[4:], seq)
+	return b[:]
+}
+
+// The piece index and begin are authenticated so that payloads can't be moved.
+func payloadCryptAdditionalData(index, begin pp.Integer) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(index))
+	binary.BigEndian.PutUint32(b[4:], uint32(begin))
+	return b[:]
+}
+
+func (s *payloadCryptState) seal(index, begin pp.Integer, data []byte) []byte {
+	ret := s.send.Seal(nil, payloadCryptNonce(s.sendSeq), data, payloadCryptAdditionalData(index, begin))
+	s.sendSeq++
+	return ret
+}
+
+// Decrypts the Piece message payload in place.
+func (s *payloadCryptState) open(msg *pp.Message) (err error) {
+	if s.recv == nil {
+		return errors.New("unencrypted piece received")
+	}
+	msg.Piece, err = s.recv.Open(
+		msg.Piece[:0], payloadCryptNonce(s.recvSeq), msg.Piece,
+		payloadCryptAdditionalData(msg.Index, msg.Begin))
+	if err != nil {
+		return fmt.Errorf("decrypting piece payload: %w", err)
+	}
+	s.recvSeq++
+	return nil
+}
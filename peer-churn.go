@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+type DisconnectReason int
+
+const (
+	// The connection was closed locally for other reasons, such as protocol errors or the Torrent
+	// being dropped.
+	DisconnectOther DisconnectReason = iota
+	// The remote peer closed or reset the connection.
+	DisconnectRemoteClose
+	// Nothing was received from the peer for too long.
+	DisconnectTimeout
+	// The peer's IP was banned.
+	DisconnectBanned
+	// The connection was dropped in favour of better connections, or for not being useful.
+	DisconnectEvicted
+	// The connection was evicted while the peer was choking us and we wanted data from it.
+	DisconnectChokedOut
+)
+
+func (me DisconnectReason) String() string {
+	switch me {
+	case DisconnectOther:
+		return "other"
+	case DisconnectRemoteClose:
+		return "remote close"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectBanned:
+		return "banned"
+	case DisconnectEvicted:
+		return "evicted"
+	case DisconnectChokedOut:
+		return "choked out"
+	default:
+		return "unknown"
+	}
+}
+
+// The upper bounds of the buckets in ConnLifetimeHistogram. The last bucket is unbounded.
+var ConnLifetimeBuckets = [...]time.Duration{10 * time.Second, time.Minute, 10 * time.Minute, time.Hour}
+
+type ConnLifetimeHistogram struct {
+	// Counts[i] is the number of connections that lasted less than ConnLifetimeBuckets[i]. The last
+	// element counts the rest.
+	Counts [len(ConnLifetimeBuckets) + 1]int
+	Sum    time.Duration
+}
+
+func (me *ConnLifetimeHistogram) add(lifetime time.Duration) {
+	i := 0
+	for i < len(ConnLifetimeBuckets) && lifetime >= ConnLifetimeBuckets[i] {
+		i++
+	}
+	me.Counts[i]++
+	me.Sum += lifetime
+}
+
+func (me ConnLifetimeHistogram) Count() (n int) {
+	for _, c := range me.Counts {
+		n += c
+	}
+	return
+}
+
+// Peer connection churn for a Torrent. Only connections that completed the handshake and were
+// added to the Torrent are counted.
+type PeerChurnStats struct {
+	Connects int
+	// Connections to remote clients that had been connected before.
+	Reconnects int
+	// Lifetimes of closed connections, by why they were closed.
+	Disconnects map[DisconnectReason]ConnLifetimeHistogram
+}
+
+type peerChurnState struct {
+	stats PeerChurnStats
+	seen  map[remotePeerKey]struct{}
+}
+
+func (t *Torrent) recordPeerConnAdded(c *PeerConn) {
+	s := &t.peerChurn
+	s.stats.Connects++
+	key, ok := c.remotePeerKey()
+	if !ok {
+		return
+	}
+	if _, ok := s.seen[key]; ok {
+		s.stats.Reconnects++
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[remotePeerKey]struct{})
+	}
+	s.seen[key] = struct{}{}
+}
+
+func (t *Torrent) recordPeerConnDeleted(c *PeerConn) {
+	s := &t.peerChurn
+	if s.stats.Disconnects == nil {
+		s.stats.Disconnects = make(map[DisconnectReason]ConnLifetimeHistogram)
+	}
+	h := s.stats.Disconnects[c.closeReason]
+	h.add(time.Since(c.completedHandshake))
+	s.stats.Disconnects[c.closeReason] = h
+}
+
+func (t *Torrent) PeerChurnStats() (ret PeerChurnStats) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	ret = t.peerChurn.stats
+	ret.Disconnects = make(map[DisconnectReason]ConnLifetimeHistogram, len(t.peerChurn.stats.Disconnects))
+	for k, v := range t.peerChurn.stats.Disconnects {
+		ret.Disconnects[k] = v
+	}
+	return
+}
+
+// Records why the Peer is being closed. Only the first reason is kept.
+func (p *Peer) setCloseReason(reason DisconnectReason) {
+	if !p.closed.IsSet() && p.closeReason == DisconnectOther {
+		p.closeReason = reason
+	}
+}
+
+// The reason for evicting the connection for worse ones. Connections that were kept choked while
+// we were interested are distinguished from those that were merely less useful.
+func (c *PeerConn) evictionReason() DisconnectReason {
+	if c.peerChoking && c.requestState.Interested {
+		return DisconnectChokedOut
+	}
+	return DisconnectEvicted
+}
+
+// Classifies the error that ended a connection's main read loop.
+func readLoopDisconnectReason(err error) DisconnectReason {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DisconnectTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return DisconnectRemoteClose
+	}
+	return DisconnectOther
+}
@@ -0,0 +1,33 @@
+package torrent
+
+import (
+	"errors"
+	"math"
+
+	"golang.org/x/time/rate"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Returned by a peer connection's read loop when the peer exceeds ClientConfig.PeerMessageRate.
+var ErrPeerMessageFlood = errors.New("peer exceeded message rate")
+
+// Returns nil if the peer's messages aren't limited.
+func (c *PeerConn) newMessageLimiter() *rate.Limiter {
+	cfg := c.t.cl.config
+	if cfg.PeerMessageRate == 0 || cfg.PeerMessageRate == rate.Inf || c.trusted {
+		return nil
+	}
+	burst := cfg.PeerMessageBurst
+	if burst == 0 {
+		// A second's worth.
+		burst = int(math.Ceil(float64(cfg.PeerMessageRate)))
+	}
+	return rate.NewLimiter(cfg.PeerMessageRate, burst)
+}
+
+// Piece data is limited by the download rate instead, and haves are as many as the torrent has
+// pieces.
+func countsTowardMessageRate(msg pp.Message) bool {
+	return msg.Keepalive || (msg.Type != pp.Piece && msg.Type != pp.Have)
+}
@@ -2,6 +2,7 @@ package torrent
 
 import (
 	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/generics"
 
 	"github.com/anacrolix/torrent/peer_protocol"
 )
@@ -18,6 +19,15 @@ type PeerInfo struct {
 	Trusted bool
 	// ReliableBT: whether this is a baseline provider, which maximizes its priority.
 	BaselineProvider bool
+	// ReliableBT: the peer's place in a tracker's ranking by upload rate, from 1 for the fastest,
+	// or 0 if it's unranked. Ranked peers are dialed first with
+	// ClientConfig.PreferFastPeersFromTracker.
+	TrackerSpeedRank int
+	// The URL of the tracker that gave TrackerSpeedRank.
+	trackerSpeedRanker string
+	// ReliableBT: the reliability score a tracker gave the peer. Scored peers are dialed in order of
+	// their scores with ClientConfig.PreferReliablePeersFromTracker.
+	TrackerReliability generics.Option[int]
 }
 
 func (me PeerInfo) equal(other PeerInfo) bool {
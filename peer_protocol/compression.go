@@ -0,0 +1,13 @@
+package peer_protocol
+
+// ReliableBT: an extension for LZ4 compression of piece payloads. When both peers advertise it,
+// every Piece payload between them is prefixed by a PieceCompressionFlag.
+const ExtensionNamePieceCompression ExtensionName = "rbt_lz4"
+
+type PieceCompressionFlag byte
+
+const (
+	PieceUncompressed PieceCompressionFlag = 0
+	// The payload is an LZ4 block.
+	PieceCompressedLz4 PieceCompressionFlag = 1
+)
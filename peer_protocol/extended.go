@@ -2,6 +2,8 @@ package peer_protocol
 
 import (
 	"net"
+
+	"github.com/anacrolix/torrent/bencode"
 )
 
 // http://www.bittorrent.org/beps/bep_0010.html
@@ -19,6 +21,9 @@ type (
 		YourIp CompactIp `bencode:"yourip,omitempty"`
 		Ipv4   CompactIp `bencode:"ipv4,omitempty"`
 		Ipv6   net.IP    `bencode:"ipv6,omitempty"`
+		// ReliableBT: arbitrary labels, such as experiment ids, regions and capacity classes. Values
+		// are strings, but are left encoded so a label of another type doesn't fail the handshake.
+		Metadata map[string]bencode.Bytes `bencode:"rbt_meta,omitempty"`
 	}
 
 	ExtensionName   string
@@ -0,0 +1,100 @@
+package peer_protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ReliableBT: an extension for exchanging piece availability more compactly than Have and Bitfield
+// messages, for torrents with very many pieces.
+const ExtensionNameHaveBatch ExtensionName = "rbt_have"
+
+type HaveBatchMsgType byte
+
+const (
+	// Pieces completed since the last message, replacing individual Have messages.
+	HaveBatchHavesMsgType HaveBatchMsgType = 0
+	// The complete set of pieces the sender has, replacing a Bitfield message.
+	HaveBatchBitfieldMsgType HaveBatchMsgType = 1
+)
+
+type HaveBatchMsg struct {
+	Type HaveBatchMsgType
+	// Piece indices in ascending order, for HaveBatchHavesMsgType. Encoded as varint deltas.
+	Haves []uint32
+	// Alternating lengths of runs of missing and present pieces, starting with missing, for
+	// HaveBatchBitfieldMsgType. Encoded as varints.
+	Runs []uint32
+}
+
+func (me HaveBatchMsg) MarshalBinary() ([]byte, error) {
+	b := []byte{byte(me.Type)}
+	var buf [binary.MaxVarintLen64]byte
+	appendUvarint := func(v uint32) {
+		n := binary.PutUvarint(buf[:], uint64(v))
+		b = append(b, buf[:n]...)
+	}
+	switch me.Type {
+	case HaveBatchHavesMsgType:
+		var last uint32
+		for i, h := range me.Haves {
+			if i != 0 && h <= last {
+				return nil, fmt.Errorf("haves not strictly ascending at index %v", i)
+			}
+			appendUvarint(h - last)
+			last = h
+		}
+	case HaveBatchBitfieldMsgType:
+		for _, r := range me.Runs {
+			appendUvarint(r)
+		}
+	default:
+		return nil, fmt.Errorf("unknown have batch message type %v", me.Type)
+	}
+	return b, nil
+}
+
+func (me HaveBatchMsg) MustMarshalBinary() []byte {
+	b, err := me.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (me *HaveBatchMsg) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("empty have batch message")
+	}
+	me.Type = HaveBatchMsgType(b[0])
+	me.Haves = nil
+	me.Runs = nil
+	var sum uint64
+	for b = b[1:]; len(b) != 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad varint")
+		}
+		b = b[n:]
+		sum += v
+		if sum > 1<<32 {
+			return errors.New("piece index overflow")
+		}
+		switch me.Type {
+		case HaveBatchHavesMsgType:
+			if len(me.Haves) != 0 && v == 0 {
+				return errors.New("duplicate have")
+			}
+			if sum == 1<<32 {
+				return errors.New("piece index overflow")
+			}
+			me.Haves = append(me.Haves, uint32(sum))
+		case HaveBatchBitfieldMsgType:
+			me.Runs = append(me.Runs, uint32(v))
+		default:
+			return fmt.Errorf("unknown have batch message type %v", me.Type)
+		}
+	}
+	return nil
+}
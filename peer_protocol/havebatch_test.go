@@ -0,0 +1,30 @@
+package peer_protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHaveBatchMsgRoundTrip(t *testing.T) {
+	for _, m := range []HaveBatchMsg{
+		{Type: HaveBatchHavesMsgType, Haves: []uint32{0, 1, 300, 100000}},
+		{Type: HaveBatchBitfieldMsgType, Runs: []uint32{0, 5, 200000, 1}},
+		{Type: HaveBatchHavesMsgType},
+	} {
+		b := m.MustMarshalBinary()
+		var out HaveBatchMsg
+		require.NoError(t, out.UnmarshalBinary(b))
+		require.Equal(t, m, out)
+	}
+}
+
+func TestHaveBatchMsgInvalid(t *testing.T) {
+	_, err := HaveBatchMsg{Type: HaveBatchHavesMsgType, Haves: []uint32{2, 2}}.MarshalBinary()
+	require.Error(t, err)
+	var m HaveBatchMsg
+	require.Error(t, m.UnmarshalBinary(nil))
+	require.Error(t, m.UnmarshalBinary([]byte{2, 1}))
+	require.Error(t, m.UnmarshalBinary([]byte{byte(HaveBatchHavesMsgType), 1, 0}))
+	require.Error(t, m.UnmarshalBinary([]byte{byte(HaveBatchHavesMsgType), 0x80}))
+}
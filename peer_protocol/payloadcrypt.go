@@ -0,0 +1,11 @@
+package peer_protocol
+
+// ReliableBT: an extension for encrypting piece payloads between peers that share a pre-shared key
+// for the torrent. It's only advertised for torrents that have a key.
+const ExtensionNamePayloadCrypt ExtensionName = "rbt_crypt"
+
+// Sent by each side after receiving the other's extended handshake. Session keys are derived from
+// the pre-shared key and both nonces, so neither side can choose them alone.
+type PayloadCryptMsg struct {
+	Nonce []byte `bencode:"nonce"`
+}
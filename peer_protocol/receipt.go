@@ -0,0 +1,44 @@
+package peer_protocol
+
+import (
+	"crypto/ed25519"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// ReliableBT: an experimental extension where downloaders send signed receipts for the piece data
+// they've received. Uploaders collect them and present them to trackers for reputation credit.
+const ExtensionNameUploadReceipt ExtensionName = "rbt_receipt"
+
+// A downloader's signed statement of the useful piece data it has received from an uploader.
+// Bytes is cumulative, so only the latest receipt between a pair of peers matters.
+type UploadReceipt struct {
+	InfoHash   [20]byte `bencode:"ih"`
+	Uploader   [20]byte `bencode:"up"`
+	Downloader [20]byte `bencode:"down"`
+	Bytes      int64    `bencode:"n"`
+	// Unix time in seconds when the receipt was signed.
+	Time int64 `bencode:"t"`
+	// The downloader's ed25519 public key.
+	PublicKey []byte `bencode:"k"`
+	Signature []byte `bencode:"sig,omitempty"`
+}
+
+// The bencoded receipt without its signature.
+func (me UploadReceipt) signedBytes() []byte {
+	me.Signature = nil
+	return bencode.MustMarshal(me)
+}
+
+// Sets the public key and signs the receipt.
+func (me *UploadReceipt) Sign(key ed25519.PrivateKey) {
+	me.PublicKey = key.Public().(ed25519.PublicKey)
+	me.Signature = ed25519.Sign(key, me.signedBytes())
+}
+
+// Whether the receipt was signed by the holder of its public key. Trackers should also check that
+// the key belongs to the downloader.
+func (me UploadReceipt) Verify() bool {
+	return len(me.PublicKey) == ed25519.PublicKeySize &&
+		ed25519.Verify(me.PublicKey, me.signedBytes(), me.Signature)
+}
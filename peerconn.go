@@ -23,6 +23,7 @@ import (
 	"github.com/anacrolix/multiless"
 	"github.com/anacrolix/torrent/internal/alloclim"
 	typedRoaring "github.com/anacrolix/torrent/typed-roaring"
+	"github.com/pierrec/lz4/v4"
 	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/torrent/bencode"
@@ -89,6 +90,8 @@ type Peer struct {
 	completedHandshake      time.Time
 	lastUsefulChunkReceived time.Time
 	lastChunkSent           time.Time
+	// Why the Peer was closed, for churn stats.
+	closeReason DisconnectReason
 
 	// Stuff controlled by the local peer.
 	needRequestUpdate    string
@@ -110,10 +113,22 @@ type Peer struct {
 	// and implementation differences, we may receive chunks that are no longer in the set of
 	// requests actually want. This could use a roaring.BSI if the memory use becomes noticeable.
 	validReceiveChunks map[RequestIndex]int
+	// Chunks that timed out on the peer under RequestTimeoutReassignElsewhere, and when.
+	requestTimeoutExclusions map[RequestIndex]time.Time
 	// Indexed by metadata piece, set to true if posted and pending a
 	// response.
 	metadataRequests []bool
 	sentHaves        bitmap.Bitmap
+	// Haves waiting to be sent together using the have batch extension.
+	pendingHaves bitmap.Bitmap
+	// The initial bitfield is waiting on the peer's extended handshake. See
+	// deferBitfieldForHaveBatch.
+	bitfieldDeferred bool
+	// Sends the deferred bitfield if the extended handshake doesn't arrive in time.
+	deferredBitfieldTimer *time.Timer
+	payloadCrypt          payloadCryptState
+	compressionStats      PieceCompressionStats
+	lz4Compressor         *lz4.Compressor
 
 	// Stuff controlled by the remote peer.
 	peerInterested        bool
@@ -153,6 +168,8 @@ type PeerConn struct {
 	// See BEP 3 etc.
 	PeerID             PeerID
 	PeerExtensionBytes pp.PeerExtensionBits
+	// Labels from the peer's extended handshake. See PeerHandshakeMetadata.
+	peerHandshakeMetadata map[string]string
 
 	// The actual Conn, used for closing, and setting socket options. Do not use methods on this
 	// while holding any mutexes.
@@ -164,8 +181,9 @@ type PeerConn struct {
 
 	messageWriter peerConnMsgWriter
 
-	uploadTimer *time.Timer
-	pex         pexConnState
+	uploadTimer   *time.Timer
+	pex           pexConnState
+	transferRates transferRatesState
 
 	// The pieces the peer has claimed to have.
 	_peerPieces roaring.Bitmap
@@ -174,10 +192,24 @@ type PeerConn struct {
 	peerSentHaveAll bool
 
 	peerRequestDataAllocLimiter alloclim.Limiter
+
+	// This peer's shares of the Torrent's rate limits. Only set with ClientConfig.FairRateSharing.
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+	uploadShare     rateShare
+	downloadShare   rateShare
+	// The FreeRiderPolicy has choked the peer.
+	freeRiderChoked bool
 }
 
 func (cn *PeerConn) peerImplStatusLines() []string {
-	return []string{fmt.Sprintf("%+-55q %s %s", cn.PeerID, cn.PeerExtensionBytes, cn.connString)}
+	lines := []string{fmt.Sprintf("%+-55q %s %s", cn.PeerID, cn.PeerExtensionBytes, cn.connString)}
+	if cn.pieceCompressionEnabled() {
+		lines = append(lines, fmt.Sprintf(
+			"piece compression ratio: written %.2f, read %.2f",
+			cn.compressionStats.WrittenRatio(), cn.compressionStats.ReadRatio()))
+	}
+	return lines
 }
 
 func (cn *Peer) updateExpectingChunks() {
@@ -451,6 +483,7 @@ func (cn *PeerConn) onClose() {
 	if cn.pex.IsEnabled() {
 		cn.pex.Close()
 	}
+	cn.stopDeferredBitfieldTimer()
 	cn.tickleWriter()
 	if cn.conn != nil {
 		go cn.conn.Close()
@@ -520,7 +553,17 @@ var (
 
 // The actual value to use as the maximum outbound requests.
 func (cn *Peer) nominalMaxRequests() maxRequests {
-	return maxInt(1, minInt(cn.PeerMaxRequests, cn.peakRequests*2, maxLocalToRemoteRequests))
+	ret := minInt(cn.PeerMaxRequests, cn.peakRequests*2, maxLocalToRemoteRequests)
+	if depth := cn.t.schedulerParams().PipelineDepth; depth > 0 {
+		ret = minInt(ret, depth)
+	}
+	if pc, ok := cn.TryAsPeerConn(); ok {
+		ret = pc.shareRequestBudget(ret)
+	}
+	if cn.t.preemptedByPriority() {
+		ret = preemptedMaxRequests
+	}
+	return maxInt(1, ret)
 }
 
 func (cn *Peer) totalExpectingTime() (ret time.Duration) {
@@ -551,6 +594,7 @@ func (cn *PeerConn) choke(msg messageWriter) (more bool) {
 	more = msg(pp.Message{
 		Type: pp.Choke,
 	})
+	cn.t.retryWaitingUnchokes()
 	if !cn.fastEnabled() {
 		cn.deleteAllPeerRequests()
 	}
@@ -569,6 +613,7 @@ func (cn *PeerConn) unchoke(msg func(pp.Message) bool) bool {
 		return true
 	}
 	cn.choking = false
+	cn.t.retryWaitingUnchokes()
 	return msg(pp.Message{
 		Type: pp.Unchoke,
 	})
@@ -585,6 +630,9 @@ func (cn *Peer) setInterested(interested bool) bool {
 		cn.priorInterest += time.Since(cn.lastBecameInterested)
 	}
 	cn.updateExpectingChunks()
+	if pc, ok := cn.peerImpl.(*PeerConn); ok {
+		pc.t.cl.remotePeerInterestChanged(pc)
+	}
 	// log.Printf("%p: setting interest: %v", cn, interested)
 	return cn.writeInterested(interested)
 }
@@ -716,6 +764,9 @@ func (cn *PeerConn) fillWriteBuffer() {
 		// can't do this in maybeUpdateActualRequestState because it's a method on Peer and has no
 		// knowledge of write buffers.
 	}
+	if !cn.flushPendingHaves() {
+		return
+	}
 	cn.maybeUpdateActualRequestState()
 	if cn.pex.IsEnabled() {
 		if flow := cn.pex.Share(cn.write); !flow {
@@ -726,9 +777,18 @@ func (cn *PeerConn) fillWriteBuffer() {
 }
 
 func (cn *PeerConn) have(piece pieceIndex) {
+	if cn.bitfieldDeferred {
+		// The deferred bitfield will include it.
+		return
+	}
 	if cn.sentHaves.Get(bitmap.BitIndex(piece)) {
 		return
 	}
+	if cn.supportsHaveBatch() {
+		cn.pendingHaves.Add(bitmap.BitIndex(piece))
+		cn.tickleWriter()
+		return
+	}
 	cn.write(pp.Message{
 		Type:  pp.Have,
 		Index: pp.Integer(piece),
@@ -1011,12 +1071,16 @@ func (c *PeerConn) reject(r Request) {
 	}
 }
 
-func (c *PeerConn) maximumPeerRequestChunkLength() (_ Option[int]) {
-	uploadRateLimiter := c.t.cl.config.UploadRateLimiter
-	if uploadRateLimiter.Limit() == rate.Inf {
-		return
+func (c *PeerConn) maximumPeerRequestChunkLength() (ret Option[int]) {
+	for _, l := range []*rate.Limiter{c.t.cl.settings.uploadRateLimiter, c.t.cl.autobandLimiter} {
+		if l == nil || l.Limit() == rate.Inf {
+			continue
+		}
+		if !ret.Ok || l.Burst() < ret.Value {
+			ret = Some(l.Burst())
+		}
 	}
-	return Some(uploadRateLimiter.Burst())
+	return
 }
 
 // Returns whether any part of the chunk would lie outside a piece of the given length.
@@ -1079,6 +1143,12 @@ func (c *PeerConn) onReadRequest(r Request, startFetch bool) error {
 		torrent.Add("bad requests received", 1)
 		return errors.New("chunk overflows piece")
 	}
+	if !c.uploadAuthorized(r) {
+		if c.fastEnabled() {
+			c.reject(r)
+		}
+		return nil
+	}
 	if c.peerRequests == nil {
 		c.peerRequests = make(map[Request]*peerRequestState, localClientReqq)
 	}
@@ -1087,14 +1157,16 @@ func (c *PeerConn) onReadRequest(r Request, startFetch bool) error {
 	}
 	c.peerRequests[r] = value
 	if startFetch {
-		// TODO: Limit peer request data read concurrency.
 		go c.peerRequestDataReader(r, value)
 	}
 	return nil
 }
 
 func (c *PeerConn) peerRequestDataReader(r Request, prs *peerRequestState) {
+	workers := c.t.cl.storageReadWorkers
+	workers.acquire()
 	b, err := c.readPeerRequestData(r, prs)
+	workers.release()
 	c.locker().Lock()
 	defer c.locker().Unlock()
 	if err != nil {
@@ -1124,6 +1196,9 @@ func (c *PeerConn) peerRequestDataReadFailed(err error, r Request) {
 	if c.t.closed.IsSet() {
 		return
 	}
+	if fdExhausted(err) {
+		c.t.cl.onFdPressure("storage read", err)
+	}
 	i := pieceIndex(r.Index)
 	if c.t.pieceComplete(i) {
 		// There used to be more code here that just duplicated the following break. Piece
@@ -1207,11 +1282,20 @@ func (c *PeerConn) mainReadLoop() (err error) {
 	t := c.t
 	cl := t.cl
 
+	readBufferSize := cl.settings.peerReadBufferSize
+	if readBufferSize == 0 {
+		readBufferSize = 1 << 17
+	}
 	decoder := pp.Decoder{
-		R:         bufio.NewReaderSize(c.r, 1<<17),
+		R:         bufio.NewReaderSize(c.r, readBufferSize),
 		MaxLength: 4 * pp.Integer(max(int64(t.chunkSize), defaultChunkSize)),
 		Pool:      &t.chunkPool,
 	}
+	messageLimiter := c.newMessageLimiter()
+	var validator *protocolValidator
+	if cl.config.StrictProtocol {
+		validator = &protocolValidator{c: c}
+	}
 	for {
 		var msg pp.Message
 		func() {
@@ -1228,12 +1312,23 @@ func (c *PeerConn) mainReadLoop() (err error) {
 		if err != nil {
 			return err
 		}
+		if messageLimiter != nil && countsTowardMessageRate(msg) && !messageLimiter.Allow() {
+			torrent.Add("peers disconnected for message floods", 1)
+			c.logger.Levelf(log.Debug, "disconnecting for exceeding message rate")
+			return ErrPeerMessageFlood
+		}
 		c.lastMessageReceived = time.Now()
 		if msg.Keepalive {
 			receivedKeepalives.Add(1)
 			continue
 		}
 		messageTypesReceived.Add(msg.Type.String(), 1)
+		if validator != nil {
+			if v := validator.check(&msg); v != nil {
+				c.onProtocolViolation(v)
+				return v
+			}
+		}
 		if msg.Type.FastExtension() && !c.fastEnabled() {
 			runSafeExtraneous(func() { torrent.Add("fast messages received when extension is disabled", 1) })
 			return fmt.Errorf("received fast extension message (type=%v) but extension is disabled", msg.Type)
@@ -1303,6 +1398,18 @@ func (c *PeerConn) mainReadLoop() (err error) {
 				err = fmt.Errorf("on reading request %v: %w", r, err)
 			}
 		case pp.Piece:
+			if t.preSharedKey != nil {
+				err = c.payloadCrypt.open(&msg)
+				if err != nil {
+					break
+				}
+			}
+			if c.pieceCompressionEnabled() {
+				err = c.decompressPiece(&msg)
+				if err != nil {
+					break
+				}
+			}
 			c.doChunkReadStats(int64(len(msg.Piece)))
 			err = c.receiveChunk(&msg)
 			if len(msg.Piece) == int(t.chunkSize) {
@@ -1411,6 +1518,7 @@ func (c *PeerConn) onReadExtendedMsg(id pp.ExtensionNumber, payload []byte) (err
 			c.PeerMaxRequests = d.Reqq
 		}
 		c.PeerClientName.Store(d.V)
+		c.peerHandshakeMetadata = decodeHandshakeMetadata(d.Metadata)
 		if c.PeerExtensionIDs == nil {
 			c.PeerExtensionIDs = make(map[pp.ExtensionName]pp.ExtensionNumber, len(d.M))
 		}
@@ -1432,6 +1540,8 @@ func (c *PeerConn) onReadExtendedMsg(id pp.ExtensionNumber, payload []byte) (err
 			}
 		}
 		c.requestPendingMetadata()
+		c.sendDeferredBitfield()
+		c.maybeSendPayloadCryptNonce()
 		if !t.cl.config.DisablePEX {
 			t.pex.Add(c) // we learnt enough now
 			c.pex.Init(c)
@@ -1448,6 +1558,21 @@ func (c *PeerConn) onReadExtendedMsg(id pp.ExtensionNumber, payload []byte) (err
 			return nil // or hang-up maybe?
 		}
 		return c.pex.Recv(payload)
+	case haveBatchExtendedId:
+		if cl.config.DisableHaveBatching {
+			return fmt.Errorf("have batch extension disabled")
+		}
+		return c.onHaveBatchMsg(payload)
+	case payloadCryptExtendedId:
+		if t.preSharedKey == nil {
+			return fmt.Errorf("payload crypt extension not advertised")
+		}
+		return c.onPayloadCryptMsg(payload)
+	case uploadReceiptExtendedId:
+		if !cl.config.UploadReceipts {
+			return fmt.Errorf("upload receipt extension not advertised")
+		}
+		return c.onUploadReceiptMsg(payload)
 	default:
 		return fmt.Errorf("unexpected extended message ID: %v", id)
 	}
@@ -1540,6 +1665,9 @@ func (c *Peer) receiveChunk(msg *pp.Message) error {
 
 	c.allStats(add(1, func(cs *ConnStats) *Count { return &cs.ChunksReadUseful }))
 	c.allStats(add(int64(len(msg.Piece)), func(cs *ConnStats) *Count { return &cs.BytesReadUsefulData }))
+	if pc, ok := c.TryAsPeerConn(); ok {
+		pc.receivedForUploadReceipt(pieceIndex(ppReq.Index), int64(len(msg.Piece)))
+	}
 	if intended {
 		c.piecesReceivedSinceLastRequestUpdate++
 		c.allStats(add(int64(len(msg.Piece)), func(cs *ConnStats) *Count { return &cs.BytesReadUsefulIntendedData }))
@@ -1586,6 +1714,11 @@ func (c *Peer) receiveChunk(msg *pp.Message) error {
 		// request update runs while we're writing the chunk that just failed. Then we never do a
 		// fresh update after pending the failed request.
 		c.updateRequests("Peer.receiveChunk error writing chunk")
+		if fdExhausted(err) {
+			// Transient. The chunk will be requested again.
+			cl.onFdPressure("storage write", err)
+			return nil
+		}
 		t.onWriteChunkErr(err)
 		return nil
 	}
@@ -1594,6 +1727,7 @@ func (c *Peer) receiveChunk(msg *pp.Message) error {
 
 	// We need to ensure the piece is only queued once, so only the last chunk writer gets this job.
 	if t.pieceAllDirty(pieceIndex(ppReq.Index)) && piece.pendingWrites == 0 {
+		piece.queuedFromDownload = true
 		t.queuePieceCheck(pieceIndex(ppReq.Index))
 		// We don't pend all chunks here anymore because we don't want code dependent on the dirty
 		// chunk status (such as the haveChunk call above) to have to check all the various other
@@ -1621,12 +1755,31 @@ func (c *Peer) onDirtiedPiece(piece pieceIndex) {
 }
 
 func (c *PeerConn) uploadAllowed() bool {
+	if !c.uploadAllowedIgnoringUnchokeSlots() {
+		return false
+	}
+	if c.choking {
+		return c.t.unchokeSlotFor(c)
+	}
+	return !c.t.unchokeSlotsExceeded(c) && !c.t.unchokePreempted(c)
+}
+
+func (c *PeerConn) uploadAllowedIgnoringUnchokeSlots() bool {
 	if c.t.cl.config.NoUpload {
 		return false
 	}
 	if c.t.dataUploadDisallowed {
 		return false
 	}
+	if c.freeRiderChoked {
+		return false
+	}
+	if c.t.transfersPaused() {
+		return false
+	}
+	if !c.payloadCryptReady() {
+		return false
+	}
 	if c.t.seeding() {
 		return true
 	}
@@ -1662,13 +1815,8 @@ another:
 			if state.data == nil {
 				continue
 			}
-			res := c.t.cl.config.UploadRateLimiter.ReserveN(time.Now(), int(r.Length))
-			if !res.OK() {
-				panic(fmt.Sprintf("upload rate limiter burst size < %d", r.Length))
-			}
-			delay := res.Delay()
+			delay := c.reserveUpload(int(r.Length))
 			if delay > 0 {
-				res.Cancel()
 				c.setRetryUploadTimer(delay)
 				// Hard to say what to return here.
 				return true
@@ -1772,11 +1920,18 @@ func (c *PeerConn) tickleWriter() {
 func (c *PeerConn) sendChunk(r Request, msg func(pp.Message) bool, state *peerRequestState) (more bool) {
 	c.lastChunkSent = time.Now()
 	state.allocReservation.Release()
+	data := state.data
+	if c.pieceCompressionEnabled() {
+		data = c.compressPiece(data)
+	}
+	if c.t.preSharedKey != nil {
+		data = c.payloadCrypt.seal(r.Index, r.Begin, data)
+	}
 	return msg(pp.Message{
 		Type:  pp.Piece,
 		Index: r.Index,
 		Begin: r.Begin,
-		Piece: state.data,
+		Piece: data,
 	})
 }
 
@@ -8,9 +8,12 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/time/rate"
 
+	"github.com/RoaringBitmap/roaring"
+	"github.com/anacrolix/missinggo/v2/bitmap"
 	"github.com/frankban/quicktest"
 	qt "github.com/frankban/quicktest"
 	"github.com/stretchr/testify/require"
@@ -310,7 +313,7 @@ func TestReceiveLargeRequest(t *testing.T) {
 	c.Check(pc.onReadRequest(req, false), qt.IsNil)
 	c.Check(pc.peerRequests, qt.HasLen, 2)
 	pc.peerRequests = nil
-	pc.t.cl.config.UploadRateLimiter = rate.NewLimiter(1, defaultChunkSize)
+	pc.t.cl.settings.uploadRateLimiter = rate.NewLimiter(1, defaultChunkSize)
 	req.Length = defaultChunkSize
 	c.Check(pc.onReadRequest(req, false), qt.IsNil)
 	c.Check(pc.peerRequests, qt.HasLen, 1)
@@ -330,3 +333,60 @@ func TestChunkOverflowsPiece(t *testing.T) {
 	check(2, pp.IntegerMax, pp.IntegerMax, true)
 	check(2, pp.IntegerMax-2, pp.IntegerMax, false)
 }
+
+func TestHaveBatchBitfield(t *testing.T) {
+	c := qt.New(t)
+	var bm roaring.Bitmap
+	bm.AddMany([]uint32{1, 2, 3, 6, 9, 10})
+	runs := bitmapRuns(&bm)
+	c.Check(runs, qt.DeepEquals, []uint32{1, 3, 2, 1, 2, 2})
+	cl := newTestingClient(t)
+	tt := cl.newTorrentForTesting()
+	pc := PeerConn{
+		Peer: Peer{t: tt},
+	}
+	pc.initRequestState()
+	pc.peerImpl = &pc
+	tt.conns[&pc] = struct{}{}
+	c.Assert(pc.onHaveBatchMsg(pp.HaveBatchMsg{
+		Type: pp.HaveBatchBitfieldMsgType,
+		Runs: runs,
+	}.MustMarshalBinary()), qt.IsNil)
+	c.Check(pc._peerPieces.ToArray(), qt.DeepEquals, bm.ToArray())
+	c.Assert(pc.onHaveBatchMsg(pp.HaveBatchMsg{
+		Type:  pp.HaveBatchHavesMsgType,
+		Haves: []uint32{4, 11},
+	}.MustMarshalBinary()), qt.IsNil)
+	c.Check(pc._peerPieces.ToArray(), qt.DeepEquals, []uint32{1, 2, 3, 4, 6, 9, 10, 11})
+}
+
+// A peer that never sends its extended handshake still learns what we have.
+func TestDeferredBitfieldTimeout(t *testing.T) {
+	c := qt.New(t)
+	cl := newTestingClient(t)
+	tt := cl.newTorrentForTesting()
+	c.Assert(tt.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, 3*metainfo.HashSize),
+		Length:      3,
+		PieceLength: 1,
+	}), qt.IsNil)
+	tt._completedPieces.Add(1)
+	pc := PeerConn{
+		Peer: Peer{t: tt},
+	}
+	pc.initRequestState()
+	pc.peerImpl = &pc
+	pc.initMessageWriter()
+	pc.bitfieldDeferred = true
+	timer := time.AfterFunc(time.Hour, func() {})
+	pc.deferredBitfieldTimer = timer
+	pc.deferredBitfieldTimedOut()
+	c.Check(pc.bitfieldDeferred, qt.IsFalse)
+	c.Check(pc.deferredBitfieldTimer, qt.IsNil)
+	c.Check(timer.Stop(), qt.IsFalse)
+	c.Check(pc.sentHaves.ToSortedSlice(), qt.DeepEquals, []bitmap.BitIndex{1})
+	// The usual path got there first.
+	n := pc.messageWriter.writeBuffer.Len()
+	pc.deferredBitfieldTimedOut()
+	c.Check(pc.messageWriter.writeBuffer.Len(), qt.Equals, n)
+}
@@ -0,0 +1,100 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pierrec/lz4/v4"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// The compression flag prefixing piece payloads.
+const pieceCompressionOverhead = 1
+
+// Piece data sizes before and after compression on a connection. Data that didn't compress is
+// included with equal sizes.
+type PieceCompressionStats struct {
+	BytesWrittenUncompressed Count
+	BytesWrittenCompressed   Count
+	BytesReadUncompressed    Count
+	BytesReadCompressed      Count
+}
+
+func compressionRatio(uncompressed, compressed *Count) float64 {
+	if compressed.Int64() == 0 {
+		return 1
+	}
+	return float64(uncompressed.Int64()) / float64(compressed.Int64())
+}
+
+// Uncompressed size over compressed size for written piece data.
+func (me *PieceCompressionStats) WrittenRatio() float64 {
+	return compressionRatio(&me.BytesWrittenUncompressed, &me.BytesWrittenCompressed)
+}
+
+// Uncompressed size over compressed size for read piece data.
+func (me *PieceCompressionStats) ReadRatio() float64 {
+	return compressionRatio(&me.BytesReadUncompressed, &me.BytesReadCompressed)
+}
+
+// Whether piece payloads on the connection are compressed. Both peers must advertise the extension.
+func (cn *PeerConn) pieceCompressionEnabled() bool {
+	return cn.t.cl.config.PieceCompression && cn.PeerExtensionIDs[pp.ExtensionNamePieceCompression] != 0
+}
+
+// Returns the piece compression stats for the connection.
+func (cn *PeerConn) PieceCompressionStats() *PieceCompressionStats {
+	return &cn.compressionStats
+}
+
+// Prefixes the payload with the compression flag, compressing it if that makes it smaller.
+func (cn *PeerConn) compressPiece(data []byte) []byte {
+	ret := make([]byte, pieceCompressionOverhead+lz4.CompressBlockBound(len(data)))
+	if cn.lz4Compressor == nil {
+		cn.lz4Compressor = new(lz4.Compressor)
+	}
+	n, err := cn.lz4Compressor.CompressBlock(data, ret[pieceCompressionOverhead:])
+	if err != nil || n == 0 || n >= len(data) {
+		// Incompressible.
+		ret[0] = byte(pp.PieceUncompressed)
+		n = copy(ret[pieceCompressionOverhead:], data)
+	} else {
+		ret[0] = byte(pp.PieceCompressedLz4)
+		torrent.Add("pieces compressed", 1)
+	}
+	cn.compressionStats.BytesWrittenUncompressed.Add(int64(len(data)))
+	cn.compressionStats.BytesWrittenCompressed.Add(int64(n))
+	return ret[:pieceCompressionOverhead+n]
+}
+
+// Removes the compression flag from the Piece message payload, decompressing it if necessary.
+// Decompressed payloads are put in a buffer from the chunk pool, and the original is returned to
+// it.
+func (cn *PeerConn) decompressPiece(msg *pp.Message) error {
+	if len(msg.Piece) < pieceCompressionOverhead {
+		return errors.New("piece payload missing compression flag")
+	}
+	t := cn.t
+	compressed := msg.Piece[pieceCompressionOverhead:]
+	switch pp.PieceCompressionFlag(msg.Piece[0]) {
+	case pp.PieceUncompressed:
+		msg.Piece = msg.Piece[:copy(msg.Piece, compressed)]
+		cn.compressionStats.BytesReadCompressed.Add(int64(len(msg.Piece)))
+	case pp.PieceCompressedLz4:
+		buf := *t.chunkPool.Get().(*[]byte)
+		n, err := lz4.UncompressBlock(compressed, buf[:t.chunkSize])
+		if err != nil {
+			t.chunkPool.Put(&buf)
+			return fmt.Errorf("decompressing piece payload: %w", err)
+		}
+		cn.compressionStats.BytesReadCompressed.Add(int64(len(compressed)))
+		orig := msg.Piece
+		t.chunkPool.Put(&orig)
+		msg.Piece = buf[:n]
+	default:
+		return fmt.Errorf("unknown piece compression flag %v", msg.Piece[0])
+	}
+	cn.compressionStats.BytesReadUncompressed.Add(int64(len(msg.Piece)))
+	return nil
+}
@@ -0,0 +1,31 @@
+package torrent
+
+// What the request scheduler is working on, one entry per piece, such as for dashboards to render
+// as a heat map. See Torrent.PieceHeat.
+type PieceHeat struct {
+	// The effective priority of each piece, from readers, file and piece priorities. Pieces that
+	// are complete or being hashed aren't wanted, and have PiecePriorityNone. This is one byte per
+	// piece, so it's encoded as base64 in JSON.
+	Priorities []piecePriority
+	// The number of chunk requests outstanding with peers for each piece.
+	Requests []int
+}
+
+// Returns a snapshot of the priority and outstanding requests of every piece. It's cheap enough to
+// poll for live views. Empty until the info is available.
+func (t *Torrent) PieceHeat() (ret PieceHeat) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	if !t.haveInfo() {
+		return
+	}
+	ret.Priorities = make([]piecePriority, t.numPieces())
+	for i := range ret.Priorities {
+		ret.Priorities[i] = t.piecePriority(i)
+	}
+	ret.Requests = make([]int, t.numPieces())
+	for r := range t.requestState {
+		ret.Requests[t.pieceIndexOfRequestIndex(r)]++
+	}
+	return
+}
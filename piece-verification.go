@@ -0,0 +1,51 @@
+package torrent
+
+import (
+	"errors"
+
+	"github.com/anacrolix/log"
+)
+
+// Returned by a ClientConfig.VerifyPiece that only augments the SHA-1 check, when that fails.
+var ErrPieceHashMismatch = errors.New("piece hash mismatch")
+
+// The piece data given to ClientConfig.VerifyPiece. Returning nil passes the piece, and an error
+// fails it. To augment the SHA-1 check rather than replace it, return ErrPieceHashMismatch when
+// HashOk is false.
+type PieceVerification struct {
+	Torrent *Torrent
+	Index   int
+	// Only valid until VerifyPiece returns.
+	Data []byte
+	// Whether the data matched the piece's SHA-1 hash in the info.
+	HashOk bool
+}
+
+// Applies ClientConfig.VerifyPiece to the hashed piece. Data is nil if it couldn't be read in full,
+// in which case the piece fails.
+func (t *Torrent) verifyPiece(index pieceIndex, data []byte, hashOk bool) bool {
+	verify := t.cl.config.VerifyPiece
+	if verify == nil {
+		return hashOk
+	}
+	if data == nil {
+		return false
+	}
+	err := verify(PieceVerification{
+		Torrent: t,
+		Index:   index,
+		Data:    data,
+		HashOk:  hashOk,
+	})
+	if err != nil {
+		if hashOk {
+			torrent.Add("pieces failing VerifyPiece", 1)
+			t.logger.Levelf(log.Debug, "piece %v failed VerifyPiece: %v", index, err)
+		}
+		return false
+	}
+	if !hashOk {
+		torrent.Add("pieces passed by VerifyPiece despite hash", 1)
+	}
+	return true
+}
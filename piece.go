@@ -21,9 +21,12 @@ type Piece struct {
 
 	readerCond chansync.BroadcastCond
 
-	numVerifies         int64
-	hashing             bool
-	marking             bool
+	numVerifies int64
+	hashing     bool
+	marking     bool
+	// The piece was queued for hashing because its last dirty chunk was written, rather than to
+	// verify data already in storage. Such hashes aren't rate limited.
+	queuedFromDownload  bool
 	storageCompletionOk bool
 
 	publicPieceState PieceState
@@ -55,6 +58,44 @@ func (p *Piece) Storage() storage.Piece {
 	return p.t.storage.Piece(p.Info())
 }
 
+// Returns the files that contain data in this piece, ordered by their offset in the torrent. The
+// piece's file-derived priority is the highest of their priorities.
+func (p *Piece) Files() []*File {
+	return append([]*File(nil), p.files...)
+}
+
+// The SHA1 hash of the piece data, from the metainfo "pieces" field.
+func (p *Piece) Hash() metainfo.Hash {
+	return *p.hash
+}
+
+// The length of the piece in bytes. Only the last piece may be shorter than the info piece length.
+func (p *Piece) Length() int64 {
+	return int64(p.length())
+}
+
+// The offset of the piece data from the start of the torrent.
+func (p *Piece) Offset() int64 {
+	return p.torrentBeginOffset()
+}
+
+// The number of connected peers that have the piece.
+func (p *Piece) Availability() (ret int) {
+	p.t.cl.rLock()
+	ret = p.availability()
+	p.t.cl.rUnlock()
+	return
+}
+
+// Marks the piece data as incomplete in storage, and updates the piece's completion. The piece will
+// be downloaded again if it's wanted. Useful for repair flows that have determined the data is bad
+// by other means.
+func (p *Piece) MarkNotComplete() error {
+	err := p.Storage().MarkNotComplete()
+	p.UpdateCompletion()
+	return err
+}
+
 func (p *Piece) Flush() {
 	if p.t.storage.Flush != nil {
 		_ = p.t.storage.Flush()
@@ -216,11 +257,14 @@ func (p *Piece) purePriority() (ret piecePriority) {
 		ret.Raise(PiecePriorityReadahead)
 	}
 	ret.Raise(p.priority)
+	if ret != PiecePriorityNone && p.t.trackerAssignedPieces.Contains(p.index) {
+		ret.Raise(PiecePriorityHigh)
+	}
 	return
 }
 
 func (p *Piece) uncachedPriority() (ret piecePriority) {
-	if p.hashing || p.marking || p.t.pieceComplete(p.index) || p.queuedForHash() {
+	if p.hashing || p.marking || p.t.pieceComplete(p.index) || p.queuedForHash() || p.t.storageReadOnly() {
 		return PiecePriorityNone
 	}
 	return p.purePriority()
@@ -10,15 +10,15 @@ import (
 
 const UpnpDiscoverLogTag = "upnp-discover"
 
-func (cl *Client) addPortMapping(d upnp.Device, proto upnp.Protocol, internalPort int, upnpID string) {
+func (cl *Client) addPortMapping(d upnp.Device, proto upnp.Protocol, internalPort, requestedExternalPort int, upnpID string) {
 	logger := cl.logger.WithContextText(fmt.Sprintf("UPnP device at %v: mapping internal %v port %v", d.GetLocalIPAddress(), proto, internalPort))
-	externalPort, err := d.AddPortMapping(proto, internalPort, internalPort, upnpID, 0)
+	externalPort, err := d.AddPortMapping(proto, internalPort, requestedExternalPort, upnpID, 0)
 	if err != nil {
 		logger.WithDefaultLevel(log.Warning).Printf("error: %v", err)
 		return
 	}
 	level := log.Info
-	if externalPort != internalPort {
+	if externalPort != requestedExternalPort {
 		level = log.Warning
 	}
 	logger.WithDefaultLevel(level).Printf("success: external port %v", externalPort)
@@ -34,12 +34,13 @@ func (cl *Client) forwardPort() {
 	ds := upnp.Discover(0, 2*time.Second, cl.logger.WithValues(UpnpDiscoverLogTag))
 	cl.lock()
 	cl.logger.WithDefaultLevel(log.Debug).Printf("discovered %d upnp devices", len(ds))
-	port := cl.incomingPeerPort()
+	internalPort := cl.LocalPort()
+	externalPort := cl.incomingPeerPort()
 	id := cl.config.UpnpID
 	cl.unlock()
 	for _, d := range ds {
-		go cl.addPortMapping(d, upnp.TCP, port, id)
-		go cl.addPortMapping(d, upnp.UDP, port, id)
+		go cl.addPortMapping(d, upnp.TCP, internalPort, externalPort, id)
+		go cl.addPortMapping(d, upnp.UDP, internalPort, externalPort, id)
 	}
 	cl.lock()
 }
@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Swarm statistics from scraping a tracker.
+type TrackerScrapeResult struct {
+	Url       string
+	InfoHash  metainfo.Hash
+	Seeders   int32
+	Leechers  int32
+	Completed int32
+	Err       error
+}
+
+// The health of a swarm, obtained without joining it. See Client.PreviewTorrent.
+type TorrentPreview struct {
+	Trackers []TrackerScrapeResult
+	// Estimates from BEP 33 DHT scrapes. Zero if there are no DHT servers that support it.
+	DhtSeeders  float64
+	DhtLeechers float64
+	// The highest counts from any source.
+	Seeders  int
+	Leechers int
+	// Estimated number of distributed copies. Each seeder is a complete copy. Leechers' progress
+	// isn't known without joining the swarm, so each is counted as half a copy.
+	Availability float64
+}
+
+// Scrapes the spec's trackers and the DHT for the swarm's seeder and leecher counts, without
+// adding the torrent or announcing to the swarm. This lets UIs show swarm health before committing
+// to a download. Sources that don't respond before the context is done are omitted.
+func (cl *Client) PreviewTorrent(ctx context.Context, spec *TorrentSpec) (ret TorrentPreview) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, tier := range spec.Trackers {
+		for _, url := range tier {
+			url := url
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := cl.scrapeTracker(ctx, url, spec.InfoHash)
+				mu.Lock()
+				ret.Trackers = append(ret.Trackers, res)
+				mu.Unlock()
+			}()
+		}
+	}
+	cl.rLock()
+	dhtServers := append([]DhtServer(nil), cl.dhtServers...)
+	cl.rUnlock()
+	for _, s := range dhtServers {
+		scraper, ok := s.(DhtScraper)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seeders, leechers, err := scraper.Scrape(ctx, spec.InfoHash)
+			if err != nil {
+				cl.logger.Levelf(log.Debug, "scraping dht for %v: %v", spec.InfoHash, err)
+				return
+			}
+			mu.Lock()
+			if seeders > ret.DhtSeeders {
+				ret.DhtSeeders = seeders
+			}
+			if leechers > ret.DhtLeechers {
+				ret.DhtLeechers = leechers
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	ret.Seeders = int(ret.DhtSeeders + 0.5)
+	ret.Leechers = int(ret.DhtLeechers + 0.5)
+	for _, tr := range ret.Trackers {
+		if tr.Err != nil {
+			continue
+		}
+		ret.Seeders = maxInt(ret.Seeders, int(tr.Seeders))
+		ret.Leechers = maxInt(ret.Leechers, int(tr.Leechers))
+	}
+	ret.Availability = float64(ret.Seeders) + float64(ret.Leechers)/2
+	return
+}
+
+func (cl *Client) scrapeTracker(ctx context.Context, url string, ih metainfo.Hash) (ret TrackerScrapeResult) {
+	res, err := cl.ScrapeTracker(ctx, url, []metainfo.Hash{ih})
+	if err != nil {
+		return TrackerScrapeResult{
+			Url:      url,
+			InfoHash: ih,
+			Err:      err,
+		}
+	}
+	return res[0]
+}
@@ -84,3 +84,18 @@ func (me *prioritizedPeers) PopMax() PeerInfo {
 func (me *prioritizedPeers) DeleteBaseLineProvider(bp PeerInfo) {
 	me.om.Delete(prioritizedPeersItem{me.getPrio(bp), bp})
 }
+
+// Deletes the peers with the given addresses, returning how many were found.
+func (me *prioritizedPeers) DeleteAddrs(addrs map[string]struct{}) (deleted int) {
+	var items []btree.Item
+	me.om.Ascend(func(i btree.Item) bool {
+		if _, ok := addrs[i.(prioritizedPeersItem).p.Addr.String()]; ok {
+			items = append(items, i)
+		}
+		return true
+	})
+	for _, i := range items {
+		me.om.Delete(i)
+	}
+	return len(items)
+}
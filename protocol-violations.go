@@ -0,0 +1,167 @@
+package torrent
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/log"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Why a peer was disconnected by ClientConfig.StrictProtocol.
+type ProtocolViolationReason int
+
+const (
+	// A fast extension message from a peer that didn't negotiate the extension.
+	ViolationFastDisabled ProtocolViolationReason = iota
+	// An extended message from a peer that didn't negotiate extension messaging.
+	ViolationExtendedDisabled
+	// A Bitfield, HaveAll or HaveNone that wasn't the first message after the handshakes, or was
+	// repeated.
+	ViolationBitfieldOrder
+	// A Bitfield of the wrong length for the torrent, or with the spare bits set.
+	ViolationBitfieldLength
+	// A message referring to a piece beyond the end of the torrent.
+	ViolationPieceIndex
+	// A Request, Cancel, Reject or Piece that's empty or runs past the end of its piece.
+	ViolationChunkBounds
+	// A Reject for a chunk we didn't request.
+	ViolationUnsolicitedReject
+)
+
+func (me ProtocolViolationReason) String() string {
+	switch me {
+	case ViolationFastDisabled:
+		return "fast disabled"
+	case ViolationExtendedDisabled:
+		return "extended disabled"
+	case ViolationBitfieldOrder:
+		return "bitfield order"
+	case ViolationBitfieldLength:
+		return "bitfield length"
+	case ViolationPieceIndex:
+		return "piece index"
+	case ViolationChunkBounds:
+		return "chunk bounds"
+	case ViolationUnsolicitedReject:
+		return "unsolicited reject"
+	default:
+		return fmt.Sprintf("ProtocolViolationReason(%d)", int(me))
+	}
+}
+
+func (me ProtocolViolationReason) MarshalText() ([]byte, error) {
+	return []byte(me.String()), nil
+}
+
+// Returned by a peer connection's read loop when ClientConfig.StrictProtocol is set and the peer
+// sends a message it shouldn't have.
+type ProtocolViolation struct {
+	Reason  ProtocolViolationReason
+	Message pp.MessageType
+	Detail  string
+}
+
+func (me *ProtocolViolation) Error() string {
+	return fmt.Sprintf("protocol violation (%v) in %v message: %v", me.Reason, me.Message, me.Detail)
+}
+
+// Returns the number of peers disconnected for each kind of protocol violation, including those of
+// Torrents since dropped. See ClientConfig.StrictProtocol.
+func (cl *Client) ProtocolViolations() map[ProtocolViolationReason]int64 {
+	cl.rLock()
+	defer cl.rUnlock()
+	ret := make(map[ProtocolViolationReason]int64, len(cl.protocolViolations))
+	for k, v := range cl.protocolViolations {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Tracks the state needed to check message ordering for a connection.
+type protocolValidator struct {
+	c *PeerConn
+	// Whether a message other than those allowed before the bitfield has been received.
+	pastBitfield bool
+}
+
+// Returns a violation if the message isn't allowed. Must be called with the Client lock held,
+// before the message is handled.
+func (v *protocolValidator) check(msg *pp.Message) (ret *ProtocolViolation) {
+	violation := func(reason ProtocolViolationReason, format string, args ...interface{}) *ProtocolViolation {
+		return &ProtocolViolation{
+			Reason:  reason,
+			Message: msg.Type,
+			Detail:  fmt.Sprintf(format, args...),
+		}
+	}
+	c := v.c
+	t := c.t
+	if msg.Type.FastExtension() && !c.fastEnabled() {
+		return violation(ViolationFastDisabled, "fast extension not negotiated")
+	}
+	switch msg.Type {
+	case pp.Extended:
+		if !c.PeerExtensionBytes.SupportsExtended() || !t.cl.config.Extensions.SupportsExtended() {
+			return violation(ViolationExtendedDisabled, "extension protocol not negotiated")
+		}
+		// The extended handshake is sent before the bitfield.
+		return nil
+	case pp.Port:
+		return nil
+	case pp.Bitfield, pp.HaveAll, pp.HaveNone:
+		if v.pastBitfield {
+			return violation(ViolationBitfieldOrder, "must be first message after handshake")
+		}
+	}
+	v.pastBitfield = true
+	if !t.haveInfo() {
+		// Nothing is known about the bounds until the info arrives.
+		return nil
+	}
+	numPieces := t.numPieces()
+	switch msg.Type {
+	case pp.Bitfield:
+		if len(msg.Bitfield) != (numPieces+7)/8*8 {
+			return violation(ViolationBitfieldLength, "%v bits for %v pieces", len(msg.Bitfield), numPieces)
+		}
+		for _, b := range msg.Bitfield[numPieces:] {
+			if b {
+				return violation(ViolationBitfieldLength, "spare bits set")
+			}
+		}
+	case pp.Have, pp.Suggest, pp.AllowedFast, pp.Request, pp.Cancel, pp.Reject, pp.Piece:
+		if int(msg.Index) >= numPieces {
+			return violation(ViolationPieceIndex, "index %v of %v pieces", msg.Index, numPieces)
+		}
+	}
+	switch msg.Type {
+	case pp.Request, pp.Cancel, pp.Reject, pp.Piece:
+		cs := ChunkSpec{Begin: msg.Begin, Length: msg.Length}
+		if msg.Type == pp.Piece {
+			cs.Length = pp.Integer(len(msg.Piece))
+		}
+		if cs.Length == 0 || chunkOverflowsPiece(cs, t.pieceLength(pieceIndex(msg.Index))) {
+			return violation(ViolationChunkBounds, "%v overflows piece %v", cs, msg.Index)
+		}
+	}
+	if msg.Type == pp.Reject {
+		ri := t.requestIndexFromRequest(newRequestFromMessage(msg))
+		if !c.requestState.Requests.Contains(ri) && !c.requestState.Cancelled.Contains(ri) {
+			return violation(ViolationUnsolicitedReject, "%v not requested", newRequestFromMessage(msg))
+		}
+	}
+	return nil
+}
+
+// Counts the violation before the peer is disconnected for it. Must be called with the Client lock
+// held.
+func (c *PeerConn) onProtocolViolation(v *ProtocolViolation) {
+	cl := c.t.cl
+	if cl.protocolViolations == nil {
+		cl.protocolViolations = make(map[ProtocolViolationReason]int64)
+	}
+	cl.protocolViolations[v.Reason]++
+	protocolViolations.Add(v.Reason.String(), 1)
+	c.logger.Levelf(log.Debug, "disconnecting: %v", v)
+}
@@ -0,0 +1,169 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateSharingInterval = time.Second
+	// A Torrent or peer that used at least this fraction of its share in the last interval is busy,
+	// and is given a part of the allowance that others left unused.
+	rateShareBusyFraction = 0.9
+	// Those that aren't busy are given this much more than they used, so they can ramp up before
+	// they're considered busy.
+	rateShareHeadroom = 1.25
+	// Those that aren't busy are given at least this, in bytes per second, budget permitting.
+	rateShareMin = 16 << 10
+)
+
+// One direction of a Torrent's or peer's part in ClientConfig.FairRateSharing. Zero limits are
+// unset.
+type rateShare struct {
+	// The limit set explicitly, such as by Torrent.SetUploadLimit.
+	cap rate.Limit
+	// The fair share of the parent's limit, assigned at the last rebalance.
+	share rate.Limit
+	// The byte count at the last rebalance, for measuring use.
+	lastBytes int64
+}
+
+// The limit to apply, the lesser of the cap and the share.
+func (me *rateShare) limit() rate.Limit {
+	ret := rate.Inf
+	if me.cap != 0 {
+		ret = me.cap
+	}
+	if me.share != 0 && me.share < ret {
+		ret = me.share
+	}
+	return ret
+}
+
+func (cl *Client) rateSharingLoop() {
+	ticker := time.NewTicker(rateSharingInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.lock()
+			cl.rebalanceRateShares(now.Sub(last))
+			cl.unlock()
+			last = now
+		}
+	}
+}
+
+// Divides the Client's rate limits among its Torrents, and each Torrent's limit among its peers,
+// by what each used over the elapsed interval. Must be called with the Client lock held.
+func (cl *Client) rebalanceRateShares(elapsed time.Duration) {
+	torrents := cl.torrentsAsSlice()
+	rebalanceRateShares(cl.uploadRateLimit(), elapsed, torrents,
+		func(t *Torrent) (*rate.Limiter, *rateShare, int64) {
+			return t.uploadLimiter, &t.uploadShare, t.stats.BytesWrittenData.Int64()
+		})
+	rebalanceRateShares(cl.settings.downloadRateLimiter.Limit(), elapsed, torrents,
+		func(t *Torrent) (*rate.Limiter, *rateShare, int64) {
+			return t.downloadLimiter, &t.downloadShare, t.stats.BytesRead.Int64()
+		})
+	for _, t := range torrents {
+		var conns []*PeerConn
+		for c := range t.conns {
+			if c.uploadLimiter != nil {
+				conns = append(conns, c)
+			}
+		}
+		rebalanceRateShares(t.uploadLimiter.Limit(), elapsed, conns,
+			func(c *PeerConn) (*rate.Limiter, *rateShare, int64) {
+				return c.uploadLimiter, &c.uploadShare, c._stats.BytesWrittenData.Int64()
+			})
+		rebalanceRateShares(t.downloadLimiter.Limit(), elapsed, conns,
+			func(c *PeerConn) (*rate.Limiter, *rateShare, int64) {
+				return c.downloadLimiter, &c.downloadShare, c._stats.BytesRead.Int64()
+			})
+	}
+}
+
+// Measures what each node used since the last rebalance, and divides the budget among them.
+func rebalanceRateShares[T any](
+	budget rate.Limit,
+	elapsed time.Duration,
+	nodes []T,
+	get func(T) (*rate.Limiter, *rateShare, int64),
+) {
+	used := make([]rate.Limit, len(nodes))
+	prev := make([]rate.Limit, len(nodes))
+	for i, n := range nodes {
+		_, s, bytes := get(n)
+		if elapsed > 0 {
+			used[i] = rate.Limit(float64(bytes-s.lastBytes) / elapsed.Seconds())
+		}
+		s.lastBytes = bytes
+		prev[i] = s.share
+	}
+	for i, share := range divideRateShares(budget, used, prev) {
+		l, s, _ := get(nodes[i])
+		s.share = share
+		l.SetLimit(s.limit())
+	}
+}
+
+// Divides the budget among nodes that used the given rates under their previous shares, which are
+// zero if unset. Nodes that didn't use most of their share are given what they used with some
+// headroom, and the rest is divided evenly among the busy nodes, so that allowance left unused by
+// some goes to those that can use it. If none are busy, the remainder is divided among them all.
+// Returns zero shares for an unlimited budget.
+func divideRateShares(budget rate.Limit, used, prev []rate.Limit) []rate.Limit {
+	ret := make([]rate.Limit, len(used))
+	if budget == rate.Inf || len(used) == 0 {
+		return ret
+	}
+	type demand struct {
+		i    int
+		want rate.Limit
+	}
+	var idle []demand
+	for i := range used {
+		if prev[i] != 0 && used[i] < prev[i]*rateShareBusyFraction {
+			want := used[i] * rateShareHeadroom
+			if want < rateShareMin {
+				want = rateShareMin
+			}
+			idle = append(idle, demand{i, want})
+		}
+	}
+	// Satisfy the smallest demands first, while they're within an even split of what remains.
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].want < idle[j].want
+	})
+	remaining := budget
+	unassigned := len(used)
+	satisfied := make([]bool, len(used))
+	for _, d := range idle {
+		if d.want > remaining/rate.Limit(unassigned) {
+			break
+		}
+		ret[d.i] = d.want
+		satisfied[d.i] = true
+		remaining -= d.want
+		unassigned--
+	}
+	if unassigned == 0 {
+		// Nobody wants more, so spread the remainder evenly.
+		for i := range ret {
+			ret[i] += remaining / rate.Limit(len(ret))
+		}
+		return ret
+	}
+	for i := range ret {
+		if !satisfied[i] {
+			ret[i] = remaining / rate.Limit(unassigned)
+		}
+	}
+	return ret
+}
@@ -51,3 +51,33 @@ func (me *rateLimitedReader) Read(b []byte) (n int, err error) {
 	}
 	return
 }
+
+type rateLimitedWriter struct {
+	l *rate.Limiter
+	w io.Writer
+}
+
+// Waits for the limiter before each write, splitting writes larger than the burst.
+func (me *rateLimitedWriter) Write(b []byte) (n int, err error) {
+	if me.l == nil || me.l.Limit() == rate.Inf {
+		return me.w.Write(b)
+	}
+	for len(b) != 0 {
+		b1 := b
+		if burst := me.l.Burst(); burst > 0 && len(b1) > burst {
+			b1 = b1[:burst]
+		}
+		err = me.l.WaitN(context.Background(), len(b1))
+		if err != nil {
+			return
+		}
+		var n1 int
+		n1, err = me.w.Write(b1)
+		n += n1
+		b = b[n1:]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
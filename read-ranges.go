@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+)
+
+// A span of a Torrent's data.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// The result of reading one of the ranges passed to Torrent.ReadRanges.
+type RangeData struct {
+	// The position of the range in the slice passed to ReadRanges.
+	Index int
+	ByteRange
+	Data []byte
+	Err  error
+}
+
+// Reads ranges of a Torrent concurrently. See Torrent.ReadRanges.
+type RangesReader struct {
+	results chan RangeData
+	// The number of results not yet returned by Next.
+	remaining int
+	cancel    context.CancelFunc
+}
+
+// Reads the ranges concurrently, returning each from RangesReader.Next as it completes. The pieces
+// of all the ranges are prioritized together from the start, so they're requested in parallel
+// rather than one range after another. This suits extracting scattered records, such as indexes,
+// from large files. Each range is read into memory whole. Note that you probably want to ensure the
+// Torrent Info is available first.
+func (t *Torrent) ReadRanges(ranges []ByteRange) *RangesReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	me := &RangesReader{
+		results:   make(chan RangeData, len(ranges)),
+		remaining: len(ranges),
+		cancel:    cancel,
+	}
+	for i, br := range ranges {
+		ret := RangeData{
+			Index:     i,
+			ByteRange: br,
+		}
+		if br.Offset < 0 || br.Length < 0 || br.Offset+br.Length > t.length() {
+			ret.Err = fmt.Errorf("range %v+%v out of bounds of %v bytes", br.Offset, br.Length, t.length())
+			me.results <- ret
+			continue
+		}
+		// Prioritize the whole range now, rather than waiting for the first read.
+		r := t.newReader(br.Offset, br.Length).(*reader)
+		r.reading = true
+		r.SetReadahead(br.Length)
+		go func() {
+			defer r.Close()
+			ret.Data, ret.Err = readRange(ctx, r, ret.Length)
+			me.results <- ret
+		}()
+	}
+	return me
+}
+
+func readRange(ctx context.Context, r *reader, length int64) ([]byte, error) {
+	b := make([]byte, length)
+	var n int
+	for n < len(b) {
+		n1, err := r.ReadContext(ctx, b[n:])
+		n += n1
+		if err != nil && n < len(b) {
+			return b[:n], err
+		}
+	}
+	return b, nil
+}
+
+// Returns the next range to finish reading, in no particular order. Returns false once all the
+// ranges have been returned, or if ctx is done first.
+func (me *RangesReader) Next(ctx context.Context) (ret RangeData, ok bool) {
+	if me.remaining == 0 {
+		return
+	}
+	select {
+	case ret = <-me.results:
+		me.remaining--
+		return ret, true
+	case <-ctx.Done():
+		return
+	}
+}
+
+// Stops reading the ranges that haven't completed. They're returned by Next with errors.
+func (me *RangesReader) Close() error {
+	me.cancel()
+	return nil
+}
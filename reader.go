@@ -28,6 +28,16 @@ type Reader interface {
 	SetResponsive()
 }
 
+// Optional interface for Readers that can return only verified data. The Readers returned by this
+// package implement it.
+type OrderedReader interface {
+	// Only return data from verified pieces, and discard reads of pieces that were invalidated while
+	// they were being read, so that every byte returned is correct. This overrides SetResponsive.
+	// Seeks fail once reading has started, so the data read is a contiguous stream, suitable for
+	// piping into decoders and hashes.
+	SetOrdered()
+}
+
 // Piece range by piece index, [begin, end).
 type pieceRange struct {
 	begin, end pieceIndex
@@ -64,15 +74,25 @@ type reader struct {
 	// after a seek or with a new reader at the starting position.
 	reading    bool
 	responsive bool
+	// See OrderedReader.
+	ordered bool
 }
 
-var _ io.ReadSeekCloser = (*reader)(nil)
+var (
+	_ Reader        = (*reader)(nil)
+	_ OrderedReader = (*reader)(nil)
+	_ io.WriterTo   = (*reader)(nil)
+)
 
 func (r *reader) SetResponsive() {
 	r.responsive = true
 	r.t.cl.event.Broadcast()
 }
 
+func (r *reader) SetOrdered() {
+	r.ordered = true
+}
+
 // Disable responsive mode. TODO: Remove?
 func (r *reader) SetNonResponsive() {
 	r.responsive = false
@@ -102,7 +122,7 @@ func (r *reader) available(off, max int64) (ret int64) {
 		if !ok {
 			break
 		}
-		if !r.responsive && !r.t.pieceComplete(pieceIndex(req.Index)) {
+		if (!r.responsive || r.ordered) && !r.t.pieceComplete(pieceIndex(req.Index)) {
 			break
 		}
 		if !r.t.haveChunk(req) {
@@ -207,8 +227,6 @@ func (r *reader) waitAvailable(ctx context.Context, pos, wanted int64, wait bool
 		case <-ctx.Done():
 			err = ctx.Err()
 			return
-		case <-r.t.dataDownloadDisallowed.On():
-			err = errors.New("torrent data downloading disabled")
 		case <-r.t.networkingEnabled.Off():
 			err = errors.New("torrent networking disabled")
 			return
@@ -240,7 +258,20 @@ func (r *reader) readOnceAt(ctx context.Context, b []byte, pos int64) (n int, er
 		firstPieceIndex := pieceIndex(r.torrentOffset(pos) / r.t.info.PieceLength)
 		firstPieceOffset := r.torrentOffset(pos) % r.t.info.PieceLength
 		b1 := missinggo.LimitLen(b, avail)
+		var verifies []int64
+		if r.ordered {
+			verifies = r.pieceVerifies(pos, int64(len(b1)))
+			if verifies == nil {
+				// Invalidated since it was available.
+				continue
+			}
+		}
 		n, err = r.t.readAt(b1, r.torrentOffset(pos))
+		if n != 0 && r.ordered && !int64sEqual(r.pieceVerifies(pos, int64(len(b1))), verifies) {
+			torrent.Add("ordered reads of invalidated pieces", 1)
+			n = 0
+			continue
+		}
 		if n != 0 {
 			err = nil
 			return
@@ -279,6 +310,105 @@ func (r *reader) readOnceAt(ctx context.Context, b []byte, pos int64) (n int, er
 	}
 }
 
+// Writes the rest of the data to the writer as it becomes available, a piece at a time straight from
+// storage where the storage supports it (see storage.SectionWriterTo). This lets io.Copy to a socket
+// or file avoid copying the data through a buffer, such as by using sendfile.
+func (r *reader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.ordered {
+		// Data can't be taken back from the writer if its piece is invalidated, so it's read and
+		// checked first. The Reader is wrapped so io.Copy doesn't call back here.
+		return io.Copy(w, struct{ io.Reader }{r})
+	}
+	ctx := context.Background()
+	for r.pos < r.length {
+		r.reading = true
+		r.mu.Lock()
+		r.posChanged()
+		r.mu.Unlock()
+		var n1 int64
+		n1, err = r.writeOnceTo(ctx, w, r.pos)
+		n += n1
+		r.mu.Lock()
+		r.pos += n1
+		r.posChanged()
+		r.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Waits for data at the position to be available, and writes what's available up to the end of its
+// piece from storage to w.
+func (r *reader) writeOnceTo(ctx context.Context, w io.Writer, pos int64) (n int64, err error) {
+	t := r.t
+	for {
+		var avail int64
+		avail, err = r.waitAvailable(ctx, pos, r.length-pos, true)
+		if avail == 0 {
+			return
+		}
+		off := r.torrentOffset(pos)
+		p := &t.pieces[off/t.info.PieceLength]
+		pieceOff := off - p.Info().Offset()
+		if left := p.Info().Length() - pieceOff; avail > left {
+			avail = left
+		}
+		p.waitNoPendingWrites()
+		n, err = p.Storage().WriteSectionTo(w, pieceOff, avail)
+		if err == nil {
+			return
+		}
+		if t.closed.IsSet() {
+			err = fmt.Errorf("reading from closed torrent: %w", err)
+			return
+		}
+		// Storage may have lost the data, in which case it's downloaded again and we wait for it.
+		// Otherwise the writer failed.
+		t.cl.lock()
+		lost := !t.closed.IsSet() && t.updatePieceCompletion(p.index)
+		t.cl.unlock()
+		if !lost {
+			return
+		}
+		r.log(log.Fstr("error writing torrent %s piece %d offset %d, %d bytes: %v",
+			t.infoHash.HexString(), p.index, pieceOff, avail, err))
+		err = nil
+		if n != 0 {
+			return
+		}
+	}
+}
+
+// Returns the verification counts of the pieces overlapping the reader range, or nil if any of
+// them aren't complete. Data in a complete piece can only change if it's verified again.
+func (r *reader) pieceVerifies(pos, length int64) (ret []int64) {
+	t := r.t
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	begin, end := t.byteRegionPieces(r.torrentOffset(pos), length)
+	for i := begin; i < end; i++ {
+		if !t.pieceComplete(i) {
+			return nil
+		}
+		ret = append(ret, t.piece(i).numVerifies)
+	}
+	return
+}
+
+func int64sEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Hodor
 func (r *reader) Close() error {
 	r.t.cl.lock()
@@ -312,6 +442,10 @@ func (r *reader) Seek(off int64, whence int) (newPos int64, err error) {
 	default:
 		return 0, errors.New("bad whence")
 	}
+	if newPos != r.pos && r.ordered && r.reading {
+		r.mu.Unlock()
+		return r.pos, errors.New("can't seek ordered reader after reading")
+	}
 	if newPos != r.pos {
 		r.reading = false
 		r.pos = newPos
@@ -1,7 +1,11 @@
 package torrent
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"net"
+	"os"
 	"testing"
 	"time"
 
@@ -24,3 +28,180 @@ func TestReaderReadContext(t *testing.T) {
 	_, err = r.ReadContext(ctx, make([]byte, 1))
 	require.EqualValues(t, context.DeadlineExceeded, err)
 }
+
+// Readers wait for data while downloading is disallowed, rather than failing.
+func TestReaderWaitsWhileDownloadDisallowed(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	defer tt.Drop()
+	tt.DisallowDataDownload()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := tt.NewReader()
+	defer r.Close()
+	_, err = r.ReadContext(ctx, make([]byte, 1))
+	require.EqualValues(t, context.DeadlineExceeded, err)
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingTempDir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	defer tt.Drop()
+	tt.VerifyData()
+	r := tt.NewReader()
+	defer r.Close()
+	_, err = r.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	// Copy to a socket, where the data can be sent straight from the file.
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	received := make(chan []byte)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer c.Close()
+		b, _ := io.ReadAll(c)
+		received <- b
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	n, err := io.Copy(c, r)
+	c.Close()
+	require.NoError(t, err)
+	require.EqualValues(t, len(testutil.GreetingFileContents)-2, n)
+	require.EqualValues(t, testutil.GreetingFileContents[2:], <-received)
+	// The reader is at the end.
+	n, err = r.(io.WriterTo).WriteTo(io.Discard)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, n)
+	// Ordered readers give the same data.
+	r = tt.NewReader()
+	defer r.Close()
+	r.(OrderedReader).SetOrdered()
+	var buf bytes.Buffer
+	n, err = r.(io.WriterTo).WriteTo(&buf)
+	require.NoError(t, err)
+	require.EqualValues(t, len(testutil.GreetingFileContents), n)
+	require.EqualValues(t, testutil.GreetingFileContents, buf.String())
+}
+
+func TestReaderOrdered(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	defer tt.Drop()
+	// Finish the initial checks, then write the first piece's data without verifying it.
+	tt.VerifyData()
+	require.NoError(t, tt.writeChunk(0, 0, []byte(testutil.GreetingFileContents[:5])))
+	cl.lock()
+	tt.piece(0).unpendChunkIndex(0)
+	cl.unlock()
+	b := make([]byte, 5)
+	responsive := tt.NewReader()
+	defer responsive.Close()
+	responsive.SetResponsive()
+	n, err := responsive.Read(b)
+	require.NoError(t, err)
+	require.EqualValues(t, "hello", b[:n])
+	// Ordered readers wait for the piece to be verified, even if responsive.
+	r := tt.NewReader()
+	defer r.Close()
+	r.SetResponsive()
+	r.(OrderedReader).SetOrdered()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = r.ReadContext(ctx, b)
+	require.Equal(t, context.DeadlineExceeded, err)
+	tt.Piece(0).VerifyData()
+	n, err = r.Read(b)
+	require.NoError(t, err)
+	require.EqualValues(t, "hello", b[:n])
+	// Having started reading, the reader can't be moved.
+	_, err = r.Seek(0, io.SeekStart)
+	require.Error(t, err)
+	pos, err := r.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, pos)
+}
+
+func TestReadRanges(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingTempDir
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	defer tt.Drop()
+	tt.VerifyData()
+	ranges := []ByteRange{{0, 5}, {11, 2}, {4, 3}, {12, 2}, {6, 0}}
+	rr := tt.ReadRanges(ranges)
+	defer rr.Close()
+	got := make(map[int]RangeData)
+	for {
+		rd, ok := rr.Next(context.Background())
+		if !ok {
+			break
+		}
+		got[rd.Index] = rd
+	}
+	require.Len(t, got, len(ranges))
+	for i, br := range ranges[:3] {
+		require.NoError(t, got[i].Err)
+		require.EqualValues(t, br, got[i].ByteRange)
+		require.EqualValues(t, testutil.GreetingFileContents[br.Offset:br.Offset+br.Length], got[i].Data)
+	}
+	require.Error(t, got[3].Err)
+	require.NoError(t, got[4].Err)
+	require.Empty(t, got[4].Data)
+}
+
+func TestReadRangesPrioritizesTogether(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(testutil.GreetingMetaInfo())
+	require.NoError(t, err)
+	defer tt.Drop()
+	tt.VerifyData()
+	rr := tt.ReadRanges([]ByteRange{{0, 2}, {11, 2}})
+	// All the ranges are wanted straight away, but not the pieces between them.
+	require.NotEqual(t, PiecePriorityNone, tt.PieceState(0).Priority)
+	require.Equal(t, PiecePriorityNone, tt.PieceState(1).Priority)
+	require.NotEqual(t, PiecePriorityNone, tt.PieceState(2).Priority)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, ok := rr.Next(ctx)
+	require.False(t, ok)
+	// Closing fails the outstanding reads.
+	rr.Close()
+	for i := 0; i < 2; i++ {
+		rd, ok := rr.Next(context.Background())
+		require.True(t, ok)
+		require.ErrorIs(t, rd.Err, context.Canceled)
+	}
+	_, ok = rr.Next(context.Background())
+	require.False(t, ok)
+	// The readers are gone once the reads end, so nothing is wanted.
+	require.Eventually(t, func() bool {
+		return tt.PieceState(0).Priority == PiecePriorityNone
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,93 @@
+package torrent
+
+// Identifies a remote client that may serve several of our Torrents.
+type remotePeerKey struct {
+	addr bannableAddr
+	id   PeerID
+}
+
+func (c *PeerConn) remotePeerKey() (_ remotePeerKey, ok bool) {
+	if !c.bannableAddr.Ok {
+		return
+	}
+	return remotePeerKey{c.bannableAddr.Value, c.PeerID}, true
+}
+
+func (cl *Client) addRemotePeerConn(c *PeerConn) {
+	key, ok := c.remotePeerKey()
+	if !ok {
+		return
+	}
+	if cl.remotePeerConns == nil {
+		cl.remotePeerConns = make(map[remotePeerKey]map[*PeerConn]struct{})
+	}
+	conns := cl.remotePeerConns[key]
+	if conns == nil {
+		conns = make(map[*PeerConn]struct{})
+		cl.remotePeerConns[key] = conns
+	}
+	conns[c] = struct{}{}
+	cl.remotePeerConnsChanged(key)
+}
+
+func (cl *Client) deleteRemotePeerConn(c *PeerConn) {
+	key, ok := c.remotePeerKey()
+	if !ok {
+		return
+	}
+	conns := cl.remotePeerConns[key]
+	if _, ok := conns[c]; !ok {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(cl.remotePeerConns, key)
+		return
+	}
+	cl.remotePeerConnsChanged(key)
+}
+
+// Request budgets for the other connections to the remote client may have changed.
+func (cl *Client) remotePeerConnsChanged(key remotePeerKey) {
+	conns := cl.remotePeerConns[key]
+	if len(conns) < 2 {
+		return
+	}
+	for c := range conns {
+		c.updateRequests("remote peer conns changed")
+	}
+}
+
+// The request budgets of the other connections to c's remote client depend on whether we're
+// interested in c.
+func (cl *Client) remotePeerInterestChanged(c *PeerConn) {
+	key, ok := c.remotePeerKey()
+	if !ok {
+		return
+	}
+	for other := range cl.remotePeerConns[key] {
+		if other != c {
+			other.updateRequests("remote peer interest changed")
+		}
+	}
+}
+
+// Splits the request budget evenly between connections to the same remote client that we're
+// interested in, so that one Torrent doesn't monopolize the link to that client.
+func (c *PeerConn) shareRequestBudget(budget maxRequests) maxRequests {
+	key, ok := c.remotePeerKey()
+	if !ok {
+		return budget
+	}
+	conns := c.t.cl.remotePeerConns[key]
+	if len(conns) < 2 {
+		return budget
+	}
+	n := 1
+	for other := range conns {
+		if other != c && other.requestState.Interested {
+			n++
+		}
+	}
+	return (budget + n - 1) / n
+}
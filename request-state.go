@@ -0,0 +1,50 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+)
+
+// An outstanding chunk request, see Torrent.RequestState.
+type OutstandingRequest struct {
+	Request
+	// When the request was sent.
+	Sent time.Time
+	// The peer the request was sent to. PeerID is zero for webseeds.
+	PeerID     PeerID
+	RemoteAddr string
+	Network    string
+	// Whether the peer is choking us. Requests to choking peers are only kept if it supports the
+	// fast extension, and may not be served until it unchokes.
+	PeerChoking bool
+}
+
+// Returns the outstanding chunk requests, ordered by piece and offset. This shows which peer each
+// request is waiting on, and for how long, when pieces aren't completing.
+func (t *Torrent) RequestState() (ret []OutstandingRequest) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	for ri, rs := range t.requestState {
+		or := OutstandingRequest{
+			Request:     t.requestIndexToRequest(ri),
+			Sent:        rs.when,
+			Network:     rs.peer.Network,
+			PeerChoking: rs.peer.peerChoking,
+		}
+		if rs.peer.RemoteAddr != nil {
+			or.RemoteAddr = rs.peer.RemoteAddr.String()
+		}
+		if pc, ok := rs.peer.TryAsPeerConn(); ok {
+			or.PeerID = pc.PeerID
+		}
+		ret = append(ret, or)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		l, r := ret[i], ret[j]
+		if l.Index != r.Index {
+			return l.Index < r.Index
+		}
+		return l.Begin < r.Begin
+	})
+	return
+}
@@ -23,6 +23,8 @@ type (
 
 func pieceOrderLess(i, j *pieceRequestOrderItem) multiless.Computation {
 	return multiless.New().Int(
+		int(j.state.TorrentPriority), int(i.state.TorrentPriority),
+	).Int(
 		int(j.state.Priority), int(i.state.Priority),
 		// TODO: Should we match on complete here to prevent churn when availability changes?
 	).Bool(
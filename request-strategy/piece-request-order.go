@@ -1,6 +1,9 @@
 package request_strategy
 
-import "github.com/anacrolix/torrent/metainfo"
+import (
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
+)
 
 type Btree interface {
 	Delete(pieceRequestOrderItem)
@@ -26,9 +29,12 @@ type PieceRequestOrderKey struct {
 }
 
 type PieceRequestOrderState struct {
-	Priority     piecePriority
-	Partial      bool
-	Availability int
+	// Pieces of higher priority Torrents sharing the order are requested first, regardless of
+	// their own priority.
+	TorrentPriority types.TorrentPriority
+	Priority        piecePriority
+	Partial         bool
+	Availability    int
 }
 
 type pieceRequestOrderItem struct {
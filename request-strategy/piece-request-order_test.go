@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/bradfitz/iter"
+
+	"github.com/anacrolix/torrent/types"
 )
 
 func benchmarkPieceRequestOrder[B Btree](
@@ -104,3 +106,19 @@ func BenchmarkPieceRequestOrder(b *testing.B) {
 		benchmarkPieceRequestOrder(b, NewAjwernerBtree, func(index int) {}, numPieces)
 	})
 }
+
+func TestPieceRequestOrderTorrentPriority(t *testing.T) {
+	pro := NewPieceOrder(NewAjwernerBtree(), 2)
+	low := PieceRequestOrderKey{InfoHash: [20]byte{1}}
+	high := PieceRequestOrderKey{InfoHash: [20]byte{2}}
+	pro.Add(low, PieceRequestOrderState{TorrentPriority: types.TorrentPriorityLow, Priority: types.PiecePriorityNow})
+	pro.Add(high, PieceRequestOrderState{TorrentPriority: types.TorrentPriorityHigh, Priority: types.PiecePriorityNormal})
+	var order []PieceRequestOrderKey
+	pro.tree.Scan(func(item pieceRequestOrderItem) bool {
+		order = append(order, item.key)
+		return true
+	})
+	if len(order) != 2 || order[0] != high || order[1] != low {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
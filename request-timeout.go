@@ -0,0 +1,121 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+// What's done with chunk requests that exceed SchedulerParams.RequestTimeout.
+type RequestTimeoutPolicy int
+
+const (
+	// Timed out requests are cancelled so that any peer can request the chunk, including the one it
+	// timed out on.
+	RequestTimeoutReassign RequestTimeoutPolicy = iota
+	// Timed out requests are cancelled, and the peer they timed out on won't request the chunk
+	// again until another RequestTimeout has passed.
+	RequestTimeoutReassignElsewhere
+	// Timed out requests are left with the peer, but other peers can take them over regardless of
+	// how many requests each has outstanding.
+	RequestTimeoutSteal
+)
+
+func (me RequestTimeoutPolicy) String() string {
+	switch me {
+	case RequestTimeoutReassign:
+		return "reassign"
+	case RequestTimeoutReassignElsewhere:
+		return "reassign elsewhere"
+	case RequestTimeoutSteal:
+		return "steal"
+	default:
+		return fmt.Sprintf("RequestTimeoutPolicy(%d)", int(me))
+	}
+}
+
+func (me RequestTimeoutPolicy) MarshalText() ([]byte, error) {
+	return []byte(me.String()), nil
+}
+
+// How often outstanding requests are checked against SchedulerParams.RequestTimeout. This bounds
+// how late a timeout can be applied.
+const requestTimeoutCheckInterval = 100 * time.Millisecond
+
+func (cl *Client) requestTimeoutLoop() {
+	ticker := time.NewTicker(requestTimeoutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.lock()
+			for _, t := range cl.torrents {
+				t.timeOutRequests(now)
+			}
+			cl.unlock()
+		}
+	}
+}
+
+// Whether the request has been outstanding longer than SchedulerParams.RequestTimeout.
+func (t *Torrent) requestTimedOut(r RequestIndex, now time.Time) bool {
+	timeout := t.schedulerParams().RequestTimeout
+	if timeout <= 0 {
+		return false
+	}
+	rs, ok := t.requestState[r]
+	return ok && now.Sub(rs.when) >= timeout
+}
+
+// Applies the RequestTimeoutPolicy to requests that have timed out. Must be called with the Client
+// lock held.
+func (t *Torrent) timeOutRequests(now time.Time) {
+	params := t.schedulerParams()
+	if params.RequestTimeout <= 0 {
+		return
+	}
+	t.iterPeers(func(p *Peer) {
+		p.expireRequestTimeoutExclusions(now, params.RequestTimeout)
+	})
+	if params.RequestTimeoutPolicy == RequestTimeoutSteal {
+		// Handled as peers update their requests.
+		return
+	}
+	var timedOut []RequestIndex
+	for r, rs := range t.requestState {
+		if now.Sub(rs.when) >= params.RequestTimeout {
+			timedOut = append(timedOut, r)
+		}
+	}
+	if len(timedOut) == 0 {
+		return
+	}
+	for _, r := range timedOut {
+		p := t.cancelRequest(r)
+		if params.RequestTimeoutPolicy == RequestTimeoutReassignElsewhere {
+			if p.requestTimeoutExclusions == nil {
+				p.requestTimeoutExclusions = make(map[RequestIndex]time.Time)
+			}
+			p.requestTimeoutExclusions[r] = now
+		}
+	}
+	torrent.Add("chunk requests timed out", int64(len(timedOut)))
+	t.iterPeers(func(p *Peer) {
+		p.updateRequests("requests timed out")
+	})
+}
+
+// Lets the peer request chunks again once they timed out on it more than timeout ago.
+func (p *Peer) expireRequestTimeoutExclusions(now time.Time, timeout time.Duration) {
+	expired := false
+	for r, when := range p.requestTimeoutExclusions {
+		if now.Sub(when) >= timeout {
+			delete(p.requestTimeoutExclusions, r)
+			expired = true
+		}
+	}
+	if expired {
+		p.updateRequests("request timeout exclusions expired")
+	}
+}
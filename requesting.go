@@ -19,9 +19,10 @@ import (
 
 func (t *Torrent) requestStrategyPieceOrderState(i int) request_strategy.PieceRequestOrderState {
 	return request_strategy.PieceRequestOrderState{
-		Priority:     t.piece(i).purePriority(),
-		Partial:      t.piecePartiallyDownloaded(i),
-		Availability: t.piece(i).availability(),
+		TorrentPriority: t.priority,
+		Priority:        t.piece(i).purePriority(),
+		Partial:         t.piecePartiallyDownloaded(i),
+		Availability:    t.piece(i).availability(),
 	}
 }
 
@@ -190,6 +191,12 @@ func (p *Peer) getDesiredRequestState() (desired desiredRequestState) {
 	if t.closed.IsSet() {
 		return
 	}
+	if t.transfersPaused() {
+		return
+	}
+	if pc, ok := p.peerImpl.(*PeerConn); ok && !pc.payloadCryptReady() {
+		return
+	}
 	input := t.getRequestStrategyInput()
 	requestHeap := desiredPeerRequests{
 		peer:           p,
@@ -229,6 +236,9 @@ func (p *Peer) getDesiredRequestState() (desired desiredRequestState) {
 					// Can't re-request while awaiting acknowledgement.
 					return
 				}
+				if _, ok := p.requestTimeoutExclusions[r]; ok {
+					return
+				}
 
 				// In our Baseline provider model, we assume baseline provider would have all the pieces and always available.
 				// Therefore, BP would only handle the cases where piece Availability is 1, that is only itself have the piece.
@@ -292,9 +302,16 @@ func (p *Peer) applyRequestState(next desiredRequestState) {
 		if existing != nil && existing != p {
 			// Don't steal from the poor.
 			diff := int64(current.Requests.GetCardinality()) + 1 - (int64(existing.uncancelledRequests()) - 1)
-			// Steal a request that leaves us with one more request than the existing peer
-			// connection if the stealer more recently received a chunk.
-			if diff > 1 || (diff == 1 && p.lastUsefulChunkReceived.Before(existing.lastUsefulChunkReceived)) {
+			if t.requestTimedOut(req, time.Now()) {
+				// The existing peer has had its chance. See RequestTimeoutSteal.
+			} else if t.completionSLOEscalated() {
+				// Behind on a completion deadline, so requests go to whoever is delivering.
+				if !p.lastUsefulChunkReceived.After(existing.lastUsefulChunkReceived) {
+					continue
+				}
+			} else if diff > 1 || (diff == 1 && p.lastUsefulChunkReceived.Before(existing.lastUsefulChunkReceived)) {
+				// Steal a request that leaves us with one more request than the existing peer
+				// connection if the stealer more recently received a chunk.
 				continue
 			}
 			t.cancelRequest(req)
@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const scheduledVerificationCheckInterval = time.Minute
+
+type ScheduledVerificationEvent struct {
+	Torrent  *Torrent
+	Started  time.Time
+	Finished time.Time
+	// Pieces that were complete before the verification and failed it. Their data will be
+	// downloaded again if wanted.
+	FailedPieces []int
+}
+
+// Per-Torrent state for scheduled verification.
+type scheduledVerificationState struct {
+	// Set by Torrent.SetScheduledVerificationInterval. Zero for the ClientConfig default, negative
+	// to disable.
+	interval time.Duration
+	// When the data was last fully verified. Data is verified when it's added.
+	last    time.Time
+	running bool
+}
+
+// Sets how often the Torrent's data is fully re-verified once it's complete, overriding
+// ClientConfig.ScheduledVerificationInterval. Zero restores the default, and a negative interval
+// disables scheduled verification for the Torrent.
+func (t *Torrent) SetScheduledVerificationInterval(interval time.Duration) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.scheduledVerification.interval = interval
+	if interval > 0 {
+		t.cl.startScheduledVerification()
+	}
+}
+
+func (t *Torrent) scheduledVerificationInterval() time.Duration {
+	if i := t.scheduledVerification.interval; i != 0 {
+		return i
+	}
+	return t.cl.config.ScheduledVerificationInterval
+}
+
+// When the Torrent is due for verification, and false if it isn't scheduled. Each Torrent is
+// offset by up to a quarter of the interval, derived from its infohash, so that Torrents added
+// together don't come due together.
+func (t *Torrent) scheduledVerificationDue() (time.Time, bool) {
+	interval := t.scheduledVerificationInterval()
+	if interval <= 0 || !t.haveInfo() || !t.haveAllPieces() || t.scheduledVerification.running {
+		return time.Time{}, false
+	}
+	last := t.scheduledVerification.last
+	if last.IsZero() {
+		last = t.joinTimes.Added
+	}
+	stagger := time.Duration(binary.BigEndian.Uint64(t.infoHash[:8]) % uint64(interval/4+1))
+	return last.Add(interval + stagger), true
+}
+
+// Must be called with the Client lock held.
+func (cl *Client) startScheduledVerification() {
+	if cl.scheduledVerificationStarted {
+		return
+	}
+	cl.scheduledVerificationStarted = true
+	go cl.scheduledVerificationLoop()
+}
+
+func (cl *Client) scheduledVerificationLoop() {
+	ticker := time.NewTicker(scheduledVerificationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.runScheduledVerifications(now)
+		}
+	}
+}
+
+// Verifies the Torrents that are due, one at a time and most overdue first, to bound the disk
+// load.
+func (cl *Client) runScheduledVerifications(now time.Time) {
+	type due struct {
+		t    *Torrent
+		when time.Time
+	}
+	var dues []due
+	cl.rLock()
+	for _, t := range cl.torrents {
+		if when, ok := t.scheduledVerificationDue(); ok && !when.After(now) {
+			dues = append(dues, due{t, when})
+		}
+	}
+	cl.rUnlock()
+	sort.Slice(dues, func(i, j int) bool {
+		return dues[i].when.Before(dues[j].when)
+	})
+	for _, d := range dues {
+		select {
+		case <-cl.closed.Done():
+			return
+		default:
+		}
+		d.t.runScheduledVerification()
+	}
+}
+
+func (t *Torrent) runScheduledVerification() {
+	t.cl.lock()
+	if t.closed.IsSet() || t.scheduledVerification.running {
+		t.cl.unlock()
+		return
+	}
+	t.scheduledVerification.running = true
+	var complete []int
+	for i := 0; i < t.numPieces(); i++ {
+		if t.pieceComplete(i) {
+			complete = append(complete, i)
+		}
+	}
+	t.cl.unlock()
+	e := ScheduledVerificationEvent{
+		Torrent: t,
+		Started: time.Now(),
+	}
+	t.logger.Levelf(log.Info, "starting scheduled verification")
+	t.VerifyData()
+	e.Finished = time.Now()
+	t.cl.lock()
+	t.scheduledVerification.running = false
+	t.scheduledVerification.last = e.Finished
+	for _, i := range complete {
+		if !t.pieceComplete(i) {
+			e.FailedPieces = append(e.FailedPieces, i)
+		}
+	}
+	t.cl.unlock()
+	torrent.Add("scheduled verifications", 1)
+	if len(e.FailedPieces) != 0 {
+		torrent.Add("scheduled verification failed pieces", int64(len(e.FailedPieces)))
+		t.logger.Levelf(log.Warning, "scheduled verification failed %v pieces", len(e.FailedPieces))
+	}
+	for _, f := range t.cl.config.Callbacks.ScheduledVerification {
+		f(e)
+	}
+}
@@ -0,0 +1,109 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+	trHttp "github.com/anacrolix/torrent/tracker/http"
+	"github.com/anacrolix/torrent/tracker/udp"
+)
+
+// The most infohashes to scrape in a single request. UDP trackers can't fit many more in a packet
+// (BEP 15), and HTTP trackers may limit the length of request URLs.
+const maxScrapeInfohashes = 74
+
+// Scrapes the tracker for swarm statistics of each infohash, without announcing. Both HTTP (BEP
+// 48) and UDP (BEP 15) trackers are supported. Results are in the same order as the infohashes, and
+// infohashes the tracker doesn't know about get zero counts. The error is for the tracker as a
+// whole, such as when it's unreachable or doesn't support scraping.
+func (cl *Client) ScrapeTracker(ctx context.Context, url string, ihs []metainfo.Hash) (ret []TrackerScrapeResult, err error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return
+	}
+	tc, err := tracker.NewClient(url, tracker.NewClientOpts{
+		Http: trHttp.NewClientOpts{
+			Proxy:       cl.config.HTTPProxy,
+			DialContext: cl.config.TrackerDialContext,
+			Header:      cl.trackerHttpHeader(u),
+		},
+		Logger:       cl.logger.WithContextValue(fmt.Sprintf("tracker client for %q", url)),
+		ListenPacket: cl.config.TrackerListenPacket,
+	})
+	if err != nil {
+		return
+	}
+	defer tc.Close()
+	scraper, ok := tc.(tracker.Scraper)
+	if !ok {
+		err = fmt.Errorf("scrape not supported for %q", url)
+		return
+	}
+	for len(ihs) != 0 {
+		batch := ihs
+		if len(batch) > maxScrapeInfohashes {
+			batch = batch[:maxScrapeInfohashes]
+		}
+		ihs = ihs[len(batch):]
+		req := make([]udp.InfoHash, 0, len(batch))
+		for _, ih := range batch {
+			req = append(req, ih)
+		}
+		var res tracker.ScrapeResponse
+		res, err = scraper.Scrape(ctx, req)
+		if err != nil {
+			return
+		}
+		// UDP trackers may return fewer results than requested. The rest get zero counts.
+		for i, ih := range batch {
+			tsr := TrackerScrapeResult{
+				Url:      url,
+				InfoHash: ih,
+			}
+			if i < len(res) {
+				tsr.Seeders = res[i].Seeders
+				tsr.Leechers = res[i].Leechers
+				tsr.Completed = res[i].Completed
+			}
+			ret = append(ret, tsr)
+		}
+	}
+	return
+}
+
+// Scrapes each of the Torrent's trackers concurrently for the swarm's seeder, leecher and
+// completed counts, without announcing. Trackers that can't be scraped, including WebTorrent
+// trackers, have Err set. See also Client.PreviewTorrent, which doesn't need the Torrent added.
+func (t *Torrent) Scrape(ctx context.Context) (ret []TrackerScrapeResult) {
+	t.cl.rLock()
+	tiers := t.metainfo.UpvertedAnnounceList()
+	t.cl.rUnlock()
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	seen := make(map[string]bool)
+	for _, tier := range tiers {
+		for _, url := range tier {
+			if url == "" || seen[url] {
+				continue
+			}
+			seen[url] = true
+			url := url
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := t.cl.scrapeTracker(ctx, url, t.infoHash)
+				mu.Lock()
+				ret = append(ret, res)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	return
+}
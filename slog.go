@@ -0,0 +1,87 @@
+package torrent
+
+import (
+	"strings"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/exp/slog"
+)
+
+// Passes the Client's log records to ClientConfig.Slogger. Values attached to messages that are
+// slog.Attrs, such as from Torrent.logAttrs, are passed through as structured fields, and left out
+// of the message. Other values only appear in the text, as with the default handlers.
+type slogHandler struct {
+	l *slog.Logger
+}
+
+func (me slogHandler) Handle(r log.Record) {
+	level := slogLevel(r.Level)
+	if !me.l.Enabled(level) {
+		return
+	}
+	var attrs []slog.Attr
+	if len(r.Names) != 0 {
+		attrs = append(attrs, slog.String("names", strings.Join(r.Names, "/")))
+	}
+	text, haveText := "", false
+	r.Values(func(v interface{}) bool {
+		switch v := v.(type) {
+		case slog.Attr:
+			attrs = append(attrs, v)
+		case slogText:
+			text, haveText = string(v), true
+		}
+		return true
+	})
+	if !haveText {
+		text = r.Text()
+	}
+	me.l.LogAttrs(level, text, attrs...)
+}
+
+func slogLevel(level log.Level) slog.Level {
+	switch level {
+	case log.Debug:
+		return slog.LevelDebug
+	case log.Warning:
+		return slog.LevelWarn
+	case log.Error:
+		return slog.LevelError
+	case log.Critical:
+		return slog.LevelError + 4
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Logs text at the level with structured fields for ClientConfig.Slogger, and the Torrent's
+// infohash. The text shouldn't repeat the fields, as other handlers show them after it.
+func (t *Torrent) logAttrs(level log.Level, text string, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{slog.String("infohash", t.infoHash.HexString())}, attrs...)
+	t.logger.LogLevel(level, attrsMsg(text, attrs).Skip(1))
+}
+
+// Logs text at the level with structured fields for ClientConfig.Slogger.
+func (cl *Client) logAttrs(level log.Level, text string, attrs ...slog.Attr) {
+	cl.logger.LogLevel(level, attrsMsg(text, attrs).Skip(1))
+}
+
+// The text of a message from logAttrs, without its fields.
+type slogText string
+
+func attrsMsg(text string, attrs []slog.Attr) log.Msg {
+	values := make([]interface{}, 0, len(attrs)+1)
+	values = append(values, slogText(text))
+	for _, a := range attrs {
+		values = append(values, a)
+	}
+	return log.Str(text).AddValues(values...).WithText(func(log.Msg) string {
+		var sb strings.Builder
+		sb.WriteString(text)
+		for _, a := range attrs {
+			sb.WriteByte(' ')
+			sb.WriteString(a.String())
+		}
+		return sb.String()
+	})
+}
@@ -2,8 +2,11 @@ package torrent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/anacrolix/log"
 
@@ -57,7 +60,11 @@ func (t *Torrent) useActiveTorrentSource(source string) error {
 	if err != nil {
 		return err
 	}
-	return t.MergeSpec(TorrentSpecFromMetaInfo(&mi))
+	err = t.MergeSpec(TorrentSpecFromMetaInfo(&mi))
+	if err == nil {
+		t.cacheMetainfo(mi)
+	}
+	return err
 }
 
 func getTorrentSource(ctx context.Context, source string, hc *http.Client) (mi metainfo.MetaInfo, err error) {
@@ -77,3 +84,100 @@ func getTorrentSource(ctx context.Context, source string, hc *http.Client) (mi m
 	err = bencode.NewDecoder(resp.Body).Decode(&mi)
 	return
 }
+
+// Used for MetadataSource when Timeout isn't set.
+const defaultMetadataSourceTimeout = time.Minute
+
+// A fallback for obtaining the info for a Torrent added without it, such as from a magnet link.
+// Exactly one of Url, CacheDir and PeerAddr should be set.
+type MetadataSource struct {
+	// An HTTP endpoint serving the metainfo, like the "xs" field in magnet links.
+	Url string
+	// A directory containing metainfo files named by infohash, as in "<hex infohash>.torrent".
+	CacheDir string
+	// A peer address ("host:port") to obtain the info from using ut_metadata.
+	PeerAddr string
+	// How long to try the source before moving on to the next.
+	Timeout time.Duration
+}
+
+func (me MetadataSource) String() string {
+	switch {
+	case me.Url != "":
+		return me.Url
+	case me.CacheDir != "":
+		return "cache dir " + me.CacheDir
+	default:
+		return "peer " + me.PeerAddr
+	}
+}
+
+// Tries each source in order, with its timeout, until the info is available. This improves the
+// reliability of adding magnet links, where otherwise the info might only be available from peers
+// found through trackers and the DHT.
+func (t *Torrent) UseMetadataSources(sources []MetadataSource) {
+	if len(sources) == 0 {
+		return
+	}
+	go func() {
+		for _, s := range sources {
+			select {
+			case <-t.Closed():
+				return
+			case <-t.GotInfo():
+				return
+			default:
+			}
+			err := t.useMetadataSource(s)
+			level := log.Debug
+			if err != nil {
+				level = log.Warning
+			}
+			t.logger.Levelf(level, "used metadata source %v [err=%v]", s, err)
+		}
+	}()
+}
+
+func (t *Torrent) useMetadataSource(s MetadataSource) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultMetadataSourceTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var mi metainfo.MetaInfo
+	switch {
+	case s.Url != "":
+		var err error
+		mi, err = getTorrentSource(ctx, s.Url, t.cl.httpClient)
+		if err != nil {
+			return err
+		}
+	case s.CacheDir != "":
+		cached, err := metainfo.LoadFromFile(filepath.Join(s.CacheDir, t.infoHash.HexString()+".torrent"))
+		if err != nil {
+			return err
+		}
+		mi = *cached
+	case s.PeerAddr != "":
+		t.cl.lock()
+		t.addPeer(PeerInfo{
+			Addr:    stringAddr(s.PeerAddr),
+			Source:  PeerSourceDirect,
+			Trusted: true,
+		})
+		t.maybeNewConns()
+		t.cl.unlock()
+		return t.WaitForInfo(ctx)
+	default:
+		return errors.New("empty metadata source")
+	}
+	if mi.HashInfoBytes() != t.infoHash {
+		return fmt.Errorf("metainfo has infohash %v", mi.HashInfoBytes())
+	}
+	err := t.MergeSpec(TorrentSpecFromMetaInfo(&mi))
+	if err == nil && s.Url != "" {
+		t.cacheMetainfo(mi)
+	}
+	return err
+}
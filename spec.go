@@ -26,12 +26,18 @@ type TorrentSpec struct {
 	PeerAddrs []string
 	// The combination of the "xs" and "as" fields in magnet links, for now.
 	Sources []string
+	// Fallbacks for obtaining the info, tried in order until it's available. See MetadataSource.
+	MetadataSources []MetadataSource
+	// BEP 35 signatures of InfoBytes. See ClientConfig.TrustedPublishers.
+	Signatures map[string]metainfo.Signature
 
 	// The chunk size to use for outbound requests. Defaults to 16KiB if not set. Can only be set
 	// for new Torrents. TODO: Move into a "new" Torrent opt type.
 	ChunkSize pp.Integer
 	// TODO: Move into a "new" Torrent opt type.
 	Storage storage.ClientImpl
+	// See Torrent.SetPreSharedKey. Can only be set for new Torrents.
+	PreSharedKey []byte
 
 	DisableInitialPieceCheck bool
 
@@ -70,6 +76,7 @@ func TorrentSpecFromMetaInfoErr(mi *metainfo.MetaInfo) (*TorrentSpec, error) {
 		InfoBytes:   mi.InfoBytes,
 		DisplayName: info.Name,
 		Webseeds:    mi.UrlList,
+		Signatures:  mi.Signatures,
 		DhtNodes: func() (ret []string) {
 			ret = make([]string, 0, len(mi.Nodes))
 			for _, node := range mi.Nodes {
@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/exp/slog"
+
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+const (
+	// Stats reports are held off for at most this many report intervals after failures.
+	maxStatsReportBackoffIntervals = 32
+	// And at most this long, unless the report interval is longer.
+	maxStatsReportBackoff = 5 * time.Minute
+)
+
+// Holds off stats reports after failures, so that trackers that are down aren't retried every
+// interval. The delay doubles with each consecutive failure up to a cap, and is jittered so that
+// reporters that failed together don't retry together. Reports resume at the usual interval once
+// one succeeds.
+type statsReportBackoff struct {
+	failures int
+	retryAt  time.Time
+}
+
+func (me *statsReportBackoff) ready(now time.Time) bool {
+	return !now.Before(me.retryAt)
+}
+
+// Records a failed report and schedules the next attempt.
+func (me *statsReportBackoff) failed(now time.Time, interval time.Duration) {
+	me.failures++
+	limit := interval * maxStatsReportBackoffIntervals
+	if limit > maxStatsReportBackoff {
+		limit = maxStatsReportBackoff
+	}
+	if limit < interval {
+		limit = interval
+	}
+	delay := interval
+	for i := 1; i < me.failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	// Half the delay is random.
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	me.retryAt = now.Add(delay)
+}
+
+// Clears the backoff after a successful report, returning how many failures preceded it.
+func (me *statsReportBackoff) succeeded() (failures int) {
+	failures = me.failures
+	*me = statsReportBackoff{}
+	return
+}
+
+// Whether the Torrent's stats report is due, or it's backing off after failures.
+func (t *Torrent) statsReportReady(now time.Time) bool {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.statsReportBackoff.ready(now)
+}
+
+// Updates the Torrent's stats report backoff from the result of Torrent.reportStats.
+func (t *Torrent) statsReportDone(err error) {
+	if err == httpTracker.ErrReportNotSupported {
+		return
+	}
+	t.cl.lock()
+	defer t.cl.unlock()
+	if err == nil {
+		if n := t.statsReportBackoff.succeeded(); n != 0 {
+			t.logAttrs(log.Info, "stats reports resumed",
+				slog.Int("failures", n))
+		}
+		return
+	}
+	t.statsReportsFailed++
+	if t.statsReportBackoff.failures == 0 {
+		t.logAttrs(log.Warning, "stats report failed, backing off",
+			slog.Any("error", err), slog.Duration("interval", t.cl.settings.statsReportInterval))
+	} else {
+		t.logAttrs(log.Debug, "stats report failed",
+			slog.Any("error", err), slog.Int("failures", t.statsReportBackoff.failures))
+	}
+	t.statsReportBackoff.failed(time.Now(), t.cl.settings.statsReportInterval)
+}
@@ -0,0 +1,83 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/bencode"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+func statsReportQueuePath(dir string) string {
+	return filepath.Join(dir, "stats-reports.queue")
+}
+
+// Loads reports queued by a previous Client from ClientConfig.StatsReportQueueDir. The queue is a
+// bencoded list of report bodies from StatsReport.MarshalBody, so queued reports carry the body
+// version they were made with.
+func loadStatsReportQueue(dir string) (ret []httpTracker.StatsReport, err error) {
+	b, err := os.ReadFile(statsReportQueuePath(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	var bodies [][]byte
+	err = bencode.Unmarshal(b, &bodies)
+	if err != nil {
+		return nil, fmt.Errorf("parsing queue: %w", err)
+	}
+	for _, body := range bodies {
+		r, err := httpTracker.ParseStatsReportBody(body)
+		if err != nil {
+			return ret, fmt.Errorf("parsing queued report %v: %w", len(ret), err)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// Replaces the queue on disk. The file is removed when the queue is empty.
+func saveStatsReportQueue(dir string, queue []httpTracker.StatsReport) error {
+	if len(queue) == 0 {
+		err := os.Remove(statsReportQueuePath(dir))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	bodies := make([][]byte, 0, len(queue))
+	for _, r := range queue {
+		body, err := r.MarshalBody()
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, body)
+	}
+	b, err := bencode.Marshal(bodies)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a partial queue.
+	f, err := os.CreateTemp(dir, "stats-reports.*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), statsReportQueuePath(dir))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,498 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/chansync"
+	"github.com/anacrolix/log"
+	"golang.org/x/exp/slog"
+
+	"github.com/anacrolix/torrent/metainfo"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+// Adds the data uploaded over a closed connection to the per-peer totals for stats reports.
+func (t *Torrent) recordPeerUpload(c *PeerConn) {
+	n := c._stats.BytesWrittenData.Int64()
+	if n == 0 {
+		return
+	}
+	if t.uploadedToPeers == nil {
+		t.uploadedToPeers = make(map[PeerID]int64)
+	}
+	t.uploadedToPeers[c.PeerID] += n
+}
+
+// Bytes of piece data uploaded to each remote peer, by peer ID, over past and current
+// connections.
+func (t *Torrent) uploadedToPeersLocked() map[[20]byte]int64 {
+	ret := make(map[[20]byte]int64, len(t.uploadedToPeers)+len(t.conns))
+	for id, n := range t.uploadedToPeers {
+		ret[id] = n
+	}
+	for c := range t.conns {
+		if n := c._stats.BytesWrittenData.Int64(); n != 0 {
+			ret[c.PeerID] += n
+		}
+	}
+	return ret
+}
+
+// Makes the next stats report, with deltas since the last report that was delivered or queued for
+// delivery. The returned sample is passed to the sampler's advanceLocked when that happens, so
+// reports that fail don't lose their deltas. Durations are taken from the monotonic clock.
+func (t *Torrent) nextStatsReportLocked() (httpTracker.StatsReport, TorrentStatsSample) {
+	now := time.Now()
+	if t.statsReportSampler == nil {
+		t.statsReportSampler = &TorrentStatsSampler{t: t}
+	}
+	sample := t.statsReportSampler.peekLocked(now)
+	return httpTracker.StatsReport{
+		InfoHash:        t.infoHash,
+		PeerId:          t.cl.peerID,
+		Downloaded:      sample.Total.BytesReadUsefulData.Int64(),
+		Uploaded:        sample.Total.BytesWrittenData.Int64(),
+		UploadedDelta:   sample.Delta.BytesWrittenData.Int64(),
+		DownloadedDelta: sample.Delta.BytesReadUsefulData.Int64(),
+		Left:            t.bytesLeftAnnounce(),
+		UploadedTo:      t.uploadedToPeersLocked(),
+		HashFailures:    t.hashFailuresByPeerLocked(),
+		Receipts:        t.marshalledUploadReceiptsLocked(),
+		Time:            now.Unix(),
+		Elapsed:         now.Sub(t.joinTimes.Added),
+		Interval:        sample.Interval,
+		ActivePeers:     int64(t.numActivePeers()),
+		Pieces:          t.statsReportPiecesLocked(),
+		ExperimentId:    t.cl.config.ExperimentId,
+	}, sample
+}
+
+// Makes the next stats report, for the queue of reports that are delivered at least once.
+func (t *Torrent) takeStatsReportLocked() httpTracker.StatsReport {
+	r, sample := t.nextStatsReportLocked()
+	t.statsReportSampler.advanceLocked(sample)
+	return r
+}
+
+func (t *Torrent) statsReportPiecesLocked() (ret httpTracker.StatsReportPieces) {
+	if !t.haveInfo() {
+		return
+	}
+	ret.Total = int64(t.numPieces())
+	ret.Complete = int64(t.numPiecesCompleted())
+	for i := range t.pieces {
+		if !t.pieceComplete(i) && t.piece(i).availability() == 0 {
+			ret.Unavailable++
+		}
+	}
+	ret.DistributedCopiesMilli = int64(t.distributedCopies() * 1000)
+	return
+}
+
+// Accounts for a report that a tracker accepted.
+func (t *Torrent) statsReportDelivered(r httpTracker.StatsReport) {
+	t.reportedUploadBytes += r.UploadedDelta
+}
+
+// Returns the upload bytes covered by stats reports that trackers have accepted. This is the sum
+// of StatsReport.UploadedDelta over delivered reports, so it trails the Torrent's upload total by
+// what's been uploaded since the last report, and by reports that couldn't be delivered.
+func (t *Torrent) ReportedUploadBytes() int64 {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.reportedUploadBytes
+}
+
+// The HTTP trackers to send stats reports to, which are ClientConfig.StatsReportTrackers if set.
+func (t *Torrent) statsReportTrackerUrls() (ret []*url.URL) {
+	if urls := t.cl.config.StatsReportTrackers; len(urls) != 0 {
+		return parseHttpTrackerUrls(urls)
+	}
+	return parseHttpTrackerUrls(t.metainfo.UpvertedAnnounceList().DistinctValues())
+}
+
+// Parses the HTTP tracker URLs, dropping duplicates and those with other schemes.
+func parseHttpTrackerUrls(urls []string) (ret []*url.URL) {
+	add := func(s string) {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		for _, have := range ret {
+			if *have == *u {
+				return
+			}
+		}
+		ret = append(ret, u)
+	}
+	for _, s := range urls {
+		add(s)
+	}
+	return
+}
+
+func (cl *Client) newHttpTrackerClient(u *url.URL) httpTracker.Client {
+	return httpTracker.NewClient(u, httpTracker.NewClientOpts{
+		Proxy:       cl.config.HTTPProxy,
+		DialContext: cl.config.TrackerDialContext,
+		ServerName:  u.Hostname(),
+		Header:      cl.trackerHttpHeader(u),
+	})
+}
+
+func (cl *Client) httpTrackerReportOpt() httpTracker.ReportOpt {
+	return httpTracker.ReportOpt{
+		UserAgent:           cl.config.HTTPUserAgent,
+		HttpRequestDirector: cl.config.HttpRequestDirector,
+	}
+}
+
+// Stats reports go here if there's nowhere better. This is where the ReliableBT tracker listens in
+// local deployments.
+const fallbackStatsReportURL = "http://127.0.0.1:1337/download"
+
+// Sets the endpoint that the Torrent's stats reports are sent to, overriding
+// ClientConfig.StatsReportURL and the Torrent's trackers. An empty URL removes the override.
+// Reports are sent to ClientConfig.StatsReportTrackers instead, if that's set.
+func (t *Torrent) SetStatsReportURL(u string) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.statsReportURL = u
+}
+
+type statsReportTarget struct {
+	u        *url.URL
+	endpoint bool
+}
+
+// Where the Torrent's stats reports are sent, in order of preference: the URL set with
+// SetStatsReportURL, ClientConfig.StatsReportURL, the report counterparts of the Torrent's HTTP
+// trackers, or fallbackStatsReportURL.
+func (t *Torrent) statsReportTargets() (ret []statsReportTarget) {
+	for _, s := range []string{t.statsReportURL, t.cl.config.StatsReportURL} {
+		if s == "" {
+			continue
+		}
+		for _, u := range parseHttpTrackerUrls([]string{s}) {
+			return []statsReportTarget{{u, true}}
+		}
+		t.logAttrs(log.Warning, "bad stats report URL", slog.String("url", s))
+	}
+	for _, u := range t.statsReportTrackerUrls() {
+		ret = append(ret, statsReportTarget{u, false})
+	}
+	if len(ret) == 0 {
+		for _, u := range parseHttpTrackerUrls([]string{fallbackStatsReportURL}) {
+			ret = append(ret, statsReportTarget{u, true})
+		}
+	}
+	return
+}
+
+// Sends the Torrent's stats report to each of its targets, returning the response from the first
+// that accepted it. An error is returned only if none did, in which case it's from the last target
+// that failed, or ErrReportNotSupported if none support reports. Reports in flight are abandoned
+// if the Torrent is dropped.
+func (t *Torrent) reportStats(ctx context.Context) (ret httpTracker.ReportResponse, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closed.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	t.cl.lock()
+	if t.closed.IsSet() {
+		t.cl.unlock()
+		err = errors.New("torrent closed")
+		return
+	}
+	report, sample := t.nextStatsReportLocked()
+	targets := t.statsReportTargets()
+	t.cl.unlock()
+	err = httpTracker.ErrReportNotSupported
+	delivered := false
+	for _, target := range targets {
+		u := target.u
+		tc := t.cl.newHttpTrackerClient(u)
+		ctx, cancel := context.WithTimeout(ctx, statsReportTimeout)
+		opt := t.cl.httpTrackerReportOpt()
+		opt.Endpoint = target.endpoint
+		resp, reportErr := tc.Report(ctx, report, opt)
+		cancel()
+		tc.Close()
+		switch reportErr {
+		case nil:
+			torrent.Add("stats reports sent", 1)
+			t.logAttrs(log.Debug, "reported stats",
+				append(statsReportAttrs(report)[1:], slog.String("url", u.String()))...)
+			t.cl.lock()
+			t.setTrackerReportedPeers(resp.Peers)
+			if !delivered {
+				t.statsReportSampler.advanceLocked(sample)
+				t.statsReportDelivered(report)
+				ret = resp
+			}
+			t.cl.unlock()
+			delivered = true
+		case httpTracker.ErrReportNotSupported:
+		default:
+			torrent.Add("stats report errors", 1)
+			err = fmt.Errorf("reporting stats to %q: %w", u, reportErr)
+		}
+	}
+	if delivered {
+		err = nil
+	}
+	return
+}
+
+// Fetches the Torrent's contribution leaderboard from the first of its HTTP trackers that serves
+// one. Leaderboards are built by trackers from stats reports, see ClientConfig.StatsReportInterval.
+func (t *Torrent) Leaderboard(ctx context.Context) ([]httpTracker.LeaderboardEntry, error) {
+	t.cl.rLock()
+	urls := t.statsReportTrackerUrls()
+	t.cl.rUnlock()
+	err := httpTracker.ErrLeaderboardNotSupported
+	for _, u := range urls {
+		tc := t.cl.newHttpTrackerClient(u)
+		var entries []httpTracker.LeaderboardEntry
+		entries, err = tc.Leaderboard(ctx, t.infoHash, t.cl.httpTrackerReportOpt())
+		tc.Close()
+		if err == nil {
+			return entries, nil
+		}
+		if err != httpTracker.ErrLeaderboardNotSupported {
+			t.logAttrs(log.Debug, "error getting leaderboard",
+				slog.String("url", u.String()), slog.Any("error", err))
+		}
+	}
+	return nil, err
+}
+
+// Reports to each tracker are abandoned after this long.
+const statsReportTimeout = 30 * time.Second
+
+// Reports awaiting delivery to ClientConfig.StatsReportTrackers beyond this are dropped, oldest
+// first.
+const maxQueuedStatsReports = 1 << 12
+
+// Returns the delay until the next stats reports, varied by ClientConfig.StatsReportJitter.
+func (cl *Client) statsReportDelay() time.Duration {
+	ret, jitter := cl.statsReportIntervalSettings()
+	if jitter > 0 {
+		ret += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+	if ret <= 0 {
+		ret = time.Millisecond
+	}
+	return ret
+}
+
+// Periodically sends stats reports for the Client's Torrents. See
+// ClientConfig.StatsReportInterval.
+type statsReporter struct {
+	cl       *Client
+	stopping chansync.SetOnce
+	// Signalled when the report interval changes.
+	reschedule chan struct{}
+	// Closed when the reporter has stopped, and any queued reports have been saved.
+	done chan struct{}
+}
+
+func (cl *Client) newStatsReporter() *statsReporter {
+	return &statsReporter{
+		cl:         cl,
+		reschedule: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+func (me *statsReporter) start() {
+	go func() {
+		defer close(me.done)
+		me.run()
+	}()
+}
+
+// Restarts the wait for the next reports, for a change to the report interval.
+func (me *statsReporter) intervalChanged() {
+	select {
+	case me.reschedule <- struct{}{}:
+	default:
+	}
+}
+
+// Stops the reporter, abandoning reports in flight. wg is done when it has stopped.
+func (me *statsReporter) stop(wg *sync.WaitGroup) {
+	me.stopping.Set()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-me.done
+	}()
+}
+
+func (me *statsReporter) run() {
+	cl := me.cl
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-me.stopping.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	timer := time.NewTimer(cl.statsReportDelay())
+	defer timer.Stop()
+	queueDir := cl.config.StatsReportQueueDir
+	var queue []httpTracker.StatsReport
+	if queueDir != "" {
+		var err error
+		queue, err = loadStatsReportQueue(queueDir)
+		if err != nil {
+			cl.logAttrs(log.Warning, "error loading stats report queue",
+				slog.String("dir", queueDir), slog.Any("error", err))
+		}
+	}
+	queueSaved := len(queue) != 0
+	// For delivery of the queue to ClientConfig.StatsReportTrackers.
+	var backoff statsReportBackoff
+	for {
+		select {
+		case <-me.stopping.Done():
+			return
+		case <-me.reschedule:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(cl.statsReportDelay())
+			continue
+		case <-timer.C:
+		}
+		timer.Reset(cl.statsReportDelay())
+		now := time.Now()
+		if len(cl.config.StatsReportTrackers) == 0 {
+			for _, t := range cl.Torrents() {
+				if !t.statsReportReady(now) {
+					continue
+				}
+				_, err := t.reportStats(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				t.statsReportDone(err)
+			}
+			continue
+		}
+		cl.lock()
+		for _, t := range cl.torrents {
+			queue = append(queue, t.takeStatsReportLocked())
+		}
+		cl.unlock()
+		if drop := len(queue) - maxQueuedStatsReports; drop > 0 {
+			torrent.Add("stats reports dropped", int64(drop))
+			queue = queue[drop:]
+		}
+		if backoff.ready(now) {
+			queue = cl.deliverStatsReports(ctx, queue)
+			if ctx.Err() != nil {
+				return
+			}
+			if len(queue) != 0 {
+				interval, _ := cl.statsReportIntervalSettings()
+				if backoff.failures == 0 {
+					cl.logAttrs(log.Warning, "stats report delivery failed, backing off",
+						slog.Int("queued", len(queue)),
+						slog.Duration("interval", interval))
+				}
+				backoff.failed(now, interval)
+			} else if n := backoff.succeeded(); n != 0 {
+				cl.logAttrs(log.Info, "stats report delivery resumed",
+					slog.Int("failures", n))
+			}
+		}
+		// The queue on disk is only removed once, when it's first emptied.
+		if queueDir != "" && (len(queue) != 0 || queueSaved) {
+			queueSaved = len(queue) != 0
+			if err := saveStatsReportQueue(queueDir, queue); err != nil {
+				cl.logAttrs(log.Warning, "error saving stats report queue",
+					slog.String("dir", queueDir), slog.Int("queued", len(queue)), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// Delivers queued reports in order to the configured stats report trackers, returning those that
+// couldn't be delivered. Reports stay queued until a tracker accepts them, so delivery is at least
+// once.
+func (cl *Client) deliverStatsReports(ctx context.Context, queue []httpTracker.StatsReport) []httpTracker.StatsReport {
+	urls := parseHttpTrackerUrls(cl.config.StatsReportTrackers)
+	for len(queue) != 0 {
+		resp, ok := cl.sendStatsReportWithFailover(ctx, urls, queue[0])
+		if !ok {
+			cl.lock()
+			if t, ok := cl.torrents[queue[0].InfoHash]; ok {
+				t.statsReportsFailed++
+			}
+			cl.unlock()
+			break
+		}
+		cl.lock()
+		if t, ok := cl.torrents[queue[0].InfoHash]; ok {
+			t.setTrackerReportedPeers(resp.Peers)
+			t.statsReportDelivered(queue[0])
+		}
+		cl.unlock()
+		queue[0] = httpTracker.StatsReport{}
+		queue = queue[1:]
+	}
+	return queue
+}
+
+// Sends the report to each tracker in turn until one accepts it.
+func (cl *Client) sendStatsReportWithFailover(
+	ctx context.Context, urls []*url.URL, report httpTracker.StatsReport,
+) (resp httpTracker.ReportResponse, ok bool) {
+	for _, u := range urls {
+		tc := cl.newHttpTrackerClient(u)
+		ctx, cancel := context.WithTimeout(ctx, statsReportTimeout)
+		var err error
+		resp, err = tc.Report(ctx, report, cl.httpTrackerReportOpt())
+		cancel()
+		tc.Close()
+		if err == nil {
+			torrent.Add("stats reports sent", 1)
+			cl.logAttrs(log.Debug, "reported stats",
+				append(statsReportAttrs(report), slog.String("url", u.String()))...)
+			return resp, true
+		}
+		torrent.Add("stats report errors", 1)
+		cl.logAttrs(log.Debug, "error reporting stats",
+			append(statsReportAttrs(report), slog.String("url", u.String()), slog.Any("error", err))...)
+	}
+	return
+}
+
+// Structured log fields describing a stats report, starting with its infohash.
+func statsReportAttrs(r httpTracker.StatsReport) []slog.Attr {
+	return []slog.Attr{
+		slog.String("infohash", metainfo.Hash(r.InfoHash).HexString()),
+		slog.Int64("uploaded", r.UploadedDelta),
+		slog.Int64("downloaded", r.DownloadedDelta),
+		slog.Int64("left", r.Left),
+		slog.Duration("interval", r.Interval),
+	}
+}
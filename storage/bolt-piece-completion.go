@@ -41,6 +41,47 @@ func NewBoltPieceCompletion(dir string) (ret PieceCompletion, err error) {
 	return
 }
 
+// Reads an existing database without writing to it. The database is opened for each read rather
+// than held open, so any number of processes can read it, and a writer can open it between reads.
+// Bolt databases can't be read while they're open for writing, so while a writer has it open,
+// pieces' completion is unknown to readers.
+func NewBoltPieceCompletionReadOnly(dir string) (ret PieceCompletion, err error) {
+	p := filepath.Join(dir, ".torrent.bolt.db")
+	if _, err = os.Stat(p); err != nil {
+		return
+	}
+	ret = boltReadOnlyPieceCompletion{p}
+	return
+}
+
+type boltReadOnlyPieceCompletion struct {
+	path string
+}
+
+func (me boltReadOnlyPieceCompletion) Get(pk metainfo.PieceKey) (cn Completion, err error) {
+	db, err := bbolt.Open(me.path, 0o660, &bbolt.Options{
+		// Don't wait on a writer.
+		Timeout:  time.Millisecond,
+		ReadOnly: true,
+	})
+	if err == bbolt.ErrTimeout {
+		return Completion{}, nil
+	}
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	return boltPieceCompletion{db}.Get(pk)
+}
+
+func (me boltReadOnlyPieceCompletion) Set(metainfo.PieceKey, bool) error {
+	return ErrReadOnly
+}
+
+func (me boltReadOnlyPieceCompletion) Close() error {
+	return nil
+}
+
 func (me boltPieceCompletion) Get(pk metainfo.PieceKey) (cn Completion, err error) {
 	err = me.db.View(func(tx *bbolt.Tx) error {
 		cb := tx.Bucket(completionBucketKey)
@@ -9,3 +9,7 @@ package storage
 func NewDefaultPieceCompletionForDir(dir string) (PieceCompletion, error) {
 	return NewBoltPieceCompletion(dir)
 }
+
+func newDefaultReadOnlyPieceCompletionForDir(dir string) (PieceCompletion, error) {
+	return NewBoltPieceCompletionReadOnly(dir)
+}
@@ -0,0 +1,51 @@
+// Bolt piece completion is the default, so read-only file storage reads it.
+//go:build !noboltdb && (!cgo || nosqlite) && !wasm
+// +build !noboltdb
+// +build !cgo nosqlite
+// +build !wasm
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestReadOnlyFileSharesBoltPieceCompletion(t *testing.T) {
+	td := t.TempDir()
+	pk := metainfo.PieceKey{InfoHash: metainfo.Hash{1}, Index: 2}
+	pc, err := NewBoltPieceCompletion(td)
+	require.NoError(t, err)
+	require.NoError(t, pc.Set(pk, true))
+	require.NoError(t, pc.Close())
+	newReader := func() PieceCompletion {
+		s := NewFileOpts(NewFileClientOpts{ClientBaseDir: td, ReadOnly: true})
+		return s.(fileClientImpl).opts.PieceCompletion
+	}
+	r1 := newReader()
+	defer r1.Close()
+	r2 := newReader()
+	defer r2.Close()
+	c, err := r1.Get(pk)
+	require.NoError(t, err)
+	assert.Equal(t, Completion{Complete: true, Ok: true}, c)
+	// Changes stay local to each reader.
+	require.NoError(t, r1.Set(pk, false))
+	c, _ = r1.Get(pk)
+	assert.False(t, c.Complete)
+	c, _ = r2.Get(pk)
+	assert.True(t, c.Complete)
+	// Readers don't keep a writer out. Completion is unknown to them while it's open.
+	pc, err = NewBoltPieceCompletion(td)
+	require.NoError(t, err)
+	c, err = r2.Get(pk)
+	require.NoError(t, err)
+	assert.False(t, c.Ok)
+	require.NoError(t, pc.Close())
+	c, _ = r2.Get(pk)
+	assert.Equal(t, Completion{Complete: true, Ok: true}, c)
+}
@@ -12,3 +12,7 @@ import (
 func NewDefaultPieceCompletionForDir(dir string) (PieceCompletion, error) {
 	return nil, errors.New("y ur OS no have features")
 }
+
+func newDefaultReadOnlyPieceCompletionForDir(dir string) (PieceCompletion, error) {
+	return NewDefaultPieceCompletionForDir(dir)
+}
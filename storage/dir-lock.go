@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Returned when opening torrents in a base directory that a Client in another process has locked.
+// See NewFileClientOpts.LockBaseDir.
+var ErrBaseDirLocked = errors.New("storage base directory is locked by another client")
+
+const baseDirLockFileName = ".torrent.lock"
+
+// An advisory lock on a base directory, taken when the storage is created and held until it's
+// closed.
+type baseDirLock struct {
+	dir    string
+	shared bool
+
+	mu       sync.Mutex
+	acquired bool
+	f        *os.File
+	err      error
+}
+
+func (me *baseDirLock) acquire() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if !me.acquired {
+		me.acquired = true
+		me.f, me.err = lockBaseDir(me.dir, me.shared)
+	}
+	return me.err
+}
+
+func (me *baseDirLock) release() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.f == nil {
+		return nil
+	}
+	// Closing the file releases the lock.
+	err := me.f.Close()
+	me.f = nil
+	return err
+}
+
+func lockBaseDir(dir string, shared bool) (*os.File, error) {
+	path := filepath.Join(dir, baseDirLockFileName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !shared {
+		if err = os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+		f, err = os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o660)
+	}
+	if os.IsNotExist(err) {
+		// Read-only storage doesn't create the lock file, as the directory may not be writable.
+		// There's nothing to coordinate with until a writer creates it.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := lockFile(f, shared); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -15,7 +15,10 @@ type filePieceImpl struct {
 	io.ReaderAt
 }
 
-var _ PieceImpl = (*filePieceImpl)(nil)
+var (
+	_ PieceImpl       = (*filePieceImpl)(nil)
+	_ SectionWriterTo = (*filePieceImpl)(nil)
+)
 
 func (me *filePieceImpl) pieceKey() metainfo.PieceKey {
 	return metainfo.PieceKey{me.infoHash, me.p.Index()}
@@ -57,3 +60,7 @@ func (fs *filePieceImpl) MarkComplete() error {
 func (fs *filePieceImpl) MarkNotComplete() error {
 	return fs.completion.Set(fs.pieceKey(), false)
 }
+
+func (fs *filePieceImpl) WriteSectionTo(w io.Writer, off, n int64) (int64, error) {
+	return fileTorrentImplIO{fs.fileTorrentImpl}.writeSectionTo(w, fs.p.Offset()+off, n)
+}
@@ -16,6 +16,7 @@ import (
 // File-based storage for torrents, that isn't yet bound to a particular torrent.
 type fileClientImpl struct {
 	opts NewFileClientOpts
+	lock *baseDirLock
 }
 
 // All Torrent data stored in this baseDir. The info names of each torrent are used as directories.
@@ -29,6 +30,17 @@ type NewFileClientOpts struct {
 	FilePathMaker   FilePathMaker
 	TorrentDirMaker TorrentDirFilePathMaker
 	PieceCompletion PieceCompletion
+	// Serve existing files without ever writing to them, such as from a read-only mount. By
+	// default, piece completion is read from the base directory's database if one exists, and
+	// changes to it are kept in memory. See NewBoltPieceCompletionReadOnly for sharing bolt
+	// databases with a writer.
+	ReadOnly bool
+	// Take an advisory lock on the base directory, so Clients in several processes can share it
+	// safely. Read-only storage takes a shared lock, so several Clients can seed the same data,
+	// such as during blue/green upgrades. Otherwise the lock is exclusive, so data isn't written
+	// while another Client serves or writes it. The lock is taken when the storage is created, and
+	// opening torrents fails with ErrBaseDirLocked if it was held by another Client.
+	LockBaseDir bool
 }
 
 // NewFileOpts creates a new ClientImplCloser that stores files using the OS native filesystem.
@@ -46,16 +58,42 @@ func NewFileOpts(opts NewFileClientOpts) ClientImplCloser {
 		}
 	}
 	if opts.PieceCompletion == nil {
-		opts.PieceCompletion = pieceCompletionForDir(opts.ClientBaseDir)
+		if opts.ReadOnly {
+			opts.PieceCompletion = readOnlyPieceCompletionForDir(opts.ClientBaseDir)
+		} else {
+			opts.PieceCompletion = pieceCompletionForDir(opts.ClientBaseDir)
+		}
+	}
+	ret := fileClientImpl{opts: opts}
+	if opts.LockBaseDir {
+		ret.lock = &baseDirLock{
+			dir:    opts.ClientBaseDir,
+			shared: opts.ReadOnly,
+		}
+		// Taken now rather than when the first torrent is opened, so storage that's created first
+		// holds the directory. Failures are returned from OpenTorrent.
+		ret.lock.acquire()
 	}
-	return fileClientImpl{opts}
+	return ret
 }
 
 func (me fileClientImpl) Close() error {
-	return me.opts.PieceCompletion.Close()
+	err := me.opts.PieceCompletion.Close()
+	if me.lock != nil {
+		if lockErr := me.lock.release(); err == nil {
+			err = lockErr
+		}
+	}
+	return err
 }
 
 func (fs fileClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (_ TorrentImpl, err error) {
+	if fs.lock != nil {
+		if err = fs.lock.acquire(); err != nil {
+			err = fmt.Errorf("locking %q: %w", fs.opts.ClientBaseDir, err)
+			return
+		}
+	}
 	dir := fs.opts.TorrentDirMaker(fs.opts.ClientBaseDir, info, infoHash)
 	upvertedFiles := info.UpvertedFiles()
 	files := make([]file, 0, len(upvertedFiles))
@@ -72,7 +110,7 @@ func (fs fileClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash
 			path:   filePath,
 			length: fileInfo.Length,
 		}
-		if f.length == 0 {
+		if f.length == 0 && !fs.opts.ReadOnly {
 			err = CreateNativeZeroLengthFile(f.path)
 			if err != nil {
 				err = fmt.Errorf("creating zero length file: %w", err)
@@ -86,10 +124,14 @@ func (fs fileClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash
 		segments.NewIndex(common.LengthIterFromUpvertedFiles(upvertedFiles)),
 		infoHash,
 		fs.opts.PieceCompletion,
+		fs.opts.ReadOnly,
 	}
 	return TorrentImpl{
-		Piece: t.Piece,
-		Close: t.Close,
+		Piece:     t.Piece,
+		Close:     t.Close,
+		ReadAt:    fileTorrentImplIO{t}.ReadAt,
+		FileStats: t.FileStats,
+		ReadOnly:  fs.opts.ReadOnly,
 	}, nil
 }
 
@@ -104,6 +146,7 @@ type fileTorrentImpl struct {
 	segmentLocater segments.Index
 	infoHash       metainfo.Hash
 	completion     PieceCompletion
+	readOnly       bool
 }
 
 func (fts *fileTorrentImpl) Piece(p metainfo.Piece) PieceImpl {
@@ -122,6 +165,25 @@ func (fs *fileTorrentImpl) Close() error {
 	return nil
 }
 
+func (fs *fileTorrentImpl) FileStats() ([]FileStat, error) {
+	ret := make([]FileStat, 0, len(fs.files))
+	for _, f := range fs.files {
+		fi, err := os.Stat(f.path)
+		if os.IsNotExist(err) {
+			ret = append(ret, FileStat{})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, FileStat{
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	return ret, nil
+}
+
 // A helper to create zero-length files which won't appear for file-orientated storage since no
 // writes will ever occur to them (no torrent data is associated with a zero-length file). The
 // caller should make sure the file name provided is safe/sanitized.
@@ -184,7 +246,47 @@ func (fst fileTorrentImplIO) ReadAt(b []byte, off int64) (n int, err error) {
 	return
 }
 
+// Copies n bytes at off to w a file at a time. The file is given to io.Copy, so writers that can
+// read from files directly copy the data within the kernel, such as TCP connections with sendfile,
+// and other files with copy_file_range. Returns EOF on short or missing file.
+func (fst fileTorrentImplIO) writeSectionTo(w io.Writer, off, n int64) (written int64, err error) {
+	fst.fts.segmentLocater.Locate(segments.Extent{Start: off, Length: n}, func(i int, e segments.Extent) bool {
+		var n1 int64
+		n1, err = fst.writeFileSectionTo(w, fst.fts.files[i], e.Start, e.Length)
+		written += n1
+		return err == nil
+	})
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return
+}
+
+func (fst fileTorrentImplIO) writeFileSectionTo(w io.Writer, file file, off, n int64) (written int64, err error) {
+	f, err := os.Open(file.path)
+	if os.IsNotExist(err) {
+		// File missing is treated the same as a short file.
+		err = io.EOF
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		return
+	}
+	written, err = io.Copy(w, io.LimitReader(f, n))
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return
+}
+
 func (fst fileTorrentImplIO) WriteAt(p []byte, off int64) (n int, err error) {
+	if fst.fts.readOnly {
+		return 0, ErrReadOnly
+	}
 	// log.Printf("write at %v: %v bytes", off, len(p))
 	fst.fts.segmentLocater.Locate(segments.Extent{off, int64(len(p))}, func(i int, e segments.Extent) bool {
 		name := fst.fts.files[i].path
@@ -39,3 +39,121 @@ func TestShortFile(t *testing.T) {
 		t.Errorf("expected nil or EOF error from truncated piece, got %v", err)
 	}
 }
+
+func TestTorrentReadAtSpansFiles(t *testing.T) {
+	td := t.TempDir()
+	s := NewFile(td)
+	info := &metainfo.Info{
+		Name:        "d",
+		PieceLength: 4,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 3},
+			{Path: []string{"b"}, Length: 2},
+			{Path: []string{"c"}, Length: 5},
+		},
+	}
+	ts, err := s.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	require.NotNil(t, ts.ReadAt)
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "d"), 0o755))
+	for _, f := range []struct{ name, data string }{{"a", "abc"}, {"b", "de"}, {"c", "fghij"}} {
+		require.NoError(t, os.WriteFile(filepath.Join(td, "d", f.name), []byte(f.data), 0o644))
+	}
+	b := make([]byte, 8)
+	n, err := ts.ReadAt(b, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "bcdefghi", string(b[:n]))
+	n, err = ts.ReadAt(b, 4)
+	assert.Equal(t, "efghij", string(b[:n]))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPieceWriteSectionToSpansFiles(t *testing.T) {
+	td := t.TempDir()
+	s := NewFile(td)
+	info := &metainfo.Info{
+		Name:        "d",
+		PieceLength: 8,
+		Pieces:      make([]byte, 2*20),
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 3},
+			{Path: []string{"b"}, Length: 2},
+			{Path: []string{"c"}, Length: 5},
+		},
+	}
+	ts, err := s.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "d"), 0o755))
+	for _, f := range []struct{ name, data string }{{"a", "abc"}, {"b", "de"}, {"c", "fghij"}} {
+		require.NoError(t, os.WriteFile(filepath.Join(td, "d", f.name), []byte(f.data), 0o644))
+	}
+	piece := func(i int) Piece {
+		return Piece{ts.Piece(info.Piece(i)), info.Piece(i)}
+	}
+	// Copying to a file lets the data go straight between files.
+	out, err := os.Create(filepath.Join(td, "out"))
+	require.NoError(t, err)
+	defer out.Close()
+	n, err := piece(0).WriteSectionTo(out, 1, 6)
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, n)
+	var buf bytes.Buffer
+	n, err = piece(1).WriteSectionTo(&buf, 0, 2)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	b, err := os.ReadFile(out.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "bcdefg", string(b))
+	assert.Equal(t, "ij", buf.String())
+	_, err = piece(1).WriteSectionTo(&buf, 1, 2)
+	assert.ErrorIs(t, err, os.ErrInvalid)
+	// Missing data is reported as a short write.
+	require.NoError(t, os.Remove(filepath.Join(td, "d", "c")))
+	buf.Reset()
+	n, err = piece(0).WriteSectionTo(&buf, 0, 8)
+	assert.EqualValues(t, 5, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFileLockBaseDir(t *testing.T) {
+	td := t.TempDir()
+	info := &metainfo.Info{
+		Name:        "a",
+		Length:      1,
+		PieceLength: missinggo.MiB,
+	}
+	open := func(readOnly bool) (ClientImplCloser, error) {
+		s := NewFileOpts(NewFileClientOpts{
+			ClientBaseDir:   td,
+			PieceCompletion: NewMapPieceCompletion(),
+			ReadOnly:        readOnly,
+			LockBaseDir:     true,
+		})
+		_, err := s.OpenTorrent(info, metainfo.Hash{})
+		return s, err
+	}
+	// The lock is held before any torrents are opened.
+	writer := NewFileOpts(NewFileClientOpts{
+		ClientBaseDir:   td,
+		PieceCompletion: NewMapPieceCompletion(),
+		LockBaseDir:     true,
+	})
+	_, err := open(true)
+	assert.ErrorIs(t, err, ErrBaseDirLocked)
+	_, err = writer.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	_, err = open(false)
+	assert.ErrorIs(t, err, ErrBaseDirLocked)
+	_, err = open(true)
+	assert.ErrorIs(t, err, ErrBaseDirLocked)
+	require.NoError(t, writer.Close())
+	// Read-only storage can share the directory, but not with a writer.
+	reader1, err := open(true)
+	require.NoError(t, err)
+	defer reader1.Close()
+	reader2, err := open(true)
+	require.NoError(t, err)
+	defer reader2.Close()
+	_, err = open(false)
+	assert.ErrorIs(t, err, ErrBaseDirLocked)
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"errors"
 	"io"
+	"time"
 
 	"github.com/anacrolix/torrent/metainfo"
 )
@@ -27,12 +29,32 @@ type TorrentImpl struct {
 	// to determine the storage for torrents sharing the same function pointer, and mutated in
 	// place.
 	Capacity TorrentCapacity
+	// Optional. Reads torrent data at the given torrent offset, possibly spanning several pieces
+	// and files in a single call. Storages that can service such reads more efficiently than
+	// piece-by-piece, such as when many small files share pieces, should provide this.
+	ReadAt func(b []byte, off int64) (n int, err error)
+	// Optional. Returns the current state of each file, in the order of Info.UpvertedFiles. This
+	// is used to detect changes made to the data outside the client.
+	FileStats func() ([]FileStat, error)
+	// The storage can't be written, such as for data on read-only media. Torrents using it only
+	// seed the pieces that are already complete.
+	ReadOnly bool
+}
+
+// Returned by writes to read-only storage.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// The state of a file in storage. Missing files have the zero value.
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
 }
 
 // Interacts with torrent piece data. Optional interfaces to implement include:
 //
 //	io.WriterTo, such as when a piece supports a more efficient way to write out incomplete chunks.
 //	SelfHashing, such as when a piece supports a more efficient way to hash its contents.
+//	SectionWriterTo, such as when a piece can be copied to a writer without buffering it in memory.
 type PieceImpl interface {
 	// These interfaces are not as strict as normally required. They can
 	// assume that the parameters are appropriate for the dimensions of the
@@ -53,6 +75,12 @@ type Completion struct {
 	Ok       bool
 }
 
+// Allows a storage backend to write part of a piece directly to a writer, such as with sendfile to a
+// socket, instead of the data being read into a buffer and written out.
+type SectionWriterTo interface {
+	WriteSectionTo(w io.Writer, off, n int64) (written int64, err error)
+}
+
 // Allows a storage backend to override hashing (i.e. if it can do it more efficiently than the torrent client can)
 type SelfHashing interface {
 	SelfHash() (metainfo.Hash, error)
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"os"
+
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/torrent/metainfo"
@@ -17,6 +19,41 @@ type PieceCompletion interface {
 	Close() error
 }
 
+// Reads completion that's shared with other Clients and can't be written, such as a database
+// opened read-only, and keeps changes in memory.
+type overlayPieceCompletion struct {
+	shared PieceCompletion
+	local  mapPieceCompletion
+}
+
+func (me *overlayPieceCompletion) Get(pk metainfo.PieceKey) (Completion, error) {
+	if c, _ := me.local.Get(pk); c.Ok {
+		return c, nil
+	}
+	return me.shared.Get(pk)
+}
+
+func (me *overlayPieceCompletion) Set(pk metainfo.PieceKey, complete bool) error {
+	return me.local.Set(pk, complete)
+}
+
+func (me *overlayPieceCompletion) Close() error {
+	return me.shared.Close()
+}
+
+// Piece completion for read-only storage, read from the directory's default database if there is
+// one, with changes kept in memory.
+func readOnlyPieceCompletionForDir(dir string) PieceCompletion {
+	shared, err := newDefaultReadOnlyPieceCompletionForDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("couldn't open piece completion db in %q read-only: %s", dir, err)
+		}
+		return NewMapPieceCompletion()
+	}
+	return &overlayPieceCompletion{shared: shared}
+}
+
 func pieceCompletionForDir(dir string) (ret PieceCompletion) {
 	ret, err := NewDefaultPieceCompletionForDir(dir)
 	if err != nil {
@@ -8,6 +8,7 @@ package storage
 
 import (
 	"errors"
+	"os"
 	"path/filepath"
 	"sync"
 
@@ -23,9 +24,10 @@ func NewDefaultPieceCompletionForDir(dir string) (PieceCompletion, error) {
 }
 
 type sqlitePieceCompletion struct {
-	mu     sync.Mutex
-	closed bool
-	db     *sqlite.Conn
+	mu       sync.Mutex
+	closed   bool
+	readOnly bool
+	db       *sqlite.Conn
 }
 
 var _ PieceCompletion = (*sqlitePieceCompletion)(nil)
@@ -45,6 +47,20 @@ func NewSqlitePieceCompletion(dir string) (ret *sqlitePieceCompletion, err error
 	return
 }
 
+// Opens an existing database for reading only. sqlite locks only for the duration of each read, so
+// readers and a writer can share it.
+func newDefaultReadOnlyPieceCompletionForDir(dir string) (PieceCompletion, error) {
+	p := filepath.Join(dir, ".torrent.db")
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	db, err := sqlite.OpenConn(p, sqlite.SQLITE_OPEN_READONLY|sqlite.SQLITE_OPEN_NOMUTEX)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlitePieceCompletion{db: db, readOnly: true}, nil
+}
+
 func (me *sqlitePieceCompletion) Get(pk metainfo.PieceKey) (c Completion, err error) {
 	me.mu.Lock()
 	defer me.mu.Unlock()
@@ -65,6 +81,9 @@ func (me *sqlitePieceCompletion) Set(pk metainfo.PieceKey, b bool) error {
 	if me.closed {
 		return errors.New("closed")
 	}
+	if me.readOnly {
+		return ErrReadOnly
+	}
 	return sqlitex.Exec(
 		me.db,
 		`insert or replace into piece_completion(infohash, "index", complete) values(?, ?, ?)`,
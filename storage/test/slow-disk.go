@@ -0,0 +1,167 @@
+package test_storage
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Returned by reads and writes that SlowDisk fails on purpose.
+var ErrInjected = errors.New("injected storage error")
+
+// How a SlowDisk misbehaves. The zero value passes operations straight through.
+type SlowDiskParams struct {
+	// Added to every piece read and write respectively. Marking pieces complete or not counts as a
+	// write.
+	ReadLatency  time.Duration
+	WriteLatency time.Duration
+	// Up to this much more latency is added at random to each operation.
+	Jitter time.Duration
+	// The probability, from 0 to 1, that each read or write fails with ErrInjected. Failing
+	// operations incur the latency first.
+	ReadErrorRate  float64
+	WriteErrorRate float64
+}
+
+// Counts of the operations passed through a SlowDisk.
+type SlowDiskStats struct {
+	Reads          int64
+	Writes         int64
+	FailedReads    int64
+	FailedWrites   int64
+	TotalReadWait  time.Duration
+	TotalWriteWait time.Duration
+}
+
+// Wraps storage to inject latency and errors, so tests can exercise how slow or failing disks
+// interact with the request scheduler, and not just unreliable networks. Optional piece interfaces
+// of the wrapped storage, such as storage.SelfHashing, are hidden so that all data goes through
+// ReadAt and WriteAt. Safe for concurrent use, and the params can be changed while it's in use.
+type SlowDisk struct {
+	ci storage.ClientImpl
+
+	mu     sync.Mutex
+	params SlowDiskParams
+	rand   *rand.Rand
+	stats  SlowDiskStats
+}
+
+var _ storage.ClientImplCloser = (*SlowDisk)(nil)
+
+// The seed makes the injected jitter and errors reproducible for a given sequence of operations.
+func NewSlowDisk(ci storage.ClientImpl, params SlowDiskParams, seed int64) *SlowDisk {
+	return &SlowDisk{
+		ci:     ci,
+		params: params,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (me *SlowDisk) SetParams(params SlowDiskParams) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.params = params
+}
+
+func (me *SlowDisk) Stats() SlowDiskStats {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.stats
+}
+
+// Closes the wrapped storage if it can be.
+func (me *SlowDisk) Close() error {
+	if c, ok := me.ci.(storage.ClientImplCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (me *SlowDisk) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	t, err := me.ci.OpenTorrent(info, infoHash)
+	if err != nil {
+		return t, err
+	}
+	piece := t.Piece
+	t.Piece = func(p metainfo.Piece) storage.PieceImpl {
+		return slowDiskPiece{piece(p), me}
+	}
+	if readAt := t.ReadAt; readAt != nil {
+		t.ReadAt = func(b []byte, off int64) (int, error) {
+			if err := me.delay(false); err != nil {
+				return 0, err
+			}
+			return readAt(b, off)
+		}
+	}
+	return t, nil
+}
+
+// Waits out the latency for an operation, and returns whether it should fail.
+func (me *SlowDisk) delay(write bool) error {
+	me.mu.Lock()
+	latency, errorRate := me.params.ReadLatency, me.params.ReadErrorRate
+	if write {
+		latency, errorRate = me.params.WriteLatency, me.params.WriteErrorRate
+	}
+	if me.params.Jitter > 0 {
+		latency += time.Duration(me.rand.Int63n(int64(me.params.Jitter)))
+	}
+	fail := errorRate > 0 && me.rand.Float64() < errorRate
+	if write {
+		me.stats.Writes++
+		me.stats.TotalWriteWait += latency
+		if fail {
+			me.stats.FailedWrites++
+		}
+	} else {
+		me.stats.Reads++
+		me.stats.TotalReadWait += latency
+		if fail {
+			me.stats.FailedReads++
+		}
+	}
+	me.mu.Unlock()
+	time.Sleep(latency)
+	if fail {
+		return ErrInjected
+	}
+	return nil
+}
+
+type slowDiskPiece struct {
+	storage.PieceImpl
+	d *SlowDisk
+}
+
+func (me slowDiskPiece) ReadAt(b []byte, off int64) (int, error) {
+	if err := me.d.delay(false); err != nil {
+		return 0, err
+	}
+	return me.PieceImpl.ReadAt(b, off)
+}
+
+func (me slowDiskPiece) WriteAt(b []byte, off int64) (int, error) {
+	if err := me.d.delay(true); err != nil {
+		return 0, err
+	}
+	return me.PieceImpl.WriteAt(b, off)
+}
+
+func (me slowDiskPiece) MarkComplete() error {
+	if err := me.d.delay(true); err != nil {
+		return err
+	}
+	return me.PieceImpl.MarkComplete()
+}
+
+func (me slowDiskPiece) MarkNotComplete() error {
+	if err := me.d.delay(true); err != nil {
+		return err
+	}
+	return me.PieceImpl.MarkNotComplete()
+}
@@ -51,6 +51,17 @@ func (p Piece) WriteTo(w io.Writer) (int64, error) {
 	return io.CopyN(w, r, n)
 }
 
+// Writes n bytes of the piece from off to w. See SectionWriterTo.
+func (p Piece) WriteSectionTo(w io.Writer, off, n int64) (int64, error) {
+	if off < 0 || off+n > p.mip.Length() {
+		return 0, os.ErrInvalid
+	}
+	if i, ok := p.PieceImpl.(SectionWriterTo); ok {
+		return i.WriteSectionTo(w, off, n)
+	}
+	return io.CopyN(w, io.NewSectionReader(p, off, n), n)
+}
+
 func (p Piece) WriteAt(b []byte, off int64) (n int, err error) {
 	// Callers should not be writing to completed pieces, but it's too
 	// expensive to be checking this on every single write using uncached
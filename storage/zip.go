@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/anacrolix/torrent/common"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/segments"
+)
+
+// Read-only storage that serves torrent data from the files in a zip archive. Files are located in
+// the archive by their torrent paths, including the info name, as in "name/dir/file". Entries that
+// are stored without compression support efficient random access.
+type zipClientImpl struct {
+	f          *os.File
+	r          *zip.Reader
+	completion PieceCompletion
+}
+
+func NewZip(name string) (ClientImplCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading zip: %w", err)
+	}
+	return &zipClientImpl{
+		f:          f,
+		r:          r,
+		completion: NewMapPieceCompletion(),
+	}, nil
+}
+
+func (me *zipClientImpl) Close() error {
+	return me.f.Close()
+}
+
+func (me *zipClientImpl) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (TorrentImpl, error) {
+	entries := make(map[string]*zip.File, len(me.r.File))
+	for _, zf := range me.r.File {
+		entries[zf.Name] = zf
+	}
+	upvertedFiles := info.UpvertedFiles()
+	t := &zipTorrentImpl{
+		client:         me,
+		files:          make([]*zip.File, 0, len(upvertedFiles)),
+		segmentLocater: segments.NewIndex(common.LengthIterFromUpvertedFiles(upvertedFiles)),
+		infoHash:       infoHash,
+	}
+	for _, fi := range upvertedFiles {
+		var parts []string
+		if info.Name != metainfo.NoName {
+			parts = append(parts, info.BestName())
+		}
+		// Missing entries are treated like missing files, and their pieces won't complete.
+		t.files = append(t.files, entries[path.Join(append(parts, fi.BestPath()...)...)])
+	}
+	return TorrentImpl{
+		Piece:    t.Piece,
+		Close:    func() error { return nil },
+		ReadAt:   t.ReadAt,
+		ReadOnly: true,
+	}, nil
+}
+
+type zipTorrentImpl struct {
+	client         *zipClientImpl
+	files          []*zip.File
+	segmentLocater segments.Index
+	infoHash       metainfo.Hash
+}
+
+func (me *zipTorrentImpl) Piece(p metainfo.Piece) PieceImpl {
+	return zipPieceImpl{
+		t:        me,
+		p:        p,
+		ReaderAt: io.NewSectionReader(readerAtFunc(me.ReadAt), p.Offset(), p.Length()),
+	}
+}
+
+func (me *zipTorrentImpl) readFileAt(zf *zip.File, b []byte, off int64) (n int, err error) {
+	if zf == nil || off >= int64(zf.UncompressedSize64) {
+		return 0, io.EOF
+	}
+	if rem := int64(zf.UncompressedSize64) - off; int64(len(b)) > rem {
+		b = b[:rem]
+	}
+	if zf.Method == zip.Store {
+		dataOff, err := zf.DataOffset()
+		if err != nil {
+			return 0, err
+		}
+		return me.client.f.ReadAt(b, dataOff+off)
+	}
+	// Compressed entries have to be decompressed from the start.
+	rc, err := zf.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	if _, err = io.CopyN(io.Discard, rc, off); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(rc, b)
+}
+
+// Only returns EOF at the end of the torrent. Premature EOF is ErrUnexpectedEOF.
+func (me *zipTorrentImpl) ReadAt(b []byte, off int64) (n int, err error) {
+	me.segmentLocater.Locate(segments.Extent{Start: off, Length: int64(len(b))}, func(i int, e segments.Extent) bool {
+		var n1 int
+		n1, err = me.readFileAt(me.files[i], b[:e.Length], e.Start)
+		n += n1
+		b = b[n1:]
+		if err == nil && int64(n1) != e.Length {
+			err = io.ErrUnexpectedEOF
+		}
+		return err == nil
+	})
+	if len(b) != 0 && err == nil {
+		err = io.EOF
+	}
+	return
+}
+
+type readerAtFunc func([]byte, int64) (int, error)
+
+func (f readerAtFunc) ReadAt(b []byte, off int64) (int, error) {
+	return f(b, off)
+}
+
+type zipPieceImpl struct {
+	t *zipTorrentImpl
+	p metainfo.Piece
+	io.ReaderAt
+}
+
+func (me zipPieceImpl) pieceKey() metainfo.PieceKey {
+	return metainfo.PieceKey{InfoHash: me.t.infoHash, Index: me.p.Index()}
+}
+
+func (me zipPieceImpl) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (me zipPieceImpl) MarkComplete() error {
+	return me.t.client.completion.Set(me.pieceKey(), true)
+}
+
+func (me zipPieceImpl) MarkNotComplete() error {
+	return me.t.client.completion.Set(me.pieceKey(), false)
+}
+
+func (me zipPieceImpl) Completion() Completion {
+	c, err := me.t.client.completion.Get(me.pieceKey())
+	if err != nil {
+		c.Ok = false
+	}
+	return c
+}
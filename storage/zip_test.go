@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestZipStorage(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "d.zip")
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, e := range []struct {
+		name, data string
+		method     uint16
+	}{
+		{"d/a", "abc", zip.Store},
+		{"d/b", "de", zip.Deflate},
+		{"d/c", "fghij", zip.Store},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		require.NoError(t, err)
+		_, err = io.WriteString(w, e.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	s, err := NewZip(name)
+	require.NoError(t, err)
+	defer s.Close()
+	info := &metainfo.Info{
+		Name:        "d",
+		PieceLength: 4,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 3},
+			{Path: []string{"b"}, Length: 2},
+			{Path: []string{"c"}, Length: 5},
+		},
+	}
+	ts, err := s.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	assert.True(t, ts.ReadOnly)
+	b := make([]byte, 8)
+	n, err := ts.ReadAt(b, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "bcdefghi", string(b[:n]))
+	p := ts.Piece(info.Piece(1))
+	n, err = p.ReadAt(b[:4], 0)
+	require.NoError(t, err)
+	assert.Equal(t, "efgh", string(b[:n]))
+	_, err = p.WriteAt([]byte("x"), 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestFileStorageReadOnly(t *testing.T) {
+	td := t.TempDir()
+	s := NewFileOpts(NewFileClientOpts{ClientBaseDir: td, ReadOnly: true})
+	info := &metainfo.Info{
+		Name:        "a",
+		PieceLength: 4,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 3},
+			{Path: []string{"empty"}, Length: 0},
+		},
+	}
+	ts, err := s.OpenTorrent(info, metainfo.Hash{})
+	require.NoError(t, err)
+	assert.True(t, ts.ReadOnly)
+	_, err = ts.Piece(info.Piece(0)).WriteAt([]byte("abc"), 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = os.Stat(filepath.Join(td, "a"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(td, "a", "empty"))
+	assert.True(t, os.IsNotExist(err))
+}
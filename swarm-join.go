@@ -0,0 +1,74 @@
+package torrent
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Durations from when a Torrent was added to the Client until it reached milestones in joining its
+// swarm. Zero durations are for milestones that haven't been reached.
+type SwarmJoinTimes struct {
+	Added time.Time
+	// A peer connection was established.
+	FirstPeer time.Duration
+	// A piece passed verification, including pieces that were already in storage.
+	FirstPiece time.Duration
+	// Half of the pieces are complete.
+	HalfComplete time.Duration
+	// All pieces are complete.
+	Complete time.Duration
+}
+
+// Returns the times at which the Torrent reached swarm join milestones.
+func (t *Torrent) SwarmJoinTimes() SwarmJoinTimes {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.joinTimes
+}
+
+func (t *Torrent) markJoinMilestone(d *time.Duration) {
+	if *d != 0 {
+		return
+	}
+	*d = time.Since(t.joinTimes.Added)
+	if *d == 0 {
+		// Distinguish from unset on platforms with coarse clocks.
+		*d = 1
+	}
+}
+
+func (t *Torrent) updateJoinPieceMilestones() {
+	t.markJoinMilestone(&t.joinTimes.FirstPiece)
+	if t.joinTimes.Complete != 0 {
+		return
+	}
+	completed := t.numPiecesCompleted()
+	if 2*completed >= t.numPieces() {
+		t.markJoinMilestone(&t.joinTimes.HalfComplete)
+	}
+	if completed == t.numPieces() {
+		t.markJoinMilestone(&t.joinTimes.Complete)
+	}
+}
+
+// Extra HTTP tracker announce parameters reporting swarm join milestones, if enabled by
+// ClientConfig.AnnounceSwarmJoinTimes. Values are in milliseconds.
+func (t *Torrent) addJoinTimesAnnounceParams(vs url.Values) {
+	if !t.cl.config.AnnounceSwarmJoinTimes {
+		return
+	}
+	for _, m := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"join_first_peer_ms", t.joinTimes.FirstPeer},
+		{"join_first_piece_ms", t.joinTimes.FirstPiece},
+		{"join_half_complete_ms", t.joinTimes.HalfComplete},
+		{"join_complete_ms", t.joinTimes.Complete},
+	} {
+		if m.d != 0 {
+			vs.Set(m.key, strconv.FormatInt(m.d.Milliseconds(), 10))
+		}
+	}
+}
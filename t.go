@@ -1,9 +1,12 @@
 package torrent
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"strings"
 
+	"github.com/anacrolix/chansync"
 	"github.com/anacrolix/chansync/events"
 	"github.com/anacrolix/missinggo/v2/pubsub"
 	"github.com/anacrolix/sync"
@@ -21,6 +24,50 @@ func (t *Torrent) GotInfo() events.Done {
 	return t.gotMetainfoC
 }
 
+// Blocks until the info for the torrent is available, the context is done, or the Torrent is
+// closed.
+func (t *Torrent) WaitForInfo(ctx context.Context) error {
+	select {
+	case <-t.GotInfo():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.Closed():
+		return errors.New("torrent closed")
+	}
+}
+
+// Progress of retrieving the info dictionary from peers (BEP 9).
+type MetadataProgress struct {
+	// Size of the info dictionary in bytes, as reported by peers. Zero if not yet known.
+	Size int
+	// Metadata pieces of 16KiB, and the number of those received.
+	Pieces     int
+	PiecesHave int
+	// The info is available.
+	Complete bool
+}
+
+// Returns the progress of retrieving the info dictionary for Torrents added without it, such as
+// from magnet links.
+func (t *Torrent) MetadataProgress() MetadataProgress {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.metadataProgressLocked()
+}
+
+func (t *Torrent) metadataProgressLocked() (ret MetadataProgress) {
+	ret.Size = t.metadataSize()
+	ret.Pieces = t.metadataPieceCount()
+	ret.Complete = t.haveInfo()
+	for i := 0; i < ret.Pieces; i++ {
+		if t.haveMetadataPiece(i) {
+			ret.PiecesHave++
+		}
+	}
+	return
+}
+
 // Returns the metainfo info dictionary, or nil if it's not yet available.
 func (t *Torrent) Info() (info *metainfo.Info) {
 	t.nameMu.RLock()
@@ -113,6 +160,47 @@ func (t *Torrent) BytesCompleted() int64 {
 	return t.bytesCompleted()
 }
 
+// Like BytesCompleted, but only counts the files that are wanted. Files are wanted if they have a
+// priority set (see File.SetPriority), or all their pieces were requested with
+// Torrent.DownloadPieces or Torrent.DownloadAll. Compare with BytesWanted for the progress of a
+// selective download.
+func (t *Torrent) BytesCompletedWanted() (n int64) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.bytesCompletedWanted()
+}
+
+func (t *Torrent) bytesCompletedWanted() (n int64) {
+	if !t.haveInfo() {
+		return 0
+	}
+	for _, f := range *t.files {
+		if t.fileWanted(f) {
+			n += f.bytesCompletedLocked()
+		}
+	}
+	return
+}
+
+// The total length of the files counted by BytesCompletedWanted.
+func (t *Torrent) BytesWanted() (n int64) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.bytesWanted()
+}
+
+func (t *Torrent) bytesWanted() (n int64) {
+	if !t.haveInfo() {
+		return 0
+	}
+	for _, f := range *t.files {
+		if t.fileWanted(f) {
+			n += f.length
+		}
+	}
+	return
+}
+
 // The subscription emits as (int) the index of pieces as their state changes.
 // A state change is when the PieceState for a piece alters in value.
 func (t *Torrent) SubscribePieceStateChanges() *pubsub.Subscription[PieceStateChange] {
@@ -212,13 +300,14 @@ func (t *Torrent) initFiles() {
 	t.files = new([]*File)
 	for _, fi := range t.info.UpvertedFiles() {
 		*t.files = append(*t.files, &File{
-			t,
-			strings.Join(append([]string{t.info.BestName()}, fi.BestPath()...), "/"),
-			offset,
-			fi.Length,
-			fi,
-			fi.DisplayPath(t.info),
-			PiecePriorityNone,
+			t:           t,
+			path:        strings.Join(append([]string{t.info.BestName()}, fi.BestPath()...), "/"),
+			offset:      offset,
+			length:      fi.Length,
+			fi:          fi,
+			displayPath: fi.DisplayPath(t.info),
+			prio:        PiecePriorityNone,
+			done:        new(chansync.SetOnce),
 		})
 		offset += fi.Length
 	}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A seeder that can be killed mid-transfer and later restored, for testing how a swarm copes with
+// losing its only seed. The restored seeder has the same data, as if the process had restarted.
+type RestartableSeeder struct {
+	t       testing.TB
+	dataDir string
+	mi      *metainfo.MetaInfo
+	// Applied to the config each time the seeder starts.
+	configure func(*torrent.ClientConfig)
+	client    *torrent.Client
+	torrent   *torrent.Torrent
+}
+
+// Starts a seeder for the torrent with its data in dataDir. configure may be nil. The seeder is
+// killed when the test ends.
+func NewRestartableSeeder(
+	t testing.TB, dataDir string, mi *metainfo.MetaInfo, configure func(*torrent.ClientConfig),
+) *RestartableSeeder {
+	me := &RestartableSeeder{
+		t:         t,
+		dataDir:   dataDir,
+		mi:        mi,
+		configure: configure,
+	}
+	me.start()
+	t.Cleanup(me.Kill)
+	return me
+}
+
+func (me *RestartableSeeder) start() {
+	cfg := torrent.TestingConfig(me.t)
+	cfg.Seed = true
+	cfg.DataDir = me.dataDir
+	if me.configure != nil {
+		me.configure(cfg)
+	}
+	cl, err := torrent.NewClient(cfg)
+	require.NoError(me.t, err)
+	tt, err := cl.AddTorrent(me.mi)
+	require.NoError(me.t, err)
+	tt.VerifyData()
+	require.True(me.t, tt.Complete.Bool(), "seeder data is incomplete")
+	me.client = cl
+	me.torrent = tt
+}
+
+// The seeder's Client, or nil while it's killed.
+func (me *RestartableSeeder) Client() *torrent.Client {
+	return me.client
+}
+
+// The seeder's Torrent, or nil while it's killed.
+func (me *RestartableSeeder) Torrent() *torrent.Torrent {
+	return me.torrent
+}
+
+// Closes the seeder's Client, dropping its connections. Does nothing if it's already killed.
+func (me *RestartableSeeder) Kill() {
+	if me.client == nil {
+		return
+	}
+	me.client.Close()
+	me.client = nil
+	me.torrent = nil
+}
+
+// Starts the seeder again. It listens on a new port, as the old one may not be released yet, so
+// peers need to be told about it again, such as with Torrent.AddClientPeer, unless they learn of it
+// through trackers.
+func (me *RestartableSeeder) Restore() {
+	require.Nil(me.t, me.client, "seeder is running")
+	me.start()
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/testdata"
+)
+
+// If set, SwarmTransfer appends its results to this file as JSON lines, for comparing throughput
+// between runs.
+const SpeedReportEnv = "TORRENT_SPEED_REPORT"
+
+type SwarmTransferParams struct {
+	Leechers int
+	// The length of the torrent's data, which is pseudo-random but the same for every run.
+	Length      int64
+	PieceLength int64
+	// If nonzero, the test fails if the leechers receive less than this many bytes per second
+	// altogether.
+	MinThroughput float64
+	// How long to wait for the leechers to complete. Zero for a minute.
+	Timeout          time.Duration
+	ConfigureSeeder  func(*torrent.ClientConfig)
+	ConfigureLeecher func(*torrent.ClientConfig)
+}
+
+// The measurements from a SwarmTransfer. This is also what's recorded in speed reports.
+type SwarmTransferResult struct {
+	Test        string    `json:"test"`
+	Time        time.Time `json:"time"`
+	GoVersion   string    `json:"go_version"`
+	Leechers    int       `json:"leechers"`
+	Length      int64     `json:"length"`
+	PieceLength int64     `json:"piece_length"`
+	// From the leechers starting until they've all completed.
+	Duration time.Duration `json:"duration_ns"`
+	// How long each leecher took to complete.
+	LeecherDurations []time.Duration `json:"leecher_durations_ns"`
+	// The payload bytes the leechers received altogether per second of Duration.
+	Throughput float64 `json:"throughput"`
+}
+
+// Transfers a torrent from a seeder to leechers that are all connected to each other, and measures
+// how long it takes.
+func SwarmTransfer(t *testing.T, ps SwarmTransferParams) (ret SwarmTransferResult) {
+	if ps.Timeout == 0 {
+		ps.Timeout = time.Minute
+	}
+	spec := testdata.Torrent{
+		Name:        "swarm",
+		PieceLength: ps.PieceLength,
+		Files:       []testdata.File{{Seed: ps.Length, Length: ps.Length}},
+	}
+	mi, err := spec.MetaInfo()
+	require.NoError(t, err)
+	seederDataDir := t.TempDir()
+	require.NoError(t, spec.WriteFiles(seederDataDir))
+
+	cfg := torrent.TestingConfig(t)
+	cfg.Seed = true
+	cfg.DataDir = seederDataDir
+	if ps.ConfigureSeeder != nil {
+		ps.ConfigureSeeder(cfg)
+	}
+	seeder, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, err := seeder.AddTorrent(mi)
+	require.NoError(t, err)
+	<-seederTorrent.Complete.On()
+
+	clients := []*torrent.Client{seeder}
+	var leechers []*torrent.Torrent
+	var leecherDataDirs []string
+	for i := 0; i < ps.Leechers; i++ {
+		cfg := torrent.TestingConfig(t)
+		cfg.DataDir = t.TempDir()
+		leecherDataDirs = append(leecherDataDirs, cfg.DataDir)
+		if ps.ConfigureLeecher != nil {
+			ps.ConfigureLeecher(cfg)
+		}
+		cl, err := torrent.NewClient(cfg)
+		require.NoError(t, err)
+		defer cl.Close()
+		tt, err := cl.AddTorrent(mi)
+		require.NoError(t, err)
+		for _, other := range clients {
+			tt.AddClientPeer(other)
+		}
+		clients = append(clients, cl)
+		leechers = append(leechers, tt)
+	}
+
+	ret = SwarmTransferResult{
+		Test:             t.Name(),
+		GoVersion:        runtime.Version(),
+		Leechers:         ps.Leechers,
+		Length:           ps.Length,
+		PieceLength:      ps.PieceLength,
+		LeecherDurations: make([]time.Duration, ps.Leechers),
+	}
+	ret.Time = time.Now()
+	var wg sync.WaitGroup
+	for i, tt := range leechers {
+		wg.Add(1)
+		go func(i int, tt *torrent.Torrent) {
+			defer wg.Done()
+			select {
+			case <-tt.Complete.On():
+				ret.LeecherDurations[i] = time.Since(ret.Time)
+			case <-tt.Closed():
+			}
+		}(i, tt)
+		tt.DownloadAll()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ps.Timeout):
+		t.Fatalf("leechers didn't complete within %v", ps.Timeout)
+	}
+	ret.Duration = time.Since(ret.Time)
+	ret.Throughput = float64(ps.Length*int64(ps.Leechers)) / ret.Duration.Seconds()
+	t.Logf("%v leechers each received %v bytes in %v (%.0f B/s)",
+		ps.Leechers, ps.Length, ret.Duration, ret.Throughput)
+	for _, dir := range leecherDataDirs {
+		require.NoError(t, spec.CheckFiles(dir))
+	}
+	if ps.MinThroughput != 0 && ret.Throughput < ps.MinThroughput {
+		t.Errorf("throughput %.0f B/s is below the minimum %.0f B/s", ret.Throughput, ps.MinThroughput)
+	}
+	if path := os.Getenv(SpeedReportEnv); path != "" {
+		require.NoError(t, appendSpeedReport(path, ret))
+	}
+	return
+}
+
+func appendSpeedReport(path string, r SwarmTransferResult) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
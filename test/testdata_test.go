@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/testdata"
+)
+
+func TestGeneratedFileDeterministic(t *testing.T) {
+	c := qt.New(t)
+	f := testdata.File{Seed: 42, Length: 100003}
+	data, err := io.ReadAll(f.NewReader())
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, int(f.Length))
+	again, err := io.ReadAll(iotest.OneByteReader(f.NewReader()))
+	c.Assert(err, qt.IsNil)
+	c.Check(again, qt.DeepEquals, data)
+	c.Check(iotest.TestReader(f.NewReader(), data), qt.IsNil)
+	// Unaligned reads match the stream.
+	b := make([]byte, 13)
+	n, err := f.ReadAt(b, 99991)
+	c.Check(n, qt.Equals, 12)
+	c.Check(err, qt.Equals, io.EOF)
+	c.Check(b[:n], qt.DeepEquals, data[99991:])
+	// A file is a prefix of a longer one with the same seed.
+	longer, err := io.ReadAll((testdata.File{Seed: 42, Length: 200000}).NewReader())
+	c.Assert(err, qt.IsNil)
+	c.Check(longer[:len(data)], qt.DeepEquals, data)
+	other, err := io.ReadAll((testdata.File{Seed: 43, Length: f.Length}).NewReader())
+	c.Assert(err, qt.IsNil)
+	c.Check(bytes.Equal(other, data), qt.IsFalse)
+}
+
+func TestGeneratedFileCheck(t *testing.T) {
+	c := qt.New(t)
+	f := testdata.File{Seed: 1, Length: 300000}
+	name := filepath.Join(t.TempDir(), "dir", "file")
+	c.Assert(f.WriteFile(name), qt.IsNil)
+	c.Assert(f.CheckFile(name), qt.IsNil)
+	data, err := os.ReadFile(name)
+	c.Assert(err, qt.IsNil)
+
+	checkErr := func(data []byte) *testdata.CheckError {
+		var ce *testdata.CheckError
+		c.Assert(errors.As(f.Check(bytes.NewReader(data)), &ce), qt.IsTrue)
+		return ce
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[200001]++
+	ce := checkErr(corrupt)
+	c.Check(ce.Offset, qt.Equals, int64(200001))
+	c.Check(ce.Err, qt.IsNil)
+	ce = checkErr(data[:100000])
+	c.Check(ce.Offset, qt.Equals, int64(100000))
+	c.Check(ce.Err, qt.Not(qt.IsNil))
+	ce = checkErr(append(data, 0))
+	c.Check(ce.Offset, qt.Equals, f.Length)
+	c.Check(ce.Err, qt.Not(qt.IsNil))
+}
+
+// A client seeds a generated multi-file torrent from the files written for it.
+func TestGeneratedTorrentVerifies(t *testing.T) {
+	spec := testdata.Torrent{
+		Name:        "generated",
+		PieceLength: 1 << 14,
+		Files: []testdata.File{
+			{Path: []string{"a"}, Seed: 1, Length: 50000},
+			{Path: []string{"b", "c"}, Seed: 2, Length: 1},
+			{Path: []string{"d"}, Seed: 3, Length: 1 << 16},
+		},
+	}
+	mi, err := spec.MetaInfo()
+	require.NoError(t, err)
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	require.Len(t, info.Files, 3)
+	require.EqualValues(t, 50000+1+1<<16, info.TotalLength())
+	dataDir := t.TempDir()
+	require.NoError(t, spec.WriteFiles(dataDir))
+	require.NoError(t, spec.CheckFiles(dataDir))
+
+	cfg := torrent.TestingConfig(t)
+	cfg.DataDir = dataDir
+	cl, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	require.True(t, tt.Complete.Bool())
+
+	// The same spec always makes the same torrent.
+	mi2, err := spec.MetaInfo()
+	require.NoError(t, err)
+	require.Equal(t, mi.HashInfoBytes(), mi2.HashInfoBytes())
+}
@@ -1,9 +1,13 @@
 package test
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"testing/iotest"
@@ -13,6 +17,8 @@ import (
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/internal/testutil"
 	"github.com/anacrolix/torrent/storage"
+	test_storage "github.com/anacrolix/torrent/storage/test"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
 	"github.com/frankban/quicktest"
 	"golang.org/x/time/rate"
 
@@ -202,3 +208,181 @@ func TestSeedAfterDownloading(t *testing.T) {
 	}()
 	wg.Wait()
 }
+
+// Both ends having slow, unreliable disks shouldn't stop the transfer.
+func TestClientTransferSlowDisk(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	seederDisk := test_storage.NewSlowDisk(storage.NewFile(greetingTempDir), test_storage.SlowDiskParams{}, 1)
+	cfg := torrent.TestingConfig(t)
+	cfg.Seed = true
+	cfg.DefaultStorage = seederDisk
+	seeder, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, err := seeder.AddTorrentSpec(torrent.TorrentSpecFromMetaInfo(mi))
+	require.NoError(t, err)
+	seederTorrent.VerifyData()
+	require.True(t, seederTorrent.Complete.Bool())
+	// The data has been verified, so reads can start failing without the seeder losing pieces.
+	seederDisk.SetParams(test_storage.SlowDiskParams{
+		ReadLatency:   5 * time.Millisecond,
+		Jitter:        5 * time.Millisecond,
+		ReadErrorRate: 0.3,
+	})
+	leecherDisk := test_storage.NewSlowDisk(storage.NewFile(t.TempDir()), test_storage.SlowDiskParams{
+		WriteLatency:   5 * time.Millisecond,
+		Jitter:         5 * time.Millisecond,
+		WriteErrorRate: 0.3,
+	}, 2)
+	cfg = torrent.TestingConfig(t)
+	cfg.DefaultStorage = leecherDisk
+	leecher, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, err := leecher.AddTorrentSpec(func() (ret *torrent.TorrentSpec) {
+		ret = torrent.TorrentSpecFromMetaInfo(mi)
+		ret.ChunkSize = 2
+		return
+	}())
+	require.NoError(t, err)
+	// The chunks that fail to be written are requested again.
+	leecherTorrent.SetOnWriteChunkError(func(error) {})
+	leecherTorrent.DownloadAll()
+	leecherTorrent.AddClientPeer(seeder)
+	select {
+	case <-leecherTorrent.Complete.On():
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out")
+	}
+	r := leecherTorrent.NewReader()
+	defer r.Close()
+	quicktest.Check(t, iotest.TestReader(r, []byte(testutil.GreetingFileContents)), quicktest.IsNil)
+	assert.NotZero(t, seederDisk.Stats().Reads)
+	assert.NotZero(t, leecherDisk.Stats().Writes)
+}
+
+// Receives stats reports, keeping the latest.
+type statsReportRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	latest httpTracker.StatsReport
+}
+
+func newStatsReportRecorder(t *testing.T) *statsReportRecorder {
+	me := &statsReportRecorder{}
+	me.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := httpTracker.ParseStatsReportRequest(r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		me.mu.Lock()
+		me.latest = report
+		me.mu.Unlock()
+		w.Write([]byte("de"))
+	}))
+	t.Cleanup(me.Close)
+	return me
+}
+
+func (me *statsReportRecorder) latestPieces() httpTracker.StatsReportPieces {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ret := me.latest.Pieces
+	ret.DistributedCopiesMilli = 0
+	return ret
+}
+
+// The only seed goes away mid-transfer and comes back. The leecher keeps what it has, reports the
+// rest as unavailable meanwhile, and finishes without downloading anything twice.
+func TestSeedFailureMidTransfer(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	seeder := NewRestartableSeeder(t, greetingTempDir, mi, nil)
+	reports := newStatsReportRecorder(t)
+	cfg := torrent.TestingConfig(t)
+	cfg.DisableTrackers = false
+	cfg.StatsReportURL = reports.URL
+	cfg.StatsReportInterval = time.Millisecond
+	leecher, err := torrent.NewClient(cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	const timeout = 10 * time.Second
+	leecherTorrent.DownloadPieces(0, 1)
+	leecherTorrent.AddClientPeer(seeder.Client())
+	require.Eventually(t, func() bool {
+		return leecherTorrent.Piece(0).State().Complete
+	}, timeout, time.Millisecond)
+
+	seeder.Kill()
+	leecherTorrent.DownloadAll()
+	require.Eventually(t, func() bool {
+		return leecherTorrent.Stats().ActivePeers == 0
+	}, timeout, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return reports.latestPieces() == httpTracker.StatsReportPieces{Total: 3, Complete: 1, Unavailable: 2}
+	}, timeout, time.Millisecond)
+	assert.False(t, leecherTorrent.Complete.Bool())
+	assert.True(t, leecherTorrent.Piece(0).State().Complete)
+	assert.EqualValues(t, len(testutil.GreetingFileContents)-5, leecherTorrent.BytesMissing())
+
+	seeder.Restore()
+	leecherTorrent.AddClientPeer(seeder.Client())
+	select {
+	case <-leecherTorrent.Complete.On():
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for download to resume")
+	}
+	r := leecherTorrent.NewReader()
+	defer r.Close()
+	quicktest.Check(t, iotest.TestReader(r, []byte(testutil.GreetingFileContents)), quicktest.IsNil)
+	stats := leecherTorrent.Stats()
+	assert.EqualValues(t, len(testutil.GreetingFileContents), stats.BytesReadUsefulData.Int64())
+	require.Eventually(t, func() bool {
+		return reports.latestPieces() == httpTracker.StatsReportPieces{Total: 3, Complete: 3}
+	}, timeout, time.Millisecond)
+}
+
+func TestSwarmTransferSpeed(t *testing.T) {
+	res := SwarmTransfer(t, SwarmTransferParams{
+		Leechers:    3,
+		Length:      4 << 20,
+		PieceLength: 256 << 10,
+		// Loopback transfers are much faster than this, but the test shouldn't fail on a busy
+		// machine.
+		MinThroughput: 1 << 20,
+	})
+	for _, d := range res.LeecherDurations {
+		assert.NotZero(t, d)
+		assert.LessOrEqual(t, d, res.Duration)
+	}
+}
+
+func TestSwarmTransferSpeedReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speed.jsonl")
+	t.Setenv(SpeedReportEnv, path)
+	for i := 0; i < 2; i++ {
+		SwarmTransfer(t, SwarmTransferParams{
+			Leechers:    1,
+			Length:      13,
+			PieceLength: 5,
+		})
+	}
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	d := json.NewDecoder(f)
+	for i := 0; i < 2; i++ {
+		var r SwarmTransferResult
+		require.NoError(t, d.Decode(&r))
+		assert.Equal(t, t.Name(), r.Test)
+		assert.Equal(t, 1, r.Leechers)
+		assert.EqualValues(t, 13, r.Length)
+		assert.NotZero(t, r.Duration)
+		assert.Len(t, r.LeecherDurations, 1)
+		assert.Greater(t, r.Throughput, 0.)
+	}
+	require.ErrorIs(t, d.Decode(new(SwarmTransferResult)), io.EOF)
+}
@@ -0,0 +1,129 @@
+// Package testdata generates deterministic file data of any size from a seed, and torrents of
+// it, for tests and benchmarks that need more than the fixtures in this directory. Data is
+// generated as it's read, so large files don't need to fit in memory.
+//
+// The go tool skips directories named testdata when matching patterns like ./..., so this
+// package's tests are in the test package.
+package testdata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A file of pseudo-random bytes that are the same for a given Seed on every platform and Go
+// release.
+type File struct {
+	// Relative to the torrent's directory in multi-file torrents. Empty for single-file torrents.
+	Path   []string
+	Seed   int64
+	Length int64
+}
+
+var _ io.ReaderAt = File{}
+
+// Returns the 8 bytes of the file data at offset 8*i, from the SplitMix64 sequence.
+func (me File) word(i int64) uint64 {
+	z := uint64(me.Seed) + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (me File) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= me.Length {
+		return 0, io.EOF
+	}
+	if rem := me.Length - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	var b [8]byte
+	for n < len(p) {
+		i := (off + int64(n)) / 8
+		binary.LittleEndian.PutUint64(b[:], me.word(i))
+		n += copy(p[n:], b[(off+int64(n))%8:])
+	}
+	return
+}
+
+// Returns a reader of the file data from the start.
+func (me File) NewReader() io.Reader {
+	return io.NewSectionReader(me, 0, me.Length)
+}
+
+// Writes the file data to the named file, creating its directory if necessary.
+func (me File) WriteFile(name string) error {
+	err := os.MkdirAll(filepath.Dir(name), 0o750)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, me.NewReader())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Returns an error if the named file doesn't contain exactly the file data.
+func (me File) CheckFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return me.Check(f)
+}
+
+// Returns an error if r doesn't read exactly the file data.
+func (me File) Check(r io.Reader) error {
+	want := me.NewReader()
+	wantBuf := make([]byte, 64<<10)
+	gotBuf := make([]byte, len(wantBuf))
+	var off int64
+	for {
+		n, wantErr := io.ReadFull(want, wantBuf)
+		m, gotErr := io.ReadFull(r, gotBuf[:n])
+		if m < n {
+			return &CheckError{Offset: off + int64(m), Err: gotErr}
+		}
+		for i := 0; i < n; i++ {
+			if gotBuf[i] != wantBuf[i] {
+				return &CheckError{Offset: off + int64(i)}
+			}
+		}
+		off += int64(n)
+		if wantErr != nil {
+			break
+		}
+	}
+	if n, _ := r.Read(gotBuf[:1]); n != 0 {
+		return &CheckError{Offset: off, Err: errors.New("data is longer than expected")}
+	}
+	return nil
+}
+
+// Where data first differed from a File.
+type CheckError struct {
+	Offset int64
+	// Why the data ended early or was too long. Nil if a byte differed at Offset.
+	Err error
+}
+
+func (me *CheckError) Error() string {
+	if me.Err != nil {
+		return fmt.Sprintf("at offset %v: %v", me.Offset, me.Err)
+	}
+	return fmt.Sprintf("data differs at offset %v", me.Offset)
+}
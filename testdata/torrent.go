@@ -0,0 +1,93 @@
+package testdata
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A torrent of generated files. It's a single-file torrent if there's one file and it has no
+// Path.
+type Torrent struct {
+	Name        string
+	PieceLength int64
+	Files       []File
+}
+
+func (me Torrent) singleFile() bool {
+	return len(me.Files) == 1 && len(me.Files[0].Path) == 0
+}
+
+// Returns the info for the torrent, hashing the generated data as it's read.
+func (me Torrent) Info() (info metainfo.Info, err error) {
+	info = metainfo.Info{
+		Name:        me.Name,
+		PieceLength: me.PieceLength,
+	}
+	files := make(map[string]File, len(me.Files))
+	if me.singleFile() {
+		info.Length = me.Files[0].Length
+	} else {
+		for _, f := range me.Files {
+			info.Files = append(info.Files, metainfo.FileInfo{
+				Path:   f.Path,
+				Length: f.Length,
+			})
+		}
+	}
+	for _, f := range me.Files {
+		files[strings.Join(f.Path, "/")] = f
+	}
+	err = info.GeneratePieces(func(fi metainfo.FileInfo) (io.ReadCloser, error) {
+		f, ok := files[strings.Join(fi.Path, "/")]
+		if !ok {
+			return nil, fmt.Errorf("no file at %q", fi.Path)
+		}
+		return io.NopCloser(f.NewReader()), nil
+	})
+	return
+}
+
+// Returns metainfo for the torrent without trackers.
+func (me Torrent) MetaInfo() (*metainfo.MetaInfo, error) {
+	info, err := me.Info()
+	if err != nil {
+		return nil, err
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	return &metainfo.MetaInfo{InfoBytes: infoBytes}, nil
+}
+
+// Returns where the file is stored in a data directory, as for file storage.
+func (me Torrent) filePath(dir string, f File) string {
+	return filepath.Join(append([]string{dir, me.Name}, f.Path...)...)
+}
+
+// Writes the torrent's files to a data directory, such as ClientConfig.DataDir for a seeder.
+func (me Torrent) WriteFiles(dir string) error {
+	for _, f := range me.Files {
+		if err := f.WriteFile(me.filePath(dir, f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an error if the torrent's files in a data directory aren't exactly as generated, such
+// as after a download to it.
+func (me Torrent) CheckFiles(dir string) error {
+	for _, f := range me.Files {
+		name := me.filePath(dir, f)
+		if err := f.CheckFile(name); err != nil {
+			return fmt.Errorf("checking %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func torrentCachePath(dir string, ih metainfo.Hash) string {
+	return filepath.Join(dir, ih.HexString()+".torrent")
+}
+
+// Writes the metainfo to ClientConfig.TorrentCacheDir, if set. This is for Torrents that obtained
+// their info after being added, such as from magnet links.
+func (t *Torrent) cacheMetainfo(mi metainfo.MetaInfo) {
+	dir := t.cl.config.TorrentCacheDir
+	if dir == "" {
+		return
+	}
+	err := writeCachedMetainfo(dir, t.infoHash, mi)
+	if err != nil {
+		t.logger.Printf("error caching metainfo: %v", err)
+	}
+}
+
+func writeCachedMetainfo(dir string, ih metainfo.Hash, mi metainfo.MetaInfo) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	// Write to a temporary file first so concurrent adds never see a partial file.
+	f, err := os.CreateTemp(dir, ih.HexString()+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = mi.Write(f)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), torrentCachePath(dir, ih))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Returns the metainfo from ClientConfig.TorrentCacheDir for the infohash, or nil if there isn't a
+// valid one.
+func (cl *Client) cachedMetainfo(ih metainfo.Hash) *metainfo.MetaInfo {
+	dir := cl.config.TorrentCacheDir
+	if dir == "" {
+		return nil
+	}
+	mi, err := metainfo.LoadFromFile(torrentCachePath(dir, ih))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			cl.logger.Printf("error loading cached metainfo for %v: %v", ih, err)
+		}
+		return nil
+	}
+	if mi.HashInfoBytes() != ih {
+		cl.logger.Printf("cached metainfo for %v has wrong infohash", ih)
+		return nil
+	}
+	return mi
+}
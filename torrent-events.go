@@ -0,0 +1,71 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/missinggo/v2/pubsub"
+)
+
+// An event in a Torrent's lifecycle, see Torrent.Events. The concrete types are the ones ending
+// in Event in this file.
+type TorrentEvent interface {
+	isTorrentEvent()
+}
+
+// The Torrent's info became available, from the metainfo or from peers.
+type MetadataReceivedEvent struct{}
+
+// A piece became complete, either by passing verification or from existing storage.
+type PieceCompletedEvent struct {
+	Index int
+}
+
+// A piece failed verification after data was written to it. Err is set if the failure was due to
+// an error reading the piece from storage.
+type PieceHashFailedEvent struct {
+	Index int
+	Err   error
+}
+
+// All the Torrent's pieces became complete.
+type DownloadCompletedEvent struct{}
+
+type PeerConnectedEvent struct {
+	Peer *PeerConn
+}
+
+type PeerDisconnectedEvent struct {
+	Peer *PeerConn
+}
+
+// An announce to an HTTP or UDP tracker completed.
+type TrackerAnnounceResultEvent struct {
+	Url      string
+	NumPeers int
+	// The interval the tracker asked for before the next announce.
+	Interval time.Duration
+	Err      error
+	// The reason the tracker gave for failing the announce, if Err came from the tracker.
+	FailureReason string
+	// A warning the tracker gave with a successful announce.
+	Warning string
+}
+
+func (MetadataReceivedEvent) isTorrentEvent()      {}
+func (PieceCompletedEvent) isTorrentEvent()        {}
+func (PieceHashFailedEvent) isTorrentEvent()       {}
+func (DownloadCompletedEvent) isTorrentEvent()     {}
+func (PeerConnectedEvent) isTorrentEvent()         {}
+func (PeerDisconnectedEvent) isTorrentEvent()      {}
+func (TrackerAnnounceResultEvent) isTorrentEvent() {}
+
+// Subscribes to the Torrent's lifecycle events, which are delivered in the order they occurred.
+// Events aren't dropped for slow subscribers, so the subscription should be closed when no longer
+// read. The Values channel is closed when the Torrent is dropped.
+func (t *Torrent) Events() *pubsub.Subscription[TorrentEvent] {
+	return t.events.Subscribe()
+}
+
+func (t *Torrent) publishEvent(e TorrentEvent) {
+	t.events.Publish(e)
+}
@@ -0,0 +1,82 @@
+package torrent
+
+// The request budget for each peer of a Torrent preempted by a higher priority one. Leaving one
+// request keeps the Torrent's peers from going idle entirely.
+const preemptedMaxRequests = 1
+
+// Sets the Torrent's priority relative to the others in the Client. While a higher priority
+// Torrent is downloading, lower priority ones are limited to a single outstanding request per
+// peer, and where Torrents share storage capacity, the higher priority Torrent's pieces are
+// requested first.
+func (t *Torrent) SetPriority(priority TorrentPriority) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	if priority == t.priority {
+		return
+	}
+	t.priority = priority
+	if t.storage != nil && t.haveInfo() {
+		for i := 0; i < t.numPieces(); i++ {
+			t.updatePieceRequestOrder(i)
+		}
+	}
+	t.cl.torrentPrioritiesChanged("torrent priority changed")
+}
+
+func (t *Torrent) Priority() TorrentPriority {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.priority
+}
+
+// Whether another Torrent of higher priority is downloading, and should take this one's request
+// slots and bandwidth.
+func (t *Torrent) preemptedByPriority() bool {
+	cl := t.cl
+	return cl.anyDownloadingPriority && cl.highestDownloadingPriority > t.priority
+}
+
+// Whether the Torrent wants data and has peers to get it from.
+func (t *Torrent) downloadingForPriority() bool {
+	return t.haveInfo() && t.needData() && !t.dataDownloadDisallowed.Bool() && len(t.conns) != 0
+}
+
+// Must be called when anything downloadingForPriority depends on may have changed. Request budgets
+// across the Client are updated if the highest downloading priority changes.
+func (t *Torrent) updateDownloadingForPriority(reason string) {
+	downloading := t.downloadingForPriority()
+	if downloading == t.downloadingPriority {
+		return
+	}
+	t.downloadingPriority = downloading
+	cl := t.cl
+	highest, anyDownloading := cl.highestDownloadingPriority, cl.anyDownloadingPriority
+	cl.updateHighestDownloadingPriority()
+	if cl.highestDownloadingPriority != highest || cl.anyDownloadingPriority != anyDownloading {
+		cl.torrentPrioritiesChanged(reason)
+	}
+}
+
+func (cl *Client) updateHighestDownloadingPriority() {
+	cl.anyDownloadingPriority = false
+	cl.highestDownloadingPriority = TorrentPriorityLow
+	for _, t := range cl.torrents {
+		if !t.downloadingPriority {
+			continue
+		}
+		if !cl.anyDownloadingPriority || t.priority > cl.highestDownloadingPriority {
+			cl.highestDownloadingPriority = t.priority
+		}
+		cl.anyDownloadingPriority = true
+	}
+}
+
+// Request budgets depend on the priorities of the other Torrents.
+func (cl *Client) torrentPrioritiesChanged(reason string) {
+	cl.updateHighestDownloadingPriority()
+	for _, t := range cl.torrents {
+		t.iterPeers(func(p *Peer) {
+			p.updateRequests(reason)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Needs to fit the largest chunk that'll be uploaded in one reservation.
+const torrentRateBurst = 1 << 20
+
+// Limits the Torrent's download rate in bytes per second, in addition to the Client's limit. Zero
+// or less removes the limit. This takes effect for existing connections.
+func (t *Torrent) SetDownloadLimit(bytesPerSec int64) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.downloadShare.cap = rateCap(bytesPerSec)
+	t.downloadLimiter.SetLimit(t.downloadShare.limit())
+}
+
+// Limits the Torrent's upload rate in bytes per second, in addition to the Client's limit. Zero or
+// less removes the limit.
+func (t *Torrent) SetUploadLimit(bytesPerSec int64) {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.uploadShare.cap = rateCap(bytesPerSec)
+	t.uploadLimiter.SetLimit(t.uploadShare.limit())
+}
+
+// Returns the Torrent's download rate limit in bytes per second, or zero if there isn't one. This
+// doesn't include the Torrent's share under ClientConfig.FairRateSharing.
+func (t *Torrent) DownloadLimit() int64 {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return int64(t.downloadShare.cap)
+}
+
+// Returns the Torrent's upload rate limit in bytes per second, or zero if there isn't one. This
+// doesn't include the Torrent's share under ClientConfig.FairRateSharing.
+func (t *Torrent) UploadLimit() int64 {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return int64(t.uploadShare.cap)
+}
+
+func rateCap(bytesPerSec int64) rate.Limit {
+	if bytesPerSec <= 0 {
+		return 0
+	}
+	return rate.Limit(bytesPerSec)
+}
+
+// The limiters that uploads to the peer are subject to.
+func (c *PeerConn) uploadLimiters() []*rate.Limiter {
+	ret := []*rate.Limiter{c.t.cl.settings.uploadRateLimiter, c.t.uploadLimiter}
+	if l := c.t.cl.autobandLimiter; l != nil {
+		ret = append(ret, l)
+	}
+	if ns := c.t.namespace; ns != nil {
+		ret = append(ret, ns.uploadLimiter)
+	}
+	if c.uploadLimiter != nil {
+		ret = append(ret, c.uploadLimiter)
+	}
+	return ret
+}
+
+// Reserves upload bandwidth for n bytes from each of the upload limiters, returning how long to
+// wait first. Nothing is reserved if there's a wait.
+func (c *PeerConn) reserveUpload(n int) time.Duration {
+	now := time.Now()
+	var (
+		reservations []*rate.Reservation
+		delay        time.Duration
+	)
+	for _, l := range c.uploadLimiters() {
+		res := l.ReserveN(now, n)
+		if !res.OK() {
+			panic(fmt.Sprintf("upload rate limiter burst size < %d", n))
+		}
+		if d := res.DelayFrom(now); d > delay {
+			delay = d
+		}
+		reservations = append(reservations, res)
+	}
+	if delay > 0 {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	return delay
+}
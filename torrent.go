@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"text/tabwriter"
 	"time"
 	"unsafe"
@@ -33,6 +34,7 @@ import (
 	"github.com/anacrolix/sync"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/pion/datachannel"
+	"golang.org/x/time/rate"
 
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/common"
@@ -42,6 +44,7 @@ import (
 	"github.com/anacrolix/torrent/segments"
 	"github.com/anacrolix/torrent/storage"
 	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
 	typedRoaring "github.com/anacrolix/torrent/typed-roaring"
 	"github.com/anacrolix/torrent/webseed"
 	"github.com/anacrolix/torrent/webtorrent"
@@ -70,6 +73,14 @@ type Torrent struct {
 	pieceRequestOrder []int
 	// Values are the piece indices that changed.
 	pieceStateChanges pubsub.PubSub[PieceStateChange]
+	// See Torrent.Events.
+	events pubsub.PubSub[TorrentEvent]
+	// See Torrent.SetUploadLimit and Torrent.SetDownloadLimit. These also apply the Torrent's shares
+	// under ClientConfig.FairRateSharing.
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+	uploadShare     rateShare
+	downloadShare   rateShare
 	// The size of chunks to request from peers over the wire. This is
 	// normally 16KiB by convention these days.
 	chunkSize pp.Integer
@@ -111,8 +122,14 @@ type Torrent struct {
 	peers prioritizedPeers
 	// Whether we want to know to know more peers.
 	wantPeersEvent missinggo.Event
+	// Broadcast by ForceReannounce.
+	forceReannounce chansync.BroadcastCond
+	// When ForceReannounce was last called, for announcers that were busy announcing at the time.
+	forcedReannounceAt time.Time
 	// An announcer for each tracker URL.
 	trackerAnnouncers map[string]torrentTrackerAnnouncer
+	// See ClientConfig.TrackerTierFailover.
+	trackerTiers trackerTiers
 	// How many times we've initiated a DHT announce. TODO: Move into stats.
 	numDHTAnnounces int
 
@@ -129,6 +146,9 @@ type Torrent struct {
 	// received that piece.
 	metadataCompletedChunks []bool
 	metadataChanged         sync.Cond
+	// BEP 35 signatures of the info from merged specs, checked against
+	// ClientConfig.TrustedPublishers when the info is set.
+	infoSignatures map[string]metainfo.Signature
 
 	// Closed when .Info is obtained.
 	gotMetainfoC chan struct{}
@@ -146,6 +166,59 @@ type Torrent struct {
 	piecesQueuedForHash       bitmap.Bitmap
 	activePieceHashes         int
 	initialPieceCheckDisabled bool
+	// The number of full rechecks (Torrent.VerifyData calls) in progress.
+	activeRechecks int
+
+	joinTimes   SwarmJoinTimes
+	deadState   deadTorrentState
+	fileChanges fileChangeState
+	// Sampled every ClientConfig.AvailabilitySampleInterval.
+	availabilityHistory []AvailabilitySample
+	peerChurn           peerChurnState
+	// Piece data uploaded over closed connections, by remote peer ID.
+	uploadedToPeers map[PeerID]int64
+	// Pieces that failed their hash check with data from each remote peer, for stats reports.
+	hashFailuresByPeer map[PeerID]int64
+	// Reliability scores from tracker announces, by announced address and peer ID. See
+	// ClientConfig.PreferReliablePeersFromTracker.
+	trackerReliability map[trackerReliabilityKey]trackerReliabilityScore
+	uploadReceipts     uploadReceiptState
+	// Swarm-wide peer totals returned by trackers for stats reports, by peer ID.
+	trackerReportedPeers map[PeerID]httpTracker.ReportedPeer
+	// For the deltas in stats reports. Created with the first report, so that it starts from zero.
+	statsReportSampler *TorrentStatsSampler
+	// See ReportedUploadBytes.
+	reportedUploadBytes int64
+	statsReportBackoff  statsReportBackoff
+	// See TorrentStats.StatsReportsFailed.
+	statsReportsFailed int64
+	// See TorrentStats.AnnouncesSucceeded.
+	announces AnnounceStats
+	// Set by SetStatsReportURL.
+	statsReportURL string
+	// See Client.Namespace.
+	namespace *Namespace
+	// The Namespace's download limiter, for readers that don't hold the Client lock. Holds a
+	// *rate.Limiter once the Torrent joins a Namespace. See namespaceLimitedReader.
+	namespaceDownloadLimiter atomic.Value
+	namespaceTransferSampled int64
+	pausedForQuota           bool
+	// See SetPriority.
+	priority TorrentPriority
+	// The last value of downloadingForPriority, as counted in the Client's highest downloading
+	// priority.
+	downloadingPriority   bool
+	scheduledVerification scheduledVerificationState
+	transferRates         transferRatesState
+	// Set by SetCompletionDeadline.
+	completionSLO *completionSLOState
+	// Scheduler parameters from the most recent tracker announce response that included any.
+	trackerSchedulerParams SchedulerParams
+	// The pieces the most recent tracker announce response assigning any asked us to fetch first.
+	// See ClientConfig.TrackerPieceAssignment.
+	trackerAssignedPieces tracker.PieceRange
+	// Piece payloads are encrypted and only exchanged with peers having the same key, if set.
+	preSharedKey []byte
 
 	connsWithAllPieces map[*Peer]struct{}
 
@@ -157,6 +230,8 @@ type Torrent struct {
 
 	// Is On when all pieces are complete.
 	Complete chansync.Flag
+	// Set when the Torrent completes after downloading data, for the completed tracker event.
+	downloadCompleted chansync.SetOnce
 
 	// Torrent sources in use keyed by the source string.
 	activeSources sync.Map
@@ -267,12 +342,17 @@ func (t *Torrent) setChunkSize(size pp.Integer) {
 	t.chunkSize = size
 	t.chunkPool = sync.Pool{
 		New: func() interface{} {
-			b := make([]byte, size)
+			b := make([]byte, size, size+chunkBufferSpare)
 			return &b
 		},
 	}
 }
 
+// Extra capacity in chunk buffers for decrypting and removing the compression flag from payloads
+// in place. It's reserved whether or not those features are in use, so that buffers pooled before
+// they're enabled, such as by Torrent.SetPreSharedKey, are still big enough.
+const chunkBufferSpare = payloadCryptOverhead + pieceCompressionOverhead
+
 func (t *Torrent) pieceComplete(piece pieceIndex) bool {
 	return t._completedPieces.Contains(bitmap.BitIndex(piece))
 }
@@ -402,8 +482,26 @@ func (t *Torrent) makePieces() {
 		files := *t.files
 		beginFile := pieceFirstFileIndex(piece.torrentBeginOffset(), files)
 		endFile := pieceEndFileIndex(piece.torrentEndOffset(), files)
-		piece.files = files[beginFile:endFile]
+		piece.files = pieceFiles(files[beginFile:endFile])
+	}
+}
+
+// Removes zero-length files from the files spanned by a piece, as they contain no piece data and
+// shouldn't contribute to its priority. The original slice is reused if there are none.
+func pieceFiles(files []*File) []*File {
+	for i, f := range files {
+		if f.length != 0 {
+			continue
+		}
+		ret := append([]*File(nil), files[:i]...)
+		for _, f := range files[i+1:] {
+			if f.length != 0 {
+				ret = append(ret, f)
+			}
+		}
+		return ret
 	}
+	return files
 }
 
 // Returns the index of the first file containing the piece. files must be
@@ -446,7 +544,14 @@ func (t *Torrent) setInfo(info *metainfo.Info) error {
 		var err error
 		t.storage, err = t.storageOpener.OpenTorrent(info, t.infoHash)
 		if err != nil {
-			return fmt.Errorf("error opening torrent storage: %s", err)
+			if fdExhausted(err) && t.cl != nil {
+				t.cl.onFdPressure("storage open", err)
+			}
+			return fmt.Errorf("error opening torrent storage: %w", err)
+		}
+		if t.storage.ReadOnly {
+			// Readers fail instead of waiting for data that can't be stored.
+			t.dataDownloadDisallowed.Set()
 		}
 	}
 	t.nameMu.Lock()
@@ -489,17 +594,30 @@ func (t *Torrent) onSetInfo() {
 			t.queuePieceCheck(i)
 		}
 	}
+	for _, f := range *t.files {
+		if f.length == 0 {
+			// These span no pieces.
+			f.done.Set()
+		}
+	}
 	t.cl.event.Broadcast()
 	close(t.gotMetainfoC)
 	t.updateWantPeersEvent()
 	t.requestState = make(map[RequestIndex]requestState)
 	t.tryCreateMorePieceHashers()
+	t.updateDownloadingForPriority("onSetInfo")
 	t.iterPeers(func(p *Peer) {
 		p.onGotInfo(t.info)
 		p.updateRequests("onSetInfo")
 	})
+	t.checkNamespaceBytesQuota()
+	t.publishEvent(MetadataReceivedEvent{})
 }
 
+// Returned when info has the right hash but isn't signed by one of
+// ClientConfig.TrustedPublishers.
+var errUntrustedInfo = errors.New("untrusted info")
+
 // Called when metadata for a torrent becomes available.
 func (t *Torrent) setInfoBytesLocked(b []byte) error {
 	if metainfo.HashBytes(b) != t.infoHash {
@@ -509,6 +627,11 @@ func (t *Torrent) setInfoBytesLocked(b []byte) error {
 	if err := bencode.Unmarshal(b, &info); err != nil {
 		return fmt.Errorf("error unmarshalling info bytes: %s", err)
 	}
+	if t.info == nil && len(t.cl.config.TrustedPublishers) != 0 {
+		if err := t.cl.checkPublisherSignature(b, t.infoSignatures); err != nil {
+			return fmt.Errorf("%w: %v", errUntrustedInfo, err)
+		}
+	}
 	t.metadataBytes = b
 	t.metadataCompletedChunks = nil
 	if t.info != nil {
@@ -808,6 +931,16 @@ func (t *Torrent) newMetaInfo() metainfo.MetaInfo {
 				return nil
 			}
 		}(),
+		Signatures: func() map[string]metainfo.Signature {
+			if !t.haveInfo() || len(t.infoSignatures) == 0 {
+				return nil
+			}
+			ret := make(map[string]metainfo.Signature, len(t.infoSignatures))
+			for name, sig := range t.infoSignatures {
+				ret[name] = sig
+			}
+			return ret
+		}(),
 		UrlList: func() []string {
 			ret := make([]string, 0, len(t.webSeeds))
 			for url := range t.webSeeds {
@@ -899,6 +1032,8 @@ func (t *Torrent) close(wg *sync.WaitGroup) (err error) {
 	t.iterPeers(func(p *Peer) {
 		p.close()
 	})
+	t.announceTrackersStopped(wg)
+	t.trackerReliability = nil
 	if t.storage != nil {
 		t.deletePieceRequestOrder()
 	}
@@ -906,6 +1041,7 @@ func (t *Torrent) close(wg *sync.WaitGroup) (err error) {
 	t.pex.Reset()
 	t.cl.event.Broadcast()
 	t.pieceStateChanges.Close()
+	t.events.Close()
 	t.updateWantPeersEvent()
 	return
 }
@@ -995,6 +1131,16 @@ func (t *Torrent) hashPiece(piece pieceIndex) (
 	// These are peers that sent us blocks that differ from what we hash here.
 	differingPeers map[bannableAddr]struct{},
 	err error,
+) {
+	return t.hashPieceData(piece, nil, false)
+}
+
+// Hashes the piece, also writing its data to data if it's not nil. Reads from storage are limited
+// by ClientConfig.PieceHashRateLimiter if limit is set.
+func (t *Torrent) hashPieceData(piece pieceIndex, data *bytes.Buffer, limit bool) (
+	ret metainfo.Hash,
+	differingPeers map[bannableAddr]struct{},
+	err error,
 ) {
 	p := t.piece(piece)
 	p.waitNoPendingWrites()
@@ -1006,6 +1152,9 @@ func (t *Torrent) hashPiece(piece pieceIndex) (
 		// log.Printf("A piece decided to self-hash: %d", piece)
 		sum, err = i.SelfHash()
 		missinggo.CopyExact(&ret, sum)
+		if data != nil && err == nil {
+			_, err = storagePiece.WriteTo(data)
+		}
 		return
 	}
 
@@ -1017,7 +1166,17 @@ func (t *Torrent) hashPiece(piece pieceIndex) (
 	if logPieceContents {
 		writers = append(writers, &examineBuf)
 	}
-	_, err = storagePiece.WriteTo(io.MultiWriter(writers...))
+	if data != nil {
+		writers = append(writers, data)
+	}
+	w := io.MultiWriter(writers...)
+	if limit {
+		w = &rateLimitedWriter{
+			l: t.cl.config.PieceHashRateLimiter,
+			w: w,
+		}
+	}
+	_, err = storagePiece.WriteTo(w)
 	if logPieceContents {
 		t.logger.WithDefaultLevel(log.Debug).Printf("hashed %q with copy err %v", examineBuf.Bytes(), err)
 	}
@@ -1059,7 +1218,11 @@ func (t *Torrent) maybeDropMutuallyCompletePeer(
 	if p.useful() {
 		return
 	}
+	if pc, ok := p.TryAsPeerConn(); ok && pc.leaveDropForUploadReceipts() {
+		return
+	}
 	t.logger.WithDefaultLevel(log.Debug).Printf("dropping %v, which is mutually complete", p)
+	p.setCloseReason(DisconnectEvicted)
 	p.drop()
 }
 
@@ -1233,6 +1396,7 @@ func (t *Torrent) updatePiecePriority(piece pieceIndex, reason string) {
 			return
 		}
 	}
+	t.updateDownloadingForPriority(reason)
 	t.piecePriorityChanged(piece, reason)
 }
 
@@ -1318,7 +1482,7 @@ func (t *Torrent) maxHalfOpen() int {
 	extraIncoming := int64(t.numReceivedConns() - t.maxEstablishedConns/2)
 	// We want to allow some experimentation with new peers, and to try to
 	// upset an oversupply of received connections.
-	return int(min(max(5, extraIncoming)+establishedHeadroom, int64(t.cl.config.HalfOpenConnsPerTorrent)))
+	return int(min(max(5, extraIncoming)+establishedHeadroom, int64(t.cl.settings.halfOpenConnsPerTorrent)))
 }
 
 func (t *Torrent) openNewConns() (initiated int) {
@@ -1328,6 +1492,10 @@ func (t *Torrent) openNewConns() (initiated int) {
 	if t.isCompleteReliable() {
 		return
 	}
+	// Dials would fail until file descriptors are freed.
+	if t.cl.fdPressure.active(time.Now()) {
+		return
+	}
 	defer t.updateWantPeersEvent()
 	for t.peers.Len() != 0 {
 		if !t.wantConns() {
@@ -1339,7 +1507,7 @@ func (t *Torrent) openNewConns() (initiated int) {
 		if len(t.cl.dialers) == 0 {
 			return
 		}
-		if t.cl.numHalfOpen >= t.cl.config.TotalHalfOpenConns {
+		if t.cl.numHalfOpen >= t.cl.settings.totalHalfOpenConns {
 			return
 		}
 		p := t.peers.PopMax()
@@ -1364,6 +1532,13 @@ func (t *Torrent) updatePieceCompletion(piece pieceIndex) bool {
 		t._completedPieces.Remove(x)
 	}
 	p.t.updatePieceRequestOrder(piece)
+	if changed && complete {
+		for _, f := range p.files {
+			f.updateDone()
+		}
+		// Before the Torrent's completion, so the events are in order.
+		t.publishEvent(PieceCompletedEvent{Index: piece})
+	}
 	t.updateComplete()
 	if complete && len(p.dirtiers) != 0 {
 		t.logger.Printf("marked piece %v complete but still has dirtiers", piece)
@@ -1377,6 +1552,11 @@ func (t *Torrent) updatePieceCompletion(piece pieceIndex) bool {
 
 // Non-blocking read. Client lock is not required.
 func (t *Torrent) readAt(b []byte, off int64) (n int, err error) {
+	if t.storage.ReadAt != nil && len(b) != 0 {
+		n = t.readAtSpanning(b, off)
+		off += int64(n)
+		b = b[n:]
+	}
 	for len(b) != 0 {
 		p := &t.pieces[off/t.info.PieceLength]
 		p.waitNoPendingWrites()
@@ -1392,6 +1572,17 @@ func (t *Torrent) readAt(b []byte, off int64) (n int, err error) {
 	return
 }
 
+// Reads across piece and file boundaries in one call to storage. Short reads are left to the
+// piece-by-piece path, which handles storage losing data.
+func (t *Torrent) readAtSpanning(b []byte, off int64) int {
+	begin, end := t.byteRegionPieces(off, int64(len(b)))
+	for i := begin; i < end; i++ {
+		t.piece(i).waitNoPendingWrites()
+	}
+	n, _ := t.storage.ReadAt(b, off)
+	return n
+}
+
 // Returns an error if the metadata was completed, but couldn't be set for some reason. Blame it on
 // the last peer to contribute. TODO: Actually we shouldn't blame peers for failure to open storage
 // etc. Also we should probably cached metadata pieces per-Peer, to isolate failure appropriately.
@@ -1405,13 +1596,19 @@ func (t *Torrent) maybeCompleteMetadata() error {
 		return nil
 	}
 	err := t.setInfoBytesLocked(t.metadataBytes)
+	if errors.Is(err, errUntrustedInfo) {
+		// The bytes are what the infohash commits to, so fetching them again won't get signed
+		// info. They're kept, and no more are requested, until signed info is merged.
+		return fmt.Errorf("error setting info bytes from peers: %w", err)
+	}
 	if err != nil {
 		t.invalidateMetadata()
 		return fmt.Errorf("error setting info bytes: %s", err)
 	}
-	if t.cl.config.Debug {
+	if t.cl.debugLogging() {
 		t.logger.Printf("%s: got metadata from peers", t)
 	}
+	go t.cacheMetainfo(t.newMetaInfo())
 	return nil
 }
 
@@ -1426,6 +1623,11 @@ func (t *Torrent) readerPiecePriorities() (now, readahead bitmap.Bitmap) {
 	return
 }
 
+// Read-only storage can only be seeded from.
+func (t *Torrent) storageReadOnly() bool {
+	return t.storage != nil && t.storage.ReadOnly
+}
+
 func (t *Torrent) needData() bool {
 	if t.closed.IsSet() {
 		return false
@@ -1491,6 +1693,13 @@ func (t *Torrent) deletePeerConn(c *PeerConn) (ret bool) {
 	}
 	_, ret = t.conns[c]
 	delete(t.conns, c)
+	t.cl.deleteRemotePeerConn(c)
+	if ret {
+		t.recordPeerConnDeleted(c)
+		t.recordPeerUpload(c)
+		t.updateDownloadingForPriority("peer conn deleted")
+		t.publishEvent(PeerDisconnectedEvent{c})
+	}
 	// Avoid adding a drop event more than once. Probably we should track whether we've generated
 	// the drop event against the PexConnState instead.
 	if ret {
@@ -1576,10 +1785,13 @@ func (t *Torrent) seeding() bool {
 	if t.dataUploadDisallowed {
 		return false
 	}
+	if t.transfersPaused() {
+		return false
+	}
 	if cl.config.NoUpload {
 		return false
 	}
-	if !cl.config.Seed {
+	if !cl.config.Seed && !t.storageReadOnly() {
 		return false
 	}
 	if cl.config.DisableAggressiveUpload && t.needData() {
@@ -1663,7 +1875,9 @@ func (t *Torrent) startWebsocketAnnouncer(u url.URL) torrentTrackerAnnouncer {
 	return wst
 }
 
-func (t *Torrent) startScrapingTracker(_url string) {
+// Starts announcing to the tracker URL, and returns the scrapers created for it, if any. With
+// ClientConfig.TrackerTierFailover, the scrapers are left to be run by their tier.
+func (t *Torrent) startScrapingTracker(_url string) (scrapers []*trackerScraper) {
 	if _url == "" {
 		return
 	}
@@ -1678,10 +1892,9 @@ func (t *Torrent) startScrapingTracker(_url string) {
 	}
 	if u.Scheme == "udp" {
 		u.Scheme = "udp4"
-		t.startScrapingTracker(u.String())
+		scrapers = t.startScrapingTracker(u.String())
 		u.Scheme = "udp6"
-		t.startScrapingTracker(u.String())
-		return
+		return append(scrapers, t.startScrapingTracker(u.String())...)
 	}
 	if _, ok := t.trackerAnnouncers[_url]; ok {
 		return
@@ -1707,7 +1920,10 @@ func (t *Torrent) startScrapingTracker(_url string) {
 			t:               t,
 			lookupTrackerIp: t.cl.config.LookupTrackerIp,
 		}
-		go newAnnouncer.Run()
+		if !t.cl.config.TrackerTierFailover {
+			go newAnnouncer.Run()
+		}
+		scrapers = append(scrapers, newAnnouncer)
 		return newAnnouncer
 	}()
 	if sl == nil {
@@ -1717,6 +1933,17 @@ func (t *Torrent) startScrapingTracker(_url string) {
 		t.trackerAnnouncers = make(map[string]torrentTrackerAnnouncer)
 	}
 	t.trackerAnnouncers[_url] = sl
+	return
+}
+
+// Extra parameters for HTTP tracker announces that aren't part of AnnounceRequest.
+func (t *Torrent) announceExtraParams() url.Values {
+	vs := make(url.Values)
+	t.addJoinTimesAnnounceParams(vs)
+	t.cl.addExperimentAnnounceParams(vs)
+	t.addPieceAssignmentAnnounceParams(vs)
+	t.cl.addUploadReceiptAnnounceParams(vs)
+	return vs
 }
 
 // Adds and starts tracker scrapers for tracker URLs that aren't already
@@ -1725,6 +1952,10 @@ func (t *Torrent) startMissingTrackerScrapers() {
 	if t.cl.config.DisableTrackers {
 		return
 	}
+	if t.cl.config.TrackerTierFailover {
+		t.addTrackerTiers()
+		return
+	}
 	t.startScrapingTracker(t.metainfo.Announce)
 	for _, tier := range t.metainfo.AnnounceList {
 		for _, url := range tier {
@@ -1792,7 +2023,9 @@ func (t *Torrent) consumeDhtAnnouncePeers(pvs <-chan dht.PeersValues) {
 // Announce using the provided DHT server. Peers are consumed automatically. done is closed when the
 // announce ends. stop will force the announce to end.
 func (t *Torrent) AnnounceToDht(s DhtServer) (done <-chan struct{}, stop func(), err error) {
-	ps, err := s.Announce(t.infoHash, t.cl.incomingPeerPort(), true)
+	// The DHT can only imply our port from the source port of its own packets, which won't match an
+	// advertised port override.
+	ps, err := s.Announce(t.infoHash, t.cl.incomingPeerPort(), t.cl.config.AdvertisedPort == 0)
 	if err != nil {
 		return
 	}
@@ -1825,7 +2058,7 @@ func (t *Torrent) dhtAnnouncer(s DhtServer) {
 	defer cl.unlock()
 	for {
 		for {
-			if t.closed.IsSet() {
+			if t.closed.IsSet() || cl.dhtServerReplaced(s) {
 				return
 			}
 			// We're also announcing ourselves as a listener, so we don't just want peer addresses.
@@ -1884,6 +2117,10 @@ func (t *Torrent) statsLocked() (ret TorrentStats) {
 	}
 	ret.ConnStats = t.stats.Copy()
 	ret.PiecesComplete = t.numPiecesCompleted()
+	ret.DistributedCopies = t.distributedCopies()
+	ret.StatsReportsFailed = t.statsReportsFailed
+	ret.AnnounceStats = t.announces
+	ret.ConnEncryption = t.connEncryptionCounts()
 	return
 }
 
@@ -1942,6 +2179,7 @@ func (t *Torrent) addPeerConn(c *PeerConn) (err error) {
 			continue
 		}
 		if c.hasPreferredNetworkOver(c0) {
+			c0.setCloseReason(DisconnectEvicted)
 			c0.close()
 			t.deletePeerConn(c0)
 		} else {
@@ -1953,6 +2191,7 @@ func (t *Torrent) addPeerConn(c *PeerConn) (err error) {
 		if c == nil {
 			return errors.New("don't want conns")
 		}
+		c.setCloseReason(c.evictionReason())
 		c.close()
 		t.deletePeerConn(c)
 	}
@@ -1960,9 +2199,14 @@ func (t *Torrent) addPeerConn(c *PeerConn) (err error) {
 		panic(len(t.conns))
 	}
 	t.conns[c] = struct{}{}
+	t.cl.addRemotePeerConn(c)
+	t.recordPeerConnAdded(c)
+	t.updateDownloadingForPriority("peer conn added")
+	t.markJoinMilestone(&t.joinTimes.FirstPeer)
 	if !t.cl.config.DisablePEX && !c.PeerExtensionBytes.SupportsExtended() {
 		t.pex.Add(c) // as no further extended handshake expected
 	}
+	t.publishEvent(PeerConnectedEvent{c})
 	return nil
 }
 
@@ -1983,9 +2227,18 @@ func (t *Torrent) isCompleteReliable() bool {
 	return t.haveAllPieces() && t.cl.config.Reliable
 }
 
+func (t *Torrent) initLogger() {
+	t.logger = t.cl.logger.WithContextValue(t).WithNames("torrent", t.infoHash.HexString())
+	t.sourcesLogger = t.logger.WithNames("sources")
+}
+
 func (t *Torrent) SetMaxEstablishedConns(max int) (oldMax int) {
 	t.cl.lock()
 	defer t.cl.unlock()
+	return t.setMaxEstablishedConns(max)
+}
+
+func (t *Torrent) setMaxEstablishedConns(max int) (oldMax int) {
 	oldMax = t.maxEstablishedConns
 	t.maxEstablishedConns = max
 	wcs := worseConnSlice{
@@ -1996,7 +2249,9 @@ func (t *Torrent) SetMaxEstablishedConns(max int) (oldMax int) {
 	wcs.initKeys()
 	heap.Init(&wcs)
 	for len(t.conns) > t.maxEstablishedConns && wcs.Len() > 0 {
-		t.dropConnection(heap.Pop(&wcs).(*PeerConn))
+		c := heap.Pop(&wcs).(*PeerConn)
+		c.setCloseReason(c.evictionReason())
+		t.dropConnection(c)
 	}
 	t.openNewConns()
 	return oldMax
@@ -2012,6 +2267,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 	if t.closed.IsSet() {
 		return
 	}
+	t.uploadReceiptsPieceHashed(piece, passed)
 
 	// Don't score the first time a piece is hashed, it could be an initial check.
 	if p.storageCompletionOk {
@@ -2025,6 +2281,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 				log.Debug, t.logger)
 
 			pieceHashedNotCorrect.Add(1)
+			t.publishEvent(PieceHashFailedEvent{Index: piece, Err: hashIoErr})
 		}
 	}
 
@@ -2069,6 +2326,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 			for c := range p.dirtiers {
 				// Y u do dis peer?!
 				c.stats().incrementPiecesDirtiedBad()
+				t.recordPeerHashFailure(c)
 			}
 
 			bannableTouchers := make([]*Peer, 0, len(p.dirtiers))
@@ -2080,7 +2338,7 @@ func (t *Torrent) pieceHashed(piece pieceIndex, passed bool, hashIoErr error) {
 			t.clearPieceTouchers(piece)
 			slices.Sort(bannableTouchers, connLessTrusted)
 
-			if t.cl.config.Debug {
+			if t.cl.debugLogging() {
 				t.logger.Printf(
 					"bannable conns by trust for piece %d: %v",
 					piece,
@@ -2122,6 +2380,7 @@ func (t *Torrent) cancelRequestsForPiece(piece pieceIndex) {
 }
 
 func (t *Torrent) onPieceCompleted(piece pieceIndex) {
+	t.updateJoinPieceMilestones()
 	t.pendAllChunkSpecs(piece)
 	t.cancelRequestsForPiece(piece)
 	t.piece(piece).readerCond.Broadcast()
@@ -2129,6 +2388,10 @@ func (t *Torrent) onPieceCompleted(piece pieceIndex) {
 		conn.have(piece)
 		t.maybeDropMutuallyCompletePeer(&conn.Peer)
 	}
+	if t.completionSLO != nil {
+		// Catches completion as it happens, rather than at the next check.
+		t.checkCompletionSLO(time.Now())
+	}
 }
 
 // Called when a piece is found to be not complete.
@@ -2159,8 +2422,15 @@ func (t *Torrent) onIncompletePiece(piece pieceIndex) {
 }
 
 func (t *Torrent) tryCreateMorePieceHashers() {
-	for !t.closed.IsSet() && t.activePieceHashes < 2 && t.tryCreatePieceHasher() {
+	for !t.closed.IsSet() && t.activePieceHashes < t.maxPieceHashers() && t.tryCreatePieceHasher() {
+	}
+}
+
+func (t *Torrent) maxPieceHashers() int {
+	if n := t.cl.config.PieceHashersPerTorrent; n > 0 {
+		return n
 	}
+	return 2
 }
 
 func (t *Torrent) tryCreatePieceHasher() bool {
@@ -2174,11 +2444,15 @@ func (t *Torrent) tryCreatePieceHasher() bool {
 	p := t.piece(pi)
 	t.piecesQueuedForHash.Remove(bitmap.BitIndex(pi))
 	p.hashing = true
+	// Pieces we just finished downloading are checked at full speed, the limit is for passes over
+	// data already in storage.
+	limit := !p.queuedFromDownload
+	p.queuedFromDownload = false
 	t.publishPieceChange(pi)
 	t.updatePiecePriority(pi, "Torrent.tryCreatePieceHasher")
 	t.storageLock.RLock()
 	t.activePieceHashes++
-	go t.pieceHasher(pi)
+	go t.pieceHasher(pi, limit)
 	return true
 }
 
@@ -2211,15 +2485,20 @@ func (t *Torrent) dropBannedPeers() {
 		}
 		if _, ok := t.cl.badPeerIPs[netipAddr]; ok {
 			// Should this be a close?
+			p.setCloseReason(DisconnectBanned)
 			p.drop()
 			t.logger.WithDefaultLevel(log.Debug).Printf("dropped %v for banned remote IP %v", p, netipAddr)
 		}
 	})
 }
 
-func (t *Torrent) pieceHasher(index pieceIndex) {
+func (t *Torrent) pieceHasher(index pieceIndex, limit bool) {
 	p := t.piece(index)
-	sum, failedPeers, copyErr := t.hashPiece(index)
+	var data *bytes.Buffer
+	if len(t.cl.config.Callbacks.PieceVerified) != 0 || t.cl.config.VerifyPiece != nil {
+		data = bytes.NewBuffer(make([]byte, 0, p.length()))
+	}
+	sum, failedPeers, copyErr := t.hashPieceData(index, data, limit)
 	correct := sum == *p.hash
 	switch copyErr {
 	case nil, io.EOF:
@@ -2227,6 +2506,21 @@ func (t *Torrent) pieceHasher(index pieceIndex) {
 		log.Fmsg("piece %v (%s) hash failure copy error: %v", p, p.hash.HexString(), copyErr).Log(t.logger)
 	}
 	t.storageLock.RUnlock()
+	var pieceData []byte
+	if data != nil && data.Len() == int(p.length()) {
+		pieceData = data.Bytes()
+	}
+	correct = t.verifyPiece(index, pieceData, correct)
+	if correct && pieceData != nil {
+		e := PieceVerifiedEvent{
+			Torrent: t,
+			Index:   index,
+			Data:    pieceData,
+		}
+		for _, f := range t.cl.config.Callbacks.PieceVerified {
+			f(e)
+		}
+	}
 	t.cl.lock()
 	defer t.cl.unlock()
 	if correct {
@@ -2274,13 +2568,55 @@ func (t *Torrent) queuePieceCheck(pieceIndex pieceIndex) {
 }
 
 // Forces all the pieces to be re-hashed. See also Piece.VerifyData. This should not be called
-// before the Info is available.
+// before the Info is available. If ClientConfig.PauseTransfersDuringRecheck is set, the torrent
+// doesn't request or serve data until the recheck completes.
 func (t *Torrent) VerifyData() {
+	t.cl.lock()
+	t.activeRechecks++
+	if t.activeRechecks == 1 {
+		t.onTransfersPausedChanged("recheck started")
+	}
+	t.cl.unlock()
+	defer func() {
+		t.cl.lock()
+		defer t.cl.unlock()
+		t.activeRechecks--
+		if t.activeRechecks == 0 {
+			t.onTransfersPausedChanged("recheck finished")
+		}
+	}()
 	for i := pieceIndex(0); i < t.NumPieces(); i++ {
 		t.Piece(i).VerifyData()
 	}
 }
 
+// Whether data transfers are paused because the torrent is being rechecked.
+func (t *Torrent) transfersPausedForRecheck() bool {
+	return t.activeRechecks != 0 && t.cl.config.PauseTransfersDuringRecheck
+}
+
+// Whether data transfers are paused for a recheck, or because the Torrent's Namespace is over
+// quota.
+func (t *Torrent) transfersPaused() bool {
+	return t.transfersPausedForRecheck() || t.pausedForQuota
+}
+
+func (t *Torrent) onTransfersPausedChanged(reason string) {
+	if !t.cl.config.PauseTransfersDuringRecheck {
+		return
+	}
+	t.transfersPausedChanged(reason)
+}
+
+func (t *Torrent) transfersPausedChanged(reason string) {
+	t.iterPeers(func(p *Peer) {
+		p.updateRequests(reason)
+	})
+	for c := range t.conns {
+		c.tickleWriter()
+	}
+}
+
 // Start the process of connecting to the given peer for the given torrent if appropriate.
 func (t *Torrent) initiateConn(peer PeerInfo) {
 	if peer.Id == t.cl.peerID {
@@ -2328,7 +2664,7 @@ func (t *Torrent) pieceQueuedForHash(i pieceIndex) bool {
 }
 
 func (t *Torrent) dialTimeout() time.Duration {
-	return reducedDialTimeout(t.cl.config.MinDialTimeout, t.cl.config.NominalDialTimeout, t.cl.config.HalfOpenConnsPerTorrent, t.peers.Len())
+	return reducedDialTimeout(t.cl.config.MinDialTimeout, t.cl.config.NominalDialTimeout, t.cl.settings.halfOpenConnsPerTorrent, t.peers.Len())
 }
 
 func (t *Torrent) piece(i int) *Piece {
@@ -2345,15 +2681,21 @@ func (t *Torrent) onWriteChunkErr(err error) {
 }
 
 func (t *Torrent) DisallowDataDownload() {
+	t.cl.lock()
+	defer t.cl.unlock()
 	t.disallowDataDownloadLocked()
 }
 
 func (t *Torrent) disallowDataDownloadLocked() {
 	t.dataDownloadDisallowed.Set()
+	t.updateDownloadingForPriority("data download disallowed")
 }
 
 func (t *Torrent) AllowDataDownload() {
+	t.cl.lock()
+	defer t.cl.unlock()
 	t.dataDownloadDisallowed.Clear()
+	t.updateDownloadingForPriority("data download allowed")
 }
 
 // Enables uploading data, if it was disabled.
@@ -2448,8 +2790,11 @@ func (t *Torrent) addWebSeed(url string, opts ...AddWebSeedsOpt) {
 			Url:        url,
 			ResponseBodyWrapper: func(r io.Reader) io.Reader {
 				return &rateLimitedReader{
-					l: t.cl.config.DownloadRateLimiter,
-					r: r,
+					l: t.cl.settings.downloadRateLimiter,
+					r: &rateLimitedReader{
+						l: t.downloadLimiter,
+						r: &namespaceLimitedReader{t: t, r: r},
+					},
 				}
 			},
 		},
@@ -2501,7 +2846,15 @@ func (t *Torrent) pieceRequestIndexOffset(piece pieceIndex) RequestIndex {
 }
 
 func (t *Torrent) updateComplete() {
-	t.Complete.SetBool(t.haveAllPieces())
+	complete := t.haveAllPieces()
+	if complete && !t.Complete.Bool() {
+		t.flushUploadReceipts()
+		t.publishEvent(DownloadCompletedEvent{})
+		if t.stats.BytesReadUsefulData.Int64() != 0 {
+			t.downloadCompleted.Set()
+		}
+	}
+	t.Complete.SetBool(complete)
 }
 
 func (t *Torrent) cancelRequest(r RequestIndex) *Peer {
@@ -1,5 +1,10 @@
 package torrent
 
+import (
+	"sync"
+	"time"
+)
+
 // Due to ConnStats, may require special alignment on some platforms. See
 // https://github.com/anacrolix/torrent/issues/383.
 type TorrentStats struct {
@@ -14,4 +19,97 @@ type TorrentStats struct {
 	ConnectedSeeders int
 	HalfOpenPeers    int
 	PiecesComplete   int
+	// See AvailabilitySample.DistributedCopies, and Torrent.AvailabilityHistory for past values.
+	DistributedCopies float64
+	// Attempts to deliver the Torrent's stats reports that no tracker accepted. Attempts are backed
+	// off while they're failing.
+	StatsReportsFailed int64
+	// Announces to the Torrent's HTTP and UDP trackers.
+	AnnounceStats
+	// The active peers by how their connections are protected.
+	ConnEncryption ConnEncryptionCounts
+}
+
+// Counts of tracker announces and their outcomes.
+type AnnounceStats struct {
+	AnnouncesSucceeded int64
+	AnnouncesFailed    int64
+}
+
+func (me *AnnounceStats) count(err error) {
+	if err == nil {
+		me.AnnouncesSucceeded++
+	} else {
+		me.AnnouncesFailed++
+	}
+}
+
+// Stats for a Torrent along with the activity since the previous sample.
+type TorrentStatsSample struct {
+	Total TorrentStats
+	// The ConnStats accumulated since the previous sample.
+	Delta ConnStats
+	// The time since the previous sample.
+	Interval time.Duration
+	// When the sample was taken.
+	at time.Time
+}
+
+// Takes samples of a Torrent's stats, computing the deltas between them. Each consumer, such as an
+// external metrics sampler, should have its own so they don't interfere. Safe for concurrent use.
+type TorrentStatsSampler struct {
+	// First for 64-bit alignment.
+	last     ConnStats
+	t        *Torrent
+	mu       sync.Mutex
+	lastTime time.Time
+}
+
+// Returns a sampler whose first sample has deltas since now.
+func (t *Torrent) NewStatsSampler() *TorrentStatsSampler {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return &TorrentStatsSampler{
+		last:     t.stats.Copy(),
+		t:        t,
+		lastTime: time.Now(),
+	}
+}
+
+// Returns the lifetime totals and the deltas since the previous sample. Both come from the same
+// snapshot of the stats, so the deltas of consecutive samples always sum to the totals.
+func (me *TorrentStatsSampler) Sample() TorrentStatsSample {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.t.cl.rLock()
+	defer me.t.cl.rUnlock()
+	return me.sampleLocked(time.Now())
+}
+
+// Takes a sample as of now, for samplers that are only used with the Client lock held.
+func (me *TorrentStatsSampler) sampleLocked(now time.Time) (ret TorrentStatsSample) {
+	ret = me.peekLocked(now)
+	me.advanceLocked(ret)
+	return
+}
+
+// Takes a sample as of now without making it the previous sample. A sampler with no previous
+// sample time gives no interval.
+func (me *TorrentStatsSampler) peekLocked(now time.Time) (ret TorrentStatsSample) {
+	ret.Total = me.t.statsLocked()
+	ret.Delta = ret.Total.ConnStats.Sub(&me.last)
+	if !me.lastTime.IsZero() {
+		ret.Interval = now.Sub(me.lastTime)
+	}
+	ret.at = now
+	return
+}
+
+// Makes the sample from peekLocked the previous sample, unless a later one already is.
+func (me *TorrentStatsSampler) advanceLocked(s TorrentStatsSample) {
+	if s.at.Before(me.lastTime) {
+		return
+	}
+	me.last = s.Total.ConnStats.Copy()
+	me.lastTime = s.at
 }
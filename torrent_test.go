@@ -1,6 +1,9 @@
 package torrent
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +11,7 @@ import (
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/anacrolix/generics"
 	"github.com/anacrolix/log"
@@ -251,3 +255,367 @@ func TestRelativeAvailabilityHaveNone(t *testing.T) {
 	tt.close(&wg)
 	tt.assertAllPiecesRelativeAvailabilityZero()
 }
+
+func TestPieceAccessors(t *testing.T) {
+	cl := &Client{config: TestingConfig(t)}
+	cl.initLogger()
+	tor := cl.newTorrent(metainfo.Hash{}, nil)
+	pieces := make([]byte, metainfo.HashSize*3)
+	pieces[metainfo.HashSize] = 1
+	require.NoError(t, tor.setInfo(&metainfo.Info{
+		Pieces:      pieces,
+		PieceLength: 4,
+		Length:      10,
+	}))
+	p := tor.Piece(1)
+	assert.EqualValues(t, 4, p.Offset())
+	assert.EqualValues(t, 4, p.Length())
+	assert.EqualValues(t, 1, p.Hash()[0])
+	assert.EqualValues(t, 2, tor.Piece(2).Length())
+	assert.EqualValues(t, 0, p.Availability())
+}
+
+func TestSwarmJoinPieceMilestones(t *testing.T) {
+	cl := &Client{config: TestingConfig(t)}
+	cl.initLogger()
+	tor := cl.newTorrent(metainfo.Hash{}, nil)
+	require.NoError(t, tor.setInfo(&metainfo.Info{
+		Pieces:      make([]byte, metainfo.HashSize*4),
+		PieceLength: 1,
+		Length:      4,
+	}))
+	assert.False(t, tor.joinTimes.Added.IsZero())
+	tor._completedPieces.Add(0)
+	tor.updateJoinPieceMilestones()
+	assert.NotZero(t, tor.joinTimes.FirstPiece)
+	assert.Zero(t, tor.joinTimes.HalfComplete)
+	tor._completedPieces.Add(1)
+	tor.updateJoinPieceMilestones()
+	assert.NotZero(t, tor.joinTimes.HalfComplete)
+	assert.Zero(t, tor.joinTimes.Complete)
+	tor._completedPieces.AddRange(2, 4)
+	tor.updateJoinPieceMilestones()
+	assert.NotZero(t, tor.joinTimes.Complete)
+	cl.config.AnnounceSwarmJoinTimes = true
+	vs := tor.announceExtraParams()
+	assert.NotEmpty(t, vs.Get("join_complete_ms"))
+	assert.Empty(t, vs.Get("join_first_peer_ms"))
+}
+
+func TestTorrentSchedulerParams(t *testing.T) {
+	cl := &Client{config: TestingConfig(t)}
+	cl.initLogger()
+	tor := cl.newTorrent(metainfo.Hash{}, nil)
+	assert.Zero(t, tor.schedulerParams())
+	cl.config.SchedulerParams = StaticSchedulerParams{UnchokeSlots: 2, PipelineDepth: 8}
+	assert.Equal(t, SchedulerParams{UnchokeSlots: 2, PipelineDepth: 8}, tor.schedulerParams())
+	tor.setTrackerSchedulerParams(SchedulerParams{PipelineDepth: 4})
+	assert.Equal(t, SchedulerParams{UnchokeSlots: 2, PipelineDepth: 4}, tor.schedulerParams())
+	assert.True(t, tor.unchokeSlotAvailable())
+	tor.setTrackerSchedulerParams(SchedulerParams{})
+	assert.Equal(t, SchedulerParams{UnchokeSlots: 2, PipelineDepth: 8}, tor.schedulerParams())
+	cl.config.ExperimentId = "exp-a"
+	assert.Equal(t, "exp-a", tor.announceExtraParams().Get("experiment_id"))
+}
+
+func TestTorrentMetadataProgress(t *testing.T) {
+	cl := &Client{config: TestingConfig(t)}
+	cl.initLogger()
+	tor := cl.newTorrent(metainfo.Hash{}, nil)
+	assert.Equal(t, MetadataProgress{}, tor.MetadataProgress())
+	require.NoError(t, tor.setMetadataSize(40000))
+	tor.saveMetadataPiece(1, make([]byte, 1<<14))
+	assert.Equal(t, MetadataProgress{Size: 40000, Pieces: 3, PiecesHave: 1}, tor.MetadataProgress())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, tor.WaitForInfo(ctx), context.Canceled)
+	tor.close(&sync.WaitGroup{})
+	assert.Error(t, tor.WaitForInfo(context.Background()))
+}
+
+func TestPeerMessageFlood(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.PeerMessageRate = 10
+	cfg.PeerMessageBurst = 20
+	// We initiate the connection without the fast extension.
+	cfg.MinPeerExtensions.SetBit(pp.ExtensionBitFast, false)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ih := testutil.GreetingMetaInfo().HashInfoBytes()
+	cl.AddTorrentInfoHash(ih)
+	nc, err := net.Dial("tcp", fmt.Sprintf(":%d", cl.LocalPort()))
+	require.NoError(t, err)
+	defer nc.Close()
+	_, err = pp.Handshake(nc, &ih, [20]byte{}, PeerExtensionBits{})
+	require.NoError(t, err)
+	keepalive := pp.Message{Keepalive: true}.MustMarshalBinary()
+	isTimeout := func(err error) bool {
+		var ne net.Error
+		return errors.As(err, &ne) && ne.Timeout()
+	}
+	// Within the burst the connection is kept. Haves don't count.
+	for i := 0; i < 10; i++ {
+		_, err := nc.Write(keepalive)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err := nc.Write(pp.Message{Type: pp.Have, Index: 0}.MustMarshalBinary())
+		require.NoError(t, err)
+	}
+	nc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = io.Copy(io.Discard, nc)
+	require.True(t, isTimeout(err), "%v", err)
+	for i := 0; i < 100; i++ {
+		if _, err := nc.Write(keepalive); err != nil {
+			break
+		}
+	}
+	nc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = io.Copy(io.Discard, nc)
+	// The Client closed the connection, rather than our read timing out.
+	require.False(t, isTimeout(err), "%v", err)
+}
+
+func TestStrictProtocol(t *testing.T) {
+	cfg := TestingConfig(t)
+	cfg.StrictProtocol = true
+	cfg.DisableAcceptRateLimiting = true
+	cfg.AlwaysWantConns = true
+	cfg.MinPeerExtensions.SetBit(pp.ExtensionBitFast, false)
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	mi := testutil.GreetingMetaInfo()
+	ih := mi.HashInfoBytes()
+	_, err = cl.AddTorrent(mi)
+	require.NoError(t, err)
+	isTimeout := func(err error) bool {
+		var ne net.Error
+		return errors.As(err, &ne) && ne.Timeout()
+	}
+	// Sends the messages from a new peer, and returns whether the Client disconnected it.
+	send := func(msgs ...pp.Message) bool {
+		nc, err := net.Dial("tcp", fmt.Sprintf(":%d", cl.LocalPort()))
+		require.NoError(t, err)
+		defer nc.Close()
+		_, err = pp.Handshake(nc, &ih, [20]byte{}, PeerExtensionBits{})
+		require.NoError(t, err)
+		for _, msg := range msgs {
+			_, err := nc.Write(msg.MustMarshalBinary())
+			require.NoError(t, err)
+		}
+		nc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = io.Copy(io.Discard, nc)
+		return !isTimeout(err)
+	}
+	bitfield := pp.Message{Type: pp.Bitfield, Bitfield: make([]bool, 8)}
+	assert.False(t, send(bitfield, pp.Message{Type: pp.Interested}, pp.Message{Type: pp.Have, Index: 2}))
+	assert.True(t, send(pp.Message{Type: pp.Interested}, bitfield))
+	assert.True(t, send(pp.Message{Type: pp.Have, Index: 3}))
+	assert.True(t, send(pp.Message{Type: pp.Bitfield, Bitfield: make([]bool, 16)}))
+	spare := make([]bool, 8)
+	spare[7] = true
+	assert.True(t, send(pp.Message{Type: pp.Bitfield, Bitfield: spare}))
+	assert.True(t, send(pp.Message{Type: pp.Request, Index: 2, Begin: 4, Length: 2}))
+	assert.True(t, send(pp.Message{Type: pp.HaveAll}))
+	assert.Equal(t, map[ProtocolViolationReason]int64{
+		ViolationBitfieldOrder:  1,
+		ViolationPieceIndex:     1,
+		ViolationBitfieldLength: 2,
+		ViolationChunkBounds:    1,
+		ViolationFastDisabled:   1,
+	}, cl.ProtocolViolations())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	for _, policy := range []RequestTimeoutPolicy{RequestTimeoutReassign, RequestTimeoutReassignElsewhere} {
+		t.Run(policy.String(), func(t *testing.T) {
+			testRequestTimeout(t, policy)
+		})
+	}
+}
+
+// Connects a peer that has all the pieces but never serves requests, and checks when the Client
+// cancels and requests the chunks again.
+func testRequestTimeout(t *testing.T, policy RequestTimeoutPolicy) {
+	const timeout = 200 * time.Millisecond
+	cfg := TestingConfig(t)
+	cfg.AlwaysWantConns = true
+	cfg.MinPeerExtensions.SetBit(pp.ExtensionBitFast, false)
+	cfg.SchedulerParams = StaticSchedulerParams{
+		RequestTimeout:       timeout,
+		RequestTimeoutPolicy: policy,
+	}
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	mi := testutil.GreetingMetaInfo()
+	ih := mi.HashInfoBytes()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.DownloadAll()
+	nc, err := net.Dial("tcp", fmt.Sprintf(":%d", cl.LocalPort()))
+	require.NoError(t, err)
+	defer nc.Close()
+	_, err = pp.Handshake(nc, &ih, [20]byte{}, PeerExtensionBits{})
+	require.NoError(t, err)
+	bitfield := pp.Message{Type: pp.Bitfield, Bitfield: make([]bool, 8)}
+	for i := 0; i < tt.NumPieces(); i++ {
+		bitfield.Bitfield[i] = true
+	}
+	_, err = nc.Write(append(bitfield.MustMarshalBinary(), pp.Message{Type: pp.Unchoke}.MustMarshalBinary()...))
+	require.NoError(t, err)
+	nc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	d := pp.Decoder{
+		R:         bufio.NewReader(nc),
+		MaxLength: 1 << 20,
+	}
+	requested := make(map[Request]time.Time)
+	cancelled := make(map[Request]time.Time)
+	for {
+		var msg pp.Message
+		require.NoError(t, d.Decode(&msg))
+		switch msg.Type {
+		case pp.Request:
+			r := newRequestFromMessage(&msg)
+			if when, ok := cancelled[r]; ok {
+				if policy == RequestTimeoutReassign {
+					assert.Less(t, int64(time.Since(when)), int64(timeout))
+				} else {
+					assert.GreaterOrEqual(t, int64(time.Since(when)), int64(timeout/2))
+				}
+				return
+			}
+			requested[r] = time.Now()
+		case pp.Cancel:
+			r := newRequestFromMessage(&msg)
+			when, ok := requested[r]
+			require.True(t, ok)
+			assert.GreaterOrEqual(t, int64(time.Since(when)), int64(timeout))
+			cancelled[r] = time.Now()
+		}
+	}
+}
+
+func TestPieceVerifiedCallback(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	var mu sync.Mutex
+	got := make(map[int]string)
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingTempDir
+	cfg.Callbacks.PieceVerified = append(cfg.Callbacks.PieceVerified, func(e PieceVerifiedEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[e.Index] = string(e.Data)
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	mu.Lock()
+	assert.Equal(t, map[int]string{0: "hello", 1: ",\x00wor", 2: "ld\n"}, got)
+	got = make(map[int]string)
+	mu.Unlock()
+	// Pieces that fail verification aren't passed on.
+	f, err := os.OpenFile(filepath.Join(greetingTempDir, testutil.GreetingFileName), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("W"), 7)
+	f.Close()
+	require.NoError(t, err)
+	tt.VerifyData()
+	mu.Lock()
+	assert.Equal(t, map[int]string{0: "hello", 2: "ld\n"}, got)
+	mu.Unlock()
+}
+
+func TestVerifyPiece(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	var mu sync.Mutex
+	var verify func(PieceVerification) error
+	cfg := TestingConfig(t)
+	cfg.DataDir = greetingTempDir
+	cfg.VerifyPiece = func(v PieceVerification) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return verify(v)
+	}
+	setVerify := func(f func(PieceVerification) error) {
+		mu.Lock()
+		defer mu.Unlock()
+		verify = f
+	}
+	// Augment the hash check with one that fails the middle piece.
+	setVerify(func(v PieceVerification) error {
+		if !v.HashOk {
+			return ErrPieceHashMismatch
+		}
+		if string(v.Data) == ",\x00wor" {
+			return errors.New("bad mac")
+		}
+		return nil
+	})
+	cl, err := NewClient(cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	assert.True(t, tt.PieceState(0).Complete)
+	assert.False(t, tt.PieceState(1).Complete)
+	assert.True(t, tt.PieceState(2).Complete)
+	// Replace the hash check with one that passes anything.
+	f, err := os.OpenFile(filepath.Join(greetingTempDir, testutil.GreetingFileName), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("W"), 7)
+	f.Close()
+	require.NoError(t, err)
+	setVerify(func(v PieceVerification) error {
+		return nil
+	})
+	tt.VerifyData()
+	assert.True(t, tt.PieceState(1).Complete)
+}
+
+func TestPieceHeat(t *testing.T) {
+	cl, err := NewClient(TestingConfig(t))
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{InfoHash: metainfo.Hash{1}})
+	require.NoError(t, err)
+	assert.Empty(t, tt.PieceHeat().Priorities)
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	tt, err = cl.AddTorrent(mi)
+	require.NoError(t, err)
+	// Pieces being checked have no priority.
+	tt.VerifyData()
+	tt.DownloadPieces(0, 2)
+	tt.Piece(2).SetPriority(PiecePriorityHigh)
+	// Pretend a peer was sent a request.
+	cl.lock()
+	tt.requestState[tt.pieceRequestIndexOffset(1)] = requestState{}
+	cl.unlock()
+	heat := tt.PieceHeat()
+	assert.Equal(t, []piecePriority{PiecePriorityNormal, PiecePriorityNormal, PiecePriorityHigh}, heat.Priorities)
+	assert.Equal(t, []int{0, 1, 0}, heat.Requests)
+	// The fake request has no peer, so remove it before the Torrent is closed.
+	cl.lock()
+	tt.requestState = make(map[RequestIndex]requestState)
+	cl.unlock()
+}
+
+// Chunk buffers only have room for payload transformations in torrents that use them.
+func TestChunkBufferSpare(t *testing.T) {
+	cl := newTestingClient(t)
+	tt := cl.newTorrentForTesting()
+	// Buffers pooled before the key is set still have room to decrypt payloads in place.
+	tt.chunkPool.Put(tt.chunkPool.Get())
+	tt.SetPreSharedKey([]byte("key"))
+	b := tt.chunkPool.Get().(*[]byte)
+	qt.Assert(t, cap(*b), qt.Equals, defaultChunkSize+payloadCryptOverhead+pieceCompressionOverhead)
+}
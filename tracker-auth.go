@@ -0,0 +1,43 @@
+package torrent
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Authentication for an HTTP tracker. See ClientConfig.TrackerCredentials.
+type TrackerCredentials struct {
+	// HTTP Basic auth, used if Username is set.
+	Username string
+	Password string
+	// Headers for this tracker, such as an authentication token.
+	Header http.Header
+}
+
+// Returns the headers to send with requests to the HTTP tracker at the URL, or nil if there are
+// none.
+func (cl *Client) trackerHttpHeader(u *url.URL) (ret http.Header) {
+	set := func(k string, vs []string) {
+		if ret == nil {
+			ret = make(http.Header)
+		}
+		ret[k] = vs
+	}
+	for k, vs := range cl.config.TrackerHTTPHeaders {
+		set(k, vs)
+	}
+	if cl.config.TrackerCredentials == nil {
+		return
+	}
+	creds := cl.config.TrackerCredentials(u)
+	for k, vs := range creds.Header {
+		set(k, vs)
+	}
+	if creds.Username != "" {
+		// Borrow the standard encoding rather than doing it ourselves.
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(creds.Username, creds.Password)
+		set("Authorization", req.Header.Values("Authorization"))
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package torrent
+
+import (
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// Ranks the peers from a tracker announce by the tracker's list of the fastest peers, for
+// ClientConfig.PreferFastPeersFromTracker. Ranked peers that weren't otherwise returned are added.
+// Reserve peers at the ranked addresses are dropped, so they're added again at their new rank, and
+// reserve peers the tracker ranked before but no longer lists lose their rank.
+func (t *Torrent) rankTrackerPeers(trackerUrl string, peers peerInfos, fast []tracker.Peer) peerInfos {
+	ranks := make(map[string]int, len(fast))
+	addrs := make(map[string]struct{}, len(fast))
+	for i, p := range fast {
+		addr := ipPortAddr{p.IP, p.Port}.String()
+		if _, ok := ranks[addr]; !ok {
+			ranks[addr] = i + 1
+			addrs[addr] = struct{}{}
+		}
+	}
+	unlisted := make(map[string]struct{}, len(addrs))
+	for addr := range addrs {
+		unlisted[addr] = struct{}{}
+	}
+	for i := range peers {
+		addr := peers[i].Addr.String()
+		if rank, ok := ranks[addr]; ok {
+			peers[i].TrackerSpeedRank = rank
+			peers[i].trackerSpeedRanker = trackerUrl
+			delete(unlisted, addr)
+		}
+	}
+	for _, p := range fast {
+		addr := ipPortAddr{p.IP, p.Port}.String()
+		if _, ok := unlisted[addr]; !ok {
+			continue
+		}
+		delete(unlisted, addr)
+		peers = peers.AppendFromTracker([]tracker.Peer{p})
+		peers[len(peers)-1].TrackerSpeedRank = ranks[addr]
+		peers[len(peers)-1].trackerSpeedRanker = trackerUrl
+	}
+	t.cl.lock()
+	defer t.cl.unlock()
+	var unranked []PeerInfo
+	t.peers.Each(func(p PeerInfo) {
+		if _, ok := ranks[p.Addr.String()]; !ok && p.TrackerSpeedRank > 0 && p.trackerSpeedRanker == trackerUrl {
+			unranked = append(unranked, p)
+		}
+	})
+	for _, p := range unranked {
+		addrs[p.Addr.String()] = struct{}{}
+	}
+	t.peers.DeleteAddrs(addrs)
+	for _, p := range unranked {
+		p.TrackerSpeedRank = 0
+		p.trackerSpeedRanker = ""
+		t.peers.Add(p)
+	}
+	return peers
+}
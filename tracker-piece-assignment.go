@@ -0,0 +1,42 @@
+package torrent
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// Asks HTTP trackers for a range of pieces to fetch first, if enabled by
+// ClientConfig.TrackerPieceAssignment. Trackers need the number of pieces to divide them.
+func (t *Torrent) addPieceAssignmentAnnounceParams(vs url.Values) {
+	if !t.cl.config.TrackerPieceAssignment || !t.haveInfo() {
+		return
+	}
+	vs.Set("num_pieces", strconv.Itoa(t.numPieces()))
+}
+
+// Raises the priority of wanted pieces in the range a tracker assigned us, so they're requested
+// before the others. The range is clipped to the torrent's pieces, and an empty range clears the
+// assignment.
+func (t *Torrent) setTrackerAssignedPieces(r tracker.PieceRange) {
+	if !t.haveInfo() {
+		return
+	}
+	if r.Begin < 0 {
+		r.Begin = 0
+	}
+	if r.End > t.numPieces() {
+		r.End = t.numPieces()
+	}
+	if r.Begin >= r.End {
+		r = tracker.PieceRange{}
+	}
+	if r == t.trackerAssignedPieces {
+		return
+	}
+	old := t.trackerAssignedPieces
+	t.trackerAssignedPieces = r
+	t.updatePiecePriorities(old.Begin, old.End, "tracker piece assignment changed")
+	t.updatePiecePriorities(r.Begin, r.End, "tracker piece assignment changed")
+}
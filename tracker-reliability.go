@@ -0,0 +1,206 @@
+package torrent
+
+import (
+	"bytes"
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/anacrolix/generics"
+
+	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+// Blames a peer that contributed to a piece that failed its hash check, for stats reports.
+func (t *Torrent) recordPeerHashFailure(p *Peer) {
+	pc, ok := p.TryAsPeerConn()
+	if !ok {
+		return
+	}
+	if t.hashFailuresByPeer == nil {
+		t.hashFailuresByPeer = make(map[PeerID]int64)
+	}
+	t.hashFailuresByPeer[pc.PeerID]++
+}
+
+func (t *Torrent) hashFailuresByPeerLocked() map[[20]byte]int64 {
+	if len(t.hashFailuresByPeer) == 0 {
+		return nil
+	}
+	ret := make(map[[20]byte]int64, len(t.hashFailuresByPeer))
+	for id, n := range t.hashFailuresByPeer {
+		ret[id] = n
+	}
+	return ret
+}
+
+// A reliability score from a tracker announce, and when it's forgotten unless a tracker scores the
+// peer again.
+type trackerReliabilityScore struct {
+	score   int
+	expires time.Time
+}
+
+// Scores are kept for the peer ID at the address the tracker saw it announce from, so peers can't
+// take the scores of others by copying their IDs.
+type trackerReliabilityKey struct {
+	addr netip.AddrPort
+	id   PeerID
+}
+
+func makeTrackerReliabilityKey(addr string, id PeerID) (trackerReliabilityKey, bool) {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return trackerReliabilityKey{}, false
+	}
+	return trackerReliabilityKey{netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), id}, true
+}
+
+// Scores are forgotten after this many of the tracker's announce intervals, or this long if it gave
+// none.
+const (
+	trackerReliabilityExpiryIntervals = 2
+	defaultTrackerReliabilityExpiry   = time.Hour
+)
+
+// Records the reliability scores from a tracker announce for ClientConfig.PreferReliablePeersFromTracker,
+// and sets them on the announced peers. Reserve peers at the scored addresses are dropped, so
+// they're added again with their new priority. Scores that trackers haven't repeated since their
+// announce intervals are forgotten.
+func (t *Torrent) applyTrackerReliability(
+	peers peerInfos, scores []tracker.PeerReliability, interval time.Duration,
+) peerInfos {
+	byAddr := make(map[string]int, len(scores))
+	addrs := make(map[string]struct{}, len(scores))
+	for _, s := range scores {
+		byAddr[s.Addr] = s.Score
+	}
+	for i := range peers {
+		addr := peers[i].Addr.String()
+		if score, ok := byAddr[addr]; ok {
+			peers[i].TrackerReliability = generics.Some(score)
+			addrs[addr] = struct{}{}
+		}
+	}
+	now := time.Now()
+	expires := now.Add(defaultTrackerReliabilityExpiry)
+	if interval > 0 {
+		expires = now.Add(trackerReliabilityExpiryIntervals * interval)
+	}
+	t.cl.lock()
+	defer t.cl.unlock()
+	for id, s := range t.trackerReliability {
+		if now.After(s.expires) {
+			delete(t.trackerReliability, id)
+		}
+	}
+	if t.trackerReliability == nil {
+		t.trackerReliability = make(map[trackerReliabilityKey]trackerReliabilityScore)
+	}
+	for _, s := range scores {
+		if key, ok := makeTrackerReliabilityKey(s.Addr, s.PeerId); ok {
+			t.trackerReliability[key] = trackerReliabilityScore{s.Score, expires}
+		}
+	}
+	t.peers.DeleteAddrs(addrs)
+	return peers
+}
+
+// Peers scored at least neutrally by trackers are dialed before unscored peers, and the rest after
+// them, most reliable first.
+func trackerReliabilityPeerPriority(score int) peerPriority {
+	if score >= httpTracker.NeutralReliabilityScore {
+		return math.MaxUint32 - 1<<16 + peerPriority(score)
+	}
+	return peerPriority(score)
+}
+
+// The reliability score trackers gave the peer, or the neutral score if they gave none or it's
+// expired. Scores only apply if the peer's ID and address match what it announced with, so
+// incoming connections from peers that haven't given their listen ports are unscored.
+func (t *Torrent) peerReliability(c *PeerConn) int {
+	key, ok := makeTrackerReliabilityKey(c.dialAddr().String(), c.PeerID)
+	if !ok {
+		return httpTracker.NeutralReliabilityScore
+	}
+	if s, ok := t.trackerReliability[key]; ok && !time.Now().After(s.expires) {
+		return s.score
+	}
+	return httpTracker.NeutralReliabilityScore
+}
+
+// Whether l is less reliable than r, with ties broken by peer ID so that the order is total.
+func (t *Torrent) lessReliable(l, r *PeerConn) bool {
+	ls, rs := t.peerReliability(l), t.peerReliability(r)
+	if ls != rs {
+		return ls < rs
+	}
+	return bytes.Compare(l.PeerID[:], r.PeerID[:]) < 0
+}
+
+// The unchoked peer that gives up its unchoke slot to more reliable peers when the slots are full.
+func (t *Torrent) leastReliableUnchoked() (ret *PeerConn) {
+	for c := range t.conns {
+		if !c.choking && (ret == nil || t.lessReliable(c, ret)) {
+			ret = c
+		}
+	}
+	return
+}
+
+// Whether c is choked and would be unchoked if there were a slot for it.
+func (t *Torrent) waitingForUnchoke(c *PeerConn) bool {
+	return c.choking && c.peerInterested && c.uploadAllowedIgnoringUnchokeSlots()
+}
+
+// Whether a peer more reliable than c is waiting for an unchoke slot.
+func (t *Torrent) moreReliableWaitingForUnchoke(c *PeerConn) bool {
+	for w := range t.conns {
+		if w != c && t.lessReliable(c, w) && t.waitingForUnchoke(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the choked peer c can take an unchoke slot. With
+// ClientConfig.PreferReliablePeersFromTracker, free slots go to the most reliable waiting peers,
+// and when there are none free, the least reliable unchoked peer is made to give up its slot if
+// it's less reliable than c.
+func (t *Torrent) unchokeSlotFor(c *PeerConn) bool {
+	if !t.unchokeSlotAvailable() {
+		if t.cl.config.PreferReliablePeersFromTracker {
+			if l := t.leastReliableUnchoked(); l != nil && t.lessReliable(l, c) {
+				l.tickleWriter()
+			}
+		}
+		return false
+	}
+	if !t.cl.config.PreferReliablePeersFromTracker || t.schedulerParams().UnchokeSlots <= 0 {
+		return true
+	}
+	return !t.moreReliableWaitingForUnchoke(c)
+}
+
+// Whether the unchoked peer c is to give up its slot to a more reliable waiting peer. See
+// unchokeSlotFor.
+func (t *Torrent) unchokePreempted(c *PeerConn) bool {
+	if !t.cl.config.PreferReliablePeersFromTracker || t.unchokeSlotAvailable() {
+		return false
+	}
+	return t.leastReliableUnchoked() == c && t.moreReliableWaitingForUnchoke(c)
+}
+
+// Lets peers waiting for an unchoke slot try again, when one is freed, or taken by a more reliable
+// peer that they were held back for.
+func (t *Torrent) retryWaitingUnchokes() {
+	if !t.cl.config.PreferReliablePeersFromTracker || t.schedulerParams().UnchokeSlots <= 0 {
+		return
+	}
+	for c := range t.conns {
+		if c.choking && c.peerInterested {
+			c.tickleWriter()
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A Torrent's trackers in BEP 12 announce-list tiers, for ClientConfig.TrackerTierFailover.
+type trackerTiers struct {
+	// Tracker URLs by tier. Each tracker is put in a random position in its tier when it's added,
+	// and moved to the front when it works.
+	tiers [][]string
+	// The scrapers to announce to for each tracker URL. UDP trackers have one for each address
+	// family, and WebTorrent trackers have none as they're always announced to.
+	scrapers map[string][]*trackerScraper
+	// The tracker last announced to successfully.
+	current string
+	running bool
+}
+
+func (me *trackerTiers) has(url string) bool {
+	_, ok := me.scrapers[url]
+	return ok
+}
+
+func (me *trackerTiers) add(tier int, url string, scrapers []*trackerScraper) {
+	for len(me.tiers) <= tier {
+		me.tiers = append(me.tiers, nil)
+	}
+	urls := append(me.tiers[tier], "")
+	i := rand.Intn(len(urls))
+	copy(urls[i+1:], urls[i:])
+	urls[i] = url
+	me.tiers[tier] = urls
+	if me.scrapers == nil {
+		me.scrapers = make(map[string][]*trackerScraper)
+	}
+	me.scrapers[url] = scrapers
+}
+
+// Moves the tracker to the front of its tier.
+func (me *trackerTiers) promote(tier int, url string) {
+	urls := me.tiers[tier]
+	for i, u := range urls {
+		if u == url {
+			copy(urls[1:i+1], urls[:i])
+			urls[0] = url
+			return
+		}
+	}
+}
+
+// Adds trackers from the announce-list that aren't known yet to their tiers, and starts announcing
+// to the tiers. Must be called with the Client lock held.
+func (t *Torrent) addTrackerTiers() {
+	for tier, urls := range t.metainfo.UpvertedAnnounceList() {
+		for _, u := range urls {
+			if u == "" || t.trackerTiers.has(u) {
+				continue
+			}
+			t.trackerTiers.add(tier, u, t.startScrapingTracker(u))
+		}
+	}
+	if !t.trackerTiers.running && len(t.trackerTiers.scrapers) != 0 {
+		t.trackerTiers.running = true
+		go t.announceTrackerTiers()
+	}
+}
+
+func (t *Torrent) announceTrackerTiers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+		case <-t.Closed():
+		}
+	}()
+	for {
+		ar := t.announceTrackerTiersOnce(ctx)
+		if !t.waitNextTrackerAnnounce(ar, t.trackerTiersCompletedDue()) {
+			return
+		}
+	}
+}
+
+// Returns a channel that receives when the completed event is due to be announced to the current
+// tracker.
+func (t *Torrent) trackerTiersCompletedDue() <-chan struct{} {
+	t.cl.rLock()
+	scrapers := t.trackerTiers.scrapers[t.trackerTiers.current]
+	t.cl.rUnlock()
+	for _, s := range scrapers {
+		if due := s.completedDue(); due != nil {
+			return due
+		}
+	}
+	return nil
+}
+
+// Announces to trackers in tier order, trying each tracker in a tier until one works, and moving
+// that one to the front of its tier. Returns the result from the tracker that worked, or from the
+// last one tried if none did.
+func (t *Torrent) announceTrackerTiersOnce(ctx context.Context) (ret trackerAnnounceResult) {
+	ret.Err = errors.New("no trackers")
+	ret.Completed = time.Now()
+	started := ret.Completed
+	defer func() {
+		ret.Started = started
+	}()
+	for tier := 0; ; tier++ {
+		t.cl.lock()
+		if tier >= len(t.trackerTiers.tiers) {
+			t.cl.unlock()
+			return
+		}
+		urls := append([]string(nil), t.trackerTiers.tiers[tier]...)
+		t.cl.unlock()
+		for _, u := range urls {
+			if ctx.Err() != nil {
+				return
+			}
+			t.cl.lock()
+			scrapers := t.trackerTiers.scrapers[u]
+			t.cl.unlock()
+			if len(scrapers) == 0 {
+				continue
+			}
+			ret = announceTieredTracker(ctx, scrapers)
+			if ret.Err == nil {
+				t.cl.lock()
+				t.trackerTiers.promote(tier, u)
+				t.trackerTiers.current = u
+				t.cl.unlock()
+				return
+			}
+		}
+	}
+}
+
+// Announces to each of a tracker's scrapers at once, and combines the results. The tracker works
+// if any of its scrapers do.
+func announceTieredTracker(ctx context.Context, scrapers []*trackerScraper) (ret trackerAnnounceResult) {
+	results := make([]trackerAnnounceResult, len(scrapers))
+	var wg sync.WaitGroup
+	for i, s := range scrapers {
+		wg.Add(1)
+		go func(i int, s *trackerScraper) {
+			defer wg.Done()
+			event := s.nextEvent()
+			results[i] = s.announce(ctx, event)
+			s.recordAnnounce(ctx, event, results[i])
+		}(i, s)
+	}
+	wg.Wait()
+	ret = results[0]
+	for _, ar := range results[1:] {
+		if ret.Err != nil && ar.Err == nil {
+			ret.Err = nil
+			ret.Interval = ar.Interval
+			ret.Warning = ar.Warning
+			ret.MinInterval = ar.MinInterval
+		}
+		if ar.Err == nil {
+			ret.NumPeers += ar.NumPeers
+		}
+		if ar.Completed.After(ret.Completed) {
+			ret.Completed = ar.Completed
+		}
+	}
+	return
+}
+
+// The state of one of a Torrent's trackers. See Torrent.TrackerStatuses.
+type TrackerStatus struct {
+	// UDP trackers have a status for each address family, with the scheme udp4 or udp6.
+	Url string
+	// The tracker's tier in the announce-list, from zero.
+	Tier int
+	// With ClientConfig.TrackerTierFailover, whether this is the tracker that was last announced to
+	// successfully.
+	Current bool
+	// The time of the last announce, and zero if there hasn't been one. WebTorrent trackers don't
+	// report their announces here.
+	LastAnnounce time.Time
+	// The error from the last announce.
+	Err error
+	// The reason the tracker gave for failing the last announce, if Err came from the tracker.
+	FailureReason string
+	// The warning the tracker gave with the last announce, if it succeeded.
+	Warning  string
+	NumPeers int
+	// The interval the tracker gave in the last announce.
+	Interval time.Duration
+}
+
+// Returns the status of each tracker being announced to, ordered by tier. With
+// ClientConfig.TrackerTierFailover, trackers are in the order they're tried within each tier.
+func (t *Torrent) TrackerStatuses() (ret []TrackerStatus) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	tiers := [][]string(t.metainfo.UpvertedAnnounceList())
+	if t.cl.config.TrackerTierFailover {
+		tiers = t.trackerTiers.tiers
+	}
+	seen := make(map[string]bool)
+	for tier, urls := range tiers {
+		for _, u := range urls {
+			for _, key := range trackerAnnouncerKeys(u) {
+				ta, ok := t.trackerAnnouncers[key]
+				if !ok || seen[key] {
+					continue
+				}
+				seen[key] = true
+				ts := TrackerStatus{
+					Url:     key,
+					Tier:    tier,
+					Current: t.cl.config.TrackerTierFailover && u == t.trackerTiers.current,
+				}
+				if s, ok := ta.(*trackerScraper); ok {
+					ar := s.lastAnnounce
+					ts.LastAnnounce = ar.Completed
+					ts.Err = ar.Err
+					ts.FailureReason = trackerFailureReason(ar.Err)
+					ts.Warning = ar.Warning
+					ts.NumPeers = ar.NumPeers
+					ts.Interval = ar.Interval
+				}
+				ret = append(ret, ts)
+			}
+		}
+	}
+	return
+}
+
+// Returns the keys in Torrent.trackerAnnouncers for the tracker URL. See startScrapingTracker.
+func trackerAnnouncerKeys(_url string) []string {
+	u, err := url.Parse(_url)
+	if err != nil || u.Scheme != "udp" {
+		return []string{_url}
+	}
+	u.Scheme = "udp4"
+	ret := []string{u.String()}
+	u.Scheme = "udp6"
+	return append(ret, u.String())
+}
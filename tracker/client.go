@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"net/url"
+	"time"
 
 	"github.com/anacrolix/log"
 
@@ -18,12 +19,23 @@ type Client interface {
 
 type AnnounceOpt = trHttp.AnnounceOpt
 
+type ScrapeResponse = udp.ScrapeResponse
+
+// Implemented by Clients that support scraping trackers for swarm statistics without announcing.
+type Scraper interface {
+	Scrape(ctx context.Context, ihs []udp.InfoHash) (ScrapeResponse, error)
+}
+
 type NewClientOpts struct {
 	Http trHttp.NewClientOpts
 	// Overrides the network in the scheme. Probably a legacy thing.
 	UdpNetwork   string
 	Logger       log.Logger
 	ListenPacket func(network, addr string) (net.PacketConn, error)
+	// If set, UDP tracker clients are shared through the pool, and aren't closed with the Client.
+	UdpPool *udp.ConnClientPool
+	// See udp.Client.RetransmitTimeout.
+	UdpRetransmitTimeout time.Duration
 }
 
 func NewClient(urlStr string, opts NewClientOpts) (Client, error) {
@@ -39,19 +51,29 @@ func NewClient(urlStr string, opts NewClientOpts) (Client, error) {
 		if opts.UdpNetwork != "" {
 			network = opts.UdpNetwork
 		}
-		cc, err := udp.NewConnClient(udp.NewConnClientOpts{
-			Network:      network,
-			Host:         _url.Host,
-			Logger:       opts.Logger,
-			ListenPacket: opts.ListenPacket,
-		})
+		ccOpts := udp.NewConnClientOpts{
+			Network:           network,
+			Host:              _url.Host,
+			Logger:            opts.Logger,
+			ListenPacket:      opts.ListenPacket,
+			RetransmitTimeout: opts.UdpRetransmitTimeout,
+		}
+		ret := &udpClient{
+			requestUri: _url.RequestURI(),
+		}
+		if opts.UdpPool != nil {
+			ret.cl, err = opts.UdpPool.Get(ccOpts)
+			ret.pooled = err == nil
+		}
+		if !ret.pooled {
+			// Such as when a Client's pool is closed, and its Torrents are announcing they've
+			// stopped.
+			ret.cl, err = udp.NewConnClient(ccOpts)
+		}
 		if err != nil {
 			return nil, err
 		}
-		return &udpClient{
-			cl:         cc,
-			requestUri: _url.RequestURI(),
-		}, nil
+		return ret, nil
 	default:
 		return nil, ErrBadScheme
 	}
@@ -9,8 +9,9 @@ import (
 )
 
 type Client struct {
-	hc   *http.Client
-	url_ *url.URL
+	hc     *http.Client
+	url_   *url.URL
+	header http.Header
 }
 
 type (
@@ -23,11 +24,15 @@ type NewClientOpts struct {
 	DialContext    DialContextFunc
 	ServerName     string
 	AllowKeepAlive bool
+	// Added to every request, such as for trackers that authenticate with a token. Basic auth
+	// credentials in the tracker URL are also used.
+	Header http.Header
 }
 
 func NewClient(url_ *url.URL, opts NewClientOpts) Client {
 	return Client{
-		url_: url_,
+		url_:   url_,
+		header: opts.Header,
 		hc: &http.Client{
 			Transport: &http.Transport{
 				DialContext: opts.DialContext,
@@ -47,3 +52,10 @@ func (cl Client) Close() error {
 	cl.hc.CloseIdleConnections()
 	return nil
 }
+
+// Adds the headers from NewClientOpts.Header to the request.
+func (cl Client) setHeaders(req *http.Request) {
+	for k, vs := range cl.header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+}
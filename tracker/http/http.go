@@ -67,6 +67,12 @@ func setAnnounceParams(_url *url.URL, ar *AnnounceRequest, opts AnnounceOpt) {
 	}
 	doIp("ipv4", opts.ClientIp4)
 	doIp("ipv6", opts.ClientIp6)
+	for k, vs := range opts.ExtraParams {
+		if _, ok := q[k]; ok {
+			continue
+		}
+		q[k] = vs
+	}
 	// We're operating purely on query-escaped strings, where + would have already been encoded to
 	// %2B, and + has no other special meaning. See https://github.com/anacrolix/torrent/issues/534.
 	qstr := strings.ReplaceAll(q.Encode(), "+", "%20")
@@ -85,6 +91,9 @@ type AnnounceOpt struct {
 	ClientIp4           net.IP
 	ClientIp6           net.IP
 	HttpRequestDirector func(*http.Request) error
+	// Additional query parameters, for extensions understood by particular trackers. These can't
+	// override the standard announce parameters.
+	ExtraParams url.Values
 }
 
 type AnnounceRequest = udp.AnnounceRequest
@@ -100,6 +109,7 @@ func (cl Client) Announce(ctx context.Context, ar AnnounceRequest, opt AnnounceO
 	if userAgent != "" {
 		req.Header.Set("User-Agent", userAgent)
 	}
+	cl.setHeaders(req)
 
 	if opt.HttpRequestDirector != nil {
 		err = opt.HttpRequestDirector(req)
@@ -130,13 +140,15 @@ func (cl Client) Announce(ctx context.Context, ar AnnounceRequest, opt AnnounceO
 		return
 	}
 	if trackerResponse.FailureReason != "" {
-		err = fmt.Errorf("tracker gave failure reason: %q", trackerResponse.FailureReason)
+		err = FailureReasonError{trackerResponse.FailureReason}
 		return
 	}
 	vars.Add("successful http announces", 1)
 	ret.Interval = trackerResponse.Interval
 	ret.Leechers = trackerResponse.Incomplete
 	ret.Seeders = trackerResponse.Complete
+	ret.WarningMessage = trackerResponse.WarningMessage
+	ret.MinInterval = trackerResponse.MinInterval
 	if len(trackerResponse.Peers.List) != 0 {
 		vars.Add("http responses with nonempty peers key", 1)
 	}
@@ -157,6 +169,18 @@ func (cl Client) Announce(ctx context.Context, ar AnnounceRequest, opt AnnounceO
 		baselineProvider := trackerResponse.BaselineProvider.List[0]
 		ret.BaselineProvider = baselineProvider
 	}
+	ret.UnchokeSlots = trackerResponse.UnchokeSlots
+	ret.PipelineDepth = trackerResponse.PipelineDepth
+	for _, na := range trackerResponse.FastPeers {
+		ret.FastPeers = append(ret.FastPeers, Peer{}.FromNodeAddr(na))
+	}
+	for _, na := range trackerResponse.FastPeers6 {
+		ret.FastPeers = append(ret.FastPeers, Peer{}.FromNodeAddr(na))
+	}
+	ret.Reliability = trackerResponse.Reliability
+	if ap := trackerResponse.AssignedPieces; len(ap) == 2 && ap[0] < ap[1] {
+		ret.AssignedPieces = PieceRange{ap[0], ap[1]}
+	}
 	return
 }
 
@@ -166,4 +190,37 @@ type AnnounceResponse struct {
 	Seeders          int32
 	Peers            []Peer
 	BaselineProvider Peer
+	// ReliableBT: scheduler parameters pushed by the tracker. Zero if not given.
+	UnchokeSlots  int32
+	PipelineDepth int32
+	// A warning from the tracker about an otherwise successful announce.
+	WarningMessage string
+	// Minimum seconds between announces, even when they're forced. Zero if not given.
+	MinInterval int32
+	// ReliableBT: peers the tracker ranks fastest by their reported upload rates, fastest first
+	// with IPv4 peers before IPv6.
+	FastPeers []Peer
+	// ReliableBT: the pieces the tracker assigns the local peer to fetch first, so that a flash
+	// crowd fetches different pieces from the seeds. Empty if none were assigned.
+	AssignedPieces PieceRange
+	// ReliableBT: reliability scores the tracker gives the returned peers it knows.
+	Reliability []PeerReliability
+}
+
+// A range of piece indexes [Begin, End).
+type PieceRange struct {
+	Begin, End int
+}
+
+func (me PieceRange) Contains(i int) bool {
+	return i >= me.Begin && i < me.End
+}
+
+// The failure reason an HTTP tracker responded with.
+type FailureReasonError struct {
+	Reason string
+}
+
+func (me FailureReasonError) Error() string {
+	return fmt.Sprintf("tracker gave failure reason: %q", me.Reason)
 }
@@ -1,8 +1,12 @@
 package httpTracker
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/tracker/udp"
@@ -73,3 +77,150 @@ func TestSetAnnounceInfohashParamWithSpaces(t *testing.T) {
 		qt.Contains,
 		"info_hash=%2Bv%0A%A1x%93%200%C8G%DC%DF%8E%AE%BFV%0A%1B%D1l")
 }
+
+func TestSetAnnounceExtraParams(t *testing.T) {
+	someUrl := &url.URL{}
+	setAnnounceParams(someUrl, &udp.AnnounceRequest{Port: 1}, AnnounceOpt{
+		ExtraParams: url.Values{
+			"join_first_peer_ms": {"42"},
+			"port":               {"2"},
+		},
+	})
+	q := someUrl.Query()
+	qt.Check(t, q.Get("join_first_peer_ms"), qt.Equals, "42")
+	qt.Check(t, q.Get("port"), qt.Equals, "1")
+}
+
+func TestUnmarshalHttpResponseSchedulerParams(t *testing.T) {
+	var hr HttpResponse
+	require.NoError(t, bencode.Unmarshal(
+		[]byte("d13:pipelineDepthi32e12:unchokeSlotsi4ee"),
+		&hr,
+	))
+	assert.EqualValues(t, 4, hr.UnchokeSlots)
+	assert.EqualValues(t, 32, hr.PipelineDepth)
+}
+
+func TestScrapeUrl(t *testing.T) {
+	for _, tc := range []struct{ announce, scrape string }{
+		{"http://example.com/announce", "http://example.com/scrape"},
+		{"http://example.com/x/announce.php?passkey=1", "http://example.com/x/scrape.php?passkey=1"},
+		{"http://example.com/a", ""},
+	} {
+		u, err := url.Parse(tc.announce)
+		qt.Assert(t, err, qt.IsNil)
+		s, err := scrapeUrl(u)
+		if tc.scrape == "" {
+			qt.Check(t, err, qt.Equals, ErrScrapeNotSupported)
+			continue
+		}
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, s.String(), qt.Equals, tc.scrape)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	ih := [20]byte{1, 2, 3}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qt.Check(t, r.URL.Path, qt.Equals, "/scrape")
+		qt.Check(t, r.URL.Query().Get("info_hash"), qt.Equals, string(ih[:]))
+		bencode.NewEncoder(w).Encode(scrapeResponse{Files: map[string]scrapeFileResult{
+			string(ih[:]): {Complete: 3, Downloaded: 10, Incomplete: 2},
+		}})
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL + "/announce")
+	qt.Assert(t, err, qt.IsNil)
+	res, err := NewClient(u, NewClientOpts{}).Scrape(context.Background(), []udp.InfoHash{ih, {}})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, res, qt.DeepEquals, udp.ScrapeResponse{
+		{Seeders: 3, Completed: 10, Leechers: 2},
+		{},
+	})
+}
+
+func testStatsReport() StatsReport {
+	return StatsReport{
+		InfoHash:        [20]byte{1},
+		PeerId:          [20]byte{2},
+		Downloaded:      10,
+		Uploaded:        20,
+		Left:            -1,
+		UploadedDelta:   8,
+		DownloadedDelta: 4,
+		UploadedTo:      map[[20]byte]int64{{3}: 5, {4}: 15},
+		HashFailures:    map[[20]byte]int64{{4}: 2},
+		Receipts:        [][]byte{[]byte("d1:ni5ee"), {0, ' ', '+'}},
+		Time:            1700000000,
+		Elapsed:         90 * time.Second,
+		Interval:        1500 * time.Millisecond,
+		ActivePeers:     3,
+		Pieces: StatsReportPieces{
+			Total:                  10,
+			Complete:               4,
+			Unavailable:            1,
+			DistributedCopiesMilli: 1500,
+		},
+		ExperimentId: "exp1",
+	}
+}
+
+func TestStatsReportBody(t *testing.T) {
+	r := testStatsReport()
+	b, err := r.MarshalBody()
+	qt.Assert(t, err, qt.IsNil)
+	r2, err := ParseStatsReportBody(b)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, r2, qt.DeepEquals, r)
+	// Unknown keys are ignored, but unknown versions aren't.
+	var m map[string]interface{}
+	qt.Assert(t, bencode.Unmarshal(b, &m), qt.IsNil)
+	m["future"] = "x"
+	_, err = ParseStatsReportBody(bencode.MustMarshal(m))
+	qt.Check(t, err, qt.IsNil)
+	m["v"] = StatsReportVersion + 1
+	_, err = ParseStatsReportBody(bencode.MustMarshal(m))
+	qt.Check(t, err, qt.ErrorMatches, "unsupported stats report version.*")
+}
+
+func TestStatsReportValues(t *testing.T) {
+	r := testStatsReport()
+	r2, err := ParseStatsReport(r.Values())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, r2, qt.DeepEquals, r)
+	vs := r.Values()
+	vs.Set("uploaded_to", "nothex:1")
+	_, err = ParseStatsReport(vs)
+	qt.Check(t, err, qt.IsNotNil)
+}
+
+func TestReport(t *testing.T) {
+	var got StatsReport
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qt.Check(t, r.URL.Path, qt.Equals, "/report")
+		qt.Check(t, r.URL.Query().Get("passkey"), qt.Equals, "x")
+		qt.Check(t, r.Method, qt.Equals, http.MethodPost)
+		var err error
+		got, err = ParseStatsReportRequest(r)
+		qt.Check(t, err, qt.IsNil)
+		bencode.NewEncoder(w).Encode(ReportResponse{
+			Peers:         map[string]ReportedPeer{"peer": {Uploaded: 1, Downloaded: 2}},
+			DownloadSpeed: 3,
+			UploadSpeed:   4,
+		})
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL + "/announce?passkey=x")
+	qt.Assert(t, err, qt.IsNil)
+	r := testStatsReport()
+	resp, err := NewClient(u, NewClientOpts{}).Report(context.Background(), r, ReportOpt{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, got, qt.DeepEquals, r)
+	qt.Check(t, resp.Peers, qt.DeepEquals, map[string]ReportedPeer{"peer": {Uploaded: 1, Downloaded: 2}})
+	qt.Check(t, resp.DownloadSpeed, qt.Equals, int64(3))
+	qt.Check(t, resp.UploadSpeed, qt.Equals, int64(4))
+	u, err = url.Parse(s.URL + "/a")
+	qt.Assert(t, err, qt.IsNil)
+	_, err = NewClient(u, NewClientOpts{}).Report(context.Background(), r, ReportOpt{})
+	qt.Check(t, err, qt.Equals, ErrReportNotSupported)
+}
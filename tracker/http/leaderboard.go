@@ -0,0 +1,62 @@
+package httpTracker
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrLeaderboardNotSupported = errors.New("tracker URL doesn't support leaderboards")
+
+// A peer's contribution to a swarm, from its stats reports.
+type LeaderboardEntry struct {
+	PeerId     [20]byte `bencode:"peer id"`
+	Uploaded   int64    `bencode:"uploaded"`
+	Downloaded int64    `bencode:"downloaded"`
+	// Bytes uploaded to other peers as attested by their signed upload receipts.
+	Credit int64 `bencode:"credit"`
+	// Bytes per second between the peer's last two reports, from their monotonic elapsed times.
+	UploadRate   int64 `bencode:"upload rate"`
+	DownloadRate int64 `bencode:"download rate"`
+}
+
+type LeaderboardResponse struct {
+	FailureReason string `bencode:"failure reason,omitempty"`
+	// Ordered from the greatest contributor.
+	Entries []LeaderboardEntry `bencode:"entries"`
+}
+
+// Fetches the contribution leaderboard for a swarm. This is a ReliableBT extension, at the
+// "leaderboard" counterpart of the announce URL.
+func (cl Client) Leaderboard(ctx context.Context, infoHash [20]byte, opt ReportOpt) (ret []LeaderboardEntry, err error) {
+	_url, err := swarmSiblingUrl(cl.url_, "leaderboard", ErrLeaderboardNotSupported, infoHash)
+	if err != nil {
+		return
+	}
+	var resp LeaderboardResponse
+	err = cl.getBencoded(ctx, _url, opt, &resp)
+	if err != nil {
+		return
+	}
+	if resp.FailureReason != "" {
+		err = FailureReasonError{resp.FailureReason}
+		return
+	}
+	return resp.Entries, nil
+}
+
+// Returns the URL for a ReliableBT tracker endpoint that's queried about a swarm.
+func swarmSiblingUrl(announce *url.URL, name string, errNotSupported error, infoHash [20]byte) (*url.URL, error) {
+	ret, err := announceSiblingUrl(announce, name, errNotSupported)
+	if err != nil {
+		return nil, err
+	}
+	qstr := "info_hash=" + strings.ReplaceAll(url.QueryEscape(string(infoHash[:])), "+", "%20")
+	if ret.RawQuery != "" {
+		ret.RawQuery += "&" + qstr
+	} else {
+		ret.RawQuery = qstr
+	}
+	return ret, nil
+}
@@ -17,9 +17,27 @@ type HttpResponse struct {
 	Peers         Peers  `bencode:"peers"`
 	// BEP 7
 	Peers6 krpc.CompactIPv6NodeAddrs `bencode:"peers6"`
+	// Given alongside an otherwise successful response.
+	WarningMessage string `bencode:"warning message,omitempty"`
+	// The tracker asks that announces aren't more frequent than this, in seconds, even when
+	// clients have reason to announce sooner than the interval.
+	MinInterval int32 `bencode:"min interval,omitempty"`
 	// ReliableBT : bencode doesn't seem to like other types, so Peers would have to do
 	// a non-empty baselineProvider list will always have exactly 1 baselineProvider for use
 	BaselineProvider Peers `bencode:"baselineProvider"`
+	// ReliableBT: scheduler parameters the tracker assigns to this peer for experiments. Zero or
+	// absent means the client's own settings are used.
+	UnchokeSlots  int32 `bencode:"unchokeSlots,omitempty"`
+	PipelineDepth int32 `bencode:"pipelineDepth,omitempty"`
+	// ReliableBT: the peers with the highest upload rates in their stats reports, fastest first.
+	// These may also be in Peers and Peers6.
+	FastPeers  krpc.CompactIPv4NodeAddrs `bencode:"fastPeers,omitempty"`
+	FastPeers6 krpc.CompactIPv6NodeAddrs `bencode:"fastPeers6,omitempty"`
+	// ReliableBT: the begin and end of the range of pieces the tracker assigns this peer to fetch
+	// first, if it gave the number of pieces in its announce.
+	AssignedPieces []int `bencode:"assignedPieces,omitempty"`
+	// ReliableBT: reliability scores for the returned peers the tracker knows.
+	Reliability []PeerReliability `bencode:"reliability,omitempty"`
 }
 
 type Peers struct {
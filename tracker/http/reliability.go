@@ -0,0 +1,62 @@
+package httpTracker
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrReliabilityNotSupported = errors.New("tracker URL doesn't support reliability scores")
+
+// Reliability scores range from zero to MaxReliabilityScore. Peers a tracker knows nothing for or
+// against have the neutral score.
+const (
+	NeutralReliabilityScore = 50
+	MaxReliabilityScore     = 100
+)
+
+// A peer's reliability score, given with the peers in announce responses.
+type PeerReliability struct {
+	PeerId [20]byte `bencode:"peer id"`
+	// The address the peer announced from, as host:port.
+	Addr  string `bencode:"addr"`
+	Score int    `bencode:"score"`
+}
+
+// What a peer's reliability score is made of.
+type ReliabilityEntry struct {
+	PeerId [20]byte `bencode:"peer id"`
+	Score  int      `bencode:"score"`
+	// Announces that came within the interval given with the peer's previous announce, and those
+	// that came later.
+	OnTimeAnnounces int `bencode:"on time announces"`
+	LateAnnounces   int `bencode:"late announces"`
+	// Bytes uploaded to other peers as attested by their signed upload receipts.
+	Credit int64 `bencode:"credit"`
+	// Pieces that other peers reported failing their hash check with data from this peer.
+	HashFailures int64 `bencode:"hash failures"`
+}
+
+type ReliabilityResponse struct {
+	FailureReason string `bencode:"failure reason,omitempty"`
+	// Ordered from the most reliable.
+	Entries []ReliabilityEntry `bencode:"entries"`
+}
+
+// Fetches the breakdown of the reliability scores of a swarm's peers. This is a ReliableBT admin
+// extension, at the "reliability" counterpart of the announce URL.
+func (cl Client) Reliability(ctx context.Context, infoHash [20]byte, opt ReportOpt) (ret []ReliabilityEntry, err error) {
+	_url, err := swarmSiblingUrl(cl.url_, "reliability", ErrReliabilityNotSupported, infoHash)
+	if err != nil {
+		return
+	}
+	var resp ReliabilityResponse
+	err = cl.getBencoded(ctx, _url, opt, &resp)
+	if err != nil {
+		return
+	}
+	if resp.FailureReason != "" {
+		err = FailureReasonError{resp.FailureReason}
+		return
+	}
+	return resp.Entries, nil
+}
@@ -0,0 +1,488 @@
+package httpTracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/missinggo/httptoo"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/version"
+)
+
+var ErrReportNotSupported = errors.New("tracker URL doesn't support stats reports")
+
+// The version of the stats report body. It's only incremented for changes that trackers can't
+// ignore, such as changes to the meaning of existing keys. Keys are added without incrementing it.
+const StatsReportVersion = 1
+
+// Stats report bodies larger than this are rejected by ParseStatsReportRequest.
+const MaxStatsReportBodySize = 4 << 20
+
+// Transfer stats that a ReliableBT client periodically sends to a tracker, for accounting that
+// announces don't cover. Counters are totals for the client's lifetime in the swarm.
+type StatsReport struct {
+	InfoHash   [20]byte
+	PeerId     [20]byte
+	Downloaded int64
+	Uploaded   int64
+	// Bytes uploaded and downloaded since the reporter's previous report, so trackers can sum
+	// reports without tracking each reporter's totals.
+	UploadedDelta   int64
+	DownloadedDelta int64
+	// Negative if unknown.
+	Left int64
+	// Bytes uploaded to each remote peer, by peer ID. Trackers can cross-check these against the
+	// downloads claimed by those peers to detect free-riders.
+	UploadedTo map[[20]byte]int64
+	// Pieces that failed their hash check with data from each remote peer, by peer ID. Trackers can
+	// use these as complaints against the peers.
+	HashFailures map[[20]byte]int64
+	// Bencoded upload receipts signed by downloaders, presented for reputation credit. See
+	// peer_protocol.UploadReceipt.
+	Receipts [][]byte
+	// Unix time in seconds when the report was made. Reports may be queued and delivered late.
+	// Zero if unknown.
+	Time int64
+	// Monotonic time from when the reporter joined the swarm to the report, and since its previous
+	// report. Trackers should compute rates from these rather than from wall clock or receipt
+	// times, which are subject to clock skew and delivery delays. Differences in Elapsed between
+	// reports are valid even if reports in between were lost. Elapsed starts again if the reporter
+	// restarts. Sent with millisecond precision.
+	Elapsed  time.Duration
+	Interval time.Duration
+	// Peers the reporter is connected to in the swarm.
+	ActivePeers int64
+	Pieces      StatsReportPieces
+	// The reporter's experiment tag, so trackers can group reports by experiment. Empty if none.
+	ExperimentId string
+}
+
+// A summary of the reporter's view of piece availability. All zero if it doesn't have the info.
+type StatsReportPieces struct {
+	Total    int64 `bencode:"total"`
+	Complete int64 `bencode:"complete"`
+	// Pieces that neither the reporter nor its connected peers have.
+	Unavailable int64 `bencode:"unavailable"`
+	// Complete copies of the data among the reporter's connected peers, in thousandths, as
+	// bencode has no floats.
+	DistributedCopiesMilli int64 `bencode:"distributed copies milli"`
+}
+
+type ReportOpt struct {
+	UserAgent           string
+	HttpRequestDirector func(*http.Request) error
+	// The Client URL is the report endpoint itself, rather than an announce URL to derive it from.
+	Endpoint bool
+}
+
+// Swarm-wide transfer totals for a peer, as tracked from its stats reports.
+type ReportedPeer struct {
+	Uploaded   int64 `bencode:"uploaded"`
+	Downloaded int64 `bencode:"downloaded"`
+}
+
+type ReportResponse struct {
+	FailureReason string `bencode:"failure reason,omitempty"`
+	// Totals for peers in the swarm, keyed by raw peer ID. Trackers may omit this.
+	Peers map[string]ReportedPeer `bencode:"peers,omitempty"`
+	// The reporter's rates in bytes per second between its previous report and this one, as the
+	// tracker computed them. Omitted for a reporter's first report.
+	DownloadSpeed int64 `bencode:"downloadSpeed,omitempty"`
+	UploadSpeed   int64 `bencode:"uploadSpeed,omitempty"`
+}
+
+// Returns the URL for a ReliableBT tracker endpoint by replacing "announce" in an announce URL, as
+// for scrapes in BEP 48.
+func announceSiblingUrl(announce *url.URL, name string, errNotSupported error) (*url.URL, error) {
+	dir, file := path.Split(announce.Path)
+	if !strings.HasPrefix(file, "announce") {
+		return nil, errNotSupported
+	}
+	ret := httptoo.CopyURL(announce)
+	ret.Path = dir + name + strings.TrimPrefix(file, "announce")
+	return ret, nil
+}
+
+// Each entry of "uploaded_to" and "hash_failures" is a hex peer ID and a count separated by a colon.
+func (me StatsReport) Values() url.Values {
+	vs := url.Values{}
+	vs.Set("info_hash", string(me.InfoHash[:]))
+	vs.Set("peer_id", string(me.PeerId[:]))
+	vs.Set("downloaded", strconv.FormatInt(me.Downloaded, 10))
+	vs.Set("uploaded", strconv.FormatInt(me.Uploaded, 10))
+	vs.Set("uploadbytes", strconv.FormatInt(me.UploadedDelta, 10))
+	vs.Set("downloadbytes", strconv.FormatInt(me.DownloadedDelta, 10))
+	vs.Set("left", strconv.FormatInt(me.Left, 10))
+	addPeerCountValues(vs, "uploaded_to", me.UploadedTo)
+	addPeerCountValues(vs, "hash_failures", me.HashFailures)
+	for _, r := range me.Receipts {
+		vs.Add("receipt", string(r))
+	}
+	if me.Time != 0 {
+		vs.Set("time", strconv.FormatInt(me.Time, 10))
+	}
+	if me.Elapsed != 0 {
+		vs.Set("elapsed", strconv.FormatInt(me.Elapsed.Milliseconds(), 10))
+	}
+	if me.Interval != 0 {
+		vs.Set("interval", strconv.FormatInt(me.Interval.Milliseconds(), 10))
+	}
+	for _, f := range []struct {
+		key string
+		n   int64
+	}{
+		{"active_peers", me.ActivePeers},
+		{"pieces_total", me.Pieces.Total},
+		{"pieces_complete", me.Pieces.Complete},
+		{"pieces_unavailable", me.Pieces.Unavailable},
+		{"distributed_copies_milli", me.Pieces.DistributedCopiesMilli},
+	} {
+		if f.n != 0 {
+			vs.Set(f.key, strconv.FormatInt(f.n, 10))
+		}
+	}
+	if me.ExperimentId != "" {
+		vs.Set("experiment_id", me.ExperimentId)
+	}
+	return vs
+}
+
+// Parses a report from the query parameters of a request from StatsReport.Values, for use by
+// trackers.
+func ParseStatsReport(vs url.Values) (ret StatsReport, err error) {
+	for _, f := range []struct {
+		key string
+		dst *[20]byte
+	}{
+		{"info_hash", &ret.InfoHash},
+		{"peer_id", &ret.PeerId},
+	} {
+		s := vs.Get(f.key)
+		if len(s) != len(f.dst) {
+			err = fmt.Errorf("%v has wrong length", f.key)
+			return
+		}
+		copy(f.dst[:], s)
+	}
+	for _, f := range []struct {
+		key string
+		dst *int64
+	}{
+		{"downloaded", &ret.Downloaded},
+		{"uploaded", &ret.Uploaded},
+		{"left", &ret.Left},
+	} {
+		*f.dst, err = strconv.ParseInt(vs.Get(f.key), 10, 64)
+		if err != nil {
+			err = fmt.Errorf("parsing %v: %w", f.key, err)
+			return
+		}
+	}
+	ret.UploadedTo, err = parsePeerCountValues(vs, "uploaded_to")
+	if err != nil {
+		return
+	}
+	ret.HashFailures, err = parsePeerCountValues(vs, "hash_failures")
+	if err != nil {
+		return
+	}
+	for _, s := range vs["receipt"] {
+		ret.Receipts = append(ret.Receipts, []byte(s))
+	}
+	ret.ExperimentId = vs.Get("experiment_id")
+	for _, f := range []struct {
+		key string
+		dst *int64
+	}{
+		{"uploadbytes", &ret.UploadedDelta},
+		{"downloadbytes", &ret.DownloadedDelta},
+		{"time", &ret.Time},
+		{"active_peers", &ret.ActivePeers},
+		{"pieces_total", &ret.Pieces.Total},
+		{"pieces_complete", &ret.Pieces.Complete},
+		{"pieces_unavailable", &ret.Pieces.Unavailable},
+		{"distributed_copies_milli", &ret.Pieces.DistributedCopiesMilli},
+	} {
+		s := vs.Get(f.key)
+		if s == "" {
+			continue
+		}
+		*f.dst, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("parsing %v: %w", f.key, err)
+			return
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"elapsed", &ret.Elapsed},
+		{"interval", &ret.Interval},
+	} {
+		s := vs.Get(f.key)
+		if s == "" {
+			continue
+		}
+		var ms int64
+		ms, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("parsing %v: %w", f.key, err)
+			return
+		}
+		*f.dst = time.Duration(ms) * time.Millisecond
+	}
+	return
+}
+
+func addPeerCountValues(vs url.Values, key string, m map[[20]byte]int64) {
+	for id, n := range m {
+		vs.Add(key, hex.EncodeToString(id[:])+":"+strconv.FormatInt(n, 10))
+	}
+}
+
+func parsePeerCountValues(vs url.Values, key string) (ret map[[20]byte]int64, err error) {
+	for _, s := range vs[key] {
+		idHex, nStr, ok := strings.Cut(s, ":")
+		var id [20]byte
+		if !ok || hex.DecodedLen(len(idHex)) != len(id) {
+			err = fmt.Errorf("bad %v value %q", key, s)
+			return
+		}
+		if _, err = hex.Decode(id[:], []byte(idHex)); err != nil {
+			err = fmt.Errorf("bad %v peer id %q: %w", key, idHex, err)
+			return
+		}
+		var n int64
+		n, err = strconv.ParseInt(nStr, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("bad %v count %q: %w", key, nStr, err)
+			return
+		}
+		if ret == nil {
+			ret = make(map[[20]byte]int64)
+		}
+		ret[id] += n
+	}
+	return
+}
+
+// The bencoded body of a stats report POST. Trackers should ignore keys they don't know.
+type statsReportBody struct {
+	Version         int               `bencode:"v"`
+	InfoHash        string            `bencode:"info_hash"`
+	PeerId          string            `bencode:"peer_id"`
+	Downloaded      int64             `bencode:"downloaded"`
+	Uploaded        int64             `bencode:"uploaded"`
+	DownloadedDelta int64             `bencode:"downloaded_delta"`
+	UploadedDelta   int64             `bencode:"uploaded_delta"`
+	Left            int64             `bencode:"left"`
+	UploadedTo      map[string]int64  `bencode:"uploaded_to,omitempty"`
+	HashFailures    map[string]int64  `bencode:"hash_failures,omitempty"`
+	Receipts        [][]byte          `bencode:"receipts,omitempty"`
+	Time            int64             `bencode:"time,omitempty"`
+	ElapsedMillis   int64             `bencode:"elapsed,omitempty"`
+	IntervalMillis  int64             `bencode:"interval,omitempty"`
+	ActivePeers     int64             `bencode:"active_peers"`
+	Pieces          StatsReportPieces `bencode:"pieces"`
+	ExperimentId    string            `bencode:"experiment_id,omitempty"`
+}
+
+// Returns the report as a bencoded request body, keyed by raw peer ID where keyed by peer.
+func (me StatsReport) MarshalBody() ([]byte, error) {
+	body := statsReportBody{
+		Version:         StatsReportVersion,
+		InfoHash:        string(me.InfoHash[:]),
+		PeerId:          string(me.PeerId[:]),
+		Downloaded:      me.Downloaded,
+		Uploaded:        me.Uploaded,
+		DownloadedDelta: me.DownloadedDelta,
+		UploadedDelta:   me.UploadedDelta,
+		Left:            me.Left,
+		Receipts:        me.Receipts,
+		Time:            me.Time,
+		ElapsedMillis:   me.Elapsed.Milliseconds(),
+		IntervalMillis:  me.Interval.Milliseconds(),
+		ActivePeers:     me.ActivePeers,
+		Pieces:          me.Pieces,
+		ExperimentId:    me.ExperimentId,
+		UploadedTo:      peerCountsByRawId(me.UploadedTo),
+		HashFailures:    peerCountsByRawId(me.HashFailures),
+	}
+	return bencode.Marshal(body)
+}
+
+func peerCountsByRawId(m map[[20]byte]int64) map[string]int64 {
+	if len(m) == 0 {
+		return nil
+	}
+	ret := make(map[string]int64, len(m))
+	for id, n := range m {
+		ret[string(id[:])] = n
+	}
+	return ret
+}
+
+func peerCountsFromRawIds(key string, m map[string]int64) (ret map[[20]byte]int64, err error) {
+	for id, n := range m {
+		if len(id) != 20 {
+			err = fmt.Errorf("bad %v peer id %q", key, id)
+			return
+		}
+		if ret == nil {
+			ret = make(map[[20]byte]int64, len(m))
+		}
+		var id20 [20]byte
+		copy(id20[:], id)
+		ret[id20] = n
+	}
+	return
+}
+
+// Parses a report from a request body from StatsReport.MarshalBody, for use by trackers.
+func ParseStatsReportBody(b []byte) (ret StatsReport, err error) {
+	var body statsReportBody
+	err = bencode.Unmarshal(b, &body)
+	if err != nil {
+		return
+	}
+	if body.Version == 0 || body.Version > StatsReportVersion {
+		err = fmt.Errorf("unsupported stats report version %v", body.Version)
+		return
+	}
+	for _, f := range []struct {
+		key string
+		src string
+		dst *[20]byte
+	}{
+		{"info_hash", body.InfoHash, &ret.InfoHash},
+		{"peer_id", body.PeerId, &ret.PeerId},
+	} {
+		if len(f.src) != len(f.dst) {
+			err = fmt.Errorf("%v has wrong length", f.key)
+			return
+		}
+		copy(f.dst[:], f.src)
+	}
+	ret.UploadedTo, err = peerCountsFromRawIds("uploaded_to", body.UploadedTo)
+	if err != nil {
+		return
+	}
+	ret.HashFailures, err = peerCountsFromRawIds("hash_failures", body.HashFailures)
+	if err != nil {
+		return
+	}
+	ret.Downloaded = body.Downloaded
+	ret.Uploaded = body.Uploaded
+	ret.DownloadedDelta = body.DownloadedDelta
+	ret.UploadedDelta = body.UploadedDelta
+	ret.Left = body.Left
+	ret.Receipts = body.Receipts
+	ret.Time = body.Time
+	ret.Elapsed = time.Duration(body.ElapsedMillis) * time.Millisecond
+	ret.Interval = time.Duration(body.IntervalMillis) * time.Millisecond
+	ret.ActivePeers = body.ActivePeers
+	ret.Pieces = body.Pieces
+	ret.ExperimentId = body.ExperimentId
+	return
+}
+
+// Parses a stats report from a tracker request. Reports are POSTed with a bencoded body, but older
+// clients send them as GET query parameters.
+func ParseStatsReportRequest(r *http.Request) (StatsReport, error) {
+	if r.Method != http.MethodPost {
+		return ParseStatsReport(r.URL.Query())
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, MaxStatsReportBodySize+1))
+	if err != nil {
+		return StatsReport{}, err
+	}
+	if len(b) > MaxStatsReportBodySize {
+		return StatsReport{}, errors.New("stats report body too large")
+	}
+	return ParseStatsReportBody(b)
+}
+
+// Sends a stats report to the tracker, POSTed as a bencoded body. The report URL is derived from
+// the announce URL the same way as for scrapes, unless ReportOpt.Endpoint is set.
+func (cl Client) Report(ctx context.Context, r StatsReport, opt ReportOpt) (ret ReportResponse, err error) {
+	var _url *url.URL
+	if opt.Endpoint {
+		_url = httptoo.CopyURL(cl.url_)
+	} else {
+		_url, err = announceSiblingUrl(cl.url_, "report", ErrReportNotSupported)
+		if err != nil {
+			return
+		}
+	}
+	body, err := r.MarshalBody()
+	if err != nil {
+		return
+	}
+	err = cl.doBencoded(ctx, http.MethodPost, _url, body, opt, &ret)
+	if err == nil && ret.FailureReason != "" {
+		err = FailureReasonError{ret.FailureReason}
+	}
+	return
+}
+
+// Gets the URL and decodes the bencoded response into v.
+func (cl Client) getBencoded(ctx context.Context, _url *url.URL, opt ReportOpt, v interface{}) error {
+	return cl.doBencoded(ctx, http.MethodGet, _url, nil, opt, v)
+}
+
+// Makes a request with an optional bencoded body, and decodes the bencoded response into v.
+func (cl Client) doBencoded(
+	ctx context.Context, method string, _url *url.URL, body []byte, opt ReportOpt, v interface{},
+) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, _url.String(), bodyReader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-bittorrent")
+	}
+	userAgent := opt.UserAgent
+	if userAgent == "" {
+		userAgent = version.DefaultHttpUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	cl.setHeaders(req)
+	if opt.HttpRequestDirector != nil {
+		if err := opt.HttpRequestDirector(req); err != nil {
+			return fmt.Errorf("error modifying HTTP request: %w", err)
+		}
+	}
+	resp, err := cl.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response from tracker: %s: %q", resp.Status, buf.Bytes())
+	}
+	err = bencode.Unmarshal(buf.Bytes(), v)
+	if _, ok := err.(bencode.ErrUnusedTrailingBytes); ok {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("error decoding %q: %s", buf.Bytes(), err)
+	}
+	return err
+}
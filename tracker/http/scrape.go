@@ -0,0 +1,99 @@
+package httpTracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/missinggo/httptoo"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/tracker/udp"
+)
+
+var ErrScrapeNotSupported = errors.New("tracker URL doesn't support scrape")
+
+type scrapeResponse struct {
+	FailureReason string                      `bencode:"failure reason"`
+	Files         map[string]scrapeFileResult `bencode:"files"`
+}
+
+type scrapeFileResult struct {
+	Complete   int32 `bencode:"complete"`
+	Downloaded int32 `bencode:"downloaded"`
+	Incomplete int32 `bencode:"incomplete"`
+}
+
+// Returns the scrape URL for an announce URL by the convention in BEP 48.
+func scrapeUrl(announce *url.URL) (*url.URL, error) {
+	dir, file := path.Split(announce.Path)
+	if !strings.HasPrefix(file, "announce") {
+		return nil, ErrScrapeNotSupported
+	}
+	ret := httptoo.CopyURL(announce)
+	ret.Path = dir + "scrape" + strings.TrimPrefix(file, "announce")
+	return ret, nil
+}
+
+// Scrapes the tracker for swarm statistics (BEP 48). Results are in the same order as the
+// infohashes. Infohashes the tracker doesn't know about get zero results.
+func (cl Client) Scrape(ctx context.Context, ihs []udp.InfoHash) (ret udp.ScrapeResponse, err error) {
+	_url, err := scrapeUrl(cl.url_)
+	if err != nil {
+		return
+	}
+	// Infohashes are query-escaped individually for the same reasons as in announces.
+	var sb strings.Builder
+	sb.WriteString(_url.RawQuery)
+	for _, ih := range ihs {
+		if sb.Len() != 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString("info_hash=")
+		sb.WriteString(strings.ReplaceAll(url.QueryEscape(string(ih[:])), "+", "%20"))
+	}
+	_url.RawQuery = sb.String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url.String(), nil)
+	if err != nil {
+		return
+	}
+	cl.setHeaders(req)
+	resp, err := cl.hc.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("response from tracker: %s: %q", resp.Status, buf.Bytes())
+		return
+	}
+	var sr scrapeResponse
+	err = bencode.Unmarshal(buf.Bytes(), &sr)
+	if _, ok := err.(bencode.ErrUnusedTrailingBytes); ok {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("error decoding %q: %s", buf.Bytes(), err)
+		return
+	}
+	if sr.FailureReason != "" {
+		err = FailureReasonError{sr.FailureReason}
+		return
+	}
+	for _, ih := range ihs {
+		f := sr.Files[string(ih[:])]
+		ret = append(ret, udp.ScrapeInfohashResult{
+			Seeders:   f.Complete,
+			Completed: f.Downloaded,
+			Leechers:  f.Incomplete,
+		})
+	}
+	return
+}
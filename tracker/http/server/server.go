@@ -1,12 +1,17 @@
 package httpTrackerServer
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"net/netip"
 	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/generics"
@@ -23,6 +28,22 @@ type Handler struct {
 	// Called to derive an announcer's IP if non-nil. If not specified, the Request.RemoteAddr is
 	// used. Necessary for instances running behind reverse proxies for example.
 	RequestHost func(r *http.Request) (netip.Addr, error)
+	// If set, ReliableBT stats reports are accepted at the "report" counterpart of the announce
+	// path, and the resulting leaderboards are served at the "leaderboard" counterpart. Reports are
+	// only accepted from the address their peer ID last announced from.
+	Leaderboard *trackerServer.Leaderboard
+	// With Leaderboard, announce responses rank up to this many of the swarm's peers by the upload
+	// rates in their stats reports, so clients can connect to the fastest first.
+	FastPeers int
+	// With Leaderboard, announce responses include the reliability scores of the returned peers.
+	PeerReliability bool
+	// Requests to admin endpoints, such as the "reliability" counterpart of the announce path that
+	// serves the breakdown of Leaderboard reliability scores, are refused unless this is set and
+	// returns true.
+	AdminAuthorized func(r *http.Request) bool
+	// If set, leechers that give the number of pieces in their announces are assigned disjoint
+	// ranges of them to fetch first.
+	PieceAssigner *trackerServer.PieceAssigner
 }
 
 func unmarshalQueryKeyToArray(w http.ResponseWriter, key string, query url.Values) (ret [20]byte, ok bool) {
@@ -51,6 +72,19 @@ func (me Handler) requestHostAddr(r *http.Request) (_ netip.Addr, err error) {
 var requestHeadersLogger = log.Default.WithNames("request", "headers")
 
 func (me Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if me.Leaderboard != nil {
+		switch file := path.Base(r.URL.Path); {
+		case strings.HasPrefix(file, "report"):
+			me.serveReport(w, r)
+			return
+		case strings.HasPrefix(file, "leaderboard"):
+			me.serveLeaderboard(w, r)
+			return
+		case strings.HasPrefix(file, "reliability"):
+			me.serveReliability(w, r)
+			return
+		}
+	}
 	vs := r.URL.Query()
 	var event tracker.AnnounceEvent
 	err := event.UnmarshalText([]byte(vs.Get("event")))
@@ -118,8 +152,95 @@ func (me Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 	}
+	if me.Leaderboard != nil {
+		me.Leaderboard.TrackAnnounce(infoHash, peerId, addrPort, trackerServer.AnnounceTiming{
+			Event:    event,
+			Time:     time.Now(),
+			Interval: time.Duration(resp.Interval) * time.Second,
+		})
+		if key, err := hex.DecodeString(vs.Get("receipt_key")); err == nil && len(key) == ed25519.PublicKeySize {
+			me.Leaderboard.TrackReceiptKey(infoHash, peerId, key)
+		}
+		for _, peer := range me.Leaderboard.FastestPeers(infoHash, peerId, me.FastPeers) {
+			na := krpc.NodeAddr{IP: peer.Addr().AsSlice(), Port: int(peer.Port())}
+			if peer.Addr().Is4() {
+				resp.FastPeers = append(resp.FastPeers, na)
+			} else {
+				resp.FastPeers6 = append(resp.FastPeers6, na)
+			}
+		}
+		if me.PeerReliability {
+			resp.Reliability = me.Leaderboard.PeerReliability(infoHash, res.Peers)
+		}
+	}
+	// Only peers that ask for assignments share the pieces.
+	if me.PieceAssigner != nil && vs.Has("num_pieces") {
+		numPieces, _ := strconv.Atoi(vs.Get("num_pieces"))
+		begin, end, ok := me.PieceAssigner.Assign(
+			infoHash, peerId, numPieces, left, event == tracker.Stopped, time.Now())
+		if ok {
+			resp.AssignedPieces = []int{begin, end}
+		}
+	}
 	err = bencode.NewEncoder(w).Encode(resp)
 	if err != nil {
 		log.Printf("error encoding and writing response body: %v", err)
 	}
 }
+
+func (me Handler) serveReport(w http.ResponseWriter, r *http.Request) {
+	report, err := httpTracker.ParseStatsReportRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	addr, err := me.requestHostAddr(r)
+	if err != nil {
+		log.Printf("error getting requester IP: %v", err)
+		http.Error(w, "error determining your IP", http.StatusBadGateway)
+		return
+	}
+	if !me.Leaderboard.Announced(report.InfoHash, report.PeerId, addr) {
+		http.Error(w, "peer hasn't announced from this address", http.StatusForbidden)
+		return
+	}
+	uploadRate, downloadRate := me.Leaderboard.TrackReport(report)
+	err = bencode.NewEncoder(w).Encode(httpTracker.ReportResponse{
+		Peers:         me.Leaderboard.Peers(report.InfoHash),
+		DownloadSpeed: downloadRate,
+		UploadSpeed:   uploadRate,
+	})
+	if err != nil {
+		log.Printf("error encoding and writing response body: %v", err)
+	}
+}
+
+func (me Handler) serveLeaderboard(w http.ResponseWriter, r *http.Request) {
+	infoHash, ok := unmarshalQueryKeyToArray(w, "info_hash", r.URL.Query())
+	if !ok {
+		return
+	}
+	err := bencode.NewEncoder(w).Encode(httpTracker.LeaderboardResponse{
+		Entries: me.Leaderboard.Entries(infoHash),
+	})
+	if err != nil {
+		log.Printf("error encoding and writing response body: %v", err)
+	}
+}
+
+func (me Handler) serveReliability(w http.ResponseWriter, r *http.Request) {
+	if me.AdminAuthorized == nil || !me.AdminAuthorized(r) {
+		http.Error(w, "not authorized", http.StatusForbidden)
+		return
+	}
+	infoHash, ok := unmarshalQueryKeyToArray(w, "info_hash", r.URL.Query())
+	if !ok {
+		return
+	}
+	err := bencode.NewEncoder(w).Encode(httpTracker.ReliabilityResponse{
+		Entries: me.Leaderboard.Reliability(infoHash),
+	})
+	if err != nil {
+		log.Printf("error encoding and writing response body: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package httpTrackerServer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/anacrolix/torrent/bencode"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+	trackerServer "github.com/anacrolix/torrent/tracker/server"
+	"github.com/anacrolix/torrent/tracker/udp"
+)
+
+func TestReportsOnlyFromAnnouncedPeers(t *testing.T) {
+	c := qt.New(t)
+	lb := &trackerServer.Leaderboard{}
+	s := httptest.NewServer(Handler{Leaderboard: lb})
+	defer s.Close()
+	u, err := url.Parse(s.URL + "/announce")
+	c.Assert(err, qt.IsNil)
+	cl := httpTracker.NewClient(u, httpTracker.NewClientOpts{})
+	ih := [20]byte{1}
+	report := func(id byte) error {
+		_, err := cl.Report(context.Background(), httpTracker.StatsReport{
+			InfoHash: ih,
+			PeerId:   [20]byte{id},
+			Uploaded: 1,
+			Elapsed:  time.Second,
+		}, httpTracker.ReportOpt{})
+		return err
+	}
+	announce := func(id byte, addr string) {
+		lb.TrackAnnounce(ih, [20]byte{id}, netip.MustParseAddrPort(addr), trackerServer.AnnounceTiming{
+			Time:     time.Now(),
+			Interval: time.Minute,
+		})
+	}
+	c.Check(report(1), qt.IsNotNil)
+	// Announced from elsewhere, so the report could be forged.
+	announce(1, "192.0.2.1:1")
+	c.Check(report(1), qt.IsNotNil)
+	announce(2, "127.0.0.1:2")
+	c.Check(report(2), qt.IsNil)
+	entries := lb.Entries(ih)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Check(entries[0].PeerId, qt.Equals, [20]byte{2})
+}
+
+// Tracks nothing, and always has the same peers.
+type testAnnounceTracker struct{}
+
+func (testAnnounceTracker) TrackAnnounce(context.Context, udp.AnnounceRequest, trackerServer.AnnounceAddr) error {
+	return nil
+}
+
+func (testAnnounceTracker) Scrape(context.Context, []trackerServer.InfoHash) ([]udp.ScrapeInfohashResult, error) {
+	return nil, nil
+}
+
+func (testAnnounceTracker) GetPeers(
+	context.Context, trackerServer.InfoHash, trackerServer.GetPeersOpts, trackerServer.AnnounceAddr,
+) trackerServer.ServerAnnounceResult {
+	return trackerServer.ServerAnnounceResult{Peers: []trackerServer.PeerInfo{
+		{AnnounceAddr: netip.MustParseAddrPort("192.0.2.1:1")},
+		{AnnounceAddr: netip.MustParseAddrPort("192.0.2.2:2")},
+	}}
+}
+
+func TestAnnouncedReceiptKeysCredited(t *testing.T) {
+	c := qt.New(t)
+	lb := &trackerServer.Leaderboard{}
+	s := httptest.NewServer(Handler{
+		Announce:    &trackerServer.AnnounceHandler{AnnounceTracker: testAnnounceTracker{}},
+		Leaderboard: lb,
+	})
+	defer s.Close()
+	u, err := url.Parse(s.URL + "/announce")
+	c.Assert(err, qt.IsNil)
+	cl := httpTracker.NewClient(u, httpTracker.NewClientOpts{})
+	ih := [20]byte{1}
+	uploader, downloader := [20]byte{1}, [20]byte{2}
+	pub, key, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.IsNil)
+	announce := func(id [20]byte, extra url.Values) {
+		res, err := cl.Announce(context.Background(), httpTracker.AnnounceRequest{
+			InfoHash: ih,
+			PeerId:   id,
+			Port:     uint16(id[0]),
+		}, httpTracker.AnnounceOpt{ExtraParams: extra})
+		c.Assert(err, qt.IsNil)
+		c.Check(res.Peers, qt.HasLen, 2)
+	}
+	announce(uploader, nil)
+	announce(downloader, url.Values{"receipt_key": {hex.EncodeToString(pub)}})
+	report := func(r httpTracker.StatsReport) {
+		r.InfoHash = ih
+		_, err := cl.Report(context.Background(), r, httpTracker.ReportOpt{})
+		c.Assert(err, qt.IsNil)
+	}
+	report(httpTracker.StatsReport{PeerId: downloader, Downloaded: 100, Elapsed: time.Second})
+	rcpt := pp.UploadReceipt{InfoHash: ih, Uploader: uploader, Downloader: downloader, Bytes: 40}
+	rcpt.Sign(key)
+	report(httpTracker.StatsReport{PeerId: uploader, Receipts: [][]byte{bencode.MustMarshal(rcpt)}, Elapsed: time.Second})
+	entries, err := cl.Leaderboard(context.Background(), ih, httpTracker.ReportOpt{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Check(entries[0].PeerId, qt.Equals, uploader)
+	c.Check(entries[0].Credit, qt.Equals, int64(40))
+}
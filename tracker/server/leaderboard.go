@@ -0,0 +1,394 @@
+package trackerServer
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+// Maintains a per-swarm contribution leaderboard from ReliableBT stats reports. Report counters
+// are lifetime totals, so each report replaces the last from the same peer. Upload receipts are
+// only credited from downloaders that announced their receipt keys with TrackReceiptKey. Peers that
+// stop announcing and reporting are eventually forgotten, as are swarms left without peers.
+type Leaderboard struct {
+	mu     sync.Mutex
+	swarms map[InfoHash]*leaderboardSwarm
+	// When expired peers were last forgotten from all swarms.
+	lastSweep time.Time
+}
+
+type leaderboardSwarm struct {
+	peers map[[20]byte]*leaderboardPeer
+	// The receipt signing key each downloader gave with its announces. Only receipts from announced
+	// downloaders signed by their keys are credited, so uploaders can't make up receipts from other
+	// or nonexistent peers.
+	keys map[[20]byte][]byte
+	// The address each peer last announced from. Peers are forgotten when they announce stopped,
+	// or miss announces, along with their announce histories and complaints.
+	addrs map[[20]byte]announcedAddr
+	// For reliability scores.
+	announces map[[20]byte]*announceHistory
+	// Hash failures reported against each peer, by reporter.
+	complaints map[[20]byte]map[[20]byte]int64
+}
+
+type announcedAddr struct {
+	AnnounceAddr
+	// When the address is forgotten if the peer hasn't announced again.
+	expires time.Time
+}
+
+// Peers are forgotten after missing this many announce intervals, or this many default intervals if
+// the tracker didn't give one. It's well beyond the grace given to late announces, so they're still
+// counted against the peer.
+const (
+	announceAddrExpiryIntervals = 3
+	defaultAnnounceInterval     = 30 * time.Minute
+)
+
+const (
+	// Reported totals are kept this long after a peer was last heard from, once its address is
+	// forgotten, so standings survive peers leaving for a while.
+	leaderboardPeerExpiry = 24 * time.Hour
+	// How often announces trigger forgetting expired peers in all swarms.
+	leaderboardSweepInterval = time.Minute
+)
+
+// Forgets expired peers in every swarm, and swarms left with nothing to remember. It's done at most
+// every leaderboardSweepInterval, so swarms nobody announces to anymore are still cleaned up.
+func (me *Leaderboard) sweep(now time.Time) {
+	if now.Sub(me.lastSweep) < leaderboardSweepInterval {
+		return
+	}
+	me.lastSweep = now
+	for infoHash, s := range me.swarms {
+		s.forgetExpiredPeers(now)
+		s.forgetExpiredReportedPeers(now)
+		if s.empty() {
+			delete(me.swarms, infoHash)
+		}
+	}
+}
+
+func (me *leaderboardSwarm) empty() bool {
+	return len(me.peers) == 0 && len(me.addrs) == 0 && len(me.announces) == 0 && len(me.complaints) == 0
+}
+
+// Forgets the reported totals of peers without an announced address that haven't been heard from
+// for leaderboardPeerExpiry.
+func (me *leaderboardSwarm) forgetExpiredReportedPeers(now time.Time) {
+	for id, p := range me.peers {
+		if _, ok := me.addrs[id]; !ok && now.After(p.lastSeen.Add(leaderboardPeerExpiry)) {
+			me.forgetReportedPeer(id)
+		}
+	}
+}
+
+// Forgets the peer's reported totals. Credit uploaders got from its receipts is kept.
+func (me *leaderboardSwarm) forgetReportedPeer(id [20]byte) {
+	d := me.peers[id]
+	if d == nil {
+		return
+	}
+	delete(me.peers, id)
+	for _, p := range me.peers {
+		if n, ok := p.receipts[id]; ok {
+			p.settle(id, n, d.downloaded)
+		}
+	}
+}
+
+func (me *leaderboardSwarm) forgetExpiredPeers(now time.Time) {
+	for id, addr := range me.addrs {
+		if now.After(addr.expires) {
+			me.forgetPeer(id)
+		}
+	}
+}
+
+// Forgets the peer's address, announce history, and the complaints made by and against it.
+func (me *leaderboardSwarm) forgetPeer(id [20]byte) {
+	delete(me.addrs, id)
+	delete(me.keys, id)
+	delete(me.announces, id)
+	delete(me.complaints, id)
+	for target, m := range me.complaints {
+		delete(m, id)
+		if len(m) == 0 {
+			delete(me.complaints, target)
+		}
+	}
+}
+
+type leaderboardPeer struct {
+	uploaded   int64
+	downloaded int64
+	elapsed    time.Duration
+	// Computed from the reporter's elapsed times, so they're unaffected by clock skew.
+	uploadRate   int64
+	downloadRate int64
+	// The greatest receipted bytes from each downloader.
+	receipts map[[20]byte]int64
+	// The credit from each downloader when its reported totals were last forgotten. Receipts from
+	// the downloader are credited at least this much, and aren't counted again on top of it.
+	settled map[[20]byte]int64
+	// When the peer last announced or reported.
+	lastSeen time.Time
+}
+
+// The peer's receipted credit. Credit from each downloader is capped at what the downloader
+// itself reports downloading.
+func (me *leaderboardSwarm) credit(p *leaderboardPeer) (ret int64) {
+	for d, n := range p.receipts {
+		var downloaded int64
+		if dp := me.peers[d]; dp != nil {
+			downloaded = dp.downloaded
+		}
+		if n > downloaded {
+			n = downloaded
+		}
+		if s := p.settled[d]; s > n {
+			n = s
+		}
+		ret += n
+	}
+	return
+}
+
+// Keeps the credit from the downloader's receipts before its reported totals are forgotten.
+func (me *leaderboardPeer) settle(downloader [20]byte, receipted, downloaded int64) {
+	if receipted > downloaded {
+		receipted = downloaded
+	}
+	if receipted <= me.settled[downloader] {
+		return
+	}
+	if me.settled == nil {
+		me.settled = make(map[[20]byte]int64)
+	}
+	me.settled[downloader] = receipted
+}
+
+func (me *leaderboardPeer) trackRates(r httpTracker.StatsReport) {
+	if r.Elapsed < me.elapsed || r.Uploaded < me.uploaded || r.Downloaded < me.downloaded {
+		// The reporter restarted, and its counters began again from zero.
+		me.elapsed, me.uploaded, me.downloaded = 0, 0, 0
+	}
+	dt := (r.Elapsed - me.elapsed).Seconds()
+	if dt <= 0 {
+		return
+	}
+	me.uploadRate = int64(float64(r.Uploaded-me.uploaded) / dt)
+	me.downloadRate = int64(float64(r.Downloaded-me.downloaded) / dt)
+}
+
+func (me *Leaderboard) swarm(infoHash InfoHash) *leaderboardSwarm {
+	if me.swarms == nil {
+		me.swarms = make(map[InfoHash]*leaderboardSwarm)
+	}
+	s := me.swarms[infoHash]
+	if s == nil {
+		s = &leaderboardSwarm{
+			peers:      make(map[[20]byte]*leaderboardPeer),
+			keys:       make(map[[20]byte][]byte),
+			addrs:      make(map[[20]byte]announcedAddr),
+			announces:  make(map[[20]byte]*announceHistory),
+			complaints: make(map[[20]byte]map[[20]byte]int64),
+		}
+		me.swarms[infoHash] = s
+	}
+	return s
+}
+
+// Records the address a peer announced from, so it can be returned by FastestPeers, and the
+// announce's timing for the peer's reliability score. Peers that announce stopped, or haven't
+// announced for a while, are forgotten. Standing belongs to the peer ID at the address it announced
+// from, so a peer ID announced from another address starts over, and peers can't take over the
+// scores of others by copying their IDs.
+func (me *Leaderboard) TrackAnnounce(infoHash InfoHash, peerId [20]byte, addr AnnounceAddr, timing AnnounceTiming) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.sweep(timing.Time)
+	s := me.swarm(infoHash)
+	s.forgetExpiredPeers(timing.Time)
+	if timing.Event == tracker.Stopped {
+		s.forgetPeer(peerId)
+		return
+	}
+	if prev, ok := s.addrs[peerId]; ok && prev.AnnounceAddr != addr {
+		s.forgetPeer(peerId)
+		s.forgetReportedPeer(peerId)
+	}
+	if p := s.peers[peerId]; p != nil && timing.Time.After(p.lastSeen) {
+		p.lastSeen = timing.Time
+	}
+	interval := timing.Interval
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	s.addrs[peerId] = announcedAddr{addr, timing.Time.Add(announceAddrExpiryIntervals * interval)}
+	h := s.announces[peerId]
+	if h == nil {
+		h = &announceHistory{}
+		s.announces[peerId] = h
+	}
+	h.track(timing)
+}
+
+// Records the upload receipt signing key a peer gave when it announced. It replaces any key the
+// peer gave before, and is forgotten with the peer's address.
+func (me *Leaderboard) TrackReceiptKey(infoHash InfoHash, peerId [20]byte, key []byte) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return
+	}
+	if _, ok := s.addrs[peerId]; !ok {
+		return
+	}
+	s.keys[peerId] = append([]byte(nil), key...)
+}
+
+// Whether the peer last announced to the swarm from the IP, and hasn't since stopped or gone
+// stale.
+func (me *Leaderboard) Announced(infoHash InfoHash, peerId [20]byte, ip netip.Addr) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return false
+	}
+	addr, ok := s.addrs[peerId]
+	return ok && addr.Addr().Unmap() == ip.Unmap()
+}
+
+// Returns the addresses of up to max peers in the swarm that have reported uploading, ordered by
+// their upload rates, fastest first. Peers that haven't announced are left out, as is the peer
+// excluded, which is usually the one asking.
+func (me *Leaderboard) FastestPeers(infoHash InfoHash, exclude [20]byte, max int) (ret []AnnounceAddr) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return
+	}
+	type ranked struct {
+		id   [20]byte
+		rate int64
+	}
+	var peers []ranked
+	for id, p := range s.peers {
+		if _, ok := s.addrs[id]; ok && id != exclude && p.uploadRate > 0 {
+			peers = append(peers, ranked{id, p.uploadRate})
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		l, r := peers[i], peers[j]
+		if l.rate != r.rate {
+			return l.rate > r.rate
+		}
+		return bytes.Compare(l.id[:], r.id[:]) < 0
+	})
+	for _, p := range peers {
+		if len(ret) == max {
+			break
+		}
+		ret = append(ret, s.addrs[p.id].AnnounceAddr)
+	}
+	return
+}
+
+// Records a stats report, crediting the reporter for its valid upload receipts. Returns the
+// reporter's rates since its previous report, in bytes per second.
+func (me *Leaderboard) TrackReport(r httpTracker.StatsReport) (uploadRate, downloadRate int64) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarm(r.InfoHash)
+	p := s.peers[r.PeerId]
+	if p == nil {
+		p = &leaderboardPeer{receipts: make(map[[20]byte]int64)}
+		s.peers[r.PeerId] = p
+	}
+	p.trackRates(r)
+	p.lastSeen = time.Now()
+	p.uploaded = r.Uploaded
+	p.downloaded = r.Downloaded
+	p.elapsed = r.Elapsed
+	for _, b := range r.Receipts {
+		var rcpt pp.UploadReceipt
+		if bencode.Unmarshal(b, &rcpt) != nil || !rcpt.Verify() {
+			continue
+		}
+		if rcpt.InfoHash != r.InfoHash || rcpt.Uploader != r.PeerId || rcpt.Downloader == r.PeerId {
+			continue
+		}
+		if _, ok := s.addrs[rcpt.Downloader]; !ok {
+			continue
+		}
+		if key, ok := s.keys[rcpt.Downloader]; !ok || !bytes.Equal(key, rcpt.PublicKey) {
+			continue
+		}
+		if rcpt.Bytes > p.receipts[rcpt.Downloader] {
+			p.receipts[rcpt.Downloader] = rcpt.Bytes
+		}
+	}
+	s.trackComplaints(r)
+	return p.uploadRate, p.downloadRate
+}
+
+// Returns the swarm's leaderboard, ordered by receipted credit and then by reported upload.
+func (me *Leaderboard) Entries(infoHash InfoHash) (ret []httpTracker.LeaderboardEntry) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return
+	}
+	for id, p := range s.peers {
+		ret = append(ret, httpTracker.LeaderboardEntry{
+			PeerId:       id,
+			Uploaded:     p.uploaded,
+			Downloaded:   p.downloaded,
+			Credit:       s.credit(p),
+			UploadRate:   p.uploadRate,
+			DownloadRate: p.downloadRate,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		l, r := ret[i], ret[j]
+		if l.Credit != r.Credit {
+			return l.Credit > r.Credit
+		}
+		if l.Uploaded != r.Uploaded {
+			return l.Uploaded > r.Uploaded
+		}
+		return bytes.Compare(l.PeerId[:], r.PeerId[:]) < 0
+	})
+	return
+}
+
+// Returns the reported totals for each peer in the swarm, for responses to stats reports.
+func (me *Leaderboard) Peers(infoHash InfoHash) map[string]httpTracker.ReportedPeer {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return nil
+	}
+	ret := make(map[string]httpTracker.ReportedPeer, len(s.peers))
+	for id, p := range s.peers {
+		ret[string(id[:])] = httpTracker.ReportedPeer{
+			Uploaded:   p.uploaded,
+			Downloaded: p.downloaded,
+		}
+	}
+	return ret
+}
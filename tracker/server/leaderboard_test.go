@@ -0,0 +1,144 @@
+package trackerServer
+
+import (
+	"crypto/ed25519"
+	"net/netip"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/anacrolix/torrent/bencode"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+func TestReceiptsOnlyCreditedFromAnnouncedKeys(t *testing.T) {
+	c := qt.New(t)
+	var lb Leaderboard
+	ih := InfoHash{1}
+	uploader, downloader := [20]byte{1}, [20]byte{2}
+	announce := func(id [20]byte) {
+		lb.TrackAnnounce(ih, id, netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(id[0])), AnnounceTiming{
+			Time:     time.Now(),
+			Interval: time.Minute,
+		})
+	}
+	pub, key, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.IsNil)
+	report := func(bytes int64) {
+		rcpt := pp.UploadReceipt{InfoHash: ih, Uploader: uploader, Downloader: downloader, Bytes: bytes}
+		rcpt.Sign(key)
+		lb.TrackReport(httpTracker.StatsReport{
+			InfoHash: ih,
+			PeerId:   uploader,
+			Receipts: [][]byte{bencode.MustMarshal(rcpt)},
+		})
+	}
+	credit := func() int64 {
+		for _, e := range lb.Entries(ih) {
+			if e.PeerId == uploader {
+				return e.Credit
+			}
+		}
+		return 0
+	}
+	announce(uploader)
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: downloader, Downloaded: 100})
+	// The downloader hasn't announced.
+	report(10)
+	c.Check(credit(), qt.Equals, int64(0))
+	// The downloader announced without a key.
+	announce(downloader)
+	report(20)
+	c.Check(credit(), qt.Equals, int64(0))
+	// The downloader announced a different key.
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.IsNil)
+	lb.TrackReceiptKey(ih, downloader, otherPub)
+	report(30)
+	c.Check(credit(), qt.Equals, int64(0))
+	lb.TrackReceiptKey(ih, downloader, pub)
+	report(40)
+	c.Check(credit(), qt.Equals, int64(40))
+	// Credit is capped at what the downloader reports downloading.
+	report(1000)
+	c.Check(credit(), qt.Equals, int64(100))
+	lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: downloader, Downloaded: 500})
+	c.Check(credit(), qt.Equals, int64(500))
+}
+
+func TestPeerIdFromAnotherAddressStartsOver(t *testing.T) {
+	c := qt.New(t)
+	var lb Leaderboard
+	ih := InfoHash{1}
+	id := [20]byte{1}
+	start := time.Unix(1700000000, 0)
+	announce := func(port uint16, at time.Duration) {
+		lb.TrackAnnounce(ih, id, netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port), AnnounceTiming{
+			Time:     start.Add(at),
+			Interval: time.Minute,
+		})
+	}
+	announce(1, 0)
+	announce(1, time.Minute)
+	announce(1, 2*time.Minute)
+	c.Assert(lb.Reliability(ih), qt.HasLen, 1)
+	c.Check(lb.Reliability(ih)[0].OnTimeAnnounces, qt.Equals, 2)
+	announce(2, 3*time.Minute)
+	c.Assert(lb.Reliability(ih), qt.HasLen, 1)
+	c.Check(lb.Reliability(ih)[0].OnTimeAnnounces, qt.Equals, 0)
+	c.Check(lb.Reliability(ih)[0].Score, qt.Equals, httpTracker.NeutralReliabilityScore)
+}
+
+func TestLeaderboardForgetsStalePeersAndSwarms(t *testing.T) {
+	c := qt.New(t)
+	var lb Leaderboard
+	ih := InfoHash{1}
+	uploader, downloader := [20]byte{1}, [20]byte{2}
+	start := time.Now()
+	announce := func(ih InfoHash, id [20]byte, event tracker.AnnounceEvent, at, interval time.Duration) {
+		lb.TrackAnnounce(ih, id, netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(id[0])), AnnounceTiming{
+			Event:    event,
+			Time:     start.Add(at),
+			Interval: interval,
+		})
+	}
+	announce(ih, uploader, tracker.Started, 0, 10*time.Hour)
+	announce(ih, downloader, tracker.Started, 0, time.Minute)
+	pub, key, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.IsNil)
+	rcpt := pp.UploadReceipt{InfoHash: ih, Uploader: uploader, Downloader: downloader, Bytes: 40}
+	rcpt.Sign(key)
+	reports := func() {
+		lb.TrackReceiptKey(ih, downloader, pub)
+		lb.TrackReport(httpTracker.StatsReport{InfoHash: ih, PeerId: downloader, Downloaded: 100})
+		lb.TrackReport(httpTracker.StatsReport{
+			InfoHash: ih,
+			PeerId:   uploader,
+			Receipts: [][]byte{bencode.MustMarshal(rcpt)},
+		})
+	}
+	reports()
+	c.Assert(lb.Entries(ih), qt.HasLen, 2)
+	// The downloader stopped announcing and reporting a day ago, but the uploader keeps its credit.
+	announce(ih, uploader, tracker.None, 25*time.Hour, 10*time.Hour)
+	entries := lb.Entries(ih)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Check(entries[0].PeerId, qt.Equals, uploader)
+	c.Check(entries[0].Credit, qt.Equals, int64(40))
+	// The same receipt isn't credited twice if the downloader comes back.
+	announce(ih, downloader, tracker.Started, 25*time.Hour, time.Minute)
+	reports()
+	entries = lb.Entries(ih)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Check(entries[0].Credit, qt.Equals, int64(40))
+	// Once the uploader leaves too, the swarm is forgotten after a day of announces elsewhere.
+	announce(ih, uploader, tracker.Stopped, 26*time.Hour, 10*time.Hour)
+	c.Check(lb.Entries(ih), qt.HasLen, 1)
+	announce(InfoHash{2}, [20]byte{3}, tracker.Started, 51*time.Hour, time.Minute)
+	c.Check(lb.Entries(ih), qt.HasLen, 0)
+	_, ok := lb.swarms[ih]
+	c.Check(ok, qt.IsFalse)
+}
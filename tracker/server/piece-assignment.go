@@ -0,0 +1,84 @@
+package trackerServer
+
+import (
+	"sync"
+	"time"
+)
+
+// Assigns disjoint ranges of a torrent's pieces to the leechers in its swarm, so that a flash crowd
+// fetches different pieces from the seeds and then trades them among itself. The pieces are split
+// evenly between the leechers in the order they first announced. Assignments are recomputed at
+// each announce as leechers join and leave, so they're only disjoint between the latest announces.
+type PieceAssigner struct {
+	// Leechers that haven't announced for this long are removed from the swarm. Defaults to
+	// DefaultPieceAssignerTimeout.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	swarms map[InfoHash][]assignedLeecher
+}
+
+// Twice the default HTTP tracker announce interval.
+const DefaultPieceAssignerTimeout = 10 * time.Minute
+
+type assignedLeecher struct {
+	peerId       [20]byte
+	lastAnnounce time.Time
+}
+
+// Removes leechers that last announced before the timeout, keeping the order of the rest.
+func (me *PieceAssigner) expire(leechers []assignedLeecher, now time.Time) []assignedLeecher {
+	timeout := me.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPieceAssignerTimeout
+	}
+	kept := leechers[:0]
+	for _, l := range leechers {
+		if now.Sub(l.lastAnnounce) <= timeout {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// Returns the range of pieces [begin, end) assigned to a peer announcing to the swarm at the given
+// time. Seeders, and leechers that announce stopped, are removed from the swarm and given no
+// assignment, as are leechers that stop announcing. ok is false if no pieces are assigned, such as
+// when there are more leechers than pieces.
+func (me *PieceAssigner) Assign(
+	infoHash InfoHash, peerId [20]byte, numPieces int, left int64, stopped bool, now time.Time,
+) (begin, end int, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.swarms == nil {
+		me.swarms = make(map[InfoHash][]assignedLeecher)
+	}
+	leechers := me.expire(me.swarms[infoHash], now)
+	i := 0
+	for i < len(leechers) && leechers[i].peerId != peerId {
+		i++
+	}
+	if stopped || left == 0 {
+		if i < len(leechers) {
+			leechers = append(leechers[:i], leechers[i+1:]...)
+		}
+		if len(leechers) == 0 {
+			delete(me.swarms, infoHash)
+		} else {
+			me.swarms[infoHash] = leechers
+		}
+		return
+	}
+	if i == len(leechers) {
+		leechers = append(leechers, assignedLeecher{peerId: peerId})
+	}
+	leechers[i].lastAnnounce = now
+	me.swarms[infoHash] = leechers
+	if numPieces <= 0 {
+		return
+	}
+	begin = i * numPieces / len(leechers)
+	end = (i + 1) * numPieces / len(leechers)
+	ok = begin < end
+	return
+}
@@ -0,0 +1,165 @@
+package trackerServer
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent/tracker"
+	httpTracker "github.com/anacrolix/torrent/tracker/http"
+)
+
+// When an announce was received, and what the tracker asked of the peer in its response.
+type AnnounceTiming struct {
+	Event tracker.AnnounceEvent
+	Time  time.Time
+	// The interval given in the response.
+	Interval time.Duration
+}
+
+type announceHistory struct {
+	// When the next announce is due by, or zero if none is expected because the tracker gave no
+	// interval.
+	due    time.Time
+	onTime int
+	late   int
+}
+
+// Announces are allowed this fraction of the interval late, for delays in clients and networks.
+const announceGraceDivisor = 2
+
+func (me *announceHistory) track(a AnnounceTiming) {
+	if !me.due.IsZero() && a.Event != tracker.Started {
+		if a.Time.After(me.due) {
+			me.late++
+		} else {
+			me.onTime++
+		}
+	}
+	if a.Interval <= 0 {
+		me.due = time.Time{}
+	} else {
+		me.due = a.Time.Add(a.Interval + a.Interval/announceGraceDivisor)
+	}
+}
+
+// Hash failures reported against a peer count against its score up to this many times for each
+// reporter, so no one peer can ruin another's score.
+const maxComplaintsPerReporter = 2
+
+// Records the hash failures a stats report blames on other peers. Only reporters that have
+// announced are heard.
+func (me *leaderboardSwarm) trackComplaints(r httpTracker.StatsReport) {
+	if _, ok := me.addrs[r.PeerId]; !ok {
+		return
+	}
+	for id, n := range r.HashFailures {
+		if id == r.PeerId || n <= 0 {
+			continue
+		}
+		m := me.complaints[id]
+		if m == nil {
+			m = make(map[[20]byte]int64)
+			me.complaints[id] = m
+		}
+		// Report counts are lifetime totals.
+		if n > m[r.PeerId] {
+			m[r.PeerId] = n
+		}
+	}
+}
+
+// Scores start neutral. Announcing on time raises the score and announcing late lowers it, by up
+// to 20 either way. Receipted upload credit raises it by 3 for each doubling from 16 KiB, up to 30.
+// Each hash failure reported against the peer lowers it by 10.
+func (me *leaderboardSwarm) reliability(id [20]byte) (ret httpTracker.ReliabilityEntry) {
+	ret.PeerId = id
+	score := httpTracker.NeutralReliabilityScore
+	if h := me.announces[id]; h != nil {
+		ret.OnTimeAnnounces = h.onTime
+		ret.LateAnnounces = h.late
+		if n := h.onTime + h.late; n != 0 {
+			score += 20 * (h.onTime - h.late) / n
+		}
+	}
+	if p := me.peers[id]; p != nil {
+		ret.Credit = me.credit(p)
+		if s := 3 * bits.Len64(uint64(ret.Credit)>>14); s < 30 {
+			score += s
+		} else {
+			score += 30
+		}
+	}
+	for _, n := range me.complaints[id] {
+		ret.HashFailures += n
+		if n > maxComplaintsPerReporter {
+			n = maxComplaintsPerReporter
+		}
+		score -= 10 * int(n)
+	}
+	if score < 0 {
+		score = 0
+	} else if score > httpTracker.MaxReliabilityScore {
+		score = httpTracker.MaxReliabilityScore
+	}
+	ret.Score = score
+	return
+}
+
+// Returns the reliability scores of the given peers, such as those for an announce response, that
+// last announced from their addresses.
+func (me *Leaderboard) PeerReliability(infoHash InfoHash, peers []PeerInfo) (ret []httpTracker.PeerReliability) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return
+	}
+	wanted := make(map[AnnounceAddr]struct{}, len(peers))
+	for _, p := range peers {
+		wanted[p.AnnounceAddr] = struct{}{}
+	}
+	for id, addr := range s.addrs {
+		if _, ok := wanted[addr.AnnounceAddr]; !ok {
+			continue
+		}
+		ret = append(ret, httpTracker.PeerReliability{
+			PeerId: id,
+			Addr:   addr.AnnounceAddr.String(),
+			Score:  s.reliability(id).Score,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i].PeerId[:], ret[j].PeerId[:]) < 0
+	})
+	return
+}
+
+// Returns the breakdown of the reliability scores of the swarm's peers, most reliable first.
+func (me *Leaderboard) Reliability(infoHash InfoHash) (ret []httpTracker.ReliabilityEntry) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.swarms[infoHash]
+	if s == nil {
+		return
+	}
+	ids := make(map[[20]byte]struct{}, len(s.announces)+len(s.peers))
+	for id := range s.announces {
+		ids[id] = struct{}{}
+	}
+	for id := range s.peers {
+		ids[id] = struct{}{}
+	}
+	for id := range ids {
+		ret = append(ret, s.reliability(id))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		l, r := ret[i], ret[j]
+		if l.Score != r.Score {
+			return l.Score > r.Score
+		}
+		return bytes.Compare(l.PeerId[:], r.PeerId[:]) < 0
+	})
+	return
+}
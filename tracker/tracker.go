@@ -30,6 +30,10 @@ type AnnounceResponse = trHttp.AnnounceResponse
 
 type Peer = trHttp.Peer
 
+type PieceRange = trHttp.PieceRange
+
+type PeerReliability = trHttp.PeerReliability
+
 type AnnounceEvent = udp.AnnounceEvent
 
 var ErrBadScheme = errors.New("unknown scheme")
@@ -45,12 +49,19 @@ type Announce struct {
 	ServerName          string
 	UserAgent           string
 	UdpNetwork          string
+	// See NewClientOpts.
+	UdpPool              *udp.ConnClientPool
+	UdpRetransmitTimeout time.Duration
 	// If the port is zero, it's assumed to be the same as the Request.Port.
 	ClientIp4 krpc.NodeAddr
 	// If the port is zero, it's assumed to be the same as the Request.Port.
 	ClientIp6 krpc.NodeAddr
 	Context   context.Context
 	Logger    log.Logger
+	// Additional query parameters for HTTP trackers. Ignored by UDP trackers.
+	ExtraParams url.Values
+	// Headers for HTTP trackers, such as for authentication. Ignored by UDP trackers.
+	HttpHeader http.Header
 }
 
 // The code *is* the documentation.
@@ -62,10 +73,13 @@ func (me Announce) Do() (res AnnounceResponse, err error) {
 			Proxy:       me.HttpProxy,
 			DialContext: me.DialContext,
 			ServerName:  me.ServerName,
+			Header:      me.HttpHeader,
 		},
-		UdpNetwork:   me.UdpNetwork,
-		Logger:       me.Logger.WithContextValue(fmt.Sprintf("tracker client for %q", me.TrackerUrl)),
-		ListenPacket: me.ListenPacket,
+		UdpNetwork:           me.UdpNetwork,
+		UdpPool:              me.UdpPool,
+		UdpRetransmitTimeout: me.UdpRetransmitTimeout,
+		Logger:               me.Logger.WithContextValue(fmt.Sprintf("tracker client for %q", me.TrackerUrl)),
+		ListenPacket:         me.ListenPacket,
 	})
 	if err != nil {
 		return
@@ -85,5 +99,6 @@ func (me Announce) Do() (res AnnounceResponse, err error) {
 		ClientIp4:           me.ClientIp4.IP,
 		ClientIp6:           me.ClientIp6.IP,
 		HttpRequestDirector: me.HttpRequestDirector,
+		ExtraParams:         me.ExtraParams,
 	})
 }
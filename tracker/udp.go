@@ -11,12 +11,21 @@ import (
 type udpClient struct {
 	cl         *udp.ConnClient
 	requestUri string
+	// The ConnClient belongs to a udp.ConnClientPool.
+	pooled bool
 }
 
 func (c *udpClient) Close() error {
+	if c.pooled {
+		return nil
+	}
 	return c.cl.Close()
 }
 
+func (c *udpClient) Scrape(ctx context.Context, ihs []udp.InfoHash) (udp.ScrapeResponse, error) {
+	return c.cl.Client.Scrape(ctx, ihs)
+}
+
 func (c *udpClient) Announce(ctx context.Context, req AnnounceRequest, opts trHttp.AnnounceOpt) (res AnnounceResponse, err error) {
 	if req.IPAddress == 0 && opts.ClientIp4 != nil {
 		// I think we're taking bytes in big-endian order (all IPs), and writing it to a natively
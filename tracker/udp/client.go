@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -21,6 +22,10 @@ type Client struct {
 	connIdIssued time.Time
 	Dispatcher   *Dispatcher
 	Writer       io.Writer
+	// How long to wait for a response before the first retransmission of a request. Each further
+	// retransmission waits twice as long. Defaults to DefaultRetransmitTimeout, which can be much
+	// longer than a request's deadline on a LAN.
+	RetransmitTimeout time.Duration
 }
 
 func (cl *Client) Announce(
@@ -55,6 +60,15 @@ func (cl *Client) Announce(
 	return
 }
 
+// An error response from the tracker.
+type ErrorResponse struct {
+	Message string
+}
+
+func (me ErrorResponse) Error() string {
+	return fmt.Sprintf("error response: %#q", me.Message)
+}
+
 // There's no way to pass options in a scrape, since we don't when the request body ends.
 func (cl *Client) Scrape(
 	ctx context.Context, ihs []InfoHash,
@@ -141,12 +155,46 @@ func (cl *Client) requestWriter(ctx context.Context, action Action, body []byte,
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(timeout(n)):
+		case <-time.After(cl.retransmitTimeout(n)):
 		}
 	}
 }
 
+func (cl *Client) retransmitTimeout(contiguousTimeouts int) time.Duration {
+	initial := cl.RetransmitTimeout
+	if initial == 0 {
+		initial = DefaultRetransmitTimeout
+	}
+	return backoffTimeout(initial, contiguousTimeouts)
+}
+
+// Forgets the connection ID, so that the next request obtains a new one.
+func (cl *Client) invalidateConnId() {
+	cl.mu.Lock()
+	cl.connIdIssued = time.Time{}
+	cl.mu.Unlock()
+}
+
+// Makes the request, and if the tracker responds to a cached connection ID with an error, such as
+// when it no longer accepts the ID, tries once more with a new one.
 func (cl *Client) request(ctx context.Context, action Action, body []byte) (respBody []byte, addr net.Addr, err error) {
+	if action == ActionConnect {
+		// Connecting is done with the Client lock held.
+		return cl.requestOnce(ctx, action, body)
+	}
+	cl.mu.Lock()
+	cached := !cl.connIdIssued.IsZero()
+	cl.mu.Unlock()
+	respBody, addr, err = cl.requestOnce(ctx, action, body)
+	var errResp ErrorResponse
+	if cached && errors.As(err, &errResp) && ctx.Err() == nil {
+		cl.invalidateConnId()
+		respBody, addr, err = cl.requestOnce(ctx, action, body)
+	}
+	return
+}
+
+func (cl *Client) requestOnce(ctx context.Context, action Action, body []byte) (respBody []byte, addr net.Addr, err error) {
 	respChan := make(chan DispatchedResponse, 1)
 	t := cl.Dispatcher.NewTransaction(func(dr DispatchedResponse) {
 		respChan <- dr
@@ -166,7 +214,7 @@ func (cl *Client) request(ctx context.Context, action Action, body []byte) (resp
 		} else if dr.Header.Action == ActionError {
 			// I've seen "Connection ID mismatch.^@" in less and other tools, I think they're just
 			// not handling a trailing \x00 nicely.
-			err = fmt.Errorf("error response: %#q", dr.Body)
+			err = ErrorResponse{Message: string(dr.Body)}
 		} else {
 			err = fmt.Errorf("unexpected response action %v", dr.Header.Action)
 		}
@@ -0,0 +1,56 @@
+package udp
+
+import (
+	"errors"
+	"sync"
+)
+
+// Shares ConnClients between requests to the same tracker, so that connection IDs are reused
+// across announces as BEP 15 intends, rather than each announce starting with a connect.
+type ConnClientPool struct {
+	mu      sync.Mutex
+	clients map[connClientPoolKey]*ConnClient
+	closed  bool
+}
+
+// Returned by ConnClientPool.Get after the pool is closed.
+var ErrConnClientPoolClosed = errors.New("conn client pool closed")
+
+type connClientPoolKey struct {
+	network, host string
+}
+
+// Returns the pool's ConnClient for the network and host in opts, creating it with opts if there
+// isn't one. The ConnClient must not be closed by the caller.
+func (p *ConnClientPool) Get(opts NewConnClientOpts) (*ConnClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrConnClientPoolClosed
+	}
+	key := connClientPoolKey{opts.Network, opts.Host}
+	if cc, ok := p.clients[key]; ok {
+		return cc, nil
+	}
+	cc, err := NewConnClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if p.clients == nil {
+		p.clients = make(map[connClientPoolKey]*ConnClient)
+	}
+	p.clients[key] = cc
+	return cc, nil
+}
+
+// Closes the pool's ConnClients. Get fails after this.
+func (p *ConnClientPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, cc := range p.clients {
+		cc.Close()
+	}
+	p.clients = nil
+	return nil
+}
@@ -3,6 +3,7 @@ package udp
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/anacrolix/log"
 	"github.com/anacrolix/missinggo/v2"
@@ -21,6 +22,8 @@ type NewConnClientOpts struct {
 	Logger log.Logger
 	// Custom function to use as a substitute for net.ListenPacket
 	ListenPacket listenPacketFunc
+	// See Client.RetransmitTimeout.
+	RetransmitTimeout time.Duration
 }
 
 // Manages a Client with a specific connection.
@@ -103,6 +106,7 @@ func NewConnClient(opts NewConnClientOpts) (cc *ConnClient, err error) {
 				network: opts.Network,
 				address: opts.Host,
 			},
+			RetransmitTimeout: opts.RetransmitTimeout,
 		},
 		conn:    conn,
 		newOpts: opts,
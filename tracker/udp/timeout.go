@@ -4,13 +4,22 @@ import (
 	"time"
 )
 
-const maxTimeout = 3840 * time.Second
+const (
+	maxTimeout = 3840 * time.Second
+	// The initial retransmission timeout given in BEP 15.
+	DefaultRetransmitTimeout = 15 * time.Second
+)
 
 func timeout(contiguousTimeouts int) (d time.Duration) {
+	return backoffTimeout(DefaultRetransmitTimeout, contiguousTimeouts)
+}
+
+// Doubles the initial timeout for each contiguous timeout, up to 8 times as BEP 15 describes.
+func backoffTimeout(initial time.Duration, contiguousTimeouts int) (d time.Duration) {
 	if contiguousTimeouts > 8 {
 		contiguousTimeouts = 8
 	}
-	d = 15 * time.Second
+	d = initial
 	for ; contiguousTimeouts > 0; contiguousTimeouts-- {
 		d *= 2
 	}
@@ -3,6 +3,7 @@ package udp
 import (
 	"math"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 )
@@ -13,3 +14,10 @@ func TestTimeoutMax(t *testing.T) {
 	c.Check(timeout(9), qt.Equals, maxTimeout)
 	c.Check(timeout(math.MaxInt32), qt.Equals, maxTimeout)
 }
+
+func TestBackoffTimeout(t *testing.T) {
+	c := qt.New(t)
+	c.Check(backoffTimeout(time.Second, 0), qt.Equals, time.Second)
+	c.Check(backoffTimeout(time.Second, 3), qt.Equals, 8*time.Second)
+	c.Check(backoffTimeout(time.Second, 20), qt.Equals, 256*time.Second)
+}
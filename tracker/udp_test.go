@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -191,3 +192,63 @@ func TestURLPathOption(t *testing.T) {
 	conn.WriteTo(w.Bytes(), addr)
 	require.NoError(t, <-announceErr)
 }
+
+// Checks that pooled UDP clients reuse connection IDs across announces, get new ones when the
+// tracker stops accepting them, and retransmit lost requests.
+func TestUdpConnClientPool(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	var connects, connId int64 = 0, 1
+	go func() {
+		b := make([]byte, 0x800)
+		// Drop the initial connect request.
+		if _, _, err := pc.ReadFrom(b); err != nil {
+			return
+		}
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			r := bytes.NewReader(b[:n])
+			var h udp.RequestHeader
+			udp.Read(r, &h)
+			var w bytes.Buffer
+			switch {
+			case h.Action == udp.ActionConnect:
+				atomic.AddInt64(&connects, 1)
+				udp.Write(&w, udp.ResponseHeader{Action: udp.ActionConnect, TransactionId: h.TransactionId})
+				udp.Write(&w, udp.ConnectionResponse{ConnectionId: uint64(atomic.LoadInt64(&connId))})
+			case h.ConnectionId != uint64(atomic.LoadInt64(&connId)):
+				udp.Write(&w, udp.ResponseHeader{Action: udp.ActionError, TransactionId: h.TransactionId})
+				w.WriteString("Connection ID mismatch.")
+			default:
+				udp.Write(&w, udp.ResponseHeader{Action: udp.ActionAnnounce, TransactionId: h.TransactionId})
+				udp.Write(&w, udp.AnnounceResponseHeader{Interval: 900})
+			}
+			pc.WriteTo(w.Bytes(), addr)
+		}
+	}()
+	var pool udp.ConnClientPool
+	defer pool.Close()
+	announce := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ar, err := Announce{
+			TrackerUrl:           fmt.Sprintf("udp://%s/announce", pc.LocalAddr()),
+			Context:              ctx,
+			UdpPool:              &pool,
+			UdpRetransmitTimeout: 50 * time.Millisecond,
+		}.Do()
+		require.NoError(t, err)
+		assert.EqualValues(t, 900, ar.Interval)
+	}
+	announce()
+	announce()
+	assert.EqualValues(t, 1, atomic.LoadInt64(&connects))
+	// The tracker no longer accepts the cached connection ID.
+	atomic.AddInt64(&connId, 1)
+	announce()
+	assert.EqualValues(t, 2, atomic.LoadInt64(&connects))
+}
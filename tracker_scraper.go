@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/torrent/tracker"
+	trHttp "github.com/anacrolix/torrent/tracker/http"
+	"github.com/anacrolix/torrent/tracker/udp"
 )
 
 // Announces a torrent to a tracker at regular intervals, when peers are
@@ -22,6 +25,14 @@ type trackerScraper struct {
 	t               *Torrent
 	lastAnnounce    trackerAnnounceResult
 	lookupTrackerIp func(*url.URL) ([]net.IP, error)
+	// Whether an announce has succeeded, so that the tracker is tracking us. Cleared when an
+	// announce fails, as the tracker may have restarted and forgotten us.
+	started bool
+	// Whether the completed event has been announced.
+	completedSent bool
+	// Whether an announce other than stopped has been sent and not yet recorded, in which case the
+	// tracker may be tracking us.
+	announcing bool
 }
 
 type torrentTrackerAnnouncer interface {
@@ -53,6 +64,9 @@ func (ts *trackerScraper) statusLine() string {
 			if ts.lastAnnounce.Completed.IsZero() {
 				return "never"
 			}
+			if ts.lastAnnounce.Warning != "" {
+				return fmt.Sprintf("%d peers, warning: %q", ts.lastAnnounce.NumPeers, ts.lastAnnounce.Warning)
+			}
 			return fmt.Sprintf("%d peers", ts.lastAnnounce.NumPeers)
 		}(),
 	)
@@ -64,6 +78,26 @@ type trackerAnnounceResult struct {
 	NumPeers  int
 	Interval  time.Duration
 	Completed time.Time
+	// When the announce began. Reannounces forced after this are still due.
+	Started time.Time
+	// The warning message from the tracker, if the announce succeeded.
+	Warning string
+	// The min interval the tracker gave. Zero if it didn't give one.
+	MinInterval time.Duration
+}
+
+// Returns the reason the tracker gave for failing an announce, or "" if the error didn't come from
+// the tracker.
+func trackerFailureReason(err error) string {
+	var httpErr trHttp.FailureReasonError
+	if errors.As(err, &httpErr) {
+		return httpErr.Reason
+	}
+	var udpErr udp.ErrorResponse
+	if errors.As(err, &udpErr) {
+		return udpErr.Message
+	}
+	return ""
 }
 
 func (me *trackerScraper) getIp() (ip net.IP, err error) {
@@ -83,10 +117,6 @@ func (me *trackerScraper) getIp() (ip net.IP, err error) {
 	}
 	me.t.cl.rLock()
 	defer me.t.cl.rUnlock()
-	if me.t.cl.closed.IsSet() {
-		err = errors.New("client is closed")
-		return
-	}
 	for _, ip = range ips {
 		if me.t.cl.ipIsBlocked(ip) {
 			continue
@@ -118,6 +148,7 @@ func (me *trackerScraper) trackerUrl(ip net.IP) string {
 // Return how long to wait before trying again. For most errors, we return 5
 // minutes, a relatively quick turn around for DNS changes.
 func (me *trackerScraper) announce(ctx context.Context, event tracker.AnnounceEvent) (ret trackerAnnounceResult) {
+	ret.Started = time.Now()
 	defer func() {
 		ret.Completed = time.Now()
 	}()
@@ -140,14 +171,24 @@ func (me *trackerScraper) announce(ctx context.Context, event tracker.AnnounceEv
 		}
 	}()
 
+	// The stopped event is announced while the Client closes.
+	if event != tracker.Stopped && me.t.cl.closed.IsSet() {
+		ret.Err = errors.New("client is closed")
+		return
+	}
 	ip, err := me.getIp()
 	if err != nil {
 		ret.Err = fmt.Errorf("error getting ip: %s", err)
 		return
 	}
-	me.t.cl.rLock()
+	ip4, ip6 := me.t.cl.announceIps()
+	me.t.cl.lock()
 	req := me.t.announceRequest(event)
-	me.t.cl.rUnlock()
+	extraParams := me.t.announceExtraParams()
+	if event != tracker.Stopped {
+		me.announcing = true
+	}
+	me.t.cl.unlock()
 	// The default timeout works well as backpressure on concurrent access to the tracker. Since
 	// we're passing our own Context now, we will include that timeout ourselves to maintain similar
 	// behavior to previously, albeit with this context now being cancelled when the Torrent is
@@ -156,20 +197,24 @@ func (me *trackerScraper) announce(ctx context.Context, event tracker.AnnounceEv
 	defer cancel()
 	me.t.logger.WithDefaultLevel(log.Debug).Printf("announcing to %q: %#v", me.u.String(), req)
 	res, err := tracker.Announce{
-		Context:             ctx,
-		HttpProxy:           me.t.cl.config.HTTPProxy,
-		HttpRequestDirector: me.t.cl.config.HttpRequestDirector,
-		DialContext:         me.t.cl.config.TrackerDialContext,
-		ListenPacket:        me.t.cl.config.TrackerListenPacket,
-		UserAgent:           me.t.cl.config.HTTPUserAgent,
-		TrackerUrl:          me.trackerUrl(ip),
-		Request:             req,
-		HostHeader:          me.u.Host,
-		ServerName:          me.u.Hostname(),
-		UdpNetwork:          me.u.Scheme,
-		ClientIp4:           krpc.NodeAddr{IP: me.t.cl.config.PublicIp4},
-		ClientIp6:           krpc.NodeAddr{IP: me.t.cl.config.PublicIp6},
-		Logger:              me.t.logger,
+		Context:              ctx,
+		HttpProxy:            me.t.cl.config.HTTPProxy,
+		HttpRequestDirector:  me.t.cl.config.HttpRequestDirector,
+		DialContext:          me.t.cl.config.TrackerDialContext,
+		ListenPacket:         me.t.cl.config.TrackerListenPacket,
+		UserAgent:            me.t.cl.config.HTTPUserAgent,
+		TrackerUrl:           me.trackerUrl(ip),
+		Request:              req,
+		ExtraParams:          extraParams,
+		HttpHeader:           me.t.cl.trackerHttpHeader(&me.u),
+		HostHeader:           me.u.Host,
+		ServerName:           me.u.Hostname(),
+		UdpNetwork:           me.u.Scheme,
+		UdpPool:              &me.t.cl.udpTrackers,
+		UdpRetransmitTimeout: me.t.cl.config.UdpTrackerRetransmitTimeout,
+		ClientIp4:            krpc.NodeAddr{IP: ip4},
+		ClientIp6:            krpc.NodeAddr{IP: ip6},
+		Logger:               me.t.logger,
 	}.Do()
 	me.t.logger.WithDefaultLevel(log.Debug).Printf("announce to %q returned %#v: %v", me.u.String(), res, err)
 	if err != nil {
@@ -191,21 +236,43 @@ func (me *trackerScraper) announce(ctx context.Context, event tracker.AnnounceEv
 		me.t.peers.DeleteBaseLineProvider(peerInfos[len(peerInfos)-1])
 	}
 
+	if me.t.cl.config.PreferFastPeersFromTracker {
+		peerInfos = me.t.rankTrackerPeers(me.u.String(), peerInfos, res.FastPeers)
+	}
+	if me.t.cl.config.PreferReliablePeersFromTracker && len(res.Reliability) != 0 {
+		peerInfos = me.t.applyTrackerReliability(
+			peerInfos, res.Reliability, time.Duration(res.Interval)*time.Second)
+	}
 	me.t.AddPeers(peerInfos)
+	if me.t.cl.config.TrackerPieceAssignment {
+		// A response without an assignment clears any we had.
+		me.t.cl.lock()
+		me.t.setTrackerAssignedPieces(res.AssignedPieces)
+		me.t.cl.unlock()
+	}
+	// A response without scheduler parameters, such as after an experiment ends, reverts to ours.
+	me.t.cl.lock()
+	me.t.setTrackerSchedulerParams(SchedulerParams{
+		UnchokeSlots:  int(res.UnchokeSlots),
+		PipelineDepth: int(res.PipelineDepth),
+	})
+	me.t.cl.unlock()
 
 	ret.Interval = time.Duration(res.Interval) * time.Second
+	ret.Warning = res.WarningMessage
+	ret.MinInterval = time.Duration(res.MinInterval) * time.Second
 	return
 }
 
 // Returns whether we can shorten the interval, and sets notify to a channel that receives when we
 // might change our mind, or leaves it if we won't.
-func (me *trackerScraper) canIgnoreInterval(notify *<-chan struct{}) bool {
-	gotInfo := me.t.GotInfo()
+func (t *Torrent) canIgnoreTrackerInterval(notify *<-chan struct{}) bool {
+	gotInfo := t.GotInfo()
 	select {
 	case <-gotInfo:
 		// Private trackers really don't like us announcing more than they specify. They're also
 		// tracking us very carefully, so it's best to comply.
-		private := me.t.info.Private
+		private := t.info.Private
 		return private == nil || !*private
 	default:
 		*notify = gotInfo
@@ -213,68 +280,209 @@ func (me *trackerScraper) canIgnoreInterval(notify *<-chan struct{}) bool {
 	}
 }
 
-func (me *trackerScraper) Run() {
-	defer me.announceStopped()
+// Returns the event for the next announce. Until the started event succeeds it's repeated, as it is
+// after announces fail, and once the Torrent has finished downloading, the completed event is sent
+// once.
+func (me *trackerScraper) nextEvent() tracker.AnnounceEvent {
+	me.t.cl.rLock()
+	defer me.t.cl.rUnlock()
+	switch {
+	case !me.started:
+		return tracker.Started
+	case !me.completedSent && me.t.downloadCompleted.IsSet():
+		return tracker.Completed
+	default:
+		return tracker.None
+	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go func() {
-		defer cancel()
-		select {
-		case <-ctx.Done():
-		case <-me.t.Closed():
+// Returns a channel that receives when the completed event is due to be announced, or nil if it
+// never will be.
+func (me *trackerScraper) completedDue() <-chan struct{} {
+	me.t.cl.rLock()
+	defer me.t.cl.rUnlock()
+	if !me.started || me.completedSent {
+		return nil
+	}
+	return me.t.downloadCompleted.Done()
+}
+
+// Stores the result of an announce, and counts and publishes it unless the announce was
+// cancelled.
+func (me *trackerScraper) recordAnnounce(ctx context.Context, event tracker.AnnounceEvent, ar trackerAnnounceResult) {
+	me.t.cl.lock()
+	defer me.t.cl.unlock()
+	if ar.Warning != "" && ar.Warning != me.lastAnnounce.Warning {
+		me.t.logger.Levelf(log.Warning, "tracker %q warns: %v", me.u.String(), ar.Warning)
+	}
+	me.lastAnnounce = ar
+	me.announcing = false
+	if ar.Err == nil {
+		me.started = true
+		if event == tracker.Completed {
+			me.completedSent = true
 		}
-	}()
+	} else if ctx.Err() == nil {
+		// Register with the tracker again once it's back.
+		me.started = false
+	}
+	if ctx.Err() == nil {
+		me.t.announces.count(ar.Err)
+		me.t.cl.announces.count(ar.Err)
+		me.t.publishEvent(TrackerAnnounceResultEvent{
+			Url:           me.u.String(),
+			NumPeers:      ar.NumPeers,
+			Interval:      ar.Interval,
+			Err:           ar.Err,
+			FailureReason: trackerFailureReason(ar.Err),
+			Warning:       ar.Warning,
+		})
+	}
+}
 
-	// make sure first announce is a "started"
-	e := tracker.Started
+// Returns the soonest another announce can follow the given one. It's at least a minute unless
+// ClientConfig.MinAnnounceInterval or Torrent.SmallIntervalAllowed say otherwise, and at least the
+// tracker's min interval unless ClientConfig.IgnoreTrackerMinInterval is set.
+func (t *Torrent) trackerMinInterval(ar trackerAnnounceResult) time.Duration {
+	ret := t.cl.config.MinAnnounceInterval
+	if ret == 0 && !t.SmallIntervalAllowed {
+		ret = time.Minute
+	}
+	if !t.cl.config.IgnoreTrackerMinInterval && ar.MinInterval > ret {
+		ret = ar.MinInterval
+	}
+	return ret
+}
 
-	for {
-		ar := me.announce(ctx, e)
-		// after first announce, get back to regular "none"
-		e = tracker.None
-		me.t.cl.lock()
-		me.lastAnnounce = ar
-		me.t.cl.unlock()
+// Returns the tracker's interval clamped by ClientConfig.MinAnnounceInterval and
+// MaxAnnounceInterval.
+func (t *Torrent) trackerInterval(ar trackerAnnounceResult) time.Duration {
+	interval := ar.Interval
+	if max := t.cl.config.MaxAnnounceInterval; max != 0 && interval > max {
+		interval = max
+	}
+	if min := t.trackerMinInterval(ar); interval < min {
+		interval = min
+	}
+	return interval
+}
 
-	recalculate:
-		// Make sure we don't announce for at least a minute since the last one.
-		interval := ar.Interval
-		if interval < time.Minute && !me.t.SmallIntervalAllowed {
-			interval = time.Minute
-		}
+// Waits until the next tracker announce is due after the given one, which is sooner while peers
+// are wanted if the tracker's interval can be ignored, if due receives, or if a reannounce is
+// forced. Returns false if the Torrent is closed first.
+func (t *Torrent) waitNextTrackerAnnounce(ar trackerAnnounceResult, due <-chan struct{}) bool {
+	forced := false
+recalculate:
+	interval := t.trackerInterval(ar)
 
-		me.t.cl.lock()
-		wantPeers := me.t.wantPeersEvent.C()
-		me.t.cl.unlock()
+	t.cl.lock()
+	wantPeers := t.wantPeersEvent.C()
+	reannounce := t.forceReannounce.Signaled()
+	if t.forcedReannounceAt.After(ar.Started) {
+		forced = true
+	}
+	t.cl.unlock()
 
+	// A channel that receives when we should reconsider our interval. Starts as nil since that
+	// never receives.
+	var reconsider <-chan struct{}
+	if forced {
+		// Forced announces only wait for the tracker's min interval.
+		interval = 0
+		if !t.cl.config.IgnoreTrackerMinInterval {
+			interval = ar.MinInterval
+		}
+	} else {
 		// If we want peers, reduce the interval to the minimum if it's appropriate.
-
-		// A channel that receives when we should reconsider our interval. Starts as nil since that
-		// never receives.
-		var reconsider <-chan struct{}
+		shortened := t.trackerMinInterval(ar)
+		if shortened < time.Minute {
+			shortened = time.Minute
+		}
 		select {
 		case <-wantPeers:
-			if interval > time.Minute && me.canIgnoreInterval(&reconsider) {
-				interval = time.Minute
+			if interval > shortened && t.canIgnoreTrackerInterval(&reconsider) {
+				interval = shortened
 			}
 		default:
 			reconsider = wantPeers
 		}
+	}
+
+	select {
+	case <-t.closed.Done():
+		return false
+	case <-reconsider:
+		// Recalculate the interval.
+		goto recalculate
+	case <-reannounce:
+		forced = true
+		goto recalculate
+	case <-due:
+		return true
+	case <-time.After(time.Until(ar.Completed.Add(interval))):
+		return true
+	}
+}
+
+// Announces to the Torrent's HTTP and UDP trackers now, rather than waiting for their intervals.
+// Announces still wait for the min interval given by each tracker, unless
+// ClientConfig.IgnoreTrackerMinInterval is set.
+func (t *Torrent) ForceReannounce() {
+	t.cl.lock()
+	defer t.cl.unlock()
+	t.forcedReannounceAt = time.Now()
+	t.forceReannounce.Broadcast()
+}
 
+// Announces until the Torrent closes. The stopped event is announced by the Torrent as it closes.
+func (me *trackerScraper) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
 		select {
-		case <-me.t.closed.Done():
+		case <-ctx.Done():
+		case <-me.t.Closed():
+		}
+	}()
+
+	for {
+		e := me.nextEvent()
+		ar := me.announce(ctx, e)
+		me.recordAnnounce(ctx, e, ar)
+		if !me.t.waitNextTrackerAnnounce(ar, me.completedDue()) {
 			return
-		case <-reconsider:
-			// Recalculate the interval.
-			goto recalculate
-		case <-time.After(time.Until(ar.Completed.Add(interval))):
 		}
 	}
 }
 
+const defaultTrackerStoppedAnnounceTimeout = 5 * time.Second
+
 func (me *trackerScraper) announceStopped() {
-	ctx, cancel := context.WithTimeout(context.Background(), tracker.DefaultTrackerAnnounceTimeout)
+	timeout := me.t.cl.config.TrackerStoppedAnnounceTimeout
+	if timeout == 0 {
+		timeout = defaultTrackerStoppedAnnounceTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	me.announce(ctx, tracker.Stopped)
+	ar := me.announce(ctx, tracker.Stopped)
+	if ar.Err != nil {
+		me.t.logger.Levelf(log.Debug, "announcing stopped to %q: %v", me.u.String(), ar.Err)
+	}
+}
+
+// Announces the stopped event to the trackers that are tracking us, adding the announces to wg so
+// that closing waits for them. Must be called with the Client lock held.
+func (t *Torrent) announceTrackersStopped(wg *sync.WaitGroup) {
+	for _, ta := range t.trackerAnnouncers {
+		s, ok := ta.(*trackerScraper)
+		if !ok || !s.started && !s.announcing {
+			continue
+		}
+		wg.Add(1)
+		go func(s *trackerScraper) {
+			defer wg.Done()
+			s.announceStopped()
+		}(s)
+	}
 }
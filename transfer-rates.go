@@ -0,0 +1,112 @@
+package torrent
+
+import (
+	"math"
+	"time"
+)
+
+// How often Torrent transfer totals are sampled for DownloadRate and UploadRate.
+const transferRateSampleInterval = time.Second
+
+// Per-Torrent and per-PeerConn moving averages of transfer rates.
+type transferRatesState struct {
+	lastSample  time.Time
+	lastRead    int64
+	lastWritten int64
+	// Bytes per second.
+	download float64
+	upload   float64
+}
+
+// Updates the averages from the transfer totals. Each sample is weighted by how long it covers
+// relative to the window, so irregular sampling doesn't skew them.
+func (me *transferRatesState) sample(now time.Time, read, written int64, window time.Duration) {
+	if me.lastSample.IsZero() {
+		me.lastSample, me.lastRead, me.lastWritten = now, read, written
+		return
+	}
+	dt := now.Sub(me.lastSample)
+	if dt <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-float64(dt)/float64(window))
+	me.download += alpha * (float64(read-me.lastRead)/dt.Seconds() - me.download)
+	me.upload += alpha * (float64(written-me.lastWritten)/dt.Seconds() - me.upload)
+	me.lastSample, me.lastRead, me.lastWritten = now, read, written
+}
+
+func (t *Torrent) sampleTransferRates(now time.Time) {
+	if t.transferRates.lastSample.IsZero() {
+		// Transfers from before the first sample are counted from when the Torrent was added.
+		t.transferRates.lastSample = t.joinTimes.Added
+	}
+	t.transferRates.sample(
+		now,
+		t.stats.BytesReadData.Int64(),
+		t.stats.BytesWrittenData.Int64(),
+		t.cl.config.TransferRateWindow)
+}
+
+// Returns the rate that piece data is being downloaded, in bytes per second, as an exponentially
+// weighted moving average over ClientConfig.TransferRateWindow.
+func (t *Torrent) DownloadRate() float64 {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.transferRates.download
+}
+
+// Returns the rate that piece data is being uploaded, in bytes per second, as an exponentially
+// weighted moving average over ClientConfig.TransferRateWindow.
+func (t *Torrent) UploadRate() float64 {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.transferRates.upload
+}
+
+func (cn *PeerConn) sampleTransferRates(now time.Time) {
+	if cn.transferRates.lastSample.IsZero() && !cn.completedHandshake.IsZero() {
+		cn.transferRates.lastSample = cn.completedHandshake
+	}
+	cn.transferRates.sample(
+		now,
+		cn._stats.BytesReadData.Int64(),
+		cn._stats.BytesWrittenData.Int64(),
+		cn.t.cl.config.TransferRateWindow)
+}
+
+// Returns the rate that piece data is being downloaded from the peer, in bytes per second, as an
+// exponentially weighted moving average over ClientConfig.TransferRateWindow. Unlike
+// Peer.DownloadRate, this includes time the peer wasn't sending because we had nothing requested.
+func (cn *PeerConn) SmoothedDownloadRate() float64 {
+	cn.locker().RLock()
+	defer cn.locker().RUnlock()
+	return cn.transferRates.download
+}
+
+// Returns the rate that piece data is being uploaded to the peer, in bytes per second, as an
+// exponentially weighted moving average over ClientConfig.TransferRateWindow.
+func (cn *PeerConn) SmoothedUploadRate() float64 {
+	cn.locker().RLock()
+	defer cn.locker().RUnlock()
+	return cn.transferRates.upload
+}
+
+func (cl *Client) transferRateLoop() {
+	ticker := time.NewTicker(transferRateSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.closed.Done():
+			return
+		case now := <-ticker.C:
+			cl.lock()
+			for _, t := range cl.torrents {
+				t.sampleTransferRates(now)
+				for c := range t.conns {
+					c.sampleTransferRates(now)
+				}
+			}
+			cl.unlock()
+		}
+	}
+}
@@ -50,3 +50,12 @@ const (
 	PiecePriorityNext
 	PiecePriorityNow // A Reader is reading in this piece. Highest urgency.
 )
+
+// Describes the importance of a Torrent relative to the others in a Client.
+type TorrentPriority int8
+
+const (
+	TorrentPriorityLow    TorrentPriority = iota - 1
+	TorrentPriorityNormal                 // Must be the zero value.
+	TorrentPriorityHigh
+)
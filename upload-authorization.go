@@ -0,0 +1,33 @@
+package torrent
+
+import (
+	"github.com/anacrolix/log"
+)
+
+// A peer's request for a chunk, given to ClientConfig.AuthorizeUpload. The PeerConn identifies the
+// peer by its PeerID, RemoteAddr and extended handshake.
+type UploadRequest struct {
+	Torrent *Torrent
+	Conn    *PeerConn
+	Request
+}
+
+// Applies ClientConfig.AuthorizeUpload to a peer's request. Must be called with the Client lock
+// held.
+func (c *PeerConn) uploadAuthorized(r Request) bool {
+	authorize := c.t.cl.config.AuthorizeUpload
+	if authorize == nil {
+		return true
+	}
+	err := authorize(UploadRequest{
+		Torrent: c.t,
+		Conn:    c,
+		Request: r,
+	})
+	if err != nil {
+		torrent.Add("requests denied by AuthorizeUpload", 1)
+		c.logger.Levelf(log.Debug, "denied request for %v: %v", r, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package torrent
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/torrent/bencode"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Per-Torrent state for the ReliableBT upload receipt extension.
+type uploadReceiptState struct {
+	// Useful data received from each uploader for pieces that haven't been hashed yet. It's only
+	// receipted once the piece passes, so uploaders aren't credited for bad data.
+	unverified map[pieceIndex]map[PeerID]int64
+	// Verified data received from each uploader, and the amount covered by the last receipt sent
+	// to it.
+	received  map[PeerID]int64
+	receipted map[PeerID]int64
+	// The latest valid receipt from each downloader.
+	collected map[PeerID]pp.UploadReceipt
+}
+
+func (cn *PeerConn) supportsUploadReceipts() bool {
+	return cn.t.cl.config.UploadReceipts && cn.PeerExtensionIDs[pp.ExtensionNameUploadReceipt] != 0
+}
+
+// Announces the receipt public key, so trackers only credit receipts that this client signed.
+func (cl *Client) addUploadReceiptAnnounceParams(vs url.Values) {
+	if cl.uploadReceiptKey != nil {
+		vs.Set("receipt_key", hex.EncodeToString(cl.uploadReceiptKey.Public().(ed25519.PublicKey)))
+	}
+}
+
+// Accounts for useful data received from the peer for a piece, until the piece is hashed.
+func (cn *PeerConn) receivedForUploadReceipt(piece pieceIndex, n int64) {
+	if !cn.supportsUploadReceipts() {
+		return
+	}
+	s := &cn.t.uploadReceipts
+	if s.unverified == nil {
+		s.unverified = make(map[pieceIndex]map[PeerID]int64)
+	}
+	m := s.unverified[piece]
+	if m == nil {
+		m = make(map[PeerID]int64)
+		s.unverified[piece] = m
+	}
+	m[cn.PeerID] += n
+}
+
+// Credits the uploaders of a piece that passed its hash, and sends receipts to those with enough
+// unreceipted data. Data for a piece that failed is discarded, as it'll be fetched again.
+func (t *Torrent) uploadReceiptsPieceHashed(piece pieceIndex, passed bool) {
+	s := &t.uploadReceipts
+	credit := s.unverified[piece]
+	delete(s.unverified, piece)
+	if !passed || len(credit) == 0 {
+		return
+	}
+	if s.received == nil {
+		s.received = make(map[PeerID]int64)
+	}
+	for id, n := range credit {
+		s.received[id] += n
+	}
+	for c := range t.conns {
+		if _, ok := credit[c.PeerID]; !ok || !c.supportsUploadReceipts() {
+			continue
+		}
+		if s.received[c.PeerID]-s.receipted[c.PeerID] >= t.cl.config.UploadReceiptInterval {
+			c.sendUploadReceipt()
+		}
+	}
+}
+
+// Sends a receipt for everything received from the peer, if there's anything new.
+func (cn *PeerConn) sendUploadReceipt() {
+	s := &cn.t.uploadReceipts
+	received := s.received[cn.PeerID]
+	if received <= s.receipted[cn.PeerID] {
+		return
+	}
+	r := pp.UploadReceipt{
+		InfoHash:   cn.t.infoHash,
+		Uploader:   cn.PeerID,
+		Downloader: cn.t.cl.peerID,
+		Bytes:      received,
+		Time:       time.Now().Unix(),
+	}
+	r.Sign(cn.t.cl.uploadReceiptKey)
+	cn.write(pp.Message{
+		Type:            pp.Extended,
+		ExtendedID:      cn.PeerExtensionIDs[pp.ExtensionNameUploadReceipt],
+		ExtendedPayload: bencode.MustMarshal(r),
+	})
+	if s.receipted == nil {
+		s.receipted = make(map[PeerID]int64)
+	}
+	s.receipted[cn.PeerID] = received
+	torrent.Add("upload receipts sent", 1)
+}
+
+// Receipts for data received since the last one are sent once the download completes, as the
+// remainder may never reach the interval.
+func (t *Torrent) flushUploadReceipts() {
+	for c := range t.conns {
+		if c.supportsUploadReceipts() {
+			c.sendUploadReceipt()
+		}
+	}
+}
+
+// Dropping a conn discards its unsent messages, so a mutually complete peer that we've sent
+// receipts to is left to drop us after reading the last one. Peers that have sent us receipts as
+// well are dropped as usual, or neither side would.
+func (cn *PeerConn) leaveDropForUploadReceipts() bool {
+	s := &cn.t.uploadReceipts
+	return cn.supportsUploadReceipts() && s.receipted[cn.PeerID] != 0 && s.collected[cn.PeerID].Bytes == 0
+}
+
+func (cn *PeerConn) onUploadReceiptMsg(payload []byte) error {
+	var r pp.UploadReceipt
+	if err := bencode.Unmarshal(payload, &r); err != nil {
+		return fmt.Errorf("unmarshalling upload receipt: %w", err)
+	}
+	t := cn.t
+	if r.InfoHash != t.infoHash || r.Uploader != t.cl.peerID || r.Downloader != cn.PeerID {
+		return fmt.Errorf("upload receipt for another transfer")
+	}
+	if !r.Verify() {
+		return fmt.Errorf("upload receipt has bad signature")
+	}
+	// Receipts are only worth something to us, so overstated ones are ignored rather than treated
+	// as misbehaviour.
+	if uploaded := t.uploadedToPeersLocked()[cn.PeerID]; r.Bytes > uploaded {
+		cn.logger.Levelf(log.Debug, "ignoring upload receipt for %v bytes, only uploaded %v", r.Bytes, uploaded)
+		return nil
+	}
+	s := &t.uploadReceipts
+	if r.Bytes <= s.collected[cn.PeerID].Bytes {
+		return nil
+	}
+	if s.collected == nil {
+		s.collected = make(map[PeerID]pp.UploadReceipt)
+	}
+	s.collected[cn.PeerID] = r
+	torrent.Add("upload receipts received", 1)
+	return nil
+}
+
+// Returns the latest upload receipt from each downloader, for presenting to trackers.
+func (t *Torrent) UploadReceipts() (ret []pp.UploadReceipt) {
+	t.cl.rLock()
+	defer t.cl.rUnlock()
+	return t.uploadReceiptsLocked()
+}
+
+func (t *Torrent) uploadReceiptsLocked() (ret []pp.UploadReceipt) {
+	for _, r := range t.uploadReceipts.collected {
+		ret = append(ret, r)
+	}
+	return
+}
+
+func (t *Torrent) marshalledUploadReceiptsLocked() (ret [][]byte) {
+	for _, r := range t.uploadReceipts.collected {
+		ret = append(ret, bencode.MustMarshal(r))
+	}
+	return
+}
@@ -11,9 +11,12 @@ var (
 	DefaultExtendedHandshakeClientVersion string
 	// This should be updated when client behaviour changes in a way that other peers could care
 	// about.
-	DefaultBep20Prefix   = "-GT0003-"
-	DefaultHttpUserAgent string
-	DefaultUpnpId        string
+	DefaultBep20Prefix = "-GT0003-"
+	// Peer ID prefix for ReliableBT experiment clients, so they can be distinguished from regular
+	// clients in swarms and tracker logs. Set ClientConfig.Bep20 to use it.
+	ReliableBTBep20Prefix = "-RB0001-"
+	DefaultHttpUserAgent  string
+	DefaultUpnpId         string
 )
 
 func init() {
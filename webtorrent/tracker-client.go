@@ -19,10 +19,27 @@ import (
 	"github.com/anacrolix/torrent/tracker"
 )
 
+const (
+	// The number of offers kept outstanding for each infohash if TrackerClient.NumOffers is unset.
+	DefaultNumOffers = 5
+	// How long offers are kept waiting for an answer if TrackerClient.OfferTimeout is unset. Trackers
+	// forget offers after a while, so an answer won't come for them after that. This matches
+	// WebTorrent.
+	DefaultOfferTimeout = 50 * time.Second
+	// How often infohashes are announced until the tracker gives an interval.
+	defaultAnnounceInterval = 2 * time.Minute
+	// Intervals given by trackers are raised to this, so a bad one can't make us spin.
+	minAnnounceInterval = time.Second
+)
+
 type TrackerClientStats struct {
 	Dials                  int64
 	ConvertedInboundConns  int64
 	ConvertedOutboundConns int64
+	// Offers dropped after going unanswered for the OfferTimeout.
+	OffersExpired int64
+	// Offers awaiting answers.
+	OutstandingOffers int
 }
 
 // Client represents the webtorrent client
@@ -33,6 +50,11 @@ type TrackerClient struct {
 	OnConn             onDataChannelOpen
 	Logger             log.Logger
 	Dialer             *websocket.Dialer
+	// The number of offers to keep outstanding for each infohash. Answered and expired offers are
+	// replaced on the next announce. Zero for DefaultNumOffers.
+	NumOffers int
+	// How long to wait for an answer to an offer. Zero for DefaultOfferTimeout.
+	OfferTimeout time.Duration
 
 	mu             sync.Mutex
 	cond           sync.Cond
@@ -41,6 +63,8 @@ type TrackerClient struct {
 	closed         bool
 	stats          TrackerClientStats
 	pingTicker     *time.Ticker
+	// Infohashes that are regularly announced, from their Started announce until they're stopped.
+	announcing map[[20]byte]*infoHashAnnounceState
 
 	WebsocketTrackerHttpHeader func() http.Header
 }
@@ -48,7 +72,23 @@ type TrackerClient struct {
 func (me *TrackerClient) Stats() TrackerClientStats {
 	me.mu.Lock()
 	defer me.mu.Unlock()
-	return me.stats
+	ret := me.stats
+	ret.OutstandingOffers = len(me.outboundOffers)
+	return ret
+}
+
+func (me *TrackerClient) numOffers() int {
+	if me.NumOffers != 0 {
+		return me.NumOffers
+	}
+	return DefaultNumOffers
+}
+
+func (me *TrackerClient) offerTimeout() time.Duration {
+	if me.OfferTimeout != 0 {
+		return me.OfferTimeout
+	}
+	return DefaultOfferTimeout
 }
 
 func (me *TrackerClient) peerIdBinary() string {
@@ -66,6 +106,14 @@ type outboundOfferValue struct {
 	peerConnection *wrappedPeerConnection
 	infoHash       [20]byte
 	dataChannel    *webrtc.DataChannel
+	created        time.Time
+}
+
+type infoHashAnnounceState struct {
+	// The interval given by the tracker.
+	interval time.Duration
+	// Fires the next regular announce.
+	timer *time.Timer
 }
 
 type DataChannelContext struct {
@@ -168,32 +216,38 @@ func (tc *TrackerClient) Close() error {
 		tc.wsConn.Close()
 	}
 	tc.closeUnusedOffers()
+	for ih := range tc.announcing {
+		tc.stopAnnouncing(ih)
+	}
 	tc.pingTicker.Stop()
 	tc.mu.Unlock()
 	tc.cond.Broadcast()
 	return nil
 }
 
+// Reannounces the infohashes we were announcing before the socket reconnected. Offers made over the
+// old socket are closed, as the tracker won't route answers to them anymore.
 func (tc *TrackerClient) announceOffers() {
-	// tc.Announce grabs a lock on tc.outboundOffers. It also handles the case where outboundOffers
-	// is nil. Take ownership of outboundOffers here.
 	tc.mu.Lock()
-	offers := tc.outboundOffers
+	for _, offer := range tc.outboundOffers {
+		// TODO: Capture the errors? Are we even in a position to do anything with them?
+		offer.peerConnection.Close()
+	}
 	tc.outboundOffers = nil
+	var infoHashes [][20]byte
+	for ih := range tc.announcing {
+		infoHashes = append(infoHashes, ih)
+	}
 	tc.mu.Unlock()
 
-	if offers == nil {
+	if len(infoHashes) == 0 {
 		return
 	}
 
-	// Iterate over our locally-owned offers, close any existing "invalid" ones from before the
-	// socket reconnected, reannounce the infohash, adding it back into the tc.outboundOffers.
-	tc.Logger.WithDefaultLevel(log.Info).Printf("reannouncing %d infohashes after restart", len(offers))
-	for _, offer := range offers {
-		// TODO: Capture the errors? Are we even in a position to do anything with them?
-		offer.peerConnection.Close()
+	tc.Logger.WithDefaultLevel(log.Info).Printf("reannouncing %d infohashes after restart", len(infoHashes))
+	for _, ih := range infoHashes {
 		// Use goroutine here to allow read loop to start and ensure the buffer drains.
-		go tc.Announce(tracker.Started, offer.infoHash)
+		go tc.Announce(tracker.Started, ih)
 	}
 }
 
@@ -205,6 +259,7 @@ func (tc *TrackerClient) closeUnusedOffers() {
 	tc.outboundOffers = nil
 }
 
+// Closes the outstanding offers for the infohash, and stops announcing it regularly.
 func (tc *TrackerClient) CloseOffersForInfohash(infoHash [20]byte) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
@@ -214,41 +269,150 @@ func (tc *TrackerClient) CloseOffersForInfohash(infoHash [20]byte) {
 			delete(tc.outboundOffers, key)
 		}
 	}
+	tc.stopAnnouncing(infoHash)
 }
 
+// Announces the infohash, making enough new offers to top up its outstanding ones to NumOffers. A
+// Started announce begins regular announces of the infohash at the interval given by the tracker,
+// until it's stopped.
 func (tc *TrackerClient) Announce(event tracker.AnnounceEvent, infoHash [20]byte) error {
 	metrics.Add("outbound announces", 1)
 	if event == tracker.Stopped {
+		tc.mu.Lock()
+		tc.stopAnnouncing(infoHash)
+		tc.mu.Unlock()
 		return tc.announce(event, infoHash, nil)
 	}
+	tc.mu.Lock()
+	tc.expireOffers(time.Now())
+	need := tc.numOffers() - tc.numOffersForInfoHash(infoHash)
+	tc.mu.Unlock()
+
+	var offers []outboundOffer
+	closeOffers := func() {
+		for _, offer := range offers {
+			offer.dataChannel.Close()
+			offer.peerConnection.Close()
+		}
+	}
+	for i := 0; i < need; i++ {
+		offer, err := tc.newOutboundOffer(infoHash)
+		if err != nil {
+			closeOffers()
+			return err
+		}
+		offers = append(offers, offer)
+	}
+	err := tc.announce(event, infoHash, offers)
+	if err != nil {
+		closeOffers()
+	}
+	return err
+}
+
+func (tc *TrackerClient) newOutboundOffer(infoHash [20]byte) (outboundOffer, error) {
 	var randOfferId [20]byte
 	_, err := rand.Read(randOfferId[:])
 	if err != nil {
-		return fmt.Errorf("generating offer_id bytes: %w", err)
+		return outboundOffer{}, fmt.Errorf("generating offer_id bytes: %w", err)
 	}
 	offerIDBinary := binaryToJsonString(randOfferId[:])
 
 	pc, dc, offer, err := tc.newOffer(tc.Logger, offerIDBinary, infoHash)
 	if err != nil {
-		return fmt.Errorf("creating offer: %w", err)
-	}
-
-	err = tc.announce(event, infoHash, []outboundOffer{
-		{
-			offerId: offerIDBinary,
-			outboundOfferValue: outboundOfferValue{
-				originalOffer:  offer,
-				peerConnection: pc,
-				infoHash:       infoHash,
-				dataChannel:    dc,
-			},
+		return outboundOffer{}, fmt.Errorf("creating offer: %w", err)
+	}
+	return outboundOffer{
+		offerId: offerIDBinary,
+		outboundOfferValue: outboundOfferValue{
+			originalOffer:  offer,
+			peerConnection: pc,
+			infoHash:       infoHash,
+			dataChannel:    dc,
+			created:        time.Now(),
 		},
+	}, nil
+}
+
+func (tc *TrackerClient) numOffersForInfoHash(infoHash [20]byte) (ret int) {
+	for _, offer := range tc.outboundOffers {
+		if offer.infoHash == infoHash {
+			ret++
+		}
+	}
+	return
+}
+
+// Closes offers that have gone unanswered for the OfferTimeout. Must be called with tc.mu held.
+func (tc *TrackerClient) expireOffers(now time.Time) {
+	timeout := tc.offerTimeout()
+	for key, offer := range tc.outboundOffers {
+		if now.Sub(offer.created) >= timeout {
+			offer.peerConnection.Close()
+			delete(tc.outboundOffers, key)
+			tc.stats.OffersExpired++
+			metrics.Add("outbound offers expired", 1)
+		}
+	}
+}
+
+// Schedules the next regular announce of the infohash. Only Started announces add infohashes to be
+// announced, so that an announce racing with the infohash being stopped doesn't resume it. Must be
+// called with tc.mu held.
+func (tc *TrackerClient) scheduleAnnounce(event tracker.AnnounceEvent, infoHash [20]byte) {
+	if tc.closed {
+		return
+	}
+	s, ok := tc.announcing[infoHash]
+	if !ok {
+		if event != tracker.Started {
+			return
+		}
+		s = &infoHashAnnounceState{interval: defaultAnnounceInterval}
+		generics.MakeMapIfNilAndSet(&tc.announcing, infoHash, s)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.interval, func() {
+		tc.mu.Lock()
+		current := tc.announcing[infoHash] == s
+		tc.mu.Unlock()
+		if !current {
+			return
+		}
+		metrics.Add("regular announces", 1)
+		err := tc.Announce(tracker.None, infoHash)
+		if err != nil {
+			tc.Logger.Levelf(log.Warning, "announcing infohash %x: %v", infoHash, err)
+		}
 	})
-	if err != nil {
-		dc.Close()
-		pc.Close()
+}
+
+// Applies the announce interval given by the tracker for the infohash. Must be called with tc.mu
+// held.
+func (tc *TrackerClient) setAnnounceInterval(infoHash [20]byte, interval time.Duration) {
+	if interval < minAnnounceInterval {
+		interval = minAnnounceInterval
 	}
-	return err
+	s, ok := tc.announcing[infoHash]
+	if !ok || s.interval == interval {
+		return
+	}
+	s.interval = interval
+	tc.scheduleAnnounce(tracker.None, infoHash)
+}
+
+// Must be called with tc.mu held.
+func (tc *TrackerClient) stopAnnouncing(infoHash [20]byte) {
+	s, ok := tc.announcing[infoHash]
+	if !ok {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	delete(tc.announcing, infoHash)
 }
 
 func (tc *TrackerClient) announce(event tracker.AnnounceEvent, infoHash [20]byte, offers []outboundOffer) error {
@@ -288,6 +452,7 @@ func (tc *TrackerClient) announce(event tracker.AnnounceEvent, infoHash [20]byte
 	for _, offer := range offers {
 		generics.MakeMapIfNilAndSet(&tc.outboundOffers, offer.offerId, offer.outboundOfferValue)
 	}
+	tc.scheduleAnnounce(event, infoHash)
 	return nil
 }
 
@@ -331,6 +496,16 @@ func (tc *TrackerClient) trackerReadLoop(tracker *websocket.Conn) error {
 			}
 		case ar.Answer != nil:
 			tc.handleAnswer(ar.OfferID, *ar.Answer)
+		case ar.Interval != nil:
+			// The tracker's reply to our announce.
+			ih, err := jsonStringToInfoHash(ar.InfoHash)
+			if err != nil {
+				tc.Logger.WithDefaultLevel(log.Warning).Printf("error decoding info_hash in announce response: %v", err)
+				break
+			}
+			tc.mu.Lock()
+			tc.setAnnounceInterval(ih, time.Duration(*ar.Interval)*time.Second)
+			tc.mu.Unlock()
 		default:
 			tc.Logger.Levelf(log.Warning, "unhandled announce response %q", message)
 		}
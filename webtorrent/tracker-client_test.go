@@ -0,0 +1,87 @@
+package webtorrent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/websocket"
+
+	"github.com/anacrolix/torrent/tracker"
+)
+
+func TestTrackerClientOfferPool(t *testing.T) {
+	c := qt.New(t)
+	requests := make(chan AnnounceRequest)
+	interval := 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var req AnnounceRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			requests <- req
+			err := conn.WriteJSON(AnnounceResponse{
+				Action:   "announce",
+				InfoHash: req.InfoHash,
+				Interval: &interval,
+			})
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+	tc := &TrackerClient{
+		Url: "ws" + strings.TrimPrefix(s.URL, "http"),
+		GetAnnounceRequest: func(event tracker.AnnounceEvent, infoHash [20]byte) (tracker.AnnounceRequest, error) {
+			return tracker.AnnounceRequest{Event: event}, nil
+		},
+		Logger:       log.Default,
+		Dialer:       websocket.DefaultDialer,
+		NumOffers:    2,
+		OfferTimeout: time.Second,
+	}
+	tc.Start(func(error) {})
+	defer tc.Close()
+	ih := [20]byte{1}
+	c.Assert(tc.Announce(tracker.Started, ih), qt.IsNil)
+	req := <-requests
+	c.Check(req.Event, qt.Equals, tracker.Started.String())
+	c.Check(req.Offers, qt.HasLen, 2)
+	c.Check(req.Numwant, qt.Equals, 2)
+	c.Check(tc.Stats().OutstandingOffers, qt.Equals, 2)
+	// The pool is full, so announcing again doesn't make more offers.
+	c.Assert(tc.Announce(tracker.None, ih), qt.IsNil)
+	req = <-requests
+	c.Check(req.Offers, qt.HasLen, 0)
+	// The next regular announce comes after the interval the tracker gave, by which time the
+	// offers have expired and are replaced.
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		c.Fatal("no regular announce")
+	}
+	c.Check(req.Event, qt.Equals, tracker.None.String())
+	c.Check(req.Offers, qt.HasLen, 2)
+	stats := tc.Stats()
+	c.Check(stats.OffersExpired, qt.Equals, int64(2))
+	c.Check(stats.OutstandingOffers, qt.Equals, 2)
+	// Stopped infohashes aren't announced anymore.
+	tc.CloseOffersForInfohash(ih)
+	c.Check(tc.Stats().OutstandingOffers, qt.Equals, 0)
+	select {
+	case req = <-requests:
+		c.Fatalf("announced after closing: %+v", req)
+	case <-time.After(2 * time.Second):
+	}
+}
@@ -0,0 +1,23 @@
+package torrent
+
+// Bounds concurrency of a kind of work. A nil value is unbounded.
+type workerSlots chan struct{}
+
+func newWorkerSlots(n int) workerSlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(workerSlots, n)
+}
+
+func (me workerSlots) acquire() {
+	if me != nil {
+		me <- struct{}{}
+	}
+}
+
+func (me workerSlots) release() {
+	if me != nil {
+		<-me
+	}
+}